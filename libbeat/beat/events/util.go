@@ -43,6 +43,10 @@ const (
 	// Bulk API encoding of the event. The key's value can be an empty string, `create`, `index`, or `delete`.
 	// If empty, `create` will be used if FieldMetaID is set; otherwise `index` will be used.
 	FieldMetaOpType = "op_type"
+
+	// FieldMetaPriority defines the priority class of the event in the publisher pipeline,
+	// overwriting the priority of the client. The key's value can be `low`, `normal` or `high`.
+	FieldMetaPriority = "priority"
 )
 
 // GetMetaStringValue returns the value of the given event metadata string field
@@ -82,3 +86,22 @@ func GetOpType(e beat.Event) OpType {
 
 	return OpTypeDefault
 }
+
+// GetPriority returns the event's priority, if set
+func GetPriority(e beat.Event) beat.Priority {
+	tmp, err := e.Meta.GetValue(FieldMetaPriority)
+	if err != nil {
+		return beat.DefaultPriority
+	}
+
+	switch v := tmp.(type) {
+	case beat.Priority:
+		return v
+	case string:
+		if p, err := beat.ParsePriority(v); err == nil {
+			return p
+		}
+	}
+
+	return beat.DefaultPriority
+}
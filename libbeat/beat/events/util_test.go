@@ -88,3 +88,23 @@ func TestGetMetaStringValue(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPriority(t *testing.T) {
+	tests := map[string]struct {
+		meta     common.MapStr
+		expected beat.Priority
+	}{
+		"no_meta":      {nil, beat.DefaultPriority},
+		"not_set":      {common.MapStr{"foo": "bar"}, beat.DefaultPriority},
+		"name":         {common.MapStr{FieldMetaPriority: "high"}, beat.HighPriority},
+		"priority":     {common.MapStr{FieldMetaPriority: beat.LowPriority}, beat.LowPriority},
+		"unknown_name": {common.MapStr{FieldMetaPriority: "urgent"}, beat.DefaultPriority},
+		"non_string":   {common.MapStr{FieldMetaPriority: 17}, beat.DefaultPriority},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.expected, GetPriority(beat.Event{Meta: test.meta}))
+		})
+	}
+}
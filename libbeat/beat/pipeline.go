@@ -18,6 +18,7 @@
 package beat
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/snappyflow/beats/v7/libbeat/common"
@@ -44,6 +45,10 @@ type Client interface {
 type ClientConfig struct {
 	PublishMode PublishMode
 
+	// Priority sets the priority class of the events published by the client.
+	// Events can overwrite the priority by setting @metadata.priority.
+	Priority Priority
+
 	Processing ProcessingConfig
 
 	CloseRef CloseRef
@@ -174,3 +179,60 @@ const (
 	// state up-to-date.
 	DropIfFull
 )
+
+// Priority enum sets the priority class of events in the publisher pipeline.
+// Queues supporting priorities forward events of higher priority to the
+// outputs more often, and drop events of lower priority first if the queue
+// fills up.
+type Priority uint8
+
+const (
+	// DefaultPriority uses NormalPriority, unless the priority is set by the
+	// event.
+	DefaultPriority Priority = iota
+
+	// LowPriority is used for events that can be dropped first, for example
+	// debug logs.
+	LowPriority
+
+	// NormalPriority is used for most events.
+	NormalPriority
+
+	// HighPriority is used for events that must not be delayed by a flood of
+	// other events, for example monitor results or audit events.
+	HighPriority
+)
+
+var priorityNames = map[Priority]string{
+	DefaultPriority: "default",
+	LowPriority:     "low",
+	NormalPriority:  "normal",
+	HighPriority:    "high",
+}
+
+// ParsePriority returns the priority with the given name.
+func ParsePriority(name string) (Priority, error) {
+	for p, n := range priorityNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return DefaultPriority, fmt.Errorf("unknown priority '%v'", name)
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Priority(%d)", uint8(p))
+}
+
+// Unpack reads the priority from its name.
+func (p *Priority) Unpack(name string) error {
+	v, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
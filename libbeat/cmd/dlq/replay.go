@@ -0,0 +1,128 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dlq
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+)
+
+// GenReplayCmd is the command used to publish the events of the dead letter
+// files to the configured output.
+func GenReplayCmd(settings instance.Settings) *cobra.Command {
+	replayCmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Publish the events of the dead letter queue to the configured output",
+		Run: func(cmd *cobra.Command, args []string) {
+			files, _ := cmd.Flags().GetStringSlice("file")
+			reason, _ := cmd.Flags().GetString("reason")
+			origin, _ := cmd.Flags().GetString("output")
+			remove, _ := cmd.Flags().GetBool("delete")
+
+			var filter func(dlq.Entry) bool
+			if reason != "" || origin != "" {
+				filter = func(entry dlq.Entry) bool {
+					return (reason == "" || entry.Reason == reason) &&
+						(origin == "" || entry.Output == origin)
+				}
+			}
+
+			if err := replay(settings, files, filter, remove); err != nil {
+				fatalf("%s", err)
+			}
+		},
+	}
+
+	replayCmd.Flags().StringSlice("file", nil, "Dead letter file to replay. Defaults to the files of the dead_letter_queue settings.")
+	replayCmd.Flags().String("reason", "", "Only replay dead letters dropped for the given reason")
+	replayCmd.Flags().String("output", "", "Only replay dead letters dropped by the given output")
+	replayCmd.Flags().Bool("delete", false, "Delete the replayed dead letters once published. Dead letters skipped by --reason or --output are kept")
+
+	return replayCmd
+}
+
+func replay(settings instance.Settings, files []string, filter func(dlq.Entry) bool, remove bool) error {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return fmt.Errorf("error initializing beat: %s", err)
+	}
+
+	if len(files) == 0 {
+		config, err := dlq.ReadConfig(b.Config.DeadLetterQueue)
+		if err != nil {
+			return fmt.Errorf("error reading dead_letter_queue settings: %s", err)
+		}
+		files = dlq.Files(dlq.FilePath(config.File))
+	}
+	if len(files) == 0 {
+		fmt.Println("No dead letters to replay")
+		return nil
+	}
+
+	output, err := outputs.Load(b.IdxSupporter, b.Info, nil, b.Config.Output.Name(), b.Config.Output.Config())
+	if err != nil {
+		return fmt.Errorf("error initializing output: %s", err)
+	}
+	publisher, err := dlq.NewPublisher(output)
+	if err != nil {
+		return fmt.Errorf("error initializing output: %s", err)
+	}
+	defer publisher.Close()
+
+	for _, path := range files {
+		n, err := replayFile(publisher, path, filter)
+		if err != nil {
+			return fmt.Errorf("error replaying %v after %v events: %s", path, n, err)
+		}
+		fmt.Printf("Replayed %v events from %v\n", n, path)
+
+		if remove {
+			kept, err := dlq.Remove(path, filter)
+			if err != nil {
+				return fmt.Errorf("error deleting replayed events from %v: %s", path, err)
+			}
+			if kept > 0 {
+				fmt.Printf("Kept %v events not replayed in %v\n", kept, path)
+			}
+		}
+	}
+	return nil
+}
+
+func replayFile(publisher *dlq.Publisher, path string, filter func(dlq.Entry) bool) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return dlq.Replay(context.Background(), publisher, f, filter)
+}
+
+func fatalf(msg string, vs ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg, vs...)
+	fmt.Fprintln(os.Stderr)
+	os.Exit(1)
+}
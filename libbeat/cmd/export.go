@@ -27,7 +27,7 @@ import (
 func genExportCmd(settings instance.Settings) *cobra.Command {
 	exportCmd := &cobra.Command{
 		Use:   "export",
-		Short: "Export current config or index template",
+		Short: "Export current config, index template or events",
 	}
 
 	exportCmd.AddCommand(export.GenExportConfigCmd(settings))
@@ -35,6 +35,7 @@ func genExportCmd(settings instance.Settings) *cobra.Command {
 	exportCmd.AddCommand(export.GenIndexPatternConfigCmd(settings))
 	exportCmd.AddCommand(export.GenDashboardCmd(settings))
 	exportCmd.AddCommand(export.GenGetILMPolicyCmd(settings))
+	exportCmd.AddCommand(export.GenExportEventsCmd(settings))
 
 	return exportCmd
 }
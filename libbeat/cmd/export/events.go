@@ -0,0 +1,117 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec/cbor"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/version"
+)
+
+// GenExportEventsCmd is the command used to convert events written with the
+// cbor codec to newline delimited JSON.
+func GenExportEventsCmd(settings instance.Settings) *cobra.Command {
+	genEventsCmd := &cobra.Command{
+		Use:   "events [FILE...]",
+		Short: "Export events encoded with the cbor codec as JSON",
+		Long: "Export events encoded with the cbor codec as newline delimited JSON. " +
+			"Events are read from the given files, which can be gzip compressed, or from stdin.",
+		Run: func(cmd *cobra.Command, args []string) {
+			pretty, _ := cmd.Flags().GetBool("pretty")
+
+			ver := settings.Version
+			if ver == "" {
+				ver = version.GetDefaultVersion()
+			}
+			enc := json.New(ver, json.Config{Pretty: pretty})
+
+			out := bufio.NewWriter(os.Stdout)
+			defer out.Flush()
+
+			if len(args) == 0 {
+				args = []string{"-"}
+			}
+			for _, path := range args {
+				n, err := exportEventsFile(out, enc, path)
+				if err != nil {
+					out.Flush()
+					fatalf("Error exporting events from %v after %v events: %+v.", path, n, err)
+				}
+			}
+		},
+	}
+
+	genEventsCmd.Flags().Bool("pretty", false, "Pretty print the events")
+
+	return genEventsCmd
+}
+
+func exportEventsFile(out io.Writer, enc *json.Encoder, path string) (int, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	// gzip compressed files can not be mistaken for CBOR, as the gzip magic
+	// number is not a valid CBOR data item.
+	r := bufio.NewReader(in)
+	if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		return exportEvents(out, enc, zr)
+	}
+	return exportEvents(out, enc, r)
+}
+
+// exportEvents writes the events read from in as JSON, one event per line.
+func exportEvents(out io.Writer, enc *json.Encoder, in io.Reader) (int, error) {
+	dec := cbor.NewDecoder(in)
+	for n := 0; ; n++ {
+		index, event, err := dec.Decode()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		data, err := enc.Encode(index, &event)
+		if err != nil {
+			return n, err
+		}
+		if _, err := out.Write(append(data, '\n')); err != nil {
+			return n, err
+		}
+	}
+}
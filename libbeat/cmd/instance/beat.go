@@ -60,6 +60,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/monitoring/report"
 	"github.com/snappyflow/beats/v7/libbeat/monitoring/report/log"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/elasticsearch"
 	"github.com/snappyflow/beats/v7/libbeat/paths"
 	"github.com/snappyflow/beats/v7/libbeat/plugin"
@@ -103,7 +104,8 @@ type beatConfig struct {
 	Instrumentation instrumentation.Config `config:"instrumentation"`
 
 	// output/publishing related configurations
-	Pipeline pipeline.Config `config:",inline"`
+	Pipeline        pipeline.Config `config:",inline"`
+	DeadLetterQueue *common.Config  `config:"dead_letter_queue"`
 
 	// monitoring settings
 	MonitoringBeatConfig monitoring.BeatConfig `config:",inline"`
@@ -307,6 +309,12 @@ func (b *Beat) createBeater(bt beat.Creator) (beat.Beater, error) {
 		return nil, err
 	}
 
+	deadLetters, err := dlq.Load(b.IdxSupporter, b.Info, b.Config.DeadLetterQueue)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing dead letter queue: %+v", err)
+	}
+	dlq.SetDefault(deadLetters)
+
 	reg := monitoring.Default.GetRegistry("libbeat")
 	if reg == nil {
 		reg = monitoring.Default.NewRegistry("libbeat")
@@ -323,6 +331,12 @@ func (b *Beat) createBeater(bt beat.Creator) (beat.Beater, error) {
 
 	debugf("Initializing output plugins")
 	outputEnabled := b.Config.Output.IsSet() && b.Config.Output.Config().Enabled()
+	if len(b.Config.Pipeline.Outputs) > 0 {
+		if b.Config.Output.IsSet() {
+			return nil, errors.New("output and outputs can not be used together")
+		}
+		outputEnabled = true
+	}
 	debugf("  3326........")
 	if !outputEnabled {
 		if b.Manager.Enabled() {
@@ -335,7 +349,7 @@ func (b *Beat) createBeater(bt beat.Creator) (beat.Beater, error) {
 	}
 	debugf("  355........")
 	debugf("%v\n",b.Config.Output)
-	pipeline, err := pipeline.Load(b.Info,
+	pipeline, err := pipeline.LoadWithSettings(b.Info,
 		pipeline.Monitors{
 			Metrics:   reg,
 			Telemetry: monitoring.GetNamespace("state").GetRegistry(),
@@ -343,8 +357,12 @@ func (b *Beat) createBeater(bt beat.Creator) (beat.Beater, error) {
 			Tracer:    b.Instrumentation.Tracer(),
 		},
 		b.Config.Pipeline,
-		b.processing,
 		b.makeOutputFactory(b.Config.Output),
+		pipeline.Settings{
+			WaitCloseMode: pipeline.NoWaitOnClose,
+			Processors:    b.processing,
+			RouteFactory:  b.createOutput,
+		},
 	)
 
 	if err != nil {
@@ -420,6 +438,11 @@ func (b *Beat) launch(settings Settings, bt beat.Creator) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if deadLetters := dlq.Default(); deadLetters != nil {
+			deadLetters.Close()
+		}
+	}()
 
 	r, err := b.setupMonitoring(settings)
 	if err != nil {
@@ -562,6 +585,16 @@ func (b *Beat) Setup(settings Settings, bt beat.Creator, setup SetupSettings) er
 	}())
 }
 
+// LockDataPath acquires the lock on the data path, ensuring no other
+// instance of the Beat is running. The returned function releases the lock.
+func (b *Beat) LockDataPath() (func() error, error) {
+	bl := newLocker(b)
+	if err := bl.lock(); err != nil {
+		return nil, err
+	}
+	return bl.unlock, nil
+}
+
 // handleFlags parses the command line flags. It invokes the HandleFlags
 // callback if implemented by the Beat.
 func (b *Beat) handleFlags() error {
@@ -808,7 +841,15 @@ func (b *Beat) loadDashboards(ctx context.Context, force bool) error {
 // policy as a callback with the elasticsearch output. It is important the
 // registration happens before the publisher is created.
 func (b *Beat) registerESIndexManagement() error {
-	if b.Config.Output.Name() != "elasticsearch" || !b.IdxSupporter.Enabled() {
+	if !b.IdxSupporter.Enabled() {
+		return nil
+	}
+
+	usesES := b.Config.Output.Name() == "elasticsearch"
+	for _, route := range b.Config.Pipeline.Outputs {
+		usesES = usesES || route.Output.Name() == "elasticsearch"
+	}
+	if !usesES {
 		return nil
 	}
 
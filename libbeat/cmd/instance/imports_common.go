@@ -34,7 +34,9 @@ import (
 	_ "github.com/snappyflow/beats/v7/libbeat/processors/dns"
 	_ "github.com/snappyflow/beats/v7/libbeat/processors/extract_array"
 	_ "github.com/snappyflow/beats/v7/libbeat/processors/fingerprint"
+	_ "github.com/snappyflow/beats/v7/libbeat/processors/ratelimit"
 	_ "github.com/snappyflow/beats/v7/libbeat/processors/registered_domain"
+	_ "github.com/snappyflow/beats/v7/libbeat/processors/split_trace_body"
 	_ "github.com/snappyflow/beats/v7/libbeat/processors/translate_sid"
 	_ "github.com/snappyflow/beats/v7/libbeat/processors/urldecode"
 	_ "github.com/snappyflow/beats/v7/libbeat/publisher/includes" // Register publisher pipeline modules
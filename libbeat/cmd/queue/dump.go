@@ -0,0 +1,102 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queue
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/version"
+)
+
+var errLimitReached = errors.New("limit reached")
+
+// GenDumpCmd is the command used to print the events of an on-disk queue as
+// newline delimited JSON.
+func GenDumpCmd(settings instance.Settings) *cobra.Command {
+	dumpCmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Print the events of the queue as JSON",
+		Long: "Print the events of the queue as newline delimited JSON, oldest first. " +
+			"Events failing to decode are reported to stderr and skipped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			skip, _ := cmd.Flags().GetInt("skip")
+			limit, _ := cmd.Flags().GetInt("limit")
+
+			ver := settings.Version
+			if ver == "" {
+				ver = version.GetDefaultVersion()
+			}
+			enc := json.New(ver, json.Config{Pretty: pretty})
+			index := settings.IndexPrefix
+			if index == "" {
+				index = settings.Name
+			}
+
+			s, done := openStore(cmd, settings)
+			defer done()
+
+			out := bufio.NewWriter(os.Stdout)
+			defer out.Flush()
+
+			n, written := 0, 0
+			err := s.Read(func(event publisher.Event, err error) error {
+				n++
+				if n <= skip {
+					return nil
+				}
+				if limit > 0 && written >= limit {
+					return errLimitReached
+				}
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Skipping event %v: %s\n", n, err)
+					return nil
+				}
+
+				data, err := enc.Encode(index, &event.Content)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Skipping event %v: %s\n", n, err)
+					return nil
+				}
+				written++
+				_, err = out.Write(append(data, '\n'))
+				return err
+			})
+			if err != nil && err != errLimitReached {
+				out.Flush()
+				done()
+				fatalf("Error reading queue after %v events: %s", n, err)
+			}
+		},
+	}
+
+	dumpCmd.Flags().Bool("pretty", false, "Pretty print the events")
+	dumpCmd.Flags().Int("skip", 0, "Number of events to skip")
+	dumpCmd.Flags().Int("limit", 0, "Maximum number of events to print. 0 prints all events.")
+
+	return dumpCmd
+}
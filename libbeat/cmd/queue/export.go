@@ -0,0 +1,106 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// GenExportCmd is the command used to copy the events of an on-disk queue
+// into a dead letter file, from which they can be published again using the
+// dlq replay command.
+func GenExportCmd(settings instance.Settings) *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the events of the queue to a dead letter file",
+		Long: "Export the events of the queue to a dead letter file, which can be " +
+			"published using the dlq replay command. Events failing to decode are " +
+			"reported to stderr and skipped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			path, _ := cmd.Flags().GetString("file")
+			truncate, _ := cmd.Flags().GetBool("truncate")
+			output, _ := cmd.Flags().GetString("output")
+
+			if path == "" {
+				fatalf("--file must be set.")
+			}
+
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				fatalf("Error opening %v: %s", path, err)
+			}
+			defer f.Close()
+			w := bufio.NewWriter(f)
+
+			s, done := openStore(cmd, settings)
+			defer done()
+
+			now := time.Now()
+			exported, skipped := 0, 0
+			err = s.Read(func(event publisher.Event, err error) error {
+				if err != nil {
+					skipped++
+					fmt.Fprintf(os.Stderr, "Skipping event %v: %s\n", exported+skipped, err)
+					return nil
+				}
+
+				exported++
+				return dlq.WriteEntry(w, dlq.Entry{
+					Timestamp: now,
+					Output:    output,
+					Reason:    dlq.ReasonExported,
+					Event:     event.Content,
+				})
+			})
+			if err == nil {
+				err = w.Flush()
+			}
+			if err == nil {
+				err = f.Sync()
+			}
+			if err != nil {
+				done()
+				fatalf("Error exporting events after %v events: %s", exported, err)
+			}
+			fmt.Printf("Exported %v events to %v, skipped %v events\n", exported, path, skipped)
+
+			if truncate {
+				n, err := s.Truncate(truncateAll)
+				if err != nil {
+					done()
+					fatalf("Error truncating queue: %s", err)
+				}
+				fmt.Printf("Removed %v events\n", n)
+			}
+		},
+	}
+
+	exportCmd.Flags().String("file", "", "Dead letter file to append the events to")
+	exportCmd.Flags().Bool("truncate", false, "Remove all events from the queue once exported")
+
+	return exportCmd
+}
@@ -0,0 +1,226 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queue
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/spool"
+)
+
+// truncateAll is passed to Truncate to remove all events.
+const truncateAll = int(^uint(0) >> 1)
+
+// store gives offline access to the events of an on-disk queue.
+type store interface {
+	Stats() ([]stat, error)
+	Read(fn func(publisher.Event, error) error) error
+	Truncate(n int) (int, error)
+	Close() error
+}
+
+type stat struct {
+	name  string
+	value interface{}
+}
+
+// AddFlags adds the flags selecting the queue to cmd. The flags are
+// inherited by all subcommands.
+func AddFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("type", "", "Queue type (spool or disk). Defaults to the configured queue.")
+	cmd.PersistentFlags().String("path", "", "Path of the spool file or disk queue directory. Defaults to the configured path.")
+	cmd.PersistentFlags().String("output", "", "Use the queue of the given named output")
+}
+
+// openStore opens the queue selected by the command line flags. The data path
+// is locked until the returned function is called, such that the queue can
+// not be used by a running beat.
+func openStore(cmd *cobra.Command, settings instance.Settings) (store, func()) {
+	typ, _ := cmd.Flags().GetString("type")
+	path, _ := cmd.Flags().GetString("path")
+	output, _ := cmd.Flags().GetString("output")
+
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		fatalf("Error initializing beat: %s", err)
+	}
+
+	unlock, err := b.LockDataPath()
+	if err == instance.ErrAlreadyLocked {
+		fatalf("The data path is in use. Stop %s before accessing its queue.", b.Info.Beat)
+	} else if err != nil {
+		fatalf("Error locking data path: %s", err)
+	}
+
+	typ, path, err = resolveQueue(b, typ, path, output)
+	if err != nil {
+		unlock()
+		fatalf("Error reading queue settings: %s", err)
+	}
+
+	var s store
+	switch typ {
+	case "spool":
+		var f *spool.File
+		if f, err = spool.OpenFile(path); err == nil {
+			s = spoolStore{path: path, file: f}
+		}
+	case "disk":
+		var st *diskqueue.Store
+		if st, err = diskqueue.OpenStore(path); err == nil {
+			s = diskStore{path: path, store: st}
+		}
+	default:
+		err = fmt.Errorf("queue type '%v' does not store events on disk", typ)
+	}
+	if err != nil {
+		unlock()
+		fatalf("Error opening queue: %s", err)
+	}
+
+	return s, func() {
+		s.Close()
+		unlock()
+	}
+}
+
+// resolveQueue returns the type and path of the queue, using the queue
+// settings of the pipeline or the named output for the values not set on
+// the command line.
+func resolveQueue(b *instance.Beat, typ, path, output string) (string, string, error) {
+	ns := b.Config.Pipeline.Queue
+	if output != "" {
+		found := false
+		for _, route := range b.Config.Pipeline.Outputs {
+			if route.Name == output {
+				found = true
+				if route.Queue.IsSet() {
+					ns = route.Queue
+				}
+				break
+			}
+		}
+		if !found {
+			return "", "", fmt.Errorf("no output named '%v' configured", output)
+		}
+	}
+
+	name := "mem"
+	if ns.IsSet() {
+		name = ns.Name()
+	}
+	if typ == "" {
+		typ = name
+	}
+
+	var cfg *common.Config
+	if typ == name {
+		cfg = ns.Config()
+	}
+
+	if typ == "hybrid" {
+		// events of the memory tier are lost on shutdown, only the disk tier
+		// can be inspected.
+		typ = "disk"
+		if cfg != nil && cfg.HasField("disk") {
+			var err error
+			if cfg, err = cfg.Child("disk", -1); err != nil {
+				return "", "", err
+			}
+		} else {
+			cfg = nil
+		}
+	}
+
+	if path != "" {
+		return typ, path, nil
+	}
+
+	if cfg == nil {
+		cfg = common.NewConfig()
+	}
+	switch typ {
+	case "spool":
+		path, err := spool.FilePath(cfg)
+		return typ, path, err
+	case "disk":
+		settings, err := diskqueue.SettingsFromConfig(cfg)
+		return typ, settings.Path, err
+	}
+	return typ, "", nil
+}
+
+type spoolStore struct {
+	path string
+	file *spool.File
+}
+
+func (s spoolStore) Stats() ([]stat, error) {
+	st, err := s.file.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return []stat{
+		{"type", "spool"},
+		{"path", s.path},
+		{"size", st.Size},
+		{"page_size", st.PageSize},
+		{"events", st.Events},
+	}, nil
+}
+
+func (s spoolStore) Read(fn func(publisher.Event, error) error) error { return s.file.Read(fn) }
+func (s spoolStore) Truncate(n int) (int, error)                      { return s.file.Truncate(n) }
+func (s spoolStore) Close() error                                     { return s.file.Close() }
+
+type diskStore struct {
+	path  string
+	store *diskqueue.Store
+}
+
+func (s diskStore) Stats() ([]stat, error) {
+	st, err := s.store.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return []stat{
+		{"type", "disk"},
+		{"path", s.path},
+		{"segments", st.Segments},
+		{"size", st.Size},
+		{"events", st.Events},
+		{"corrupted_bytes", st.CorruptedBytes},
+	}, nil
+}
+
+func (s diskStore) Read(fn func(publisher.Event, error) error) error { return s.store.Read(fn) }
+func (s diskStore) Truncate(n int) (int, error)                      { return s.store.Truncate(n) }
+func (s diskStore) Close() error                                     { return s.store.Close() }
+
+func fatalf(msg string, vs ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg, vs...)
+	fmt.Fprintln(os.Stderr)
+	os.Exit(1)
+}
@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queue
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+)
+
+// GenStatsCmd is the command used to print the state of an on-disk queue.
+func GenStatsCmd(settings instance.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Show the number of events and the size of the queue",
+		Run: func(cmd *cobra.Command, args []string) {
+			s, done := openStore(cmd, settings)
+			defer done()
+
+			stats, err := s.Stats()
+			if err != nil {
+				done()
+				fatalf("Error reading queue: %s", err)
+			}
+			for _, st := range stats {
+				fmt.Printf("%-16s %v\n", st.name+":", st.value)
+			}
+		},
+	}
+}
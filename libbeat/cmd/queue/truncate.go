@@ -0,0 +1,59 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queue
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+)
+
+// GenTruncateCmd is the command used to remove events from the head of an
+// on-disk queue.
+func GenTruncateCmd(settings instance.Settings) *cobra.Command {
+	truncateCmd := &cobra.Command{
+		Use:   "truncate",
+		Short: "Remove the oldest events from the queue",
+		Run: func(cmd *cobra.Command, args []string) {
+			events, _ := cmd.Flags().GetInt("events")
+			all, _ := cmd.Flags().GetBool("all")
+
+			if all {
+				events = truncateAll
+			}
+			if events <= 0 {
+				fatalf("Either --events or --all must be set.")
+			}
+
+			s, done := openStore(cmd, settings)
+			n, err := s.Truncate(events)
+			done()
+			if err != nil {
+				fatalf("Error truncating queue: %s", err)
+			}
+			fmt.Printf("Removed %v events\n", n)
+		},
+	}
+
+	truncateCmd.Flags().Int("events", 0, "Number of events to remove")
+	truncateCmd.Flags().Bool("all", false, "Remove all events")
+
+	return truncateCmd
+}
@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queue
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/snappyflow/beats/v7/libbeat/cmd/instance"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// GenVerifyCmd is the command used to check all events of an on-disk queue
+// can be decoded.
+func GenVerifyCmd(settings instance.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Check all events of the queue can be read",
+		Long: "Check all events of the queue can be read. Corrupted events are reported " +
+			"to stderr. The command exits with status 1 if the queue is corrupted.",
+		Run: func(cmd *cobra.Command, args []string) {
+			s, done := openStore(cmd, settings)
+
+			valid, corrupted := 0, 0
+			err := s.Read(func(_ publisher.Event, err error) error {
+				if err != nil {
+					corrupted++
+					fmt.Fprintf(os.Stderr, "Event %v: %s\n", valid+corrupted, err)
+				} else {
+					valid++
+				}
+				return nil
+			})
+			done()
+			if err != nil {
+				fatalf("Error reading queue after %v events: %s", valid+corrupted, err)
+			}
+
+			fmt.Printf("%v events ok, %v errors\n", valid, corrupted)
+			if corrupted > 0 {
+				fatalf("The queue is corrupted. Use the export or truncate command to remove the corrupted events.")
+			}
+		},
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -127,6 +128,28 @@ func (r *intervalRotator) LogPrefix(filename string, modTime time.Time) string {
 	return fmt.Sprintf("%s-%s-", filename, t.Format(r.fileFormat))
 }
 
+// IsIntervalLog checks if name is a log rotated from filename, in the form
+// [filename]-[formattedDate]-n.
+func (r *intervalRotator) IsIntervalLog(filename, name string) bool {
+	rest := strings.TrimPrefix(name, filename+"-")
+	if rest == name {
+		return false
+	}
+	_, i, err := IntervalLogIndex(rest)
+	if err != nil || i < 2 || rest[i-1] != '-' {
+		return false
+	}
+
+	date := rest[:i-1]
+	if r.weekly {
+		var year, week int
+		n, err := fmt.Sscanf(date, "%04d-%02d", &year, &week)
+		return err == nil && n == 2 && len(date) == len("2006-01")
+	}
+	_, err = time.Parse(r.fileFormat, date)
+	return err == nil
+}
+
 func (r *intervalRotator) NewInterval() bool {
 	now := r.clock.Now()
 	newInterval := r.newInterval(r.lastRotate, now)
@@ -160,6 +183,7 @@ func OrderIntervalLogs(filename string) string {
 
 // IntervalLogIndex returns n as int given a log filename in the form [prefix]-[formattedDate]-n
 func IntervalLogIndex(filename string) (uint64, int, error) {
+	filename = strings.TrimSuffix(filename, compressSuffix)
 	i := len(filename) - 1
 	for ; i >= 0; i-- {
 		if '0' > filename[i] || filename[i] > '9' {
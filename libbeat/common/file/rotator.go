@@ -18,10 +18,13 @@
 package file
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +35,9 @@ import (
 // greater will result in an error.
 const MaxBackupsLimit = 1024
 
+// compressSuffix is appended to the names of compressed backup files.
+const compressSuffix = ".gz"
+
 // rotateReason is the reason why file rotation occurred.
 type rotateReason uint32
 
@@ -71,6 +77,8 @@ type Rotator struct {
 	rotateOnStartup bool
 	intervalRotator *intervalRotator // Optional, may be nil
 	redirectStderr  bool
+	compress        bool
+	maxAge          time.Duration
 
 	file  *os.File
 	size  uint
@@ -134,6 +142,22 @@ func RotateOnStartup(b bool) RotatorOption {
 	}
 }
 
+// Compress enables gzip compression of rotated files. Compressed backups get
+// the .gz extension. The default is false.
+func Compress(b bool) RotatorOption {
+	return func(r *Rotator) {
+		r.compress = b
+	}
+}
+
+// MaxAge configures the maximum age of backup files. Older backups are deleted
+// on rotation and when the file is opened. The default is 0 for no age limit.
+func MaxAge(d time.Duration) RotatorOption {
+	return func(r *Rotator) {
+		r.maxAge = d
+	}
+}
+
 // RedirectStderr causes all writes to standard error to be redirected
 // to this rotator.
 func RedirectStderr(redirect bool) RotatorOption {
@@ -166,6 +190,9 @@ func NewFileRotator(filename string, options ...RotatorOption) (*Rotator, error)
 	if r.permissions > os.ModePerm {
 		return nil, errors.Errorf("file rotator permissions mask of %o is invalid", r.permissions)
 	}
+	if r.maxAge < 0 {
+		return nil, errors.New("file rotator max age must not be negative")
+	}
 	var err error
 	r.intervalRotator, err = newIntervalRotator(r.log, r.interval, r.rotateOnStartup, r.filename)
 	if err != nil {
@@ -179,6 +206,8 @@ func NewFileRotator(filename string, options ...RotatorOption) (*Rotator, error)
 			"max_backups", r.maxBackups,
 			"permissions", r.permissions,
 			"interval", r.interval,
+			"compress", r.compress,
+			"max_age", r.maxAge,
 		)
 	}
 
@@ -249,11 +278,67 @@ func (r *Rotator) Close() error {
 	return r.closeFile()
 }
 
-func (r *Rotator) backupName(n uint) string {
+func (r *Rotator) backupPath(n uint, compressed bool) string {
 	if n == 0 {
 		return r.filename
 	}
-	return r.filename + "." + strconv.Itoa(int(n))
+	name := r.filename + "." + strconv.Itoa(int(n))
+	if compressed {
+		name += compressSuffix
+	}
+	return name
+}
+
+// existingBackup returns the name of the n-th backup, or an empty string if
+// the backup does not exist. Backups written before compression was enabled
+// or disabled are found as well.
+func (r *Rotator) existingBackup(n uint) (string, error) {
+	names := []string{r.backupPath(n, r.compress)}
+	if n > 0 {
+		names = append(names, r.backupPath(n, !r.compress))
+	}
+	for _, name := range names {
+		_, err := os.Stat(name)
+		switch {
+		case err == nil:
+			return name, nil
+		case !os.IsNotExist(err):
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// removeBackup deletes the n-th backup, compressed or not. It returns false if
+// no backup existed.
+func (r *Rotator) removeBackup(n uint) (bool, error) {
+	removed := false
+	for _, name := range []string{r.backupPath(n, false), r.backupPath(n, true)} {
+		err := os.Remove(name)
+		switch {
+		case err == nil:
+			removed = true
+		case !os.IsNotExist(err):
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// isBackup checks if name is a backup of the file written by r, compressed or
+// not. Other files sharing the same prefix are ignored.
+func (r *Rotator) isBackup(name string) bool {
+	name = strings.TrimSuffix(name, compressSuffix)
+	if r.intervalRotator != nil {
+		return r.intervalRotator.IsIntervalLog(r.filename, name)
+	}
+
+	n := strings.TrimPrefix(name, r.filename+".")
+	if n == name {
+		return false
+	}
+	_, err := strconv.ParseUint(n, 10, 64)
+	return err == nil
 }
 
 func (r *Rotator) dir() string {
@@ -279,6 +364,12 @@ func (r *Rotator) openNew() error {
 		return errors.Wrap(err, "failed to make directories for new file")
 	}
 
+	if r.maxAge > 0 {
+		if err := r.purgeExpiredBackups(); err != nil {
+			return err
+		}
+	}
+
 	_, err = os.Stat(r.filename)
 	if err == nil {
 		if !r.rotateOnStartup {
@@ -291,7 +382,15 @@ func (r *Rotator) openNew() error {
 		}
 	}
 
-	return r.openFile()
+	if err := r.openFile(); err != nil {
+		return err
+	}
+	if r.intervalRotator != nil && r.intervalRotator.lastRotate.IsZero() {
+		// A new file starts the current interval. Otherwise the next write
+		// would compare against the zero time and rotate right away.
+		r.intervalRotator.Rotate()
+	}
+	return nil
 }
 
 func (r *Rotator) openFile() error {
@@ -336,14 +435,66 @@ func (r *Rotator) closeFile() error {
 }
 
 func (r *Rotator) purgeOldBackups() error {
+	var err error
 	if r.intervalRotator != nil {
-		return r.purgeOldIntervalBackups()
+		err = r.purgeOldIntervalBackups()
+	} else {
+		err = r.purgeOldSizedBackups()
 	}
-	return r.purgeOldSizedBackups()
+	if err != nil || r.maxAge == 0 {
+		return err
+	}
+	return r.purgeExpiredBackups()
 }
 
-func (r *Rotator) purgeOldIntervalBackups() error {
+// backups lists the existing backup files of r.
+func (r *Rotator) backups() ([]string, error) {
 	files, err := filepath.Glob(r.filename + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := files[:0]
+	for _, f := range files {
+		if r.isBackup(f) {
+			backups = append(backups, f)
+		}
+	}
+	return backups, nil
+}
+
+// purgeExpiredBackups deletes all backups last modified before the max age.
+func (r *Rotator) purgeExpiredBackups() error {
+	files, err := r.backups()
+	if err != nil {
+		return errors.Wrap(err, "failed to list existing backups")
+	}
+
+	cutoff := time.Now().Add(-r.maxAge)
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return errors.Wrapf(err, "failed on %v during purge of expired backups", f)
+		}
+
+		if fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to delete expired backup %v", f)
+		}
+		if r.log != nil {
+			r.log.Debugw("Deleted expired backup", "filename", f, "max_age", r.maxAge)
+		}
+	}
+	return nil
+}
+
+func (r *Rotator) purgeOldIntervalBackups() error {
+	files, err := r.backups()
 	if err != nil {
 		return errors.Wrap(err, "failed to list existing logs during rotation")
 	}
@@ -374,18 +525,12 @@ func (r *Rotator) purgeOldIntervalBackups() error {
 
 func (r *Rotator) purgeOldSizedBackups() error {
 	for i := r.maxBackups; i < MaxBackupsLimit; i++ {
-		name := r.backupName(i + 1)
-
-		_, err := os.Stat(name)
-		switch {
-		case err == nil:
-			if err = os.Remove(name); err != nil {
-				return errors.Wrapf(err, "failed to delete %v during rotation", name)
-			}
-		case os.IsNotExist(err):
+		removed, err := r.removeBackup(i + 1)
+		if err != nil {
+			return errors.Wrapf(err, "failed to delete backup %v during rotation", i+1)
+		}
+		if !removed {
 			return nil
-		default:
-			return errors.Wrapf(err, "failed on %v during rotation", name)
 		}
 	}
 
@@ -439,7 +584,12 @@ func (r *Rotator) rotateByInterval(reason rotateReason) error {
 		targetFilename = logPrefix + strconv.Itoa(int(lastLogIndex)+1)
 	}
 
-	if err := os.Rename(r.filename, targetFilename); err != nil {
+	if r.compress {
+		err = compressFile(r.filename, targetFilename+compressSuffix, r.permissions)
+	} else {
+		err = os.Rename(r.filename, targetFilename)
+	}
+	if err != nil {
 		return errors.Wrap(err, "failed to rotate backups")
 	}
 
@@ -454,19 +604,27 @@ func (r *Rotator) rotateByInterval(reason rotateReason) error {
 
 func (r *Rotator) rotateBySize(reason rotateReason) error {
 	for i := r.maxBackups + 1; i > 0; i-- {
-		old := r.backupName(i - 1)
-		older := r.backupName(i)
-
-		if _, err := os.Stat(old); os.IsNotExist(err) {
-			continue
-		} else if err != nil {
+		old, err := r.existingBackup(i - 1)
+		if err != nil {
 			return errors.Wrap(err, "failed to rotate backups")
 		}
+		if old == "" {
+			continue
+		}
 
-		if err := os.Remove(older); err != nil && !os.IsNotExist(err) {
+		if _, err := r.removeBackup(i); err != nil {
 			return errors.Wrap(err, "failed to rotate backups")
 		}
-		if err := os.Rename(old, older); err != nil {
+
+		switch {
+		case i == 1 && r.compress:
+			// only the active file is uncompressed
+			err = compressFile(old, r.backupPath(i, true), r.permissions)
+		default:
+			// backups keep their compression, even if compress was changed
+			err = os.Rename(old, r.backupPath(i, strings.HasSuffix(old, compressSuffix)))
+		}
+		if err != nil {
 			return errors.Wrap(err, "failed to rotate backups")
 		} else if i == 1 {
 			// Log when rotation of the main file occurs.
@@ -477,3 +635,39 @@ func (r *Rotator) rotateBySize(reason rotateReason) error {
 	}
 	return nil
 }
+
+// compressFile writes the gzip compressed contents of src to dst and removes
+// src. The compressed file is written to a temporary file first, so dst is
+// either missing or complete.
+func compressFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "failed to compress %v", src)
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
@@ -18,6 +18,7 @@
 package file_test
 
 import (
+	"compress/gzip"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -214,6 +215,151 @@ func TestRotateOnStartup(t *testing.T) {
 	AssertDirContents(t, dir, logname, logname+".1")
 }
 
+func TestCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "sample.log")
+	r, err := file.NewFileRotator(filename, file.MaxBackups(2), file.Compress(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	WriteMsg(t, r)
+	Rotate(t, r)
+	AssertDirContents(t, dir, "sample.log.1.gz")
+
+	WriteMsg(t, r)
+	Rotate(t, r)
+	WriteMsg(t, r)
+	Rotate(t, r)
+	AssertDirContents(t, dir, "sample.log.1.gz", "sample.log.2.gz")
+
+	f, err := os.Open(filepath.Join(dir, "sample.log.1.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, logMessage, string(content))
+}
+
+func TestCompressChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress_changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "sample.log")
+	r, err := file.NewFileRotator(filename, file.MaxBackups(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteMsg(t, r)
+	Rotate(t, r)
+	WriteMsg(t, r)
+	Rotate(t, r)
+	r.Close()
+	AssertDirContents(t, dir, "sample.log.1", "sample.log.2")
+
+	// Uncompressed backups are rotated and purged after compress is enabled.
+	r, err = file.NewFileRotator(filename, file.MaxBackups(3), file.Compress(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteMsg(t, r)
+	Rotate(t, r)
+	AssertDirContents(t, dir, "sample.log.1.gz", "sample.log.2", "sample.log.3")
+
+	WriteMsg(t, r)
+	Rotate(t, r)
+	AssertDirContents(t, dir, "sample.log.1.gz", "sample.log.2.gz", "sample.log.3")
+	r.Close()
+
+	// Compressed backups are rotated and purged after compress is disabled.
+	r, err = file.NewFileRotator(filename, file.MaxBackups(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	WriteMsg(t, r)
+	Rotate(t, r)
+	AssertDirContents(t, dir, "sample.log.1", "sample.log.2.gz", "sample.log.3.gz")
+}
+
+func TestCompressDailyRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress_daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logname := "daily"
+	today := time.Now().Format("2006-01-02")
+
+	filename := filepath.Join(dir, logname)
+	r, err := file.NewFileRotator(filename, file.Interval(24*time.Hour), file.Compress(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	WriteMsg(t, r)
+	WriteMsg(t, r)
+	AssertDirContents(t, dir, logname)
+
+	Rotate(t, r)
+	WriteMsg(t, r)
+	Rotate(t, r)
+	AssertDirContents(t, dir,
+		logname+"-"+today+"-1.gz",
+		logname+"-"+today+"-2.gz",
+	)
+}
+
+func TestMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "max_age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logname := "sample.log"
+	old := time.Now().Add(-2 * time.Hour)
+	for _, f := range []string{logname + ".1", logname + ".2.gz", "sample.log.other"} {
+		CreateFile(t, filepath.Join(dir, f))
+		if err := os.Chtimes(filepath.Join(dir, f), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := file.NewFileRotator(filepath.Join(dir, logname), file.MaxAge(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Expired backups are deleted when the file is opened. Files not
+	// created by the rotator are kept.
+	WriteMsg(t, r)
+	AssertDirContents(t, dir, logname, "sample.log.other")
+
+	Rotate(t, r)
+	AssertDirContents(t, dir, logname+".1", "sample.log.other")
+}
+
 func CreateFile(t *testing.T, filename string) {
 	t.Helper()
 	f, err := os.Create(filename)
@@ -42,6 +42,10 @@ import (
 //
 // Default values are given defined by the colon operator. For example:
 // `%{[field.name]:default value}`.
+//
+// The field value can be transformed by functions separated by '|', like
+// `%{[field.name]|snake_case|replace(-,_)}`. Available functions are lower,
+// upper, snake_case and replace. Functions are not applied to default values.
 type EventFormatString struct {
 	expression string
 	formatter  StringFormatter
@@ -51,10 +55,12 @@ type EventFormatString struct {
 
 type eventFieldEvaler struct {
 	index int
+	funcs []stringFunc
 }
 
 type defaultEventFieldEvaler struct {
 	index        int
+	funcs        []stringFunc
 	defaultValue string
 }
 
@@ -309,6 +315,12 @@ func (e *eventFieldCompiler) compileEventField(
 		defaultValue = op.param
 	}
 
+	field, calls := splitFieldFuncs(field)
+	funcs, err := parseStringFuncs(calls)
+	if err != nil {
+		return nil, err
+	}
+
 	path, err := parseEventPath(field)
 	if err != nil {
 		return nil, err
@@ -330,10 +342,10 @@ func (e *eventFieldCompiler) compileEventField(
 	idx := info.index
 
 	if len(ops) == 0 {
-		return &eventFieldEvaler{idx}, nil
+		return &eventFieldEvaler{idx, funcs}, nil
 	}
 
-	return &defaultEventFieldEvaler{idx, defaultValue}, nil
+	return &defaultEventFieldEvaler{idx, funcs, defaultValue}, nil
 }
 
 func (e *eventFieldCompiler) compileTimestamp(
@@ -359,7 +371,7 @@ func (e *eventFieldEvaler) Eval(c interface{}, out *bytes.Buffer) error {
 	}
 
 	ctx := c.(*eventEvalContext)
-	s := ctx.keys[e.index]
+	s := applyFuncs(e.funcs, ctx.keys[e.index])
 	_, err := out.WriteString(s)
 	return err
 }
@@ -373,6 +385,8 @@ func (e *defaultEventFieldEvaler) Eval(c interface{}, out *bytes.Buffer) error {
 	s := ctx.keys[e.index]
 	if s == "" {
 		s = e.defaultValue
+	} else {
+		s = applyFuncs(e.funcs, s)
 	}
 	_, err := out.WriteString(s)
 	return err
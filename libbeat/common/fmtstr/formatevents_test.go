@@ -129,6 +129,37 @@ func TestEventFormatString(t *testing.T) {
 			"2015-05-01T20:12:34.000Z: 2015.05.01",
 			[]string{"@timestamp"},
 		},
+		{
+			"apply functions",
+			"%{[key]|lower} %{[key]|upper} %{[key] | snake_case}",
+			beat.Event{Fields: common.MapStr{"key": "myApp_v2"}},
+			"myapp_v2 MYAPP_V2 my_app__v2",
+			[]string{"key"},
+		},
+		{
+			"apply replace",
+			"%{[key]|replace(-, _)|replace(' ', '')|replace(\\:, \",\")}",
+			beat.Event{Fields: common.MapStr{"key": "a-b c:d"}},
+			"a_bc,d",
+			[]string{"key"},
+		},
+		{
+			"functions are not applied to default",
+			"%{[key]|upper:default}",
+			beat.Event{Fields: common.MapStr{}},
+			"default",
+			nil,
+		},
+		{
+			"write alias from labels",
+			"%{[@metadata.index_type]}-%{[labels.profile]}-%{[labels.project]|snake_case}-$_write",
+			beat.Event{
+				Meta:   common.MapStr{"index_type": "log"},
+				Fields: common.MapStr{"labels": common.MapStr{"profile": "p1", "project": "ShopAPI"}},
+			},
+			"log-p1-_shop_a_p_i-$_write",
+			[]string{"@metadata.index_type", "labels.profile", "labels.project"},
+		},
 	}
 
 	for i, test := range tests {
@@ -185,6 +216,26 @@ func TestEventFormatStringErrors(t *testing.T) {
 			"%{+abc}",
 			false, beat.Event{},
 		},
+		{
+			"unknown function",
+			"%{[field]|camel_case}",
+			false, beat.Event{},
+		},
+		{
+			"missing function separator",
+			"%{[field] lower}",
+			false, beat.Event{},
+		},
+		{
+			"wrong number of arguments",
+			"%{[field]|replace(a)}",
+			false, beat.Event{},
+		},
+		{
+			"arguments not closed",
+			"%{[field]|replace(a, b}",
+			false, beat.Event{},
+		},
 		{
 			"missing required field",
 			"%{[key]}",
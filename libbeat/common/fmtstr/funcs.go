@@ -0,0 +1,190 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fmtstr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// stringFunc transforms the value of an event field.
+type stringFunc func(string) string
+
+// stringFuncs lists the functions available in event field expansions. The
+// functions are applied in order, separated by '|'. For example:
+// `%{[field]|lower|replace(-,_)}`.
+var stringFuncs = map[string]func(args []string) (stringFunc, error){
+	"lower":      noArgs(strings.ToLower),
+	"upper":      noArgs(strings.ToUpper),
+	"snake_case": noArgs(SnakeCase),
+	"replace": func(args []string) (stringFunc, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("replace requires 2 arguments, got %v", len(args))
+		}
+		old, new := args[0], args[1]
+		return func(s string) string { return strings.Replace(s, old, new, -1) }, nil
+	},
+}
+
+func noArgs(fn stringFunc) func(args []string) (stringFunc, error) {
+	return func(args []string) (stringFunc, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("no arguments expected, got %v", len(args))
+		}
+		return fn, nil
+	}
+}
+
+// SnakeCase converts camel case names to snake case. Every upper case letter
+// is replaced by an underscore followed by the lower case letter, and
+// underscores are doubled to keep names unique. For example `myApp_v2`
+// becomes `my_app__v2`.
+func SnakeCase(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 4)
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			b.WriteByte('_')
+			b.WriteRune(unicode.ToLower(r))
+		case r == '_':
+			b.WriteString("__")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// splitFieldFuncs splits a field expansion into the field selector and the
+// functions applied to the field value.
+func splitFieldFuncs(field string) (string, string) {
+	i := 0
+	for i < len(field) && field[i] == '[' {
+		end := strings.IndexByte(field[i:], ']')
+		if end < 0 {
+			return field, ""
+		}
+		i += end + 1
+	}
+	return field[:i], strings.TrimSpace(field[i:])
+}
+
+// parseStringFuncs parses a list of function calls like `|name|name(a, b)`.
+// Arguments can be quoted with single or double quotes to include spaces,
+// commas or parentheses.
+func parseStringFuncs(in string) ([]stringFunc, error) {
+	var funcs []stringFunc
+
+	s := strings.TrimSpace(in)
+	for len(s) > 0 {
+		if s[0] != '|' {
+			return nil, fmt.Errorf("expected '|' before function in '%v'", in)
+		}
+		s = strings.TrimSpace(s[1:])
+
+		end := strings.IndexFunc(s, func(r rune) bool {
+			return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+		})
+		if end < 0 {
+			end = len(s)
+		}
+		name := s[:end]
+		s = strings.TrimSpace(s[end:])
+
+		var args []string
+		if len(s) > 0 && s[0] == '(' {
+			var err error
+			args, s, err = parseFuncArgs(s[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%v in function %v", err, name)
+			}
+		}
+
+		mk, exists := stringFuncs[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown function '%v'", name)
+		}
+		fn, err := mk(args)
+		if err != nil {
+			return nil, fmt.Errorf("%v in function %v", err, name)
+		}
+		funcs = append(funcs, fn)
+		s = strings.TrimSpace(s)
+	}
+	return funcs, nil
+}
+
+// parseFuncArgs parses comma separated arguments up to the closing ')'. It
+// returns the arguments and the remaining input.
+func parseFuncArgs(s string) ([]string, string, error) {
+	var (
+		args   []string
+		arg    strings.Builder
+		quote  rune
+		quoted bool
+	)
+
+	flush := func() {
+		v := arg.String()
+		if !quoted {
+			v = strings.TrimSpace(v)
+		}
+		args = append(args, v)
+		arg.Reset()
+		quoted = false
+	}
+
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, quoted = r, true
+			arg.Reset()
+		case r == ',':
+			flush()
+		case r == ')':
+			if len(args) > 0 || quoted || strings.TrimSpace(arg.String()) != "" {
+				flush()
+			}
+			return args, s[i+1:], nil
+		default:
+			if !quoted {
+				arg.WriteRune(r)
+			}
+		}
+	}
+
+	if quote != 0 {
+		return nil, "", fmt.Errorf("missing closing %c", quote)
+	}
+	return nil, "", fmt.Errorf("missing closing ')'")
+}
+
+func applyFuncs(funcs []stringFunc, s string) string {
+	for _, fn := range funcs {
+		s = fn(s)
+	}
+	return s
+}
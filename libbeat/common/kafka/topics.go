@@ -0,0 +1,61 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
+)
+
+// defaultTopics routes APM events of a project to the trace topic of its
+// profile and skips APM metrics. It is used if neither 'topic' nor 'topics'
+// is configured.
+var defaultTopics = []map[string]interface{}{
+	{
+		"topic": "trace-%{[labels._tag_profileId]}",
+		"when": map[string]interface{}{
+			"and": []map[string]interface{}{
+				{"has_fields": []string{"labels._tag_profileId", "labels._tag_projectName"}},
+				{"not": map[string]interface{}{
+					"equals": map[string]interface{}{"processor.event": "metric"},
+				}},
+			},
+		},
+	},
+}
+
+// BuildTopicSelector creates the topic selector of the Kafka outputs from the
+// 'topic' and 'topics' settings, falling back to the trace topics of APM
+// events.
+func BuildTopicSelector(cfg *common.Config) (outil.Selector, error) {
+	if !cfg.HasField("topic") && !cfg.HasField("topics") {
+		topics, err := common.NewConfigFrom(map[string]interface{}{"topics": defaultTopics})
+		if err != nil {
+			return outil.Selector{}, err
+		}
+		cfg = topics
+	}
+
+	return outil.BuildSelectorFromConfig(cfg, outil.Settings{
+		Key:              "topic",
+		MultiKey:         "topics",
+		EnableSingleOnly: true,
+		FailEmpty:        true,
+		Case:             outil.SelectorKeepCase,
+	})
+}
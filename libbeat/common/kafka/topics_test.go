@@ -0,0 +1,79 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package kafka
+
+import (
+	"testing"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+func TestDefaultTopicSelection(t *testing.T) {
+	cases := map[string]struct {
+		event beat.Event
+		want  string
+	}{
+		"trace routed to profile topic": {
+			event: beat.Event{Fields: common.MapStr{
+				"processor": common.MapStr{"event": "transaction"},
+				"labels": common.MapStr{
+					"_tag_profileId":   "abc",
+					"_tag_projectName": "proj",
+				},
+			}},
+			want: "trace-abc",
+		},
+		"metric is skipped": {
+			event: beat.Event{Fields: common.MapStr{
+				"processor": common.MapStr{"event": "metric"},
+				"labels": common.MapStr{
+					"_tag_profileId":   "abc",
+					"_tag_projectName": "proj",
+				},
+			}},
+			want: "",
+		},
+		"event without project is skipped": {
+			event: beat.Event{Fields: common.MapStr{
+				"labels": common.MapStr{"_tag_profileId": "abc"},
+			}},
+			want: "",
+		},
+	}
+
+	selector, err := BuildTopicSelector(common.NewConfig())
+	if err != nil {
+		t.Fatalf("Failed to build default selector: %v", err)
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := selector.Select(&test.event)
+			if err != nil {
+				t.Fatalf("Failed to create topic name: %v", err)
+			}
+
+			if test.want != got {
+				t.Errorf("Topic name missmatch (want: %v, got: %v)", test.want, got)
+			}
+		})
+	}
+}
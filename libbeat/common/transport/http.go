@@ -0,0 +1,108 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/testing"
+)
+
+// HTTPSettings configures the client created by NewHTTPClient.
+type HTTPSettings struct {
+	Proxy        *url.URL
+	ProxyDisable bool
+	TLS          *tlscommon.TLSConfig
+	Timeout      time.Duration
+
+	// Stats, if set, is notified about all bytes read and written on the
+	// connections of the client.
+	Stats IOStatser
+}
+
+// NewHTTPClient creates an HTTP client dialing through the network and TLS
+// dialers configured by s. The transport of the client is meant to be shared
+// by all requests sent to an endpoint, such that connections are reused.
+func NewHTTPClient(s HTTPSettings) (*http.Client, error) {
+	var dialer, tlsDialer Dialer
+	dialer = NetDialer(s.Timeout)
+	tlsDialer, err := TLSDialer(dialer, s.TLS, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if st := s.Stats; st != nil {
+		dialer = StatsDialer(dialer, st)
+		tlsDialer = StatsDialer(tlsDialer, st)
+	}
+
+	var proxy func(*http.Request) (*url.URL, error)
+	if !s.ProxyDisable {
+		proxy = http.ProxyFromEnvironment
+		if s.Proxy != nil {
+			proxy = http.ProxyURL(s.Proxy)
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial:            dialer.Dial,
+			DialTLS:         tlsDialer.Dial,
+			TLSClientConfig: s.TLS.ToConfig(),
+			Proxy:           proxy,
+		},
+		Timeout: s.Timeout,
+	}, nil
+}
+
+// TestHTTPEndpoint checks that the host of rawURL can be reached and, for
+// https URLs, that a TLS handshake with the host succeeds.
+func TestHTTPEndpoint(
+	d testing.Driver,
+	name, rawURL string,
+	config *tlscommon.TLSConfig,
+	timeout time.Duration,
+) {
+	d.Run(name+": "+rawURL, func(d testing.Driver) {
+		u, err := url.Parse(rawURL)
+		d.Fatal("parse url", err)
+
+		address := u.Host
+
+		d.Run("connection", func(d testing.Driver) {
+			netDialer := TestNetDialer(d, timeout)
+			_, err := netDialer.Dial("tcp", address)
+			d.Fatal("dial up", err)
+		})
+
+		if u.Scheme != "https" {
+			d.Warn("TLS", "secure connection disabled")
+		} else {
+			d.Run("TLS", func(d testing.Driver) {
+				netDialer := NetDialer(timeout)
+				tlsDialer, err := TestTLSDialer(d, netDialer, config, timeout)
+				d.Fatal("setup", err)
+				_, err = tlsDialer.Dial("tcp", address)
+				d.Fatal("dial up", err)
+			})
+		}
+	})
+}
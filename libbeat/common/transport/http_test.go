@@ -0,0 +1,82 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingStatser struct {
+	read, written int
+}
+
+func (s *countingStatser) WriteError(err error) {}
+func (s *countingStatser) WriteBytes(n int)     { s.written += n }
+func (s *countingStatser) ReadError(err error)  {}
+func (s *countingStatser) ReadBytes(n int)      { s.read += n }
+
+func TestNewHTTPClientStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	stats := &countingStatser{}
+	client, err := NewHTTPClient(HTTPSettings{
+		ProxyDisable: true,
+		Timeout:      5 * time.Second,
+		Stats:        stats,
+	})
+	require.NoError(t, err)
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, "ok", string(body))
+	assert.Greater(t, stats.written, 0)
+	assert.Greater(t, stats.read, 0)
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	proxyURL, _ := url.Parse("http://proxy:3128")
+
+	client, err := NewHTTPClient(HTTPSettings{Proxy: proxyURL})
+	require.NoError(t, err)
+	proxy, err := client.Transport.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, proxyURL, proxy)
+
+	client, err = NewHTTPClient(HTTPSettings{Proxy: proxyURL, ProxyDisable: true})
+	require.NoError(t, err)
+	assert.Nil(t, client.Transport.(*http.Transport).Proxy)
+}
@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpauth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Config holds the credentials used to authenticate HTTP requests. At most
+// one authentication method can be configured.
+type Config struct {
+	// Token is sent as is in the Authorization header. Store it in the keystore
+	// and reference it as `${TOKEN}`, to not keep it in the configuration file.
+	Token string `config:"token"`
+
+	// Username and Password enable HTTP basic auth.
+	Username string `config:"username"`
+	Password string `config:"password"`
+
+	OAuth2 *OAuth2Config `config:"oauth2"`
+}
+
+// OAuth2Config configures the OAuth2 client credentials flow. Access tokens
+// are requested from the token URL and refreshed before they expire.
+type OAuth2Config struct {
+	Enabled        *bool               `config:"enabled"`
+	ClientID       string              `config:"client.id"`
+	ClientSecret   string              `config:"client.secret"`
+	TokenURL       string              `config:"token_url"`
+	Scopes         []string            `config:"scopes"`
+	EndpointParams map[string][]string `config:"endpoint_params"`
+}
+
+// IsEnabled returns true if the `enabled` field is not set to false.
+func (c *OAuth2Config) IsEnabled() bool {
+	return c != nil && (c.Enabled == nil || *c.Enabled)
+}
+
+func (c *OAuth2Config) Validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.TokenURL == "" {
+		return errors.New("oauth2 token_url must be set")
+	}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return errors.New("oauth2 client.id and client.secret must be set")
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Username != "" && c.Password == "" {
+		return errors.New("password must be set when username is configured")
+	}
+
+	methods := 0
+	if c.Token != "" {
+		methods++
+	}
+	if c.Username != "" {
+		methods++
+	}
+	if c.OAuth2.IsEnabled() {
+		methods++
+	}
+	if methods > 1 {
+		return fmt.Errorf("only one of token, username or oauth2 can be configured")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package httpauth provides authentication of HTTP requests for outputs and
+// inputs talking to HTTP APIs. Credentials are never exposed by the String
+// method of a provider, such that providers can be logged safely.
+package httpauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Provider adds credentials to HTTP requests. Outputs share a single provider
+// between all their clients, such that OAuth2 access tokens are requested once
+// for all hosts. Token requests are sent with the HTTP client passed to
+// NewProvider, which should use the TLS and proxy settings of the output.
+type Provider interface {
+	// Authorize sets the authentication headers of the request.
+	Authorize(req *http.Request) error
+
+	// String describes the authentication method, without exposing
+	// credentials.
+	String() string
+}
+
+type tokenProvider struct {
+	token string
+}
+
+type basicProvider struct {
+	username string
+	password string
+}
+
+type oauth2Provider struct {
+	tokenURL string
+	source   oauth2.TokenSource
+}
+
+// NewProvider creates the provider for the configured authentication method.
+// The HTTP client is used to request OAuth2 access tokens. If client is nil,
+// http.DefaultClient is used. NewProvider returns nil if no authentication is
+// configured.
+func NewProvider(config Config, client *http.Client) (Provider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.Token != "":
+		return &tokenProvider{token: config.Token}, nil
+
+	case config.Username != "":
+		return &basicProvider{username: config.Username, password: config.Password}, nil
+
+	case config.OAuth2.IsEnabled():
+		if err := config.OAuth2.Validate(); err != nil {
+			return nil, err
+		}
+		if client == nil {
+			client = http.DefaultClient
+		}
+
+		creds := clientcredentials.Config{
+			ClientID:       config.OAuth2.ClientID,
+			ClientSecret:   config.OAuth2.ClientSecret,
+			TokenURL:       config.OAuth2.TokenURL,
+			Scopes:         config.OAuth2.Scopes,
+			EndpointParams: config.OAuth2.EndpointParams,
+		}
+		// The token source caches the access token and requests a new one
+		// shortly before the cached token expires.
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+		return &oauth2Provider{
+			tokenURL: config.OAuth2.TokenURL,
+			source:   creds.TokenSource(ctx),
+		}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func (p *tokenProvider) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", p.token)
+	return nil
+}
+
+func (p *tokenProvider) String() string {
+	return "token"
+}
+
+func (p *basicProvider) Authorize(req *http.Request) error {
+	req.SetBasicAuth(p.username, p.password)
+	return nil
+}
+
+func (p *basicProvider) String() string {
+	return "basic(" + p.username + ")"
+}
+
+// Authorize sets the access token of the request. A new access token is
+// requested if the cached one is about to expire.
+func (p *oauth2Provider) Authorize(req *http.Request) error {
+	token, err := p.source.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get oauth2 access token from %v: %w", p.tokenURL, err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+func (p *oauth2Provider) String() string {
+	return "oauth2(" + p.tokenURL + ")"
+}
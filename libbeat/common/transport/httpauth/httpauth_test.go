@@ -0,0 +1,155 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+func TestNewProviderNone(t *testing.T) {
+	p, err := NewProvider(Config{}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
+
+func TestTokenProvider(t *testing.T) {
+	p, err := NewProvider(Config{Token: "Bearer secret"}, nil)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	require.NoError(t, p.Authorize(req))
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+	assert.NotContains(t, fmt.Sprint(p), "secret")
+}
+
+func TestBasicProvider(t *testing.T) {
+	p, err := NewProvider(Config{Username: "user", Password: "secret"}, nil)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	require.NoError(t, p.Authorize(req))
+	user, pass, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "secret", pass)
+	assert.NotContains(t, fmt.Sprint(p), "secret")
+}
+
+func TestOAuth2ProviderRefresh(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.Form.Get("grant_type"))
+		assert.Equal(t, "events", r.Form.Get("scope"))
+
+		w.Header().Set("Content-Type", "application/json")
+		// Tokens expiring within the refresh margin are requested again on
+		// every use.
+		expiresIn := 3600
+		if requests == 1 {
+			expiresIn = 1
+		}
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, requests, expiresIn)
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(Config{OAuth2: &OAuth2Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		TokenURL:     server.URL,
+		Scopes:       []string{"events"},
+	}}, server.Client())
+	require.NoError(t, err)
+	assert.NotContains(t, fmt.Sprint(p), "secret")
+
+	authorize := func() string {
+		req, _ := http.NewRequest("GET", "http://localhost", nil)
+		require.NoError(t, p.Authorize(req))
+		return req.Header.Get("Authorization")
+	}
+
+	assert.Equal(t, "Bearer token-1", authorize())
+	assert.Equal(t, "Bearer token-2", authorize(), "expiring token must be refreshed")
+	assert.Equal(t, "Bearer token-2", authorize(), "valid token must be reused")
+	assert.Equal(t, 2, requests)
+}
+
+func TestOAuth2ProviderTokenError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(Config{OAuth2: &OAuth2Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		TokenURL:     server.URL,
+	}}, nil)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	err = p.Authorize(req)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), server.URL))
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]struct {
+		cfg map[string]interface{}
+		ok  bool
+	}{
+		"empty":              {map[string]interface{}{}, true},
+		"token":              {map[string]interface{}{"token": "abc"}, true},
+		"basic":              {map[string]interface{}{"username": "u", "password": "p"}, true},
+		"username only":      {map[string]interface{}{"username": "u"}, false},
+		"token and username": {map[string]interface{}{"token": "abc", "username": "u", "password": "p"}, false},
+		"oauth2": {map[string]interface{}{"oauth2": map[string]interface{}{
+			"client.id": "id", "client.secret": "secret", "token_url": "http://localhost/token",
+		}}, true},
+		"oauth2 without token_url": {map[string]interface{}{"oauth2": map[string]interface{}{
+			"client.id": "id", "client.secret": "secret",
+		}}, false},
+		"disabled oauth2 with token": {map[string]interface{}{
+			"token":  "abc",
+			"oauth2": map[string]interface{}{"enabled": false},
+		}, true},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var config Config
+			err := common.MustNewConfigFrom(test.cfg).Unpack(&config)
+			if test.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -57,8 +57,9 @@ type indexManager struct {
 }
 
 type indexSelector struct {
-	sel      outil.Selector
-	beatInfo beat.Info
+	sel        outil.Selector
+	beatInfo   beat.Info
+	dataStream bool
 }
 
 type ilmIndexSelector struct {
@@ -177,6 +178,10 @@ func (s *indexSupport) BuildSelector(cfg *common.Config) (outputs.IndexSelector,
 		}
 	}
 
+	if s.templateCfg.DataStream {
+		return s.buildDataStreamSelector(selCfg, indexName)
+	}
+
 	var alias string
 	mode := s.ilm.Mode()
 	if mode != ilm.ModeDisabled {
@@ -208,7 +213,7 @@ func (s *indexSupport) BuildSelector(cfg *common.Config) (outputs.IndexSelector,
 	}
 
 	if mode != ilm.ModeAuto {
-		return indexSelector{indexSel, s.info}, nil
+		return indexSelector{sel: indexSel, beatInfo: s.info}, nil
 	}
 
 	selCfg.SetString("index", -1, alias)
@@ -220,6 +225,29 @@ func (s *indexSupport) BuildSelector(cfg *common.Config) (outputs.IndexSelector,
 	}, nil
 }
 
+// buildDataStreamSelector selects data streams instead of dated indices or
+// write aliases. ILM policies are attached to the data streams via the index
+// template, so the output keeps writing to the data stream name.
+func (s *indexSupport) buildDataStreamSelector(selCfg *common.Config, name string) (outputs.IndexSelector, error) {
+	if name == "" {
+		name = fmt.Sprintf("%v-%v", s.info.IndexPrefix, s.info.Version)
+	}
+	s.log.Infof("Publishing events to data stream '%s'.", name)
+
+	selCfg.SetString("index", -1, name)
+	sel, err := outil.BuildSelectorFromConfig(selCfg, outil.Settings{
+		Key:              "index",
+		MultiKey:         "indices",
+		EnableSingleOnly: true,
+		FailEmpty:        true,
+		Case:             outil.SelectorLowerCase,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return indexSelector{sel: sel, beatInfo: s.info, dataStream: true}, nil
+}
+
 func (m *indexManager) VerifySetup(loadTemplate, loadILM LoadMode) (bool, string) {
 	ilmComponent := newFeature(componentILM, m.support.enabled(componentILM), m.support.ilm.Overwrite(), loadILM)
 
@@ -284,7 +312,11 @@ func (m *indexManager) Setup(loadTemplate, loadILM LoadMode) error {
 		tmplCfg.Overwrite, tmplCfg.Enabled = templateComponent.overwrite, templateComponent.enabled
 
 		if ilmComponent.enabled {
-			tmplCfg, err = applyILMSettings(log, tmplCfg, m.support.ilm.Policy(), m.support.ilm.Alias())
+			if tmplCfg.DataStream {
+				tmplCfg, err = applyDataStreamILMSettings(log, tmplCfg, m.support.ilm.Policy())
+			} else {
+				tmplCfg, err = applyILMSettings(log, tmplCfg, m.support.ilm.Policy(), m.support.ilm.Alias())
+			}
 			if err != nil {
 				return err
 			}
@@ -298,7 +330,7 @@ func (m *indexManager) Setup(loadTemplate, loadILM LoadMode) error {
 		log.Info("Loaded index template.")
 	}
 
-	if ilmComponent.load {
+	if ilmComponent.load && !m.support.templateCfg.DataStream {
 		// ensure alias is created after the template is created
 		if err := m.ilm.EnsureAlias(); err != nil {
 			if ilm.ErrReason(err) != ilm.ErrAliasAlreadyExists {
@@ -343,6 +375,11 @@ func (s *ilmIndexSelector) Select(evt *beat.Event) (string, error) {
 	return idx, err
 }
 
+// DataStream reports whether the selected indices are data streams.
+func (s indexSelector) DataStream() bool {
+	return s.dataStream
+}
+
 func (s indexSelector) Select(evt *beat.Event) (string, error) {
 	if idx := getEventCustomIndex(evt, s.beatInfo); idx != "" {
 		return idx, nil
@@ -414,6 +451,56 @@ func applyILMSettings(
 
 	// rollover_alias and lifecycle.name can't be configured and will be overwritten
 
+	tmpl, lifecycle, err := copyLifecycleSettings(tmpl)
+	if err != nil {
+		return tmpl, err
+	}
+
+	// add rollover_alias and name to index.lifecycle settings
+	if _, exists := lifecycle["rollover_alias"]; !exists {
+		log.Infof("Set settings.index.lifecycle.rollover_alias in template to %s as ILM is enabled.", alias)
+		lifecycle["rollover_alias"] = alias.Name
+	}
+	if _, exists := lifecycle["name"]; !exists {
+		log.Infof("Set settings.index.lifecycle.name in template to %s as ILM is enabled.", policy)
+		lifecycle["name"] = policy.Name
+	}
+
+	return tmpl, nil
+}
+
+// applyDataStreamILMSettings attaches the ILM policy to the template. Data
+// streams roll over on their own, so neither a rollover alias nor a custom
+// template name and pattern are required.
+func applyDataStreamILMSettings(
+	log *logp.Logger,
+	tmpl template.TemplateConfig,
+	policy ilm.Policy,
+) (template.TemplateConfig, error) {
+	if !tmpl.Enabled {
+		return tmpl, nil
+	}
+
+	if policy.Name == "" {
+		return tmpl, errors.New("no ilm policy name configured")
+	}
+
+	tmpl, lifecycle, err := copyLifecycleSettings(tmpl)
+	if err != nil {
+		return tmpl, err
+	}
+
+	if _, exists := lifecycle["name"]; !exists {
+		log.Infof("Set settings.index.lifecycle.name in template to %s as ILM is enabled.", policy)
+		lifecycle["name"] = policy.Name
+	}
+
+	return tmpl, nil
+}
+
+// copyLifecycleSettings copies the index settings of the template and returns
+// the copied index.lifecycle settings, so they can be modified safely.
+func copyLifecycleSettings(tmpl template.TemplateConfig) (template.TemplateConfig, map[string]interface{}, error) {
 	// init/copy index settings
 	idxSettings := tmpl.Settings.Index
 	if idxSettings == nil {
@@ -437,19 +524,9 @@ func applyILMSettings(
 			lifecycle[k] = v
 		}
 	} else {
-		return tmpl, errors.New("settings.index.lifecycle must be an object")
+		return tmpl, nil, errors.New("settings.index.lifecycle must be an object")
 	}
 	idxSettings["lifecycle"] = lifecycle
 
-	// add rollover_alias and name to index.lifecycle settings
-	if _, exists := lifecycle["rollover_alias"]; !exists {
-		log.Infof("Set settings.index.lifecycle.rollover_alias in template to %s as ILM is enabled.", alias)
-		lifecycle["rollover_alias"] = alias.Name
-	}
-	if _, exists := lifecycle["name"]; !exists {
-		log.Infof("Set settings.index.lifecycle.name in template to %s as ILM is enabled.", policy)
-		lifecycle["name"] = policy.Name
-	}
-
-	return tmpl, nil
+	return tmpl, lifecycle, nil
 }
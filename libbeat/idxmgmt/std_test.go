@@ -29,6 +29,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/common"
 	"github.com/snappyflow/beats/v7/libbeat/idxmgmt/ilm"
 	"github.com/snappyflow/beats/v7/libbeat/mapping"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
 	"github.com/snappyflow/beats/v7/libbeat/template"
 )
 
@@ -133,6 +134,8 @@ func TestDefaultSupport_BuildSelector(t *testing.T) {
 		cfg      map[string]interface{}
 		want     nameFunc
 		meta     common.MapStr
+
+		dataStream bool
 	}{
 		"without ilm": {
 			ilmCalls: noILM,
@@ -230,11 +233,31 @@ func TestDefaultSupport_BuildSelector(t *testing.T) {
 			},
 			want: stable("myindex"),
 		},
+		"data stream with ilm": {
+			ilmCalls: ilmTemplateSettings("test-9.9.9", "test-9.9.9"),
+			imCfg: map[string]interface{}{
+				"setup.template.type":        "index",
+				"setup.template.data_stream": true,
+			},
+			cfg:        map[string]interface{}{},
+			want:       stable("test-9.9.9"),
+			dataStream: true,
+		},
+		"data stream keeps configured index": {
+			ilmCalls: ilmTemplateSettings("test-9.9.9", "test-9.9.9"),
+			imCfg: map[string]interface{}{
+				"setup.template.type":        "index",
+				"setup.template.data_stream": true,
+			},
+			cfg:        map[string]interface{}{"index": "logs-%{[agent.version]}"},
+			want:       stable("logs-9.9.9"),
+			dataStream: true,
+		},
 	}
 	for name, test := range cases {
 		t.Run(name, func(t *testing.T) {
 			ts := time.Now()
-			info := beat.Info{Beat: "test", Version: "9.9.9"}
+			info := beat.Info{Beat: "test", IndexPrefix: "test", Version: "9.9.9"}
 
 			factory := MakeDefaultSupport(makeMockILMSupport(test.ilmCalls...))
 			im, err := factory(nil, info, common.MustNewConfigFrom(test.imCfg))
@@ -256,6 +279,9 @@ func TestDefaultSupport_BuildSelector(t *testing.T) {
 			})
 			require.NoError(t, err)
 			assert.Equal(t, test.want(ts), idx)
+
+			ds, ok := sel.(outputs.DataStreamSelector)
+			assert.Equal(t, test.dataStream, ok && ds.DataStream())
 		})
 	}
 }
@@ -464,12 +490,46 @@ func TestIndexManager_Setup(t *testing.T) {
 			loadTemplate: LoadModeDisabled,
 			loadILM:      LoadModeDisabled,
 		},
+		"data stream template ilm default": {
+			cfg: common.MapStr{
+				"setup.template.type":        "index",
+				"setup.template.data_stream": true,
+			},
+			tmplCfg: cfgWith(template.DefaultConfig(), map[string]interface{}{
+				"overwrite":                     "true",
+				"type":                          "index",
+				"data_stream":                   true,
+				"settings.index.lifecycle.name": "test",
+			}),
+			policy: "test",
+		},
+		"data stream template ilm disabled": {
+			cfg: common.MapStr{
+				"setup.template.type":        "index",
+				"setup.template.data_stream": true,
+				"setup.ilm.enabled":          false,
+			},
+			loadTemplate: LoadModeEnabled,
+			tmplCfg: cfgWith(template.DefaultConfig(), map[string]interface{}{
+				"type":        "index",
+				"data_stream": true,
+			}),
+		},
+		"data stream requires index template type": {
+			cfg: common.MapStr{
+				"setup.template.data_stream": true,
+			},
+			err: true,
+		},
 	}
 	for name, test := range cases {
 		t.Run(name, func(t *testing.T) {
 			info := beat.Info{Beat: "test", Version: "9.9.9"}
 			factory := MakeDefaultSupport(ilm.StdSupport)
 			im, err := factory(nil, info, common.MustNewConfigFrom(test.cfg))
+			if test.err && err != nil {
+				return
+			}
 			require.NoError(t, err)
 
 			clientHandler := newMockClientHandler()
@@ -0,0 +1,196 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/snappyflow/beats/v7/libbeat/asset"
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+// Encoder serializes a beat.Event to Avro binary encoding, based on a record
+// schema.
+type Encoder struct {
+	codec   *goavro.Codec
+	schema  *schemaType
+	version string
+	config  Config
+}
+
+// Config is used to pass encoding parameters to New.
+type Config struct {
+	Schema SchemaConfig `config:"schema"`
+
+	// Framing selects the header written before each encoded event.
+	Framing  string `config:"framing"`
+	SchemaID int    `config:"schema_id"`
+}
+
+// SchemaConfig selects the schema of the encoded events. The schema is read
+// from Path if set, otherwise it is generated from the field definitions of
+// the beat.
+type SchemaConfig struct {
+	Path string `config:"path"`
+
+	// Fields lists the fields and groups of the field definitions included
+	// in a generated schema. All fields are included if empty.
+	Fields    []string `config:"fields"`
+	Name      string   `config:"name"`
+	Namespace string   `config:"namespace"`
+}
+
+// Framing formats of encoded events.
+const (
+	FramingNone         = "none"
+	FramingConfluent    = "confluent"
+	FramingSingleObject = "single_object"
+)
+
+var defaultConfig = Config{
+	Schema: SchemaConfig{
+		Name: "event",
+	},
+	Framing: FramingNone,
+}
+
+func init() {
+	codec.RegisterType("avro", func(info beat.Info, cfg *common.Config) (codec.Codec, error) {
+		config := defaultConfig
+		if cfg != nil {
+			if err := cfg.Unpack(&config); err != nil {
+				return nil, err
+			}
+		}
+
+		schema, err := loadSchema(info, config.Schema)
+		if err != nil {
+			return nil, err
+		}
+		return New(info.Version, schema, config)
+	})
+}
+
+func (c *Config) Validate() error {
+	switch c.Framing {
+	case FramingNone, FramingSingleObject:
+	case FramingConfluent:
+		if c.SchemaID <= 0 {
+			return fmt.Errorf("schema_id is required with framing %v", FramingConfluent)
+		}
+	default:
+		return fmt.Errorf("framing '%v' unknown (try %v, %v, %v)",
+			c.Framing, FramingNone, FramingConfluent, FramingSingleObject)
+	}
+
+	if c.Schema.Path != "" && len(c.Schema.Fields) > 0 {
+		return fmt.Errorf("schema.fields can not be used with schema.path")
+	}
+	return nil
+}
+
+// loadSchema reads the schema file, or generates a schema from the field
+// definitions of the beat.
+func loadSchema(info beat.Info, config SchemaConfig) (string, error) {
+	if config.Path != "" {
+		schema, err := ioutil.ReadFile(config.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read avro schema: %v", err)
+		}
+		return string(schema), nil
+	}
+
+	fieldsYml, err := asset.GetFields(info.Beat)
+	if err != nil {
+		return "", fmt.Errorf("failed to load field definitions: %v", err)
+	}
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = avroName(info.Beat)
+	}
+	return SchemaFromFields(fieldsYml, config.Name, namespace, config.Fields)
+}
+
+// New creates a new avro Encoder. The schema must define a record.
+func New(version, schema string, config Config) (*Encoder, error) {
+	avroCodec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %v", err)
+	}
+
+	parsed, err := parseSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.kind != "record" {
+		return nil, fmt.Errorf("avro schema must define a record, found %v", parsed.kind)
+	}
+
+	return &Encoder{
+		codec:   avroCodec,
+		schema:  parsed,
+		version: version,
+		config:  config,
+	}, nil
+}
+
+// Encode serializes a beat event to Avro. Fields of the event not defined by
+// the schema are not encoded. The event metadata is available as the
+// `metadata` field, and the timestamp as the `timestamp` field.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	native, err := e.schema.native(makeDocument(index, e.version, event))
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.config.Framing {
+	case FramingConfluent:
+		buf := make([]byte, 5, 256)
+		binary.BigEndian.PutUint32(buf[1:], uint32(e.config.SchemaID))
+		return e.codec.BinaryFromNative(buf, native)
+	case FramingSingleObject:
+		return e.codec.SingleFromNative(nil, native)
+	default:
+		return e.codec.BinaryFromNative(nil, native)
+	}
+}
+
+func makeDocument(index, version string, event *beat.Event) common.MapStr {
+	meta := common.MapStr{
+		"beat":    index,
+		"type":    "_doc",
+		"version": version,
+	}
+	for k, v := range event.Meta {
+		meta[k] = v
+	}
+
+	doc := make(common.MapStr, len(event.Fields)+2)
+	for k, v := range event.Fields {
+		doc[k] = v
+	}
+	doc["@timestamp"] = event.Timestamp
+	doc["@metadata"] = meta
+	return doc
+}
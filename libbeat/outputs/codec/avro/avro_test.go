@@ -0,0 +1,285 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/asset"
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+const testSchema = `{
+  "type": "record",
+  "name": "event",
+  "namespace": "test",
+  "fields": [
+    {"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "metadata", "type": {"type": "map", "values": "string"}},
+    {"name": "message", "type": "string"},
+    {"name": "level", "type": "string", "default": "info"},
+    {"name": "count", "type": ["null", "long"], "default": null},
+    {"name": "tags", "type": ["null", {"type": "array", "items": "string"}], "default": null},
+    {"name": "host", "type": ["null", {
+      "type": "record",
+      "name": "host",
+      "fields": [
+        {"name": "name", "type": "string"},
+        {"name": "cpus", "type": ["null", "int"], "default": null}
+      ]
+    }], "default": null}
+  ]
+}`
+
+var testTime = time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+
+func testEvent() *beat.Event {
+	return &beat.Event{
+		Timestamp: testTime,
+		Meta:      common.MapStr{"pipeline": "logs"},
+		Fields: common.MapStr{
+			"message": "hello",
+			"count":   3,
+			"tags":    []string{"a", "b"},
+			"host":    common.MapStr{"name": "web-1", "cpus": 4, "os": "linux"},
+			"unknown": "not in schema",
+		},
+	}
+}
+
+func decode(t *testing.T, schema string, data []byte) map[string]interface{} {
+	c, err := goavro.NewCodec(schema)
+	require.NoError(t, err)
+	native, rest, err := c.NativeFromBinary(data)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	return native.(map[string]interface{})
+}
+
+func TestEncodeWithSchema(t *testing.T) {
+	enc, err := New("9.9.9", testSchema, defaultConfig)
+	require.NoError(t, err)
+
+	data, err := enc.Encode("testbeat", testEvent())
+	require.NoError(t, err)
+
+	record := decode(t, testSchema, data)
+	assert.Equal(t, testTime, record["timestamp"].(time.Time).UTC())
+	assert.Equal(t, map[string]interface{}{
+		"beat":     "testbeat",
+		"type":     "_doc",
+		"version":  "9.9.9",
+		"pipeline": "logs",
+	}, record["metadata"])
+	assert.Equal(t, "hello", record["message"])
+	assert.Equal(t, "info", record["level"])
+	assert.Equal(t, map[string]interface{}{"long": int64(3)}, record["count"])
+	assert.Equal(t, map[string]interface{}{"array": []interface{}{"a", "b"}}, record["tags"])
+	assert.Equal(t, map[string]interface{}{
+		"test.host": map[string]interface{}{
+			"name": "web-1",
+			"cpus": map[string]interface{}{"int": int32(4)},
+		},
+	}, record["host"])
+}
+
+func TestEncodeErrors(t *testing.T) {
+	enc, err := New("9.9.9", testSchema, defaultConfig)
+	require.NoError(t, err)
+
+	_, err = enc.Encode("testbeat", &beat.Event{Timestamp: testTime, Fields: common.MapStr{}})
+	assert.Error(t, err, "missing required field must fail")
+
+	_, err = enc.Encode("testbeat", &beat.Event{Timestamp: testTime, Fields: common.MapStr{
+		"message": "hello",
+		"count":   "many",
+	}})
+	assert.Error(t, err, "invalid type must fail")
+}
+
+func TestFraming(t *testing.T) {
+	t.Run("confluent", func(t *testing.T) {
+		config := defaultConfig
+		config.Framing = FramingConfluent
+		config.SchemaID = 42
+		enc, err := New("9.9.9", testSchema, config)
+		require.NoError(t, err)
+
+		data, err := enc.Encode("testbeat", testEvent())
+		require.NoError(t, err)
+		assert.Equal(t, byte(0), data[0])
+		assert.Equal(t, uint32(42), binary.BigEndian.Uint32(data[1:5]))
+		assert.Equal(t, "hello", decode(t, testSchema, data[5:])["message"])
+	})
+
+	t.Run("single object", func(t *testing.T) {
+		config := defaultConfig
+		config.Framing = FramingSingleObject
+		enc, err := New("9.9.9", testSchema, config)
+		require.NoError(t, err)
+
+		data, err := enc.Encode("testbeat", testEvent())
+		require.NoError(t, err)
+
+		c, err := goavro.NewCodec(testSchema)
+		require.NoError(t, err)
+		native, _, err := c.NativeFromSingle(data)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", native.(map[string]interface{})["message"])
+	})
+}
+
+const testFieldsYml = `
+- key: base
+  title: Base
+  fields:
+    - name: "@timestamp"
+      type: date
+    - name: message
+      type: text
+    - name: host
+      type: group
+      fields:
+        - name: name
+          type: keyword
+        - name: os.type
+          type: keyword
+    - name: labels
+      type: object
+      object_type: keyword
+- key: system
+  title: System
+  fields:
+    - name: host
+      type: group
+      fields:
+        - name: cpus
+          type: long
+    - name: system.cpu
+      type: group
+      fields:
+        - name: total.pct
+          type: scaled_float
+        - name: start
+          type: date
+        - name: alias
+          type: alias
+          path: system.cpu.total.pct
+`
+
+func TestSchemaFromFields(t *testing.T) {
+	schema, err := SchemaFromFields([]byte(testFieldsYml), "event", "testbeat", nil)
+	require.NoError(t, err)
+
+	enc, err := New("9.9.9", schema, defaultConfig)
+	require.NoError(t, err)
+
+	data, err := enc.Encode("testbeat", &beat.Event{
+		Timestamp: testTime,
+		Fields: common.MapStr{
+			"message": "hello",
+			"host":    common.MapStr{"name": "web-1", "os": common.MapStr{"type": "linux"}, "cpus": 4},
+			"labels":  common.MapStr{"env": "prod"},
+			"system": common.MapStr{
+				"cpu": common.MapStr{"total": common.MapStr{"pct": 0.5}, "start": testTime},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	record := decode(t, schema, data)
+	assert.Equal(t, map[string]interface{}{"string": "hello"}, record["message"])
+	assert.Equal(t, map[string]interface{}{"map": map[string]interface{}{"env": "prod"}}, record["labels"])
+
+	host := record["host"].(map[string]interface{})["testbeat.event.host"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"string": "web-1"}, host["name"])
+	assert.Equal(t, map[string]interface{}{"long": int64(4)}, host["cpus"], "groups defined twice must be merged")
+
+	cpu := record["system"].(map[string]interface{})["testbeat.event.system"].(map[string]interface{})["cpu"]
+	cpu = cpu.(map[string]interface{})["testbeat.event.system.cpu"]
+	assert.Equal(t, map[string]interface{}{"double": 0.5}, cpu.(map[string]interface{})["total"].(map[string]interface{})["testbeat.event.system.cpu.total"].(map[string]interface{})["pct"])
+	assert.NotContains(t, cpu, "alias")
+}
+
+func TestSchemaFromFieldsInclude(t *testing.T) {
+	schema, err := SchemaFromFields([]byte(testFieldsYml), "event", "testbeat", []string{"message", "host.os"})
+	require.NoError(t, err)
+
+	var spec struct {
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(schema), &spec))
+
+	var names []string
+	for _, f := range spec.Fields {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"timestamp", "metadata", "message", "host"}, names)
+	assert.Contains(t, string(spec.Fields[3].Type), `"os"`)
+	assert.NotContains(t, string(spec.Fields[3].Type), `"cpus"`)
+}
+
+func TestCreateEncoder(t *testing.T) {
+	data, err := asset.EncodeData(testFieldsYml)
+	require.NoError(t, err)
+	require.NoError(t, asset.SetFields("avrotest", "base", asset.BeatFieldsPri, func() string { return data }))
+
+	info := beat.Info{Beat: "avrotest", Version: "9.9.9"}
+	enc, err := codec.CreateEncoder(info, codec.Config{Namespace: configNamespace(t, "avro", common.MapStr{
+		"schema.fields": []string{"message"},
+	})})
+	require.NoError(t, err)
+	_, err = enc.Encode("avrotest", &beat.Event{Timestamp: testTime, Fields: common.MapStr{"message": "hello"}})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "event.avsc")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testSchema), 0600))
+	enc, err = codec.CreateEncoder(info, codec.Config{Namespace: configNamespace(t, "avro", common.MapStr{
+		"schema.path": path,
+		"framing":     "confluent",
+		"schema_id":   1,
+	})})
+	require.NoError(t, err)
+	_, err = enc.Encode("avrotest", testEvent())
+	assert.NoError(t, err)
+
+	_, err = codec.CreateEncoder(info, codec.Config{Namespace: configNamespace(t, "avro", common.MapStr{
+		"framing": "confluent",
+	})})
+	assert.Error(t, err, "confluent framing requires schema_id")
+}
+
+func configNamespace(t *testing.T, name string, settings common.MapStr) common.ConfigNamespace {
+	var ns common.ConfigNamespace
+	require.NoError(t, common.MustNewConfigFrom(common.MapStr{name: settings}).Unpack(&ns))
+	return ns
+}
@@ -0,0 +1,188 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/snappyflow/beats/v7/libbeat/mapping"
+)
+
+// fieldNode is a field or group of the field definitions. Groups defined
+// multiple times are merged.
+type fieldNode struct {
+	name     string
+	field    *mapping.Field
+	children []*fieldNode
+	index    map[string]*fieldNode
+}
+
+func (n *fieldNode) child(name string) *fieldNode {
+	if c, ok := n.index[name]; ok {
+		return c
+	}
+	c := &fieldNode{name: name, index: map[string]*fieldNode{}}
+	n.index[name] = c
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *fieldNode) add(prefix string, fields mapping.Fields) {
+	for i := range fields {
+		f := &fields[i]
+		if f.Type == "alias" {
+			continue
+		}
+
+		node := n
+		for _, part := range strings.Split(f.Name, ".") {
+			node = node.child(part)
+		}
+		path := prefix + f.Name
+
+		if f.Type == "group" || (f.Type == "" && len(f.Fields) > 0) {
+			node.add(path+".", f.Fields)
+			continue
+		}
+		if node.field == nil && len(node.children) == 0 {
+			node.field = f
+			node.field.Path = path
+		}
+	}
+}
+
+// SchemaFromFields generates an Avro record schema from field definitions in
+// the fields.yml format. Groups are mapped to nested records. All fields are
+// optional, except @timestamp. If include is not empty, only the listed
+// fields and groups are part of the schema.
+func SchemaFromFields(fieldsYml []byte, name, namespace string, include []string) (string, error) {
+	fields, err := mapping.LoadFields(fieldsYml)
+	if err != nil {
+		return "", err
+	}
+
+	root := &fieldNode{index: map[string]*fieldNode{}}
+	root.add("", fields)
+
+	recordFields := []interface{}{
+		map[string]interface{}{
+			"name": "timestamp",
+			"type": map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"},
+		},
+		optionalField("metadata", map[string]interface{}{"type": "map", "values": "string"}),
+	}
+	for _, child := range root.children {
+		if child.name == "@timestamp" {
+			continue
+		}
+		if f := child.schemaField(fullName(name, namespace), include); f != nil {
+			recordFields = append(recordFields, f)
+		}
+	}
+
+	schema, err := json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"name":      name,
+		"namespace": namespace,
+		"fields":    recordFields,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(schema), nil
+}
+
+// schemaField returns the optional record field of a field or group, or nil
+// if it is not included.
+func (n *fieldNode) schemaField(namespace string, include []string) map[string]interface{} {
+	name := avroName(n.name)
+	if n.field != nil {
+		if !included(n.field.Path, include) {
+			return nil
+		}
+		return optionalField(name, fieldType(n.field))
+	}
+
+	record := fullName(name, namespace)
+	var fields []interface{}
+	for _, child := range n.children {
+		if f := child.schemaField(record, include); f != nil {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return optionalField(name, map[string]interface{}{
+		"type":   "record",
+		"name":   record,
+		"fields": fields,
+	})
+}
+
+func optionalField(name string, typ interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":    name,
+		"type":    []interface{}{"null", typ},
+		"default": nil,
+	}
+}
+
+// fieldType maps the type of a field definition to an Avro type.
+func fieldType(f *mapping.Field) interface{} {
+	switch f.Type {
+	case "long", "integer", "short", "byte":
+		return "long"
+	case "float", "double", "half_float", "scaled_float":
+		return "double"
+	case "boolean":
+		return "boolean"
+	case "date":
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}
+	case "object", "flattened":
+		return map[string]interface{}{"type": "map", "values": objectType(f.ObjectType)}
+	case "array":
+		return map[string]interface{}{"type": "array", "items": objectType(f.ObjectType)}
+	}
+	return "string"
+}
+
+func objectType(t string) string {
+	switch t {
+	case "long", "integer", "short", "byte":
+		return "long"
+	case "float", "double", "half_float", "scaled_float":
+		return "double"
+	case "boolean":
+		return "boolean"
+	}
+	return "string"
+}
+
+func included(path string, include []string) bool {
+	if len(include) == 0 {
+		return true
+	}
+	for _, prefix := range include {
+		if path == prefix || strings.HasPrefix(path, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,476 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package avro
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+// schemaType is a parsed Avro schema, used to convert event fields to the
+// native types expected by goavro.
+type schemaType struct {
+	kind    string // primitive type, record, enum, fixed, array, map or union
+	logical string
+	name    string // full name of named types
+
+	fields  []schemaField // record
+	items   *schemaType   // array
+	values  *schemaType   // map
+	members []*schemaType // union
+}
+
+type schemaField struct {
+	name       string
+	typ        *schemaType
+	hasDefault bool
+}
+
+var primitiveTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+func parseSchema(schema string) (*schemaType, error) {
+	var spec interface{}
+	if err := json.Unmarshal([]byte(schema), &spec); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %v", err)
+	}
+	return parseType(spec, "", map[string]*schemaType{})
+}
+
+func parseType(spec interface{}, namespace string, names map[string]*schemaType) (*schemaType, error) {
+	switch spec := spec.(type) {
+	case string:
+		if primitiveTypes[spec] {
+			return &schemaType{kind: spec}, nil
+		}
+		if t, ok := names[fullName(spec, namespace)]; ok {
+			return t, nil
+		}
+		if t, ok := names[spec]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown avro type '%v'", spec)
+
+	case []interface{}:
+		union := &schemaType{kind: "union"}
+		for _, member := range spec {
+			t, err := parseType(member, namespace, names)
+			if err != nil {
+				return nil, err
+			}
+			union.members = append(union.members, t)
+		}
+		return union, nil
+
+	case map[string]interface{}:
+		kind, _ := spec["type"].(string)
+		switch kind {
+		case "record", "error", "enum", "fixed":
+			name, _ := spec["name"].(string)
+			if ns, ok := spec["namespace"].(string); ok && !strings.Contains(name, ".") {
+				namespace = ns
+			}
+			name = fullName(name, namespace)
+			if i := strings.LastIndexByte(name, '.'); i >= 0 {
+				namespace = name[:i]
+			}
+
+			t := &schemaType{kind: kind, name: name}
+			if kind == "error" {
+				t.kind = "record"
+			}
+			names[name] = t
+			if t.kind != "record" {
+				return t, nil
+			}
+
+			fields, _ := spec["fields"].([]interface{})
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				fieldName, _ := field["name"].(string)
+				fieldType, err := parseType(field["type"], namespace, names)
+				if err != nil {
+					return nil, fmt.Errorf("field %v of %v: %v", fieldName, name, err)
+				}
+				_, hasDefault := field["default"]
+				t.fields = append(t.fields, schemaField{name: fieldName, typ: fieldType, hasDefault: hasDefault})
+			}
+			return t, nil
+
+		case "array":
+			items, err := parseType(spec["items"], namespace, names)
+			if err != nil {
+				return nil, err
+			}
+			return &schemaType{kind: "array", items: items}, nil
+
+		case "map":
+			values, err := parseType(spec["values"], namespace, names)
+			if err != nil {
+				return nil, err
+			}
+			return &schemaType{kind: "map", values: values}, nil
+		}
+
+		t, err := parseType(spec["type"], namespace, names)
+		if err != nil {
+			return nil, err
+		}
+		if logical, ok := spec["logicalType"].(string); ok && primitiveTypes[t.kind] {
+			return &schemaType{kind: t.kind, logical: logical}, nil
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("invalid avro type %v", spec)
+}
+
+func fullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// unionName returns the name of a member type used to select the union
+// member when encoding.
+func (t *schemaType) unionName() string {
+	switch {
+	case t.name != "":
+		return t.name
+	case t.logical != "":
+		return t.kind + "." + t.logical
+	}
+	return t.kind
+}
+
+// native converts a value to the native representation of the schema type
+// expected by goavro.
+func (t *schemaType) native(v interface{}) (interface{}, error) {
+	switch t.kind {
+	case "null":
+		if v != nil {
+			return nil, fmt.Errorf("expected null, found %T", v)
+		}
+		return nil, nil
+
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+
+	case "int", "long":
+		switch t.logical {
+		case "timestamp-millis", "timestamp-micros", "date":
+			return toTime(v)
+		}
+		if i, ok := toInt(v); ok {
+			if t.kind == "int" {
+				if i < math.MinInt32 || i > math.MaxInt32 {
+					return nil, fmt.Errorf("value %v out of int range", i)
+				}
+				return int32(i), nil
+			}
+			return i, nil
+		}
+
+	case "float", "double":
+		if f, ok := toFloat(v); ok {
+			if t.kind == "float" {
+				return float32(f), nil
+			}
+			return f, nil
+		}
+
+	case "string", "enum":
+		return toString(v)
+
+	case "bytes", "fixed":
+		switch v := v.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+
+	case "array":
+		rv := reflect.ValueOf(v)
+		if v == nil || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+			// Single values are accepted for arrays, as fields can hold a
+			// value or a list of values.
+			item, err := t.items.native(v)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			item, err := t.items.native(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+
+	case "map":
+		if m, ok := toMapStr(v); ok {
+			out := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				value, err := t.values.native(v)
+				if err != nil {
+					return nil, fmt.Errorf("%v: %v", k, err)
+				}
+				out[k] = value
+			}
+			return out, nil
+		}
+
+	case "record":
+		return t.nativeRecord(v)
+
+	case "union":
+		return t.nativeUnion(v)
+	}
+	return nil, fmt.Errorf("can not encode %T as %v", v, t.kind)
+}
+
+// nativeRecord converts a map to a record. Map keys are matched to record
+// fields after replacing characters not allowed in Avro names, such that
+// @timestamp is read for the field timestamp.
+func (t *schemaType) nativeRecord(v interface{}) (interface{}, error) {
+	m, ok := toMapStr(v)
+	if !ok {
+		return nil, fmt.Errorf("can not encode %T as record %v", v, t.name)
+	}
+
+	byName := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		name := avroName(k)
+		if _, exists := byName[name]; !exists || name == k {
+			byName[name] = v
+		}
+	}
+
+	record := make(map[string]interface{}, len(t.fields))
+	for _, field := range t.fields {
+		value, exists := byName[field.name]
+		if !exists || value == nil {
+			if field.hasDefault {
+				// goavro encodes the default of fields not set.
+				continue
+			}
+			if !field.typ.nullable() {
+				return nil, fmt.Errorf("missing required field %v of %v", field.name, t.name)
+			}
+		}
+
+		native, err := field.typ.native(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", field.name, err)
+		}
+		record[field.name] = native
+	}
+	return record, nil
+}
+
+// nativeUnion converts a value to the first member of the union that
+// accepts it.
+func (t *schemaType) nativeUnion(v interface{}) (interface{}, error) {
+	var lastErr error
+	for _, member := range t.members {
+		if member.kind == "null" {
+			if v == nil {
+				return nil, nil
+			}
+			continue
+		}
+		if v == nil {
+			continue
+		}
+
+		native, err := member.native(v)
+		if err == nil {
+			return goavro.Union(member.unionName(), native), nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no union member accepts %T", v)
+	}
+	return nil, lastErr
+}
+
+func (t *schemaType) nullable() bool {
+	if t.kind == "null" {
+		return true
+	}
+	if t.kind == "union" {
+		for _, member := range t.members {
+			if member.kind == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// avroName replaces all characters not allowed in Avro names with
+// underscores. A leading @ is removed.
+func avroName(name string) string {
+	name = strings.TrimPrefix(name, "@")
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case common.Time:
+		return time.Time(v), nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	}
+	if i, ok := toInt(v); ok {
+		return time.Unix(0, i*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("can not encode %T as timestamp", v)
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float64:
+		return int64(v), v == math.Trunc(v)
+	case float32:
+		return int64(v), float64(v) == math.Trunc(float64(v))
+	case common.Float:
+		return int64(v), float64(v) == math.Trunc(float64(v))
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case common.Float:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	if i, ok := toInt(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// toString converts scalar values to strings. Structured values are encoded
+// as JSON.
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func toMapStr(v interface{}) (common.MapStr, bool) {
+	switch m := v.(type) {
+	case common.MapStr:
+		return m, true
+	case map[string]interface{}:
+		return common.MapStr(m), true
+	}
+	return nil, false
+}
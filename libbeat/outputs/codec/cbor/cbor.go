@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/elastic/go-structform"
+	"github.com/elastic/go-structform/cborl"
+	"github.com/elastic/go-structform/gotype"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+// Encoder for serializing a beat.Event to CBOR. Encoded events are self
+// delimiting and can be concatenated into a single stream.
+type Encoder struct {
+	buf    bytes.Buffer
+	folder *gotype.Iterator
+
+	version string
+}
+
+func init() {
+	codec.RegisterType("cbor", func(info beat.Info, cfg *common.Config) (codec.Codec, error) {
+		return New(info.Version), nil
+	})
+}
+
+// New creates a new CBOR Encoder.
+func New(version string) *Encoder {
+	e := &Encoder{version: version}
+	e.reset()
+	return e
+}
+
+func (e *Encoder) reset() {
+	visitor := cborl.NewVisitor(&e.buf)
+
+	// timestamps are encoded with nanosecond precision, so they are restored
+	// unchanged by the Decoder.
+	var err error
+	e.folder, err = gotype.NewIterator(visitor,
+		gotype.Folders(
+			foldTimestamp,
+			func(t *common.Time, v structform.ExtVisitor) error {
+				return foldTimestamp((*time.Time)(t), v)
+			},
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func foldTimestamp(t *time.Time, v structform.ExtVisitor) error {
+	return v.OnString(t.UTC().Format(time.RFC3339Nano))
+}
+
+// Encode serializes a beat event to CBOR. It adds additional metadata in the
+// `@metadata` namespace, like the json codec.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	e.buf.Reset()
+	err := e.folder.Fold(makeEvent(index, e.version, event))
+	if err != nil {
+		e.reset()
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// SelfDelimiting reports that encoded events must not be separated by
+// newlines.
+func (e *Encoder) SelfDelimiting() bool { return true }
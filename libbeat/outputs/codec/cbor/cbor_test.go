@@ -0,0 +1,116 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ts := time.Date(2020, 5, 4, 3, 2, 1, 123456789, time.UTC)
+	events := []beat.Event{
+		{
+			Timestamp: ts,
+			Meta:      common.MapStr{"pipeline": "p1", "_id": "abc"},
+			Fields: common.MapStr{
+				"message": "hello",
+				"count":   uint64(42),
+				"delta":   int64(-3),
+				"ratio":   0.25,
+				"ok":      true,
+				"missing": nil,
+				"tags":    []interface{}{"a", "b"},
+				"host": common.MapStr{
+					"name": "web-1",
+					"os":   common.MapStr{"family": "linux"},
+				},
+				"items": []interface{}{common.MapStr{"id": uint64(1)}},
+			},
+		},
+		{
+			Timestamp: ts.Add(time.Second),
+			Fields:    common.MapStr{"message": "second"},
+		},
+	}
+
+	var buf bytes.Buffer
+	enc := New("7.9.0")
+	for i := range events {
+		data, err := enc.Encode("testbeat", &events[i])
+		require.NoError(t, err)
+		buf.Write(data)
+	}
+
+	dec := NewDecoder(&buf)
+	for _, expected := range events {
+		index, event, err := dec.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, "testbeat", index)
+		assert.True(t, expected.Timestamp.Equal(event.Timestamp))
+		assert.Equal(t, expected.Meta, event.Meta)
+		assert.Equal(t, expected.Fields, event.Fields)
+	}
+
+	_, _, err := dec.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data, err := New("7.9.0").Encode("testbeat", &beat.Event{
+		Timestamp: time.Now(),
+		Fields:    common.MapStr{"message": "hello"},
+	})
+	require.NoError(t, err)
+
+	_, _, err = NewDecoder(bytes.NewReader(data[:len(data)-3])).Decode()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestDecodeInvalidTimestamp(t *testing.T) {
+	enc := New("7.9.0")
+	require.NoError(t, enc.folder.Fold(common.MapStr{"@timestamp": 12}))
+
+	_, _, err := NewDecoder(&enc.buf).Decode()
+	assert.Error(t, err)
+}
+
+func TestSeparator(t *testing.T) {
+	assert.Nil(t, codec.Separator(New("7.9.0")))
+}
+
+func TestCreateEncoder(t *testing.T) {
+	cfg, err := common.NewConfigWithYAML([]byte("cbor: ~"), "test")
+	require.NoError(t, err)
+
+	var config codec.Config
+	require.NoError(t, cfg.Unpack(&config))
+
+	enc, err := codec.CreateEncoder(beat.Info{Beat: "testbeat", Version: "7.9.0"}, config)
+	require.NoError(t, err)
+	assert.IsType(t, &Encoder{}, enc)
+}
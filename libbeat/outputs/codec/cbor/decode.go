@@ -0,0 +1,239 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/elastic/go-structform/cborl"
+	"github.com/elastic/go-structform/gotype"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+// Decoder reads events from a stream of events encoded by the cbor codec.
+type Decoder struct {
+	in       *bufio.Reader
+	buf      bytes.Buffer
+	parser   *cborl.Parser
+	unfolder *gotype.Unfolder
+	doc      map[string]interface{}
+}
+
+var errInvalidItem = errors.New("invalid CBOR data item")
+
+// maxStringLen limits the size of strings, to not allocate unbounded
+// buffers when reading corrupted events.
+const maxStringLen = 1 << 30
+
+// NewDecoder creates a Decoder reading events from r.
+func NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{in: bufio.NewReader(r)}
+	d.reset()
+	return d
+}
+
+func (d *Decoder) reset() {
+	unfolder, err := gotype.NewUnfolder(nil)
+	if err != nil {
+		panic(err) // can not happen
+	}
+
+	d.unfolder = unfolder
+	d.parser = cborl.NewParser(unfolder)
+}
+
+// Decode reads the next event. It returns io.EOF once all events have been
+// read. The metadata added by the encoder is removed from the event, and the
+// index the event was encoded for is returned.
+func (d *Decoder) Decode() (string, beat.Event, error) {
+	d.doc = nil
+	d.unfolder.SetTarget(&d.doc)
+	defer d.unfolder.Reset()
+
+	if _, err := d.in.Peek(1); err != nil {
+		return "", beat.Event{}, err
+	}
+
+	d.buf.Reset()
+	if err := readItem(d.in, &d.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", beat.Event{}, err
+	}
+
+	if err := d.parser.Parse(d.buf.Bytes()); err != nil {
+		d.reset() // reset parser just in case
+		return "", beat.Event{}, err
+	}
+	return makeBeatEvent(d.doc)
+}
+
+// readItem copies the next complete CBOR data item from r to buf. The parser
+// is only given complete events, so events can be read from a stream without
+// any framing.
+func readItem(r *bufio.Reader, buf *bytes.Buffer) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(b)
+
+	major, info := b>>5, b&0x1f
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		for i := 0; i < size; i++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(c)
+			n = n<<8 | uint64(c)
+		}
+	case info == 31 && major >= 2 && major <= 5:
+		// indefinite length, terminated by a break code
+		for {
+			next, err := r.Peek(1)
+			if err != nil {
+				return err
+			}
+			if next[0] == 0xff {
+				r.ReadByte()
+				buf.WriteByte(0xff)
+				return nil
+			}
+			if err := readItem(r, buf); err != nil {
+				return err
+			}
+		}
+	default:
+		return errInvalidItem
+	}
+
+	switch major {
+	case 2, 3: // byte and text strings
+		if n > maxStringLen {
+			return errInvalidItem
+		}
+		copied, err := io.CopyN(buf, r, int64(n))
+		if err == nil && uint64(copied) != n {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	case 4: // array
+		return readItems(r, buf, n)
+	case 5: // map
+		return readItems(r, buf, 2*n)
+	case 6: // tag
+		return readItem(r, buf)
+	}
+	return nil
+}
+
+func readItems(r *bufio.Reader, buf *bytes.Buffer, n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if err := readItem(r, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func makeBeatEvent(doc map[string]interface{}) (string, beat.Event, error) {
+	var event beat.Event
+
+	if ts, exists := doc["@timestamp"]; exists {
+		str, ok := ts.(string)
+		if !ok {
+			return "", event, fmt.Errorf("invalid @timestamp type %T", ts)
+		}
+
+		var err error
+		event.Timestamp, err = time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return "", event, fmt.Errorf("invalid @timestamp: %v", err)
+		}
+		delete(doc, "@timestamp")
+	}
+
+	var index string
+	if m, exists := doc["@metadata"]; exists {
+		meta, ok := m.(map[string]interface{})
+		if !ok {
+			return "", event, fmt.Errorf("invalid @metadata type %T", m)
+		}
+		index, _ = meta["beat"].(string)
+		delete(meta, "beat")
+		delete(meta, "type")
+		delete(meta, "version")
+		if len(meta) > 0 {
+			event.Meta = toMapStr(meta)
+		}
+		delete(doc, "@metadata")
+	}
+
+	event.Fields = toMapStr(doc)
+	return index, event, nil
+}
+
+// toMapStr converts decoded objects to common.MapStr, recursively. Integers
+// are decoded into the smallest type holding the value, and are converted to
+// int64 or uint64.
+func toMapStr(m map[string]interface{}) common.MapStr {
+	for k, v := range m {
+		m[k] = normalize(v)
+	}
+	return common.MapStr(m)
+}
+
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return toMapStr(v)
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = normalize(elem)
+		}
+		return v
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+// makeEvent creates the document encoded for an event. It uses the structure
+// of the json codec. A map is used instead of a struct with inline fields, as
+// CBOR requires the number of entries of an object to be known upfront.
+func makeEvent(index, version string, in *beat.Event) common.MapStr {
+	meta := make(common.MapStr, len(in.Meta)+3)
+	for k, v := range in.Meta {
+		meta[k] = v
+	}
+	meta["beat"] = index
+	meta["type"] = "_doc"
+	meta["version"] = version
+
+	doc := make(common.MapStr, len(in.Fields)+2)
+	for k, v := range in.Fields {
+		doc[k] = v
+	}
+	doc["@timestamp"] = in.Timestamp
+	doc["@metadata"] = meta
+	return doc
+}
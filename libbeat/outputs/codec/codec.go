@@ -22,3 +22,20 @@ import "github.com/snappyflow/beats/v7/libbeat/beat"
 type Codec interface {
 	Encode(index string, event *beat.Event) ([]byte, error)
 }
+
+// SelfDelimiting is implemented by codecs whose encoded events can be
+// concatenated without a separator, like binary encodings.
+type SelfDelimiting interface {
+	SelfDelimiting() bool
+}
+
+var newline = []byte("\n")
+
+// Separator returns the bytes written after each event encoded by c, when
+// writing a stream of events.
+func Separator(c Codec) []byte {
+	if d, ok := c.(SelfDelimiting); ok && d.SelfDelimiting() {
+		return nil
+	}
+	return newline
+}
@@ -0,0 +1,335 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protobuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+const timestampMessage protoreflect.FullName = "google.protobuf.Timestamp"
+
+// setMessage sets the fields of msg from the document m. Keys are matched to
+// the field names after removing a leading @ and replacing characters not
+// valid in protobuf identifiers with an underscore. Keys with no matching
+// field are ignored.
+func setMessage(msg protoreflect.Message, m common.MapStr) error {
+	fields := msg.Descriptor().Fields()
+	for k, v := range m {
+		if v == nil {
+			continue
+		}
+
+		name := fieldName(k)
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil {
+			continue
+		}
+
+		var err error
+		switch {
+		case fd.IsMap():
+			err = setMap(msg.Mutable(fd).Map(), fd, v)
+		case fd.IsList():
+			err = setList(msg.Mutable(fd).List(), fd, v)
+		default:
+			var value protoreflect.Value
+			value, err = newValue(fd, v, func() protoreflect.Value { return msg.NewField(fd) })
+			if err == nil {
+				msg.Set(fd, value)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("field %v: %v", k, err)
+		}
+	}
+	return nil
+}
+
+func setMap(m protoreflect.Map, fd protoreflect.FieldDescriptor, v interface{}) error {
+	values, ok := toMapStr(v)
+	if !ok {
+		return fmt.Errorf("can not encode %T as map", v)
+	}
+
+	keyDesc, valueDesc := fd.MapKey(), fd.MapValue()
+	for k, v := range values {
+		if v == nil {
+			continue
+		}
+
+		key, err := newValue(keyDesc, k, nil)
+		if err != nil {
+			return err
+		}
+		value, err := newValue(valueDesc, v, m.NewValue)
+		if err != nil {
+			return fmt.Errorf("key %v: %v", k, err)
+		}
+		m.Set(key.MapKey(), value)
+	}
+	return nil
+}
+
+func setList(l protoreflect.List, fd protoreflect.FieldDescriptor, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		// A single value is encoded as a list of one element.
+		value, err := newValue(fd, v, l.NewElement)
+		if err != nil {
+			return err
+		}
+		l.Append(value)
+		return nil
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		elem := rv.Index(i).Interface()
+		if elem == nil {
+			continue
+		}
+		value, err := newValue(fd, elem, l.NewElement)
+		if err != nil {
+			return fmt.Errorf("index %v: %v", i, err)
+		}
+		l.Append(value)
+	}
+	return nil
+}
+
+// newValue converts v to a value of the kind of fd. newMessage allocates the
+// message for message fields.
+func newValue(fd protoreflect.FieldDescriptor, v interface{}, newMessage func() protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		switch b := v.(type) {
+		case bool:
+			return protoreflect.ValueOfBool(b), nil
+		case string:
+			if parsed, err := strconv.ParseBool(b); err == nil {
+				return protoreflect.ValueOfBool(parsed), nil
+			}
+		}
+
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if i, ok := toInt(v); ok && i >= math.MinInt32 && i <= math.MaxInt32 {
+			return protoreflect.ValueOfInt32(int32(i)), nil
+		}
+
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if i, ok := toInt(v); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if i, ok := toInt(v); ok && i >= 0 && i <= math.MaxUint32 {
+			return protoreflect.ValueOfUint32(uint32(i)), nil
+		}
+
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if u, ok := v.(uint64); ok {
+			return protoreflect.ValueOfUint64(u), nil
+		}
+		if i, ok := toInt(v); ok && i >= 0 {
+			return protoreflect.ValueOfUint64(uint64(i)), nil
+		}
+
+	case protoreflect.FloatKind:
+		if f, ok := toFloat(v); ok {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+
+	case protoreflect.DoubleKind:
+		if f, ok := toFloat(v); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+
+	case protoreflect.StringKind:
+		s, err := toString(v)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfString(s), nil
+
+	case protoreflect.BytesKind:
+		switch b := v.(type) {
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		case string:
+			return protoreflect.ValueOfBytes([]byte(b)), nil
+		}
+
+	case protoreflect.EnumKind:
+		if s, ok := v.(string); ok {
+			if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+				return protoreflect.ValueOfEnum(ev.Number()), nil
+			}
+		} else if i, ok := toInt(v); ok && i >= math.MinInt32 && i <= math.MaxInt32 {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+		}
+
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		value := newMessage()
+		msg := value.Message()
+		if fd.Message().FullName() == timestampMessage {
+			return value, setTimestamp(msg, v)
+		}
+
+		m, ok := toMapStr(v)
+		if !ok {
+			break
+		}
+		return value, setMessage(msg, m)
+	}
+
+	return protoreflect.Value{}, fmt.Errorf("can not encode %T as %v", v, fd.Kind())
+}
+
+func setTimestamp(msg protoreflect.Message, v interface{}) error {
+	var ts time.Time
+	switch v := v.(type) {
+	case time.Time:
+		ts = v
+	case common.Time:
+		ts = time.Time(v)
+	case string:
+		var err error
+		if ts, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return err
+		}
+	default:
+		ms, ok := toInt(v)
+		if !ok {
+			return fmt.Errorf("can not encode %T as timestamp", v)
+		}
+		ts = time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	fields := msg.Descriptor().Fields()
+	msg.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(ts.Unix()))
+	msg.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(ts.Nanosecond())))
+	return nil
+}
+
+// fieldName converts an event key to a valid protobuf identifier.
+func fieldName(name string) string {
+	name = strings.TrimPrefix(name, "@")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float64:
+		return int64(v), v == math.Trunc(v)
+	case float32:
+		return int64(v), float64(v) == math.Trunc(float64(v))
+	case common.Float:
+		return int64(v), float64(v) == math.Trunc(float64(v))
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case common.Float:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	if i, ok := toInt(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// toString converts scalar values to strings. Structured values are encoded
+// as JSON.
+func toString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func toMapStr(v interface{}) (common.MapStr, bool) {
+	switch m := v.(type) {
+	case common.MapStr:
+		return m, true
+	case map[string]interface{}:
+		return common.MapStr(m), true
+	}
+	return nil, false
+}
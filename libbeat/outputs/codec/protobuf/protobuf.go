@@ -0,0 +1,219 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protobuf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+// Encoder serializes a beat.Event to a protobuf message described by a
+// descriptor set.
+type Encoder struct {
+	desc    protoreflect.MessageDescriptor
+	header  []byte
+	version string
+	config  Config
+}
+
+// Config is used to pass encoding parameters to New.
+type Config struct {
+	// DescriptorSet is the path of a FileDescriptorSet, as written by
+	// protoc --include_imports --descriptor_set_out.
+	DescriptorSet string `config:"descriptor_set" validate:"required"`
+
+	// Message is the full name of the message events are encoded to.
+	Message string `config:"message" validate:"required"`
+
+	// Framing selects the header written before each encoded event.
+	Framing  string `config:"framing"`
+	SchemaID int    `config:"schema_id"`
+}
+
+// Framing formats of encoded events.
+const (
+	FramingNone            = "none"
+	FramingLengthDelimited = "length_delimited"
+	FramingConfluent       = "confluent"
+)
+
+var defaultConfig = Config{
+	Framing: FramingNone,
+}
+
+func init() {
+	codec.RegisterType("protobuf", func(info beat.Info, cfg *common.Config) (codec.Codec, error) {
+		config := defaultConfig
+		if cfg != nil {
+			if err := cfg.Unpack(&config); err != nil {
+				return nil, err
+			}
+		}
+
+		desc, err := loadMessageDescriptor(config.DescriptorSet, config.Message)
+		if err != nil {
+			return nil, err
+		}
+		return New(info.Version, desc, config), nil
+	})
+}
+
+func (c *Config) Validate() error {
+	switch c.Framing {
+	case FramingNone, FramingLengthDelimited:
+	case FramingConfluent:
+		if c.SchemaID <= 0 {
+			return fmt.Errorf("schema_id is required with framing %v", FramingConfluent)
+		}
+	default:
+		return fmt.Errorf("framing '%v' unknown (try %v, %v, %v)",
+			c.Framing, FramingNone, FramingLengthDelimited, FramingConfluent)
+	}
+	return nil
+}
+
+// loadMessageDescriptor reads a FileDescriptorSet and returns the descriptor
+// of the named message.
+func loadMessageDescriptor(path, message string) (protoreflect.MessageDescriptor, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %v", err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %v: %v", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %v: %v", path, err)
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %v not found in %v: %v", message, path, err)
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a message", message)
+	}
+	return msgDesc, nil
+}
+
+// New creates a new protobuf Encoder for the message described by desc.
+func New(version string, desc protoreflect.MessageDescriptor, config Config) *Encoder {
+	var header []byte
+	if config.Framing == FramingConfluent {
+		header = make([]byte, 5)
+		binary.BigEndian.PutUint32(header[1:], uint32(config.SchemaID))
+		header = appendMessageIndexes(header, desc)
+	}
+
+	return &Encoder{
+		desc:    desc,
+		header:  header,
+		version: version,
+		config:  config,
+	}
+}
+
+// appendMessageIndexes appends the path of the message within its file, as
+// required by the Confluent wire format.
+func appendMessageIndexes(b []byte, desc protoreflect.MessageDescriptor) []byte {
+	var indexes []int
+	for d := protoreflect.Descriptor(desc); ; {
+		indexes = append([]int{d.Index()}, indexes...)
+		parent, ok := d.Parent().(protoreflect.MessageDescriptor)
+		if !ok {
+			break
+		}
+		d = parent
+	}
+
+	// The common case of the first message in the file is encoded as a
+	// single 0.
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(b, 0)
+	}
+	b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(len(indexes))))
+	for _, i := range indexes {
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(i)))
+	}
+	return b
+}
+
+// Encode serializes a beat event to protobuf. Event fields are set on the
+// message fields of the same name. Fields not defined by the message are
+// not encoded. The event metadata is available as the `metadata` field, and
+// the timestamp as the `timestamp` field.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	msg := dynamicpb.NewMessage(e.desc)
+	if err := setMessage(msg, makeDocument(index, e.version, event)); err != nil {
+		return nil, err
+	}
+
+	switch e.config.Framing {
+	case FramingLengthDelimited:
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		buf := protowire.AppendVarint(make([]byte, 0, len(data)+binary.MaxVarintLen64), uint64(len(data)))
+		return append(buf, data...), nil
+	default:
+		buf := append(make([]byte, 0, 256), e.header...)
+		return proto.MarshalOptions{}.MarshalAppend(buf, msg)
+	}
+}
+
+// SelfDelimiting reports whether encoded events can be concatenated without
+// separator.
+func (e *Encoder) SelfDelimiting() bool {
+	return e.config.Framing == FramingLengthDelimited
+}
+
+func makeDocument(index, version string, event *beat.Event) common.MapStr {
+	meta := common.MapStr{
+		"beat":    index,
+		"type":    "_doc",
+		"version": version,
+	}
+	for k, v := range event.Meta {
+		meta[k] = v
+	}
+
+	doc := make(common.MapStr, len(event.Fields)+2)
+	for k, v := range event.Fields {
+		doc[k] = v
+	}
+	doc["@timestamp"] = event.Timestamp
+	doc["@metadata"] = meta
+	return doc
+}
@@ -0,0 +1,297 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protobuf
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     typ.Enum(),
+		Label:    label.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+const (
+	optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+)
+
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("event.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Event"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("timestamp", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".google.protobuf.Timestamp"),
+					field("message", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("count", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+					field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+					field("host", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".test.Event.Host"),
+					field("metadata", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".test.Event.MetadataEntry"),
+					field("level", 7, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".test.Level"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("Host"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+						},
+					},
+					{
+						Name: proto.String("MetadataEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+							field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+				},
+			},
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Level"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("ERROR"), Number: proto.Int32(1)},
+				},
+			},
+		},
+	}
+
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+			file,
+		},
+	}
+}
+
+func writeDescriptorSet(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "protobuf-codec")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	raw, err := proto.Marshal(testDescriptorSet())
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "event.desc")
+	require.NoError(t, ioutil.WriteFile(path, raw, 0600))
+	return path
+}
+
+func testEvent(ts time.Time) *beat.Event {
+	return &beat.Event{
+		Timestamp: ts,
+		Meta:      common.MapStr{"pipeline": "p1"},
+		Fields: common.MapStr{
+			"message": "hello",
+			"count":   uint64(42),
+			"tags":    []string{"a", "b"},
+			"host":    common.MapStr{"name": "web-1", "ip": "10.0.0.1"},
+			"level":   "ERROR",
+			"unknown": true,
+		},
+	}
+}
+
+func decode(t *testing.T, desc protoreflect.MessageDescriptor, data []byte) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(desc)
+	require.NoError(t, proto.Unmarshal(data, msg))
+	return msg
+}
+
+func TestEncode(t *testing.T) {
+	path := writeDescriptorSet(t)
+	desc, err := loadMessageDescriptor(path, "test.Event")
+	require.NoError(t, err)
+
+	ts := time.Date(2020, 5, 4, 3, 2, 1, 500000000, time.UTC)
+	enc := New("7.9.0", desc, defaultConfig)
+	data, err := enc.Encode("testbeat", testEvent(ts))
+	require.NoError(t, err)
+
+	msg := decode(t, desc, data)
+	fields := desc.Fields()
+	assert.Equal(t, "hello", msg.Get(fields.ByName("message")).String())
+	assert.Equal(t, int64(42), msg.Get(fields.ByName("count")).Int())
+	assert.Equal(t, protoreflect.EnumNumber(1), msg.Get(fields.ByName("level")).Enum())
+
+	tags := msg.Get(fields.ByName("tags")).List()
+	require.Equal(t, 2, tags.Len())
+	assert.Equal(t, "b", tags.Get(1).String())
+
+	host := msg.Get(fields.ByName("host")).Message()
+	assert.Equal(t, "web-1", host.Get(host.Descriptor().Fields().ByName("name")).String())
+
+	tsMsg := msg.Get(fields.ByName("timestamp")).Message()
+	tsFields := tsMsg.Descriptor().Fields()
+	assert.Equal(t, ts.Unix(), tsMsg.Get(tsFields.ByName("seconds")).Int())
+	assert.Equal(t, int64(500000000), tsMsg.Get(tsFields.ByName("nanos")).Int())
+
+	meta := msg.Get(fields.ByName("metadata")).Map()
+	assert.Equal(t, "testbeat", meta.Get(protoreflect.ValueOfString("beat").MapKey()).String())
+	assert.Equal(t, "p1", meta.Get(protoreflect.ValueOfString("pipeline").MapKey()).String())
+}
+
+func TestEncodeErrors(t *testing.T) {
+	path := writeDescriptorSet(t)
+	desc, err := loadMessageDescriptor(path, "test.Event")
+	require.NoError(t, err)
+	enc := New("7.9.0", desc, defaultConfig)
+
+	cases := map[string]common.MapStr{
+		"invalid number":  {"count": "many"},
+		"invalid message": {"host": "web-1"},
+		"invalid enum":    {"level": "FATAL"},
+	}
+	for name, fields := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := enc.Encode("testbeat", &beat.Event{Timestamp: time.Now(), Fields: fields})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFraming(t *testing.T) {
+	path := writeDescriptorSet(t)
+	ts := time.Date(2020, 5, 4, 3, 2, 1, 0, time.UTC)
+
+	t.Run("length_delimited", func(t *testing.T) {
+		desc, err := loadMessageDescriptor(path, "test.Event")
+		require.NoError(t, err)
+
+		data, err := New("7.9.0", desc, Config{Framing: FramingLengthDelimited}).Encode("testbeat", testEvent(ts))
+		require.NoError(t, err)
+
+		size, n := protowire.ConsumeVarint(data)
+		require.True(t, n > 0)
+		assert.Equal(t, uint64(len(data)-n), size)
+		decode(t, desc, data[n:])
+	})
+
+	t.Run("confluent top level message", func(t *testing.T) {
+		desc, err := loadMessageDescriptor(path, "test.Event")
+		require.NoError(t, err)
+
+		data, err := New("7.9.0", desc, Config{Framing: FramingConfluent, SchemaID: 7}).Encode("testbeat", testEvent(ts))
+		require.NoError(t, err)
+
+		assert.Equal(t, byte(0), data[0])
+		assert.Equal(t, uint32(7), binary.BigEndian.Uint32(data[1:5]))
+		assert.Equal(t, byte(0), data[5])
+		decode(t, desc, data[6:])
+	})
+
+	t.Run("confluent nested message", func(t *testing.T) {
+		desc, err := loadMessageDescriptor(path, "test.Event.Host")
+		require.NoError(t, err)
+
+		data, err := New("7.9.0", desc, Config{Framing: FramingConfluent, SchemaID: 7}).Encode("testbeat", &beat.Event{
+			Fields: common.MapStr{"name": "web-1"},
+		})
+		require.NoError(t, err)
+
+		// Two indexes: Event is message 0 of the file, Host message 0 of Event.
+		assert.Equal(t, []byte{4, 0, 0}, data[5:8])
+		msg := decode(t, desc, data[8:])
+		assert.Equal(t, "web-1", msg.Get(desc.Fields().ByName("name")).String())
+	})
+}
+
+func TestCreateEncoder(t *testing.T) {
+	path := writeDescriptorSet(t)
+
+	t.Run("valid", func(t *testing.T) {
+		cfg := common.MustNewConfigFrom(map[string]interface{}{
+			"protobuf.descriptor_set": path,
+			"protobuf.message":        "test.Event",
+		})
+		var config codec.Config
+		require.NoError(t, cfg.Unpack(&config))
+
+		enc, err := codec.CreateEncoder(beat.Info{Beat: "testbeat", Version: "7.9.0"}, config)
+		require.NoError(t, err)
+		_, err = enc.Encode("testbeat", testEvent(time.Now()))
+		assert.NoError(t, err)
+	})
+
+	errCases := map[string]map[string]interface{}{
+		"missing message": {
+			"protobuf.descriptor_set": path,
+		},
+		"unknown message": {
+			"protobuf.descriptor_set": path,
+			"protobuf.message":        "test.Missing",
+		},
+		"not a message": {
+			"protobuf.descriptor_set": path,
+			"protobuf.message":        "test.Level",
+		},
+		"confluent without schema id": {
+			"protobuf.descriptor_set": path,
+			"protobuf.message":        "test.Event",
+			"protobuf.framing":        "confluent",
+		},
+		"unknown framing": {
+			"protobuf.descriptor_set": path,
+			"protobuf.message":        "test.Event",
+			"protobuf.framing":        "xml",
+		},
+	}
+	for name, settings := range errCases {
+		t.Run(name, func(t *testing.T) {
+			var config codec.Config
+			require.NoError(t, common.MustNewConfigFrom(settings).Unpack(&config))
+			_, err := codec.CreateEncoder(beat.Info{Beat: "testbeat", Version: "7.9.0"}, config)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -40,6 +40,7 @@ type console struct {
 	observer outputs.Observer
 	writer   *bufio.Writer
 	codec    codec.Codec
+	sep      []byte
 	index    string
 }
 
@@ -96,8 +97,8 @@ func makeConsole(
 	return outputs.Success(config.BatchSize, 0, c)
 }
 
-func newConsole(index string, observer outputs.Observer, codec codec.Codec) (*console, error) {
-	c := &console{log: logp.NewLogger("console"), out: os.Stdout, codec: codec, observer: observer, index: index}
+func newConsole(index string, observer outputs.Observer, enc codec.Codec) (*console, error) {
+	c := &console{log: logp.NewLogger("console"), out: os.Stdout, codec: enc, sep: codec.Separator(enc), observer: observer, index: index}
 	c.writer = bufio.NewWriterSize(c.out, 8*1024)
 	return c, nil
 }
@@ -125,8 +126,6 @@ func (c *console) Publish(_ context.Context, batch publisher.Batch) error {
 	return nil
 }
 
-var nl = []byte("\n")
-
 func (c *console) publishEvent(event *publisher.Event) bool {
 	serializedEvent, err := c.codec.Encode(c.index, &event.Content)
 	if err != nil {
@@ -145,13 +144,13 @@ func (c *console) publishEvent(event *publisher.Event) bool {
 		return false
 	}
 
-	if err := c.writeBuffer(nl); err != nil {
+	if err := c.writeBuffer(c.sep); err != nil {
 		c.observer.WriteError(err)
 		c.log.Errorf("Error when appending newline to event: %+v", err)
 		return false
 	}
 
-	c.observer.WriteBytes(len(serializedEvent) + 1)
+	c.observer.WriteBytes(len(serializedEvent) + len(c.sep))
 	return true
 }
 
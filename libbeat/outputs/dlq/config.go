@@ -0,0 +1,83 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dlq
+
+import (
+	"fmt"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/file"
+)
+
+// Config configures the dead letter queue shared by all outputs.
+type Config struct {
+	Enabled bool       `config:"enabled"`
+	File    FileConfig `config:"file"`
+
+	// Output optionally forwards dead letters to a second output.
+	Output common.ConfigNamespace `config:"output"`
+}
+
+// FileConfig configures the rotating file dead letters are written to.
+type FileConfig struct {
+	Path          string `config:"path"`
+	Filename      string `config:"filename"`
+	RotateEveryKb uint   `config:"rotate_every_kb" validate:"min=1"`
+	NumberOfFiles uint   `config:"number_of_files"`
+	Permissions   uint32 `config:"permissions"`
+}
+
+// DefaultFilename is the name of the dead letter file if none is configured.
+const DefaultFilename = "dead_letter.ndjson"
+
+// DefaultConfig returns the default dead letter queue settings.
+func DefaultConfig() Config {
+	return Config{
+		Enabled: false,
+		File:    DefaultFileConfig(),
+	}
+}
+
+// DefaultFileConfig returns the default settings of dead letter files.
+func DefaultFileConfig() FileConfig {
+	return FileConfig{
+		Filename:      DefaultFilename,
+		RotateEveryKb: 10 * 1024,
+		NumberOfFiles: 7,
+		Permissions:   0600,
+	}
+}
+
+func (c *FileConfig) Validate() error {
+	if c.NumberOfFiles < 2 || c.NumberOfFiles > file.MaxBackupsLimit {
+		return fmt.Errorf("The number_of_files to keep should be between 2 and %v",
+			file.MaxBackupsLimit)
+	}
+	return nil
+}
+
+// ReadConfig unpacks the `dead_letter_queue` settings, applying the defaults.
+func ReadConfig(cfg *common.Config) (Config, error) {
+	config := DefaultConfig()
+	if cfg != nil {
+		if err := cfg.Unpack(&config); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
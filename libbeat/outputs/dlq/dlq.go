@@ -0,0 +1,193 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package dlq provides the dead letter queue outputs hand events to, that
+// cannot be published and will not succeed if retried. Dead letters are
+// written to a rotating file and optionally forwarded to a second output.
+// The file can be replayed using the `dlq replay` command.
+package dlq
+
+import (
+	"sync"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+)
+
+// Reasons for handing an event to the dead letter queue.
+const (
+	// ReasonRejected is used for events rejected by the destination with a
+	// non-retriable error.
+	ReasonRejected = "rejected"
+
+	// ReasonEncoding is used for events the output failed to encode.
+	ReasonEncoding = "encoding"
+
+	// ReasonExported is used for events exported from an on-disk queue.
+	ReasonExported = "exported"
+)
+
+const logSelector = "dlq"
+
+// Entry is a single dead letter.
+type Entry struct {
+	Timestamp time.Time  `json:"@timestamp"`
+	Output    string     `json:"output"`
+	Reason    string     `json:"reason"`
+	Target    string     `json:"target,omitempty"` // index or topic of the event
+	Error     string     `json:"error,omitempty"`
+	Event     beat.Event `json:"-"`
+}
+
+// Sink stores dead letters.
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// Queue hands dead letters to all configured sinks.
+type Queue struct {
+	log   *logp.Logger
+	sinks []Sink
+
+	events *monitoring.Uint
+	errors *monitoring.Uint
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultQueue *Queue
+)
+
+// Load creates the dead letter queue from the `dead_letter_queue` settings.
+// It returns nil if the queue is disabled. The index manager is passed to the
+// second output, if configured.
+func Load(im outputs.IndexManager, info beat.Info, cfg *common.Config) (*Queue, error) {
+	config, err := ReadConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	fileSink, err := NewFileSink(config.File)
+	if err != nil {
+		return nil, err
+	}
+	sinks := []Sink{fileSink}
+
+	if config.Output.IsSet() {
+		outSink, err := NewOutputSink(im, info, config.Output)
+		if err != nil {
+			fileSink.Close()
+			return nil, err
+		}
+		sinks = append(sinks, outSink)
+	}
+
+	return NewQueue(sinks...), nil
+}
+
+// NewQueue creates a queue writing dead letters to the given sinks.
+func NewQueue(sinks ...Sink) *Queue {
+	reg := monitoring.Default.GetRegistry("libbeat.dlq")
+	if reg == nil {
+		reg = monitoring.Default.NewRegistry("libbeat.dlq")
+	} else {
+		reg.Clear()
+	}
+
+	return &Queue{
+		log:    logp.NewLogger(logSelector),
+		sinks:  sinks,
+		events: monitoring.NewUint(reg, "events"),
+		errors: monitoring.NewUint(reg, "errors"),
+	}
+}
+
+// SetDefault sets the queue used by Add. Passing nil disables the default
+// queue.
+func SetDefault(q *Queue) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultQueue = q
+}
+
+// Default returns the queue used by Add, or nil if no queue is configured.
+func Default() *Queue {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultQueue
+}
+
+// Add hands an event dropped by an output to the default queue. It is a no-op
+// if the dead letter queue is not enabled.
+func Add(output, reason, target string, event *beat.Event, cause error) {
+	if q := Default(); q != nil {
+		q.Add(output, reason, target, event, cause)
+	}
+}
+
+// Add writes a dead letter to all sinks of the queue.
+func (q *Queue) Add(output, reason, target string, event *beat.Event, cause error) {
+	if q == nil || event == nil {
+		return
+	}
+
+	entry := Entry{
+		Timestamp: time.Now().UTC(),
+		Output:    output,
+		Reason:    reason,
+		Target:    target,
+		Event:     *event,
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+	q.Write(entry)
+}
+
+// Write writes the entry to all sinks of the queue.
+func (q *Queue) Write(entry Entry) error {
+	q.events.Inc()
+
+	var lastErr error
+	for _, sink := range q.sinks {
+		if err := sink.Write(entry); err != nil {
+			q.errors.Inc()
+			q.log.Errorf("Failed to write dead letter: %+v", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Close closes all sinks of the queue.
+func (q *Queue) Close() error {
+	var lastErr error
+	for _, sink := range q.sinks {
+		if err := sink.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
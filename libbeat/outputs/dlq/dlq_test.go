@@ -0,0 +1,213 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+func TestFileSinkRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultFileConfig()
+	config.Path = dir
+
+	sink, err := NewFileSink(config)
+	require.NoError(t, err)
+	q := NewQueue(sink)
+
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	event := beat.Event{
+		Timestamp: ts,
+		Meta:      common.MapStr{"index": "apm"},
+		Fields:    common.MapStr{"message": "hello", "labels": common.MapStr{"env": "prod"}},
+	}
+	q.Add("elasticsearch", ReasonRejected, "apm-write", &event, errors.New("status=400: mapper_parsing_exception"))
+	require.NoError(t, q.Close())
+
+	path := filepath.Join(dir, DefaultFilename)
+	assert.Equal(t, []string{path}, Files(path))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var entries []Entry
+	require.NoError(t, ReadEntries(f, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 1)
+
+	entry := entries[0]
+	assert.Equal(t, "elasticsearch", entry.Output)
+	assert.Equal(t, ReasonRejected, entry.Reason)
+	assert.Equal(t, "apm-write", entry.Target)
+	assert.Equal(t, "status=400: mapper_parsing_exception", entry.Error)
+	assert.True(t, ts.Equal(entry.Event.Timestamp))
+	assert.Equal(t, "apm", entry.Event.Meta["index"])
+	assert.Equal(t, "hello", entry.Event.Fields["message"])
+	assert.Equal(t, map[string]interface{}{"env": "prod"}, entry.Event.Fields["labels"])
+}
+
+func TestFilesOldestFirst(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dlq.ndjson")
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		require.NoError(t, os.WriteFile(name, nil, 0600))
+	}
+	assert.Equal(t, []string{path + ".2", path + ".1", path}, Files(path))
+}
+
+func TestAddWithoutDefaultQueue(t *testing.T) {
+	SetDefault(nil)
+	Add("kafka", ReasonEncoding, "", &beat.Event{}, errors.New("failed"))
+}
+
+// mockClient fails the first publish attempts, retrying all but the first
+// event of a batch, and signals batches asynchronously.
+type mockClient struct {
+	failures  int
+	published [][]publisher.Event
+}
+
+func (c *mockClient) Close() error   { return nil }
+func (c *mockClient) String() string { return "mock" }
+
+func (c *mockClient) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	if c.failures > 0 {
+		c.failures--
+		if len(events) > 1 {
+			events = events[1:]
+		}
+		go batch.RetryEvents(events)
+		return errors.New("temporary failure")
+	}
+	c.published = append(c.published, events)
+	go batch.ACK()
+	return nil
+}
+
+func TestPublisherRetries(t *testing.T) {
+	client := &mockClient{failures: 1}
+	p, err := NewPublisher(outputs.Group{Clients: []outputs.Client{client}, BatchSize: 2, Retry: 3})
+	require.NoError(t, err)
+	p.backoff = time.Millisecond
+
+	events := []beat.Event{
+		{Fields: common.MapStr{"n": 1}},
+		{Fields: common.MapStr{"n": 2}},
+		{Fields: common.MapStr{"n": 3}},
+	}
+	require.NoError(t, p.Publish(context.Background(), events))
+
+	require.Len(t, client.published, 2)
+	assert.Len(t, client.published[0], 1, "only the failed event of the first batch is retried")
+	assert.Equal(t, 2, client.published[0][0].Content.Fields["n"])
+	assert.Len(t, client.published[1], 1)
+}
+
+func TestPublisherGivesUp(t *testing.T) {
+	client := &mockClient{failures: 10}
+	p, err := NewPublisher(outputs.Group{Clients: []outputs.Client{client}, Retry: 2})
+	require.NoError(t, err)
+	p.backoff = time.Millisecond
+
+	err = p.Publish(context.Background(), []beat.Event{{}, {}})
+	assert.Error(t, err)
+	assert.Equal(t, 7, client.failures)
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	for i, reason := range []string{ReasonRejected, ReasonEncoding, ReasonRejected} {
+		line, err := encodeEntry(Entry{
+			Output: "elasticsearch",
+			Reason: reason,
+			Event:  beat.Event{Fields: common.MapStr{"n": i}},
+		})
+		require.NoError(t, err)
+		buf.Write(append(line, '\n'))
+	}
+
+	client := &mockClient{}
+	p, err := NewPublisher(outputs.Group{Clients: []outputs.Client{client}, BatchSize: 1})
+	require.NoError(t, err)
+
+	n, err := Replay(context.Background(), p, &buf, func(e Entry) bool {
+		return e.Reason == ReasonRejected
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, client.published, 2)
+	assert.Equal(t, float64(0), client.published[0][0].Content.Fields["n"])
+	assert.Equal(t, float64(2), client.published[1][0].Content.Fields["n"])
+}
+
+func TestRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.ndjson")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	for i, reason := range []string{ReasonRejected, ReasonEncoding, ReasonRejected} {
+		require.NoError(t, WriteEntry(f, Entry{
+			Output: "elasticsearch",
+			Reason: reason,
+			Event:  beat.Event{Fields: common.MapStr{"n": i}},
+		}))
+	}
+	require.NoError(t, f.Close())
+
+	rejected := func(e Entry) bool { return e.Reason == ReasonRejected }
+	kept, err := Remove(path, rejected)
+	require.NoError(t, err)
+	assert.Equal(t, 1, kept)
+
+	var entries []Entry
+	f, err = os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, ReadEntries(f, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Len(t, entries, 1)
+	assert.Equal(t, ReasonEncoding, entries[0].Reason)
+	assert.Equal(t, float64(1), entries[0].Event.Fields["n"])
+
+	kept, err = Remove(path, func(Entry) bool { return true })
+	require.NoError(t, err)
+	assert.Equal(t, 0, kept)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "file without dead letters must be deleted")
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
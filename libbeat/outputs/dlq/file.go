@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dlq
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/file"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/paths"
+)
+
+// fileEntry is the JSON document of a dead letter in the dead letter file.
+type fileEntry struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Output    string    `json:"output"`
+	Reason    string    `json:"reason"`
+	Target    string    `json:"target,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Event     fileEvent `json:"event"`
+}
+
+type fileEvent struct {
+	Timestamp time.Time     `json:"@timestamp"`
+	Meta      common.MapStr `json:"@metadata,omitempty"`
+	Fields    common.MapStr `json:"fields"`
+}
+
+// FileSink writes dead letters as NDJSON into rotated files.
+type FileSink struct {
+	mu      sync.Mutex
+	path    string
+	rotator *file.Rotator
+}
+
+// FilePath returns the path of the dead letter file. Relative paths are
+// resolved against the data path.
+func FilePath(config FileConfig) string {
+	dir := config.Path
+	if dir == "" {
+		dir = "dead_letter"
+	}
+	dir = paths.Resolve(paths.Data, dir)
+
+	filename := config.Filename
+	if filename == "" {
+		filename = DefaultFilename
+	}
+	return filepath.Join(dir, filename)
+}
+
+// NewFileSink creates a sink writing to the configured file. The sink can be
+// shared by multiple clients. Closing the sink closes the current file only.
+// The file is reopened in append mode on the next write.
+func NewFileSink(config FileConfig) (*FileSink, error) {
+	path := FilePath(config)
+	rotator, err := file.NewFileRotator(
+		path,
+		file.MaxSizeBytes(config.RotateEveryKb*1024),
+		file.MaxBackups(config.NumberOfFiles),
+		file.Permissions(os.FileMode(config.Permissions)),
+		file.RotateOnStartup(false),
+		file.WithLogger(logp.NewLogger("rotator").With(logp.Namespace("rotator"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, rotator: rotator}, nil
+}
+
+// Path returns the path of the active dead letter file.
+func (s *FileSink) Path() string { return s.path }
+
+func (s *FileSink) Write(entry Entry) error {
+	line, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.rotator.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.rotator.Close()
+}
+
+func encodeEntry(entry Entry) ([]byte, error) {
+	doc := fileEntry{
+		Timestamp: entry.Timestamp,
+		Output:    entry.Output,
+		Reason:    entry.Reason,
+		Target:    entry.Target,
+		Error:     entry.Error,
+		Event: fileEvent{
+			Timestamp: entry.Event.Timestamp,
+			Meta:      entry.Event.Meta,
+			Fields:    entry.Event.Fields,
+		},
+	}
+	line, err := json.Marshal(doc)
+	if err == nil {
+		return line, nil
+	}
+
+	// Keep a readable copy of events that can not be encoded, such that the
+	// dead letter is not lost.
+	doc.Event.Meta = nil
+	doc.Event.Fields = common.MapStr{"message": fmt.Sprintf("%v", entry.Event.Fields)}
+	doc.Error = fmt.Sprintf("%v (event not encodable: %v)", entry.Error, err)
+	return json.Marshal(doc)
+}
+
+func decodeEntry(line []byte) (Entry, error) {
+	var doc fileEntry
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return Entry{}, err
+	}
+
+	return Entry{
+		Timestamp: doc.Timestamp,
+		Output:    doc.Output,
+		Reason:    doc.Reason,
+		Target:    doc.Target,
+		Error:     doc.Error,
+		Event: beat.Event{
+			Timestamp: doc.Event.Timestamp,
+			Meta:      doc.Event.Meta,
+			Fields:    doc.Event.Fields,
+		},
+	}, nil
+}
+
+// Files returns the dead letter file and its rotated backups that exist,
+// oldest first.
+func Files(path string) []string {
+	var files []string
+	for i := file.MaxBackupsLimit; i >= 0; i-- {
+		name := path
+		if i > 0 {
+			name = path + "." + strconv.Itoa(i)
+		}
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// WriteEntry writes a single dead letter to w, using the format of the dead
+// letter files.
+func WriteEntry(w io.Writer, entry Entry) error {
+	line, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// ReadEntries calls fn for each dead letter read from r. Reading stops at
+// the first error returned by fn.
+func ReadEntries(r io.Reader, fn func(Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 100*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry, err := decodeEntry(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("invalid dead letter in line %v: %v", line, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,271 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+const (
+	outputQueueSize = 1024
+	defaultBatch    = 50
+	retryBackoff    = 1 * time.Second
+)
+
+var errQueueFull = errors.New("dead letter output queue is full")
+
+// OutputSink forwards dead letters to a second output. Events are published
+// asynchronously, such that a slow or unavailable dead letter output does not
+// block the output handing the events to the queue. Dead letters are dropped
+// if the internal buffer is full.
+type OutputSink struct {
+	log       *logp.Logger
+	name      string
+	publisher *Publisher
+	ch        chan beat.Event
+	wg        sync.WaitGroup
+}
+
+// NewOutputSink loads the output configured in the `dead_letter_queue.output`
+// namespace.
+func NewOutputSink(im outputs.IndexManager, info beat.Info, cfg common.ConfigNamespace) (*OutputSink, error) {
+	group, err := outputs.Load(im, info, nil, cfg.Name(), cfg.Config())
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := NewPublisher(group)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &OutputSink{
+		log:       logp.NewLogger(logSelector),
+		name:      cfg.Name(),
+		publisher: p,
+		ch:        make(chan beat.Event, outputQueueSize),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// Write queues the event of the dead letter. The reason is added to the
+// event in the `dead_letter` field.
+func (s *OutputSink) Write(entry Entry) error {
+	event := entry.Event
+	event.Fields = event.Fields.Clone()
+	if event.Fields == nil {
+		event.Fields = common.MapStr{}
+	}
+	event.Fields["dead_letter"] = common.MapStr{
+		"output": entry.Output,
+		"reason": entry.Reason,
+		"target": entry.Target,
+		"error":  entry.Error,
+	}
+
+	select {
+	case s.ch <- event:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Close publishes all queued dead letters and closes the output.
+func (s *OutputSink) Close() error {
+	close(s.ch)
+	s.wg.Wait()
+	return s.publisher.Close()
+}
+
+func (s *OutputSink) run() {
+	defer s.wg.Done()
+
+	for event := range s.ch {
+		events := []beat.Event{event}
+	collect:
+		for len(events) < s.publisher.batchSize {
+			select {
+			case e, ok := <-s.ch:
+				if !ok {
+					break collect
+				}
+				events = append(events, e)
+			default:
+				break collect
+			}
+		}
+
+		if err := s.publisher.Publish(context.Background(), events); err != nil {
+			s.log.Errorf("Failed to publish %v dead letters to %v output: %+v", len(events), s.name, err)
+		}
+	}
+}
+
+// Publisher publishes events directly to the first client of an output group,
+// bypassing the publisher pipeline. Failed events are retried as configured by
+// the output.
+type Publisher struct {
+	client    outputs.Client
+	batchSize int
+	retry     int
+	backoff   time.Duration
+	connected bool
+}
+
+// NewPublisher creates a publisher for the output group.
+func NewPublisher(group outputs.Group) (*Publisher, error) {
+	if len(group.Clients) == 0 {
+		return nil, errors.New("output has no clients")
+	}
+
+	batchSize := group.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatch
+	}
+	return &Publisher{
+		client:    group.Clients[0],
+		batchSize: batchSize,
+		retry:     group.Retry,
+		backoff:   retryBackoff,
+	}, nil
+}
+
+// Publish publishes the events in batches of the output batch size. It
+// returns an error if events could not be published after all retries.
+func (p *Publisher) Publish(ctx context.Context, events []beat.Event) error {
+	for len(events) > 0 {
+		n := len(events)
+		if n > p.batchSize {
+			n = p.batchSize
+		}
+		if err := p.publishBatch(ctx, events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+func (p *Publisher) publishBatch(ctx context.Context, events []beat.Event) error {
+	pending := make([]publisher.Event, len(events))
+	for i := range events {
+		pending[i] = publisher.Event{Content: events[i], Flags: publisher.GuaranteedSend}
+	}
+
+	var lastErr error
+	for attempt := 0; p.retry < 0 || attempt <= p.retry; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.backoff):
+			}
+		}
+
+		if err := p.connect(); err != nil {
+			lastErr = err
+			continue
+		}
+
+		b := newBatch(pending)
+		if err := p.client.Publish(ctx, b); err != nil {
+			lastErr = err
+		}
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+		if len(b.retry) == 0 {
+			return nil
+		}
+		pending = b.retry
+		if lastErr == nil {
+			lastErr = fmt.Errorf("%v events failed", len(pending))
+		}
+	}
+	return fmt.Errorf("failed to publish %v events: %v", len(pending), lastErr)
+}
+
+func (p *Publisher) connect() error {
+	if p.connected {
+		return nil
+	}
+	if nc, ok := p.client.(outputs.NetworkClient); ok {
+		if err := nc.Connect(); err != nil {
+			return err
+		}
+	}
+	p.connected = true
+	return nil
+}
+
+// Close closes the output client.
+func (p *Publisher) Close() error {
+	return p.client.Close()
+}
+
+// batch records the signal of an output for a single publish attempt.
+// Outputs can signal the batch asynchronously, after Publish returned.
+type batch struct {
+	events []publisher.Event
+	retry  []publisher.Event
+	once   sync.Once
+	done   chan struct{}
+}
+
+func newBatch(events []publisher.Event) *batch {
+	return &batch{events: events, done: make(chan struct{})}
+}
+
+func (b *batch) Events() []publisher.Event { return b.events }
+func (b *batch) ACK()                      { b.signal(nil) }
+func (b *batch) Drop()                     { b.signal(nil) }
+func (b *batch) Retry()                    { b.signal(b.events) }
+func (b *batch) Cancelled()                { b.signal(b.events) }
+
+func (b *batch) RetryEvents(events []publisher.Event)     { b.signal(events) }
+func (b *batch) CancelledEvents(events []publisher.Event) { b.signal(events) }
+
+func (b *batch) signal(retry []publisher.Event) {
+	b.once.Do(func() {
+		b.retry = retry
+		close(b.done)
+	})
+}
+
+// wait blocks until the output signaled the batch.
+func (b *batch) wait(ctx context.Context) error {
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dlq
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+)
+
+// Replay publishes the events of the dead letters read from r, in batches of
+// the output batch size. Only dead letters accepted by filter are replayed. If
+// filter is nil, all dead letters are replayed. Replay returns the number of
+// events published.
+func Replay(ctx context.Context, p *Publisher, r io.Reader, filter func(Entry) bool) (int, error) {
+	var pending []Entry
+	published := 0
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		events := make([]beat.Event, len(pending))
+		for i := range pending {
+			events[i] = pending[i].Event
+		}
+		if err := p.Publish(ctx, events); err != nil {
+			return err
+		}
+		published += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+
+	err := ReadEntries(r, func(entry Entry) error {
+		if filter != nil && !filter(entry) {
+			return nil
+		}
+		pending = append(pending, entry)
+		if len(pending) >= p.batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return published, err
+	}
+	return published, flush()
+}
+
+// Remove deletes the dead letters accepted by filter from the file at path.
+// The remaining dead letters are written to a new file replacing the old
+// one. The file is deleted if no dead letters remain. If filter is nil, the
+// file is deleted. Remove returns the number of remaining dead letters.
+func Remove(path string, filter func(Entry) bool) (int, error) {
+	if filter == nil {
+		return 0, os.Remove(path)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	tmpPath := path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return 0, err
+	}
+
+	kept := 0
+	w := bufio.NewWriter(out)
+	err = ReadEntries(in, func(entry Entry) error {
+		if filter(entry) {
+			return nil
+		}
+		kept++
+		return WriteEntry(w, entry)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	if kept == 0 {
+		os.Remove(tmpPath)
+		return 0, os.Remove(path)
+	}
+	return kept, os.Rename(tmpPath, path)
+}
@@ -0,0 +1,292 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+)
+
+// adaptiveConfig configures the adaptive mode. In adaptive mode the bulk size
+// and the number of concurrent bulk requests follow the load of
+// Elasticsearch, between the configured minimums and the `bulk_max_size` and
+// `worker` settings.
+type adaptiveConfig struct {
+	Enabled        bool          `config:"enabled"`
+	MinBulkSize    int           `config:"min_bulk_size" validate:"min=1"`
+	BulkSizeStep   int           `config:"bulk_size_step" validate:"min=1"`
+	DecreaseFactor float64       `config:"decrease_factor"`
+	TargetLatency  time.Duration `config:"target_latency" validate:"positive"`
+}
+
+var defaultAdaptiveConfig = adaptiveConfig{
+	Enabled:        false,
+	MinBulkSize:    10,
+	BulkSizeStep:   10,
+	DecreaseFactor: 0.5,
+	TargetLatency:  5 * time.Second,
+}
+
+func (c *adaptiveConfig) Validate() error {
+	if c.DecreaseFactor <= 0 || c.DecreaseFactor >= 1 {
+		return errors.New("adaptive.decrease_factor must be between 0 and 1")
+	}
+	return nil
+}
+
+// adaptiveController adjusts the bulk size and the number of in-flight bulk
+// requests using additive increase, multiplicative decrease. Requests slower
+// than the target latency decrease both values. Congestion, that is 429 or 503
+// responses and connection errors, additionally makes all clients back off.
+// The controller is shared by all clients of the output.
+type adaptiveController struct {
+	config         adaptiveConfig
+	maxBulkSize    int
+	maxConcurrency int
+	backoffInit    time.Duration
+	backoffMax     time.Duration
+
+	mu           sync.Mutex
+	wakeup       chan struct{}
+	bulkSize     int
+	limit        int
+	inFlight     int
+	successes    int // successful requests since the last limit increase
+	lastDecrease time.Time
+	backoff      time.Duration
+	backoffUntil time.Time
+
+	metrics adaptiveMetrics
+}
+
+type adaptiveMetrics struct {
+	bulkSize    *monitoring.Int
+	concurrency *monitoring.Int
+	inFlight    *monitoring.Int
+	backoff     *monitoring.Int
+	increases   *monitoring.Uint
+	decreases   *monitoring.Uint
+	congestions *monitoring.Uint
+}
+
+// bulkOutcome describes the result of a bulk request, as used by the
+// adaptiveController.
+type bulkOutcome struct {
+	sent    bool
+	latency time.Duration
+	status  int
+	tooMany int
+	err     error
+}
+
+func newAdaptiveController(
+	config adaptiveConfig,
+	maxBulkSize, maxConcurrency int,
+	backoff Backoff,
+	reg *monitoring.Registry,
+) *adaptiveController {
+	if config.MinBulkSize > maxBulkSize {
+		config.MinBulkSize = maxBulkSize
+	}
+
+	c := &adaptiveController{
+		config:         config,
+		maxBulkSize:    maxBulkSize,
+		maxConcurrency: maxConcurrency,
+		backoffInit:    backoff.Init,
+		backoffMax:     backoff.Max,
+		wakeup:         make(chan struct{}),
+		bulkSize:       maxBulkSize,
+		limit:          maxConcurrency,
+		metrics: adaptiveMetrics{
+			bulkSize:    monitoring.NewInt(reg, "bulk_size"),
+			concurrency: monitoring.NewInt(reg, "concurrency"),
+			inFlight:    monitoring.NewInt(reg, "in_flight"),
+			backoff:     monitoring.NewInt(reg, "backoff.ms"),
+			increases:   monitoring.NewUint(reg, "increases"),
+			decreases:   monitoring.NewUint(reg, "decreases"),
+			congestions: monitoring.NewUint(reg, "congestions"),
+		},
+	}
+	c.updateMetrics()
+	return c
+}
+
+// adaptiveRegistry returns the registry of the adaptive mode metrics, under
+// the metrics of the output.
+func adaptiveRegistry() *monitoring.Registry {
+	outReg := monitoring.Default.GetRegistry("libbeat.output")
+	if outReg == nil {
+		return monitoring.NewRegistry()
+	}
+
+	reg := outReg.GetRegistry("adaptive")
+	if reg == nil {
+		return outReg.NewRegistry("adaptive")
+	}
+	reg.Clear()
+	return reg
+}
+
+// BulkSize returns the number of events to send in the next bulk request.
+func (c *adaptiveController) BulkSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bulkSize
+}
+
+// acquire waits until a bulk request can be sent, without exceeding the
+// number of concurrent requests and the backoff. It returns the start time of
+// the request, which must be passed to release.
+func (c *adaptiveController) acquire(ctx context.Context) (time.Time, error) {
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		wait := c.backoffUntil.Sub(now)
+		if wait <= 0 && c.inFlight < c.limit {
+			c.inFlight++
+			c.updateMetrics()
+			c.mu.Unlock()
+			return now, nil
+		}
+		wakeup := c.wakeup
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return time.Time{}, ctx.Err()
+		case <-wakeup:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// release ends a request started by acquire, adjusting the bulk size and the
+// concurrency to its outcome.
+func (c *adaptiveController) release(start time.Time, outcome bulkOutcome) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+	defer c.notify()
+	defer c.updateMetrics()
+
+	if !outcome.sent {
+		return
+	}
+
+	congested := outcome.tooMany > 0 ||
+		outcome.status == http.StatusTooManyRequests ||
+		outcome.status == http.StatusServiceUnavailable ||
+		(outcome.status == 0 && outcome.err != nil)
+
+	if !congested && outcome.latency <= c.config.TargetLatency {
+		c.increase()
+		return
+	}
+
+	if congested {
+		c.metrics.congestions.Inc()
+		if c.backoff == 0 {
+			c.backoff = c.backoffInit
+		} else if c.backoff *= 2; c.backoff > c.backoffMax {
+			c.backoff = c.backoffMax
+		}
+		c.backoffUntil = time.Now().Add(c.backoff)
+	}
+
+	// Requests already in flight at the last decrease report the same
+	// overload. Decrease only once for them.
+	if start.Before(c.lastDecrease) {
+		return
+	}
+	c.decrease()
+}
+
+func (c *adaptiveController) increase() {
+	c.backoff = 0
+
+	changed := false
+	if c.bulkSize < c.maxBulkSize {
+		c.bulkSize += c.config.BulkSizeStep
+		if c.bulkSize > c.maxBulkSize {
+			c.bulkSize = c.maxBulkSize
+		}
+		changed = true
+	}
+
+	// the concurrency grows by one after a full round of successful requests.
+	c.successes++
+	if c.successes >= c.limit {
+		c.successes = 0
+		if c.limit < c.maxConcurrency {
+			c.limit++
+			changed = true
+		}
+	}
+
+	if changed {
+		c.metrics.increases.Inc()
+	}
+}
+
+func (c *adaptiveController) decrease() {
+	c.lastDecrease = time.Now()
+	c.successes = 0
+
+	c.bulkSize = int(float64(c.bulkSize) * c.config.DecreaseFactor)
+	if c.bulkSize < c.config.MinBulkSize {
+		c.bulkSize = c.config.MinBulkSize
+	}
+	c.limit = int(float64(c.limit) * c.config.DecreaseFactor)
+	if c.limit < 1 {
+		c.limit = 1
+	}
+	c.metrics.decreases.Inc()
+}
+
+// notify wakes up the clients waiting in acquire.
+func (c *adaptiveController) notify() {
+	close(c.wakeup)
+	c.wakeup = make(chan struct{})
+}
+
+func (c *adaptiveController) updateMetrics() {
+	c.metrics.bulkSize.Set(int64(c.bulkSize))
+	c.metrics.concurrency.Set(int64(c.limit))
+	c.metrics.inFlight.Set(int64(c.inFlight))
+	c.metrics.backoff.Set(int64(c.backoff / time.Millisecond))
+}
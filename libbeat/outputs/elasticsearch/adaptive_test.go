@@ -0,0 +1,224 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/esleg/eslegclient"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
+)
+
+func newTestController(maxBulkSize, maxConcurrency int) (*adaptiveController, *monitoring.Registry) {
+	reg := monitoring.NewRegistry()
+	config := defaultAdaptiveConfig
+	config.Enabled = true
+	config.TargetLatency = time.Second
+	return newAdaptiveController(config, maxBulkSize, maxConcurrency, Backoff{Init: time.Millisecond, Max: 4 * time.Millisecond}, reg), reg
+}
+
+func sentOutcome(status, tooMany int, latency time.Duration) bulkOutcome {
+	return bulkOutcome{sent: true, status: status, tooMany: tooMany, latency: latency}
+}
+
+func TestAdaptiveCongestion(t *testing.T) {
+	c, reg := newTestController(100, 4)
+	assert.Equal(t, 100, c.BulkSize())
+	assert.Equal(t, 4, c.limit)
+
+	first, err := c.acquire(context.Background())
+	require.NoError(t, err)
+	second, err := c.acquire(context.Background())
+	require.NoError(t, err)
+
+	c.release(first, sentOutcome(429, 0, 10*time.Millisecond))
+	assert.Equal(t, 50, c.BulkSize())
+	assert.Equal(t, 2, c.limit)
+	assert.Equal(t, time.Millisecond, c.backoff)
+
+	// the second request was in flight during the decrease and only extends
+	// the backoff.
+	c.release(second, sentOutcome(200, 3, 10*time.Millisecond))
+	assert.Equal(t, 50, c.BulkSize())
+	assert.Equal(t, 2, c.limit)
+	assert.Equal(t, 2*time.Millisecond, c.backoff)
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(50), snapshot.Ints["bulk_size"])
+	assert.Equal(t, int64(2), snapshot.Ints["concurrency"])
+	assert.Equal(t, int64(0), snapshot.Ints["in_flight"])
+	assert.Equal(t, int64(2), snapshot.Ints["backoff.ms"])
+	assert.Equal(t, int64(2), snapshot.Ints["congestions"])
+	assert.Equal(t, int64(1), snapshot.Ints["decreases"])
+
+	// requests started after the decrease decrease again, down to the
+	// minimums.
+	for i := 0; i < 10; i++ {
+		start, err := c.acquire(context.Background())
+		require.NoError(t, err)
+		c.release(start, sentOutcome(503, 0, 10*time.Millisecond))
+	}
+	assert.Equal(t, defaultAdaptiveConfig.MinBulkSize, c.BulkSize())
+	assert.Equal(t, 1, c.limit)
+	assert.Equal(t, 4*time.Millisecond, c.backoff)
+}
+
+func TestAdaptiveIncrease(t *testing.T) {
+	c, _ := newTestController(100, 4)
+	c.bulkSize, c.limit = 20, 1
+
+	for i := 0; i < 3; i++ {
+		start, err := c.acquire(context.Background())
+		require.NoError(t, err)
+		c.release(start, sentOutcome(200, 0, 10*time.Millisecond))
+	}
+
+	// the bulk size grows with every request, the concurrency after all
+	// concurrent requests succeeded.
+	assert.Equal(t, 50, c.BulkSize())
+	assert.Equal(t, 3, c.limit)
+	assert.Equal(t, time.Duration(0), c.backoff)
+
+	for i := 0; i < 20; i++ {
+		start, err := c.acquire(context.Background())
+		require.NoError(t, err)
+		c.release(start, sentOutcome(200, 0, 10*time.Millisecond))
+	}
+	assert.Equal(t, 100, c.BulkSize())
+	assert.Equal(t, 4, c.limit)
+}
+
+func TestAdaptiveSlowRequests(t *testing.T) {
+	c, _ := newTestController(100, 4)
+
+	start, err := c.acquire(context.Background())
+	require.NoError(t, err)
+	c.release(start, sentOutcome(200, 0, 2*time.Second))
+
+	assert.Equal(t, 50, c.BulkSize())
+	assert.Equal(t, 2, c.limit)
+	assert.Equal(t, time.Duration(0), c.backoff, "slow requests must not back off")
+}
+
+func TestAdaptiveAcquireLimit(t *testing.T) {
+	c, _ := newTestController(100, 1)
+
+	start, err := c.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.acquire(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := c.acquire(context.Background())
+		assert.NoError(t, err)
+	}()
+
+	c.release(start, bulkOutcome{})
+	wg.Wait()
+	assert.Equal(t, 1, c.inFlight)
+}
+
+func TestPublishAdaptive(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []int
+		reject   bool
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprintln(w, `{ "version": { "number": "7.9.0" } }`)
+			return
+		}
+
+		lines := 0
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines++
+		}
+		items := lines / 2
+
+		mu.Lock()
+		requests = append(requests, items)
+		status := 201
+		if reject {
+			status = 429
+		}
+		mu.Unlock()
+
+		fmt.Fprintf(w, `{"items":[%v]}`,
+			strings.TrimSuffix(strings.Repeat(fmt.Sprintf(`{"index":{"status":%v}},`, status), items), ","))
+	}))
+	defer ts.Close()
+
+	controller, _ := newTestController(10, 1)
+	client, err := NewClient(ClientSettings{
+		ConnectionSettings: eslegclient.ConnectionSettings{URL: ts.URL},
+		Index:              outil.MakeSelector(outil.ConstSelectorExpr("log-test", outil.SelectorLowerCase)),
+		Adaptive:           controller,
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, client.Connect())
+
+	var events []beat.Event
+	for i := 0; i < 25; i++ {
+		events = append(events, beat.Event{
+			Timestamp: time.Now(),
+			Fields: common.MapStr{
+				"message": "test",
+				"labels":  common.MapStr{"_tag_profileId": "abc"},
+			},
+		})
+	}
+
+	batch := outest.NewBatch(events...)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	assert.Equal(t, []int{10, 10, 5}, requests)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	// rejected requests stop the batch, retrying all remaining events.
+	requests, reject = nil, true
+	batch = outest.NewBatch(events...)
+	assert.Error(t, client.Publish(context.Background(), batch))
+	assert.Equal(t, 1, len(requests))
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	assert.Equal(t, 25, len(batch.Signals[0].Events))
+	assert.Equal(t, time.Millisecond, controller.backoff)
+}
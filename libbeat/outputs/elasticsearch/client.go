@@ -19,7 +19,6 @@ package elasticsearch
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -34,6 +33,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/esleg/eslegclient"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
 	"github.com/snappyflow/beats/v7/libbeat/publisher"
 	"github.com/snappyflow/beats/v7/libbeat/testing"
@@ -47,6 +47,7 @@ type Client struct {
 	pipeline *outil.Selector
 
 	observer outputs.Observer
+	adaptive *adaptiveController
 
 	log *logp.Logger
 }
@@ -57,6 +58,9 @@ type ClientSettings struct {
 	Index    outputs.IndexSelector
 	Pipeline *outil.Selector
 	Observer outputs.Observer
+
+	// Adaptive adjusts the size and concurrency of bulk requests, if set.
+	Adaptive *adaptiveController
 }
 
 type bulkResultStats struct {
@@ -131,6 +135,7 @@ func NewClient(
 		pipeline: pipeline,
 
 		observer: s.Observer,
+		adaptive: s.Adaptive,
 
 		log: logp.NewLogger("elasticsearch"),
 	}
@@ -177,7 +182,14 @@ func (client *Client) Clone() *Client {
 
 func (client *Client) Publish(ctx context.Context, batch publisher.Batch) error {
 	events := batch.Events()
-	rest, err := client.publishEvents(ctx, events)
+
+	var rest []publisher.Event
+	var err error
+	if client.adaptive != nil {
+		rest, err = client.publishAdaptive(ctx, events)
+	} else {
+		rest, err = client.publishEvents(ctx, events)
+	}
 	if len(rest) == 0 {
 		batch.ACK()
 	} else {
@@ -186,10 +198,46 @@ func (client *Client) Publish(ctx context.Context, batch publisher.Batch) error
 	return err
 }
 
+// publishAdaptive sends the events in bulk requests of the size selected by
+// the adaptive controller. Sending stops at the first failed request. The
+// events of the failed request not confirmed by Elasticsearch and the events
+// of all remaining requests are returned.
+func (client *Client) publishAdaptive(ctx context.Context, data []publisher.Event) ([]publisher.Event, error) {
+	for len(data) > 0 {
+		n := client.adaptive.BulkSize()
+		if n > len(data) {
+			n = len(data)
+		}
+
+		start, err := client.adaptive.acquire(ctx)
+		if err != nil {
+			return data, err
+		}
+		failed, outcome, err := client.publishBulk(ctx, data[:n])
+		client.adaptive.release(start, outcome)
+
+		data = data[n:]
+		if len(failed) > 0 || err != nil {
+			rest := make([]publisher.Event, 0, len(failed)+len(data))
+			return append(append(rest, failed...), data...), err
+		}
+	}
+	return nil, nil
+}
+
 // PublishEvents sends all events to elasticsearch. On error a slice with all
 // events not published or confirmed to be processed by elasticsearch will be
 // returned. The input slice backing memory will be reused by return the value.
 func (client *Client) publishEvents(ctx context.Context, data []publisher.Event) ([]publisher.Event, error) {
+	rest, _, err := client.publishBulk(ctx, data)
+	return rest, err
+}
+
+// publishBulk sends the events in a single bulk request, like publishEvents.
+// It also reports the outcome of the request.
+func (client *Client) publishBulk(ctx context.Context, data []publisher.Event) ([]publisher.Event, bulkOutcome, error) {
+	var outcome bulkOutcome
+
 	span, ctx := apm.StartSpan(ctx, "publishEvents", "output")
 	defer span.End()
 	begin := time.Now()
@@ -200,7 +248,7 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 	}
 
 	if len(data) == 0 {
-		return nil, nil
+		return nil, outcome, nil
 	}
 
 	// encode events into bulk request buffer, dropping failed elements from
@@ -214,15 +262,22 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 		st.Dropped(origCount - newCount)
 	}
 	if newCount == 0 {
-		return nil, nil
+		return nil, outcome, nil
 	}
 
+	requestStart := time.Now()
 	status, result, sendErr := client.conn.Bulk(ctx, "", "", nil, bulkItems)
+	outcome = bulkOutcome{
+		sent:    true,
+		latency: time.Since(requestStart),
+		status:  status,
+		err:     sendErr,
+	}
 	if sendErr != nil {
 		err := apm.CaptureError(ctx, fmt.Errorf("failed to perform any bulk index operations: %w", sendErr))
 		err.Send()
 		client.log.Error(err)
-		return data, sendErr
+		return data, outcome, sendErr
 	}
 	pubCount := len(data)
 	span.Context.SetLabel("events_published", pubCount)
@@ -240,6 +295,7 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 	} else {
 		failedEvents, stats = bulkCollectPublishFails(client.log, result, data)
 	}
+	outcome.tooMany = stats.tooMany
 
 	failed := len(failedEvents)
 	span.Context.SetLabel("events_failed", failed)
@@ -259,146 +315,9 @@ func (client *Client) publishEvents(ctx context.Context, data []publisher.Event)
 		if sendErr == nil {
 			sendErr = eslegclient.ErrTempBulkFailure
 		}
-		return failedEvents, sendErr
+		return failedEvents, outcome, sendErr
 	}
-	return nil, nil
-}
-
-func interceptDocument(log *logp.Logger, index outputs.IndexSelector, event beat.Event) (bool, beat.Event) {
-	var valueData map[string]interface{}
-	processRequired := false
-	newEvent := beat.Event{}
-
-	// event.SetID()
-	// beat, err := event.Private
-	// log.Infof("Event meta  %+v", )
-	indexName, err := index.Select(&event)
-	newIndex := ""
-	if err != nil {
-		log.Debug("Index not found: Parse error")
-	}
-	log.Debugf("Index name %+v", indexName)
-
-	msg, err := getEventMessage(log, indexName, &event)
-	if err != nil {
-		log.Infof("Dropping event: %+v", err)
-	}
-	json.Unmarshal(msg, &valueData)
-	// log.Infof("Document data %+v", valueData)
-	if labels, ok := valueData["labels"]; ok {
-
-		profileId := labels.(map[string]interface{})["_tag_profileId"].(string)
-		projectName, projFound := labels.(map[string]interface{})["_tag_projectName"].(string)
-		redactBody := labels.(map[string]interface{})["_tag_redact_body"]
-
-		// Dropping record if project name is not found
-		if !projFound {
-			return processRequired, newEvent
-		}
-		// Delete Cookies from headers
-		event.Fields.Delete("http.request.headers.Cookies")
-		event.Fields.Delete("http.response.headers.Cookies")
-
-		var httpBodyString interface{} = nil
-		httpRequestBodyFound := false
-
-		if http, httpFound := valueData["http"]; httpFound {
-			if request, requestFound := http.(map[string]interface{})["request"]; requestFound {
-				httpBodyString = request.(map[string]interface{})["body"]
-				if httpBodyString != nil {
-					httpRequestBodyFound = true
-				}
-			}
-		}
-
-		// Only remove if redactBody Tag is present in labels
-		if redactBody != nil && httpRequestBodyFound && strings.Contains(strings.ToLower(indexName), "trace") {
-			indexType := "log"
-			docType := "user-input"
-			tagIndexType := labels.(map[string]interface{})["_tag_IndexType"]
-			tagDocType := labels.(map[string]interface{})["_tag_documentType"]
-			if tagIndexType != nil {
-				if strings.Contains(strings.ToLower(tagIndexType.(string)), "metric") {
-					indexType = "metric"
-				}
-			}
-			if tagDocType != nil {
-				docType = tagDocType.(string)
-			}
-			newIndex = indexType + "-" + profileId + "-" + getMetricName(projectName) + "-$_write"
-			// newEvent := event
-			newEvent.Meta = event.Meta.Clone()
-			newEvent.Fields = event.Fields.Clone()
-			newEvent.Private = event.Private
-			newEvent.Timestamp = event.Timestamp
-			// newEvent.Fields.Put()
-
-			for key, _ := range valueData {
-				newEvent.Fields.Delete(key)
-			}
-			newEvent.Meta.Put("snappyflow_index", newIndex)
-
-			traceBody := make(map[string]interface{})
-			if httpBodyString != nil {
-				httpBodyString = httpBodyString.(map[string]interface{})["original"]
-
-				log.Debugf("trace httpd body found : %+v ", httpBodyString)
-				httpBody := map[string]interface{}{}
-				switch v := httpBodyString.(type) {
-				case string:
-					if err := json.Unmarshal([]byte(httpBodyString.(string)), &httpBody); err == nil {
-						newEvent.Fields.Put("body_json", httpBody)
-						// traceBody["request_body"] = httpBody
-					}
-				case map[string]interface{}:
-					// traceBody["request_body"] = httpBodyString
-					newEvent.Fields.Put("body_json", httpBodyString)
-				default:
-					log.Debugf("Found unexpected type %+v", v)
-				}
-
-			} else {
-				// If httpd body is not found do not send log data
-				log.Debug("Trace http body not found")
-			}
-			traceBody["url"] = valueData["url"]
-			traceBody["service"] = valueData["service"]
-			traceBody["labels"] = valueData["labels"]
-			delete(traceBody["labels"].(map[string]interface{}), "_tag_documentType")
-			delete(traceBody["labels"].(map[string]interface{}), "_tag_IndexType")
-			delete(traceBody["labels"].(map[string]interface{}), "_tag_redact_body")
-			traceBody["processor"] = valueData["processor"]
-			traceBody["source"] = valueData["source"]
-			traceBody["agent"] = valueData["agent"]
-			traceBody["status"], _ = event.Fields.GetValue("http.response.status_code")
-			traceBody["user_agent"], _ = event.Fields.GetValue("user_agent")
-
-			if valueData["user"] == nil {
-				traceBody["user"] = make(map[string]interface{})
-			} else {
-				traceBody["user"] = valueData["user"]
-			}
-			// log.Debugf("new Index %+v", newIndex)
-			// log.Debugf("Inside trace http body :")
-			newEvent.Fields.Put("details_json", traceBody)
-			newEvent.Fields.Put("time", int(valueData["timestamp"].(map[string]interface{})["us"].(float64))/1000)
-
-			newEvent.Fields.Put("_plugin", "trace_body")
-			newEvent.Fields.Put("_documentType", docType)
-
-			newEvent.Fields.Put("_tag_projectName", labels.(map[string]interface{})["_tag_projectName"].(string))
-			newEvent.Fields.Put("_tag_appName", labels.(map[string]interface{})["_tag_appName"].(string))
-
-			newEvent.Fields.Put("message", "Trace request body")
-			newEvent.Fields.Put("trace_id", valueData["trace"].(map[string]interface{})["id"])
-			newEvent.Fields.Put("transaction_id", valueData["transaction"].(map[string]interface{})["id"])
-
-			log.Debug("Deleteing http body from trace data")
-			event.Fields.Delete("http.request.body")
-			processRequired = true
-		}
-	}
-	return processRequired, newEvent
+	return nil, outcome, nil
 }
 
 // bulkEncodePublishRequest encodes all bulk requests and returns slice of events
@@ -412,7 +331,11 @@ func bulkEncodePublishRequest(
 ) ([]publisher.Event, []interface{}) {
 	var newData []publisher.Event
 	for i := range data {
-		event := data[i].Content
+		event := &data[i].Content
+		if isTraceBodyEvent(event) {
+			newData = append(newData, data[i])
+			continue
+		}
 		// Drop event if profile id is not present
 		_, err := event.Fields.GetValue("labels._tag_profileId")
 		if err != nil {
@@ -426,14 +349,6 @@ func bulkEncodePublishRequest(
 			}
 		}
 		newData = append(newData, data[i])
-		processRequired, newEvent := interceptDocument(log, index, event)
-		if processRequired {
-			pEvent := publisher.Event{}
-			pEvent.Cache = data[i].Cache
-			pEvent.Flags = data[i].Flags
-			pEvent.Content = newEvent
-			newData = append(newData, pEvent)
-		}
 	}
 	okEvents := newData[:0]
 	bulkItems := []interface{}{}
@@ -444,6 +359,7 @@ func bulkEncodePublishRequest(
 		meta, err := createEventBulkMeta(log, version, index, pipeline, event)
 		if err != nil {
 			log.Errorf("Failed to encode event meta data: %+v", err)
+			dlq.Add("elasticsearch", dlq.ReasonEncoding, "", event, err)
 			continue
 		}
 		if opType := events.GetOpType(*event); opType == events.OpTypeDelete {
@@ -476,24 +392,9 @@ func createEventBulkMeta(
 	}
 
 	index, err := indexSel.Select(event)
-	if index == "" {
-		metricIndex, notFoundErr := event.Meta.GetValue("snappyflow_index")
-		if notFoundErr == nil {
-			index = metricIndex.(string)
-		}
-		if err != nil && notFoundErr != nil {
-			err := fmt.Errorf("failed to select event index: %v", err)
-			return nil, err
-		}
-	}
-	// For rum agent - redirection to rum index based on project name
-	agent, err := event.Fields.GetValue("labels._tag_agent")
-	if err == nil {
-		if agent == "rum" {
-			profileId, _ := event.Fields.GetValue("labels._tag_profileId")
-			projectName, _ := event.Fields.GetValue("labels._tag_projectName")
-			index = fmt.Sprintf("rum-%s-%s-$_write", profileId.(string), getMetricName(projectName.(string)))
-		}
+	if err != nil {
+		err := fmt.Errorf("failed to select event index: %v", err)
+		return nil, err
 	}
 
 	// Only process for Snappyflow indexes
@@ -533,6 +434,10 @@ func createEventBulkMeta(
 			return nil, fmt.Errorf("%s %s requires _id", events.FieldMetaOpType, events.OpTypeDelete)
 		}
 	}
+	if isDataStream(indexSel) {
+		// data streams are append-only and reject the `index` op_type
+		return eslegclient.BulkCreateAction{Create: meta}, nil
+	}
 	if id != "" || version.Major > 7 || (version.Major == 7 && version.Minor >= 5) {
 		if opType == events.OpTypeIndex {
 			return eslegclient.BulkIndexAction{Index: meta}, nil
@@ -542,6 +447,11 @@ func createEventBulkMeta(
 	return eslegclient.BulkIndexAction{Index: meta}, nil
 }
 
+func isDataStream(indexSel outputs.IndexSelector) bool {
+	ds, ok := indexSel.(outputs.DataStreamSelector)
+	return ok && ds.DataStream()
+}
+
 func getPipeline(event *beat.Event, pipelineSel *outil.Selector) (string, error) {
 	if event.Meta != nil {
 		pipeline, err := events.GetMetaStringValue(*event, events.FieldMetaPipeline)
@@ -604,6 +514,8 @@ func bulkCollectPublishFails(
 			} else {
 				// hard failure, don't collect
 				log.Warnf("Cannot index event %#v (status=%v): %s", data[i], status, msg)
+				dlq.Add("elasticsearch", dlq.ReasonRejected, "", &data[i].Content,
+					fmt.Errorf("status=%v: %s", status, msg))
 				stats.nonIndexable++
 				continue
 			}
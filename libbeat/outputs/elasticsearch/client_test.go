@@ -387,6 +387,50 @@ func TestBulkEncodeEventsWithOpType(t *testing.T) {
 
 }
 
+func TestBulkEncodeEventsToDataStream(t *testing.T) {
+	cfg := common.MustNewConfigFrom(common.MapStr{"index": "log-test"})
+	info := beat.Info{
+		IndexPrefix: "test",
+		Version:     version.GetDefaultVersion(),
+	}
+
+	im, err := idxmgmt.DefaultSupport(nil, info, common.MustNewConfigFrom(common.MapStr{
+		"setup.template.type":        "index",
+		"setup.template.data_stream": true,
+	}))
+	require.NoError(t, err)
+
+	index, pipeline, err := buildSelectors(im, info, cfg)
+	require.NoError(t, err)
+
+	var events []publisher.Event
+	for _, meta := range []common.MapStr{
+		nil,
+		{"_id": "111"},
+		{"_id": "112", e.FieldMetaOpType: e.OpTypeIndex},
+	} {
+		events = append(events, publisher.Event{
+			Content: beat.Event{
+				Meta: meta,
+				Fields: common.MapStr{
+					"message": "test",
+					"labels":  common.MapStr{"_tag_profileId": "abc"},
+				},
+			},
+		})
+	}
+
+	encoded, bulkItems := bulkEncodePublishRequest(logp.L(), *common.MustNewVersion(version.GetDefaultVersion()), index, pipeline, events)
+	require.Equal(t, len(events), len(encoded), "all events should have been encoded")
+	require.Equal(t, 2*len(events), len(bulkItems), "incomplete bulk")
+
+	for i := 0; i < len(bulkItems); i += 2 {
+		action, ok := bulkItems[i].(eslegclient.BulkCreateAction)
+		require.True(t, ok, "data streams only accept create, got %#v", bulkItems[i])
+		assert.Equal(t, "log-test", action.Create.Index)
+	}
+}
+
 func TestClientWithAPIKey(t *testing.T) {
 	var headers http.Header
 
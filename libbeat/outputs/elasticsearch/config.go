@@ -45,6 +45,7 @@ type elasticsearchConfig struct {
 	MaxRetries       int               `config:"max_retries"`
 	Timeout          time.Duration     `config:"timeout"`
 	Backoff          Backoff           `config:"backoff"`
+	Adaptive         adaptiveConfig    `config:"adaptive"`
 }
 
 type Backoff struct {
@@ -77,6 +78,7 @@ var (
 			Init: 1 * time.Second,
 			Max:  60 * time.Second,
 		},
+		Adaptive: defaultAdaptiveConfig,
 	}
 )
 
@@ -91,5 +93,9 @@ func (c *elasticsearchConfig) Validate() error {
 		return fmt.Errorf("cannot set both api_key and username/password")
 	}
 
+	if c.Adaptive.Enabled && c.BulkMaxSize <= 0 {
+		return fmt.Errorf("adaptive mode requires a positive bulk_max_size")
+	}
+
 	return nil
 }
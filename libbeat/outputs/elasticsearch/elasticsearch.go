@@ -35,6 +35,28 @@ func init() {
 
 const logSelector = "elasticsearch"
 
+// defaultIndices select the write aliases of SnappyFlow events. The rules are
+// appended to the configured indices. Events not matching any rule are sent to
+// the configured index.
+var defaultIndices = []map[string]interface{}{
+	{
+		// RUM events are sent to the alias of their project.
+		"index":                         "rum-%{[labels._tag_profileId]}-%{[labels._tag_projectName]|snake_case}-$_write",
+		"when.equals.labels._tag_agent": "rum",
+	},
+	{
+		// Events created by the split_trace_body processor. Metadata fields
+		// are reported to be present if the event has no metadata at all, such
+		// that the fields of body events are required as well.
+		"index": "%{[@metadata.index_type]}-%{[details_json.labels._tag_profileId]}-%{[_tag_projectName]|snake_case}-$_write",
+		"when.has_fields": []string{
+			"@metadata.index_type",
+			"details_json.labels._tag_profileId",
+			"_tag_projectName",
+		},
+	},
+}
+
 func makeES(
 	im outputs.IndexManager,
 	beat beat.Info,
@@ -82,6 +104,13 @@ func makeES(
 		params = nil
 	}
 
+	var adaptive *adaptiveController
+	if config.Adaptive.Enabled {
+		adaptive = newAdaptiveController(config.Adaptive, config.BulkMaxSize, len(hosts), config.Backoff, adaptiveRegistry())
+		log.Infof("Adaptive mode enabled with up to %v events per bulk request and %v concurrent requests.",
+			config.BulkMaxSize, len(hosts))
+	}
+
 	clients := make([]outputs.NetworkClient, len(hosts))
 	for i, host := range hosts {
 		esURL, err := common.MakeURL(config.Protocol, config.Path, host, 9200)
@@ -111,6 +140,7 @@ func makeES(
 			Index:    index,
 			Pipeline: pipeline,
 			Observer: observer,
+			Adaptive: adaptive,
 		}, &connectCallbackRegistry)
 		if err != nil {
 			return outputs.Fail(err)
@@ -128,7 +158,12 @@ func buildSelectors(
 	beat beat.Info,
 	cfg *common.Config,
 ) (index outputs.IndexSelector, pipeline *outil.Selector, err error) {
-	index, err = im.BuildSelector(cfg)
+	indexCfg, err := withDefaultIndices(cfg)
+	if err != nil {
+		return index, pipeline, err
+	}
+
+	index, err = im.BuildSelector(indexCfg)
 	if err != nil {
 		return index, pipeline, err
 	}
@@ -154,3 +189,29 @@ func buildPipelineSelector(cfg *common.Config) (outil.Selector, error) {
 		Case:             outil.SelectorLowerCase,
 	})
 }
+
+// withDefaultIndices returns a copy of cfg with the default indices appended
+// to the configured indices, such that configured rules take precedence.
+func withDefaultIndices(cfg *common.Config) (*common.Config, error) {
+	out, err := common.MergeConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	n := 0
+	if out.HasField("indices") {
+		if n, err = out.CountField("indices"); err != nil {
+			return nil, err
+		}
+	}
+	for i, rule := range defaultIndices {
+		ruleCfg, err := common.NewConfigFrom(rule)
+		if err != nil {
+			return nil, err
+		}
+		if err := out.SetChild("indices", n+i, ruleCfg); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
 	"github.com/snappyflow/beats/v7/libbeat/esleg/eslegclient"
+	"github.com/snappyflow/beats/v7/libbeat/idxmgmt"
 )
 
 func TestConnectCallbacksManagement(t *testing.T) {
@@ -131,3 +132,109 @@ func TestPipelineSelection(t *testing.T) {
 		})
 	}
 }
+
+func TestIndexSelection(t *testing.T) {
+	rumEvent := beat.Event{
+		Fields: common.MapStr{
+			"labels": common.MapStr{
+				"_tag_agent":       "rum",
+				"_tag_profileId":   "p1",
+				"_tag_projectName": "shopFront",
+			},
+		},
+	}
+	traceBodyEvent := beat.Event{
+		Meta: common.MapStr{"index_type": "log"},
+		Fields: common.MapStr{
+			"_tag_projectName": "my_app",
+			"details_json":     common.MapStr{"labels": common.MapStr{"_tag_profileId": "p2"}},
+		},
+	}
+
+	cases := map[string]struct {
+		cfg   map[string]interface{}
+		event beat.Event
+		want  string
+	}{
+		"configured index": {
+			event: beat.Event{Fields: common.MapStr{"message": "test"}},
+			want:  "log-test",
+		},
+		"rum project alias": {
+			event: rumEvent,
+			want:  "rum-p1-shop_front-$_write",
+		},
+		"trace body alias": {
+			event: traceBodyEvent,
+			want:  "log-p2-my__app-$_write",
+		},
+		"raw index overrides trace body alias": {
+			event: beat.Event{
+				Meta:   common.MapStr{"index_type": "log", "raw_index": "log-raw"},
+				Fields: traceBodyEvent.Fields,
+			},
+			want: "log-raw",
+		},
+		"event without index type uses the configured index": {
+			event: beat.Event{Meta: common.MapStr{}, Fields: traceBodyEvent.Fields},
+			want:  "log-test",
+		},
+		"event without body fields uses the configured index": {
+			event: beat.Event{Fields: common.MapStr{"_tag_projectName": "my_app"}},
+			want:  "log-test",
+		},
+		"configured indices take precedence over the defaults": {
+			cfg: map[string]interface{}{
+				"indices": []map[string]interface{}{{
+					"index":                         "rum-%{[labels._tag_profileId]}-%{[labels._tag_projectName]|replace(F,-f)|lower}",
+					"when.equals.labels._tag_agent": "rum",
+				}},
+			},
+			event: rumEvent,
+			want:  "rum-p1-shop-front",
+		},
+		"defaults apply with configured indices": {
+			cfg: map[string]interface{}{
+				"indices": []map[string]interface{}{{
+					"index":           "metrics-test",
+					"when.has_fields": []string{"metricset"},
+				}},
+			},
+			event: traceBodyEvent,
+			want:  "log-p2-my__app-$_write",
+		},
+	}
+
+	info := beat.Info{Beat: "test", Version: "7.9.0"}
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := common.MustNewConfigFrom(map[string]interface{}{"index": "log-test"})
+			if test.cfg != nil {
+				if err := cfg.Merge(test.cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			im, err := idxmgmt.DefaultSupport(nil, info, common.NewConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			indices, _ := cfg.CountField("indices")
+			index, _, err := buildSelectors(im, info, cfg)
+			if err != nil {
+				t.Fatalf("Failed to parse configuration: %v", err)
+			}
+			if n, _ := cfg.CountField("indices"); n != indices {
+				t.Errorf("Configuration modified: %v indices, want %v", n, indices)
+			}
+
+			got, err := index.Select(&test.event)
+			if err != nil {
+				t.Fatalf("Failed to select index: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Index mismatch: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
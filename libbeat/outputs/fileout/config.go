@@ -18,19 +18,26 @@
 package fileout
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/snappyflow/beats/v7/libbeat/common/file"
+	"github.com/snappyflow/beats/v7/libbeat/common/fmtstr"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
 )
 
 type config struct {
-	Path          string       `config:"path"`
-	Filename      string       `config:"filename"`
-	RotateEveryKb uint         `config:"rotate_every_kb" validate:"min=1"`
-	NumberOfFiles uint         `config:"number_of_files"`
-	Codec         codec.Config `config:"codec"`
-	Permissions   uint32       `config:"permissions"`
+	Path          string        `config:"path"`
+	Filename      string        `config:"filename"`
+	RotateEveryKb uint          `config:"rotate_every_kb" validate:"min=1"`
+	NumberOfFiles uint          `config:"number_of_files"`
+	Interval      time.Duration `config:"interval"`
+	Compress      bool          `config:"compress"`
+	MaxAge        time.Duration `config:"max_age"`
+	Codec         codec.Config  `config:"codec"`
+	Permissions   uint32        `config:"permissions"`
+	MaxOpenFiles  int           `config:"max_open_files" validate:"min=1"`
 }
 
 var (
@@ -38,6 +45,7 @@ var (
 		NumberOfFiles: 7,
 		RotateEveryKb: 10 * 1024,
 		Permissions:   0600,
+		MaxOpenFiles:  64,
 	}
 )
 
@@ -46,6 +54,17 @@ func (c *config) Validate() error {
 		return fmt.Errorf("The number_of_files to keep should be between 2 and %v",
 			file.MaxBackupsLimit)
 	}
+	if c.Interval != 0 && c.Interval < time.Second {
+		return errors.New("The interval must be at least 1s")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("The max_age must not be negative")
+	}
+	if c.Filename != "" {
+		if _, err := fmtstr.CompileEvent(c.Filename); err != nil {
+			return fmt.Errorf("invalid filename: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -18,13 +18,18 @@
 package fileout
 
 import (
+	"container/list"
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/joeshaw/multierror"
+
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
 	"github.com/snappyflow/beats/v7/libbeat/common/file"
+	"github.com/snappyflow/beats/v7/libbeat/common/fmtstr"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
@@ -40,8 +45,25 @@ type fileOutput struct {
 	filePath string
 	beat     beat.Info
 	observer outputs.Observer
-	rotator  *file.Rotator
 	codec    codec.Codec
+	sep      []byte
+	config   config
+
+	// filename is nil if the file name does not depend on the event.
+	filename *fmtstr.EventFormatString
+	rotator  *file.Rotator
+
+	// rotators holds the open files of event based file names, indexing the
+	// elements of lru. The least recently used file is closed if more than
+	// max_open_files are open.
+	rotators map[string]*list.Element
+	lru      *list.List
+}
+
+// namedRotator is an element of the open files list.
+type namedRotator struct {
+	name    string
+	rotator *file.Rotator
 }
 
 // makeFileout instantiates a new file output instance.
@@ -72,42 +94,111 @@ func makeFileout(
 }
 
 func (out *fileOutput) init(beat beat.Info, c config) error {
-	var path string
-	if c.Filename != "" {
-		path = filepath.Join(c.Path, c.Filename)
-	} else {
-		path = filepath.Join(c.Path, out.beat.Beat)
+	filename := c.Filename
+	if filename == "" {
+		filename = out.beat.Beat
 	}
+	path := filepath.Join(c.Path, filename)
 
 	out.filePath = path
+	out.config = c
 
-	var err error
-	out.rotator, err = file.NewFileRotator(
-		path,
-		file.MaxSizeBytes(c.RotateEveryKb*1024),
-		file.MaxBackups(c.NumberOfFiles),
-		file.Permissions(os.FileMode(c.Permissions)),
-		file.WithLogger(logp.NewLogger("rotator").With(logp.Namespace("rotator"))),
-	)
+	fs, err := fmtstr.CompileEvent(filename)
 	if err != nil {
 		return err
 	}
+	if fs.IsConst() {
+		out.rotator, err = out.newRotator(path)
+		if err != nil {
+			return err
+		}
+	} else {
+		out.filename = fs
+		out.rotators = map[string]*list.Element{}
+		out.lru = list.New()
+	}
 
 	out.codec, err = codec.CreateEncoder(beat, c.Codec)
 	if err != nil {
 		return err
 	}
+	out.sep = codec.Separator(out.codec)
 
 	out.log.Infof("Initialized file output. "+
-		"path=%v max_size_bytes=%v max_backups=%v permissions=%v",
-		path, c.RotateEveryKb*1024, c.NumberOfFiles, os.FileMode(c.Permissions))
+		"path=%v max_size_bytes=%v max_backups=%v interval=%v compress=%v max_age=%v permissions=%v",
+		path, c.RotateEveryKb*1024, c.NumberOfFiles, c.Interval, c.Compress, c.MaxAge,
+		os.FileMode(c.Permissions))
 
 	return nil
 }
 
+func (out *fileOutput) newRotator(path string, opts ...file.RotatorOption) (*file.Rotator, error) {
+	c := out.config
+	opts = append([]file.RotatorOption{
+		file.MaxSizeBytes(c.RotateEveryKb * 1024),
+		file.MaxBackups(c.NumberOfFiles),
+		file.Interval(c.Interval),
+		file.Compress(c.Compress),
+		file.MaxAge(c.MaxAge),
+		file.Permissions(os.FileMode(c.Permissions)),
+		file.WithLogger(logp.NewLogger("rotator").With(logp.Namespace("rotator"))),
+	}, opts...)
+	return file.NewFileRotator(path, opts...)
+}
+
+// getRotator returns the rotator of the file the event is written to. If the
+// file name depends on the event, rotators are created on first use.
+func (out *fileOutput) getRotator(event *beat.Event) (*file.Rotator, error) {
+	if out.filename == nil {
+		return out.rotator, nil
+	}
+
+	name, err := out.filename.Run(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format file name: %v", err)
+	}
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return nil, fmt.Errorf("invalid file name '%v'", name)
+	}
+
+	if elem := out.rotators[name]; elem != nil {
+		out.lru.MoveToFront(elem)
+		return elem.Value.(*namedRotator).rotator, nil
+	}
+
+	// Files are closed and opened again as events select them, such that
+	// existing files are appended to instead of being rotated.
+	r, err := out.newRotator(filepath.Join(out.config.Path, name), file.RotateOnStartup(false))
+	if err != nil {
+		return nil, err
+	}
+	out.rotators[name] = out.lru.PushFront(&namedRotator{name: name, rotator: r})
+
+	for out.lru.Len() > out.config.MaxOpenFiles {
+		oldest := out.lru.Remove(out.lru.Back()).(*namedRotator)
+		delete(out.rotators, oldest.name)
+		if err := oldest.rotator.Close(); err != nil {
+			out.log.Errorf("Failed to close file %v: %+v", oldest.name, err)
+		}
+	}
+	return r, nil
+}
+
 // Implement Outputer
 func (out *fileOutput) Close() error {
-	return out.rotator.Close()
+	if out.filename == nil {
+		return out.rotator.Close()
+	}
+
+	var errs multierror.Errors
+	for name, elem := range out.rotators {
+		if err := elem.Value.(*namedRotator).rotator.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(out.rotators, name)
+	}
+	out.lru.Init()
+	return errs.Err()
 }
 
 func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
@@ -134,7 +225,19 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 			continue
 		}
 
-		if _, err = out.rotator.Write(append(serializedEvent, '\n')); err != nil {
+		rotator, err := out.getRotator(&event.Content)
+		if err != nil {
+			if event.Guaranteed() {
+				out.log.Errorf("Failed to select the file: %+v", err)
+			} else {
+				out.log.Warnf("Failed to select the file: %+v", err)
+			}
+
+			dropped++
+			continue
+		}
+
+		if _, err = rotator.Write(append(serializedEvent, out.sep...)); err != nil {
 			st.WriteError(err)
 
 			if event.Guaranteed() {
@@ -147,7 +250,7 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 			continue
 		}
 
-		st.WriteBytes(len(serializedEvent) + 1)
+		st.WriteBytes(len(serializedEvent) + len(out.sep))
 	}
 
 	st.Dropped(dropped)
@@ -18,3 +18,141 @@
 // +build !integration
 
 package fileout
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec/cbor"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+)
+
+func TestPublishToEventFilename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileout")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"path":     dir,
+		"filename": "%{[data_stream.dataset]}.ndjson",
+	})
+	group, err := makeFileout(nil, beat.Info{Beat: "test"}, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	out := group.Clients[0]
+	defer out.Close()
+
+	event := func(dataset interface{}) beat.Event {
+		fields := common.MapStr{"message": "hello"}
+		if dataset != nil {
+			fields.Put("data_stream.dataset", dataset)
+		}
+		return beat.Event{Fields: fields}
+	}
+	batch := outest.NewBatch(event("nginx"), event("mysql"), event("nginx"), event(nil), event("../etc"))
+	require.NoError(t, out.Publish(context.Background(), batch))
+
+	for name, lines := range map[string]int{"nginx.ndjson": 2, "mysql.ndjson": 1} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, lines, strings.Count(string(content), "\n"), name)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestPublishClosesLeastRecentlyUsedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileout")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"path":           dir,
+		"filename":       "%{[name]}.ndjson",
+		"max_open_files": 2,
+	})
+	group, err := makeFileout(nil, beat.Info{Beat: "test"}, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	out := group.Clients[0].(*fileOutput)
+	defer out.Close()
+
+	publish := func(names ...string) {
+		events := make([]beat.Event, len(names))
+		for i, name := range names {
+			events[i] = beat.Event{Fields: common.MapStr{"name": name}}
+		}
+		require.NoError(t, out.Publish(context.Background(), outest.NewBatch(events...)))
+	}
+	openFiles := func() []string {
+		var names []string
+		for elem := out.lru.Front(); elem != nil; elem = elem.Next() {
+			names = append(names, elem.Value.(*namedRotator).name)
+		}
+		return names
+	}
+
+	publish("a", "b", "a", "c")
+	assert.Equal(t, []string{"c.ndjson", "a.ndjson"}, openFiles(), "least recently used file must be closed")
+	assert.Len(t, out.rotators, 2)
+
+	publish("b")
+	assert.Equal(t, []string{"b.ndjson", "c.ndjson"}, openFiles())
+
+	for name, lines := range map[string]int{"a.ndjson": 2, "b.ndjson": 2, "c.ndjson": 1} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, lines, strings.Count(string(content), "\n"), name)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 3, "files opened again must be appended to")
+}
+
+func TestPublishSelfDelimitingCodec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileout")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"path":       dir,
+		"filename":   "events.cbor",
+		"codec.cbor": nil,
+	})
+	group, err := makeFileout(nil, beat.Info{Beat: "test"}, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	out := group.Clients[0]
+	defer out.Close()
+
+	batch := outest.NewBatch(
+		beat.Event{Fields: common.MapStr{"message": "first"}},
+		beat.Event{Fields: common.MapStr{"message": "second"}},
+	)
+	require.NoError(t, out.Publish(context.Background(), batch))
+
+	f, err := os.Open(filepath.Join(dir, "events.cbor"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	dec := cbor.NewDecoder(f)
+	for _, expected := range []string{"first", "second"} {
+		_, event, err := dec.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, expected, event.Fields["message"])
+	}
+	_, _, err = dec.Decode()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,352 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/testing"
+)
+
+type client struct {
+	log      *logp.Logger
+	observer outputs.Observer
+	url      string
+	method   string
+	headers  map[string]string
+	index    string
+	codec    codec.Codec
+	format   string
+
+	compressionLevel int
+	retryable        map[int]bool
+	maxRetryWait     time.Duration
+
+	http    *http.Client
+	tls     *tlscommon.TLSConfig
+	timeout time.Duration
+	auth    httpauth.Provider
+}
+
+// clientSettings contains the settings for a client sending events to a
+// single host.
+type clientSettings struct {
+	URL          string
+	Method       string
+	Parameters   map[string]string
+	Headers      map[string]string
+	Proxy        *url.URL
+	ProxyDisable bool
+	TLS          *tlscommon.TLSConfig
+	Timeout      time.Duration
+
+	// CompressionLevel enables gzip compression of the request body if set
+	// to a value between 1 and 9.
+	CompressionLevel int
+	Auth             httpauth.Provider
+
+	Index  string
+	Codec  codec.Codec
+	Format string
+
+	// RetryableStatus lists the response status codes a batch is retried
+	// for. MaxRetryWait caps the time to wait as requested by the
+	// Retry-After header of a response.
+	RetryableStatus []int
+	MaxRetryWait    time.Duration
+
+	Observer outputs.Observer
+}
+
+var errRejected = errors.New("request rejected")
+
+func newClient(s clientSettings) (*client, error) {
+	httpClient, err := transport.NewHTTPClient(transport.HTTPSettings{
+		Proxy:        s.Proxy,
+		ProxyDisable: s.ProxyDisable,
+		TLS:          s.TLS,
+		Timeout:      s.Timeout,
+		Stats:        s.Observer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Parameters) > 0 {
+		query := u.Query()
+		for k, v := range s.Parameters {
+			query.Set(k, v)
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	retryable := make(map[int]bool, len(s.RetryableStatus))
+	for _, status := range s.RetryableStatus {
+		retryable[status] = true
+	}
+
+	observer := s.Observer
+	if observer == nil {
+		observer = outputs.NewNilObserver()
+	}
+
+	return &client{
+		log:      logp.NewLogger(logSelector),
+		observer: observer,
+		url:      u.String(),
+		method:   s.Method,
+		headers:  s.Headers,
+		index:    strings.ToLower(s.Index),
+		codec:    s.Codec,
+		format:   s.Format,
+
+		compressionLevel: s.CompressionLevel,
+		retryable:        retryable,
+		maxRetryWait:     s.MaxRetryWait,
+
+		http:    httpClient,
+		tls:     s.TLS,
+		timeout: s.Timeout,
+		auth:    s.Auth,
+	}, nil
+}
+
+func (c *client) Connect() error {
+	if c.auth != nil {
+		c.log.Debugf("connect: %v (auth: %v)", c.url, c.auth)
+	} else {
+		c.log.Debugf("connect: %v", c.url)
+	}
+	return nil
+}
+
+func (c *client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// Publish sends all events of the batch in a single request. The batch is
+// ACKed once the request succeeds. Events are retried if the request fails
+// with a network error or a retryable status code, and dropped if the request
+// is rejected with any other status code.
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	body, okEvents := c.encodeEvents(events)
+	dropped := len(events) - len(okEvents)
+	if len(okEvents) == 0 {
+		c.observer.Dropped(dropped)
+		batch.ACK()
+		return nil
+	}
+
+	status, retryAfter, msg, err := c.send(ctx, body)
+	switch {
+	case err != nil:
+		c.observer.Dropped(dropped)
+		c.observer.Failed(len(okEvents))
+		batch.RetryEvents(okEvents)
+		return err
+
+	case status < 300:
+		c.observer.Dropped(dropped)
+		c.observer.Acked(len(okEvents))
+		batch.ACK()
+		return nil
+
+	case c.retryable[status]:
+		if status == http.StatusTooManyRequests {
+			c.observer.ErrTooMany(len(okEvents))
+		}
+		c.observer.Dropped(dropped)
+		c.observer.Failed(len(okEvents))
+		batch.RetryEvents(okEvents)
+		c.waitRetryAfter(ctx, retryAfter)
+		return fmt.Errorf("failed to send %v events (status=%v): %s", len(okEvents), status, msg)
+
+	default:
+		err := fmt.Errorf("%w (status=%v): %s", errRejected, status, msg)
+		c.log.Errorf("Dropping %v events: %v", len(okEvents), err)
+		for i := range okEvents {
+			dlq.Add("http", dlq.ReasonRejected, c.url, &okEvents[i].Content, err)
+		}
+		c.observer.Dropped(dropped + len(okEvents))
+		batch.ACK()
+		return nil
+	}
+}
+
+// encodeEvents encodes the events into the request body. Events that cannot
+// be encoded are dropped.
+func (c *client) encodeEvents(events []publisher.Event) ([]byte, []publisher.Event) {
+	var buf bytes.Buffer
+	okEvents := events[:0:0]
+
+	if c.format == formatJSONArray {
+		buf.WriteByte('[')
+	}
+	for i := range events {
+		event := &events[i].Content
+		serialized, err := c.codec.Encode(c.index, event)
+		if err != nil {
+			c.log.Errorf("Dropping event, failed to encode: %+v", err)
+			dlq.Add("http", dlq.ReasonEncoding, c.url, event, err)
+			continue
+		}
+
+		if c.format == formatJSONArray && len(okEvents) > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(bytes.TrimRight(serialized, "\n"))
+		if c.format == formatNDJSON {
+			buf.WriteByte('\n')
+		}
+		okEvents = append(okEvents, events[i])
+	}
+	if c.format == formatJSONArray {
+		buf.WriteByte(']')
+	}
+	return buf.Bytes(), okEvents
+}
+
+// send posts the body. It returns the response status, the wait time
+// requested by the Retry-After header and a summary of the response body.
+func (c *client) send(ctx context.Context, body []byte) (int, time.Duration, string, error) {
+	contentEncoding := ""
+	if c.compressionLevel > 0 {
+		var buf bytes.Buffer
+		w, err := gzip.NewWriterLevel(&buf, c.compressionLevel)
+		if err == nil {
+			_, err = w.Write(body)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return 0, 0, "", fmt.Errorf("failed to compress request: %v", err)
+		}
+		body, contentEncoding = buf.Bytes(), "gzip"
+	}
+
+	req, err := http.NewRequest(c.method, c.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	req = req.WithContext(ctx)
+
+	if c.format == formatNDJSON {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if c.auth != nil {
+		if err := c.auth.Authorize(req); err != nil {
+			return 0, 0, "", fmt.Errorf("failed to authorize request: %v", err)
+		}
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.observer.WriteError(err)
+		return 0, 0, "", err
+	}
+	defer res.Body.Close()
+	c.observer.WriteBytes(len(body))
+
+	// Only the beginning of the response is kept for error messages.
+	// The rest is drained, such that the connection can be reused.
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		c.observer.ReadError(err)
+	}
+	n, _ := io.Copy(ioutil.Discard, res.Body)
+	c.observer.ReadBytes(len(resBody) + int(n))
+
+	return res.StatusCode, parseRetryAfter(res.Header.Get("Retry-After")), strings.TrimSpace(string(resBody)), nil
+}
+
+// waitRetryAfter waits for the time requested by the server before the
+// batch is retried. The wait is capped by the max backoff.
+func (c *client) waitRetryAfter(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if d > c.maxRetryWait {
+		d = c.maxRetryWait
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given in seconds
+// or as HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func (c *client) String() string {
+	return "http(" + c.url + ")"
+}
+
+func (c *client) Test(d testing.Driver) {
+	transport.TestHTTPEndpoint(d, "http", c.url, c.tls, c.timeout)
+}
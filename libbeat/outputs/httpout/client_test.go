@@ -0,0 +1,228 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+)
+
+func makeTestClient(
+	t *testing.T,
+	settings map[string]interface{},
+	handler http.HandlerFunc,
+) (outputs.Client, *outest.Observer) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return outest.NewClient(t, makeHTTP, beat.Info{Beat: "libbeat"}, map[string]interface{}{
+		"hosts":   []string{server.URL},
+		"path":    "/ingest",
+		"backoff": map[string]interface{}{"init": "1ms", "max": "1ms"},
+	}, settings)
+}
+
+func readMessages(t *testing.T, r *http.Request, format string) []string {
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body = zr
+	}
+	raw, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+
+	var docs []map[string]interface{}
+	if format == formatNDJSON {
+		for _, line := range strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n") {
+			var doc map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &doc))
+			docs = append(docs, doc)
+		}
+	} else {
+		require.NoError(t, json.Unmarshal(raw, &docs))
+	}
+
+	var messages []string
+	for _, doc := range docs {
+		messages = append(messages, doc["message"].(string))
+	}
+	return messages
+}
+
+func TestPublishFormats(t *testing.T) {
+	cases := map[string]struct {
+		settings    map[string]interface{}
+		format      string
+		contentType string
+		encoding    string
+	}{
+		"json array": {
+			format:      formatJSONArray,
+			contentType: "application/json",
+		},
+		"ndjson": {
+			settings:    map[string]interface{}{"format": "ndjson"},
+			format:      formatNDJSON,
+			contentType: "application/x-ndjson",
+		},
+		"gzip compressed": {
+			settings:    map[string]interface{}{"compression_level": 3},
+			format:      formatJSONArray,
+			contentType: "application/json",
+			encoding:    "gzip",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var messages []string
+			client, observer := makeTestClient(t, test.settings, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "/ingest", r.URL.Path)
+				assert.Equal(t, test.contentType, r.Header.Get("Content-Type"))
+				assert.Equal(t, test.encoding, r.Header.Get("Content-Encoding"))
+				messages = readMessages(t, r, test.format)
+			})
+
+			batch := outest.NewMessageBatch(3)
+			require.NoError(t, client.Publish(context.Background(), batch))
+
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+			assert.Equal(t, []string{"event 0", "event 1", "event 2"}, messages)
+			assert.Equal(t, 3, observer.Counts.Acked)
+		})
+	}
+}
+
+func TestPublishHeadersAndParameters(t *testing.T) {
+	settings := map[string]interface{}{
+		"method":     "PUT",
+		"headers":    map[string]interface{}{"X-Tenant": "acme", "Content-Type": "application/vnd.acme+json"},
+		"parameters": map[string]interface{}{"pipeline": "logs"},
+		"username":   "beat",
+		"password":   "secret",
+	}
+	client, _ := makeTestClient(t, settings, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "acme", r.Header.Get("X-Tenant"))
+		assert.Equal(t, "application/vnd.acme+json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "logs", r.URL.Query().Get("pipeline"))
+
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "beat", user)
+		assert.Equal(t, "secret", pass)
+	})
+
+	batch := outest.NewMessageBatch(1)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+}
+
+func TestPublishStatusHandling(t *testing.T) {
+	cases := map[string]struct {
+		settings map[string]interface{}
+		status   int
+		expected outest.Counts
+	}{
+		"server error is retried": {
+			status:   http.StatusServiceUnavailable,
+			expected: outest.Counts{Failed: 2},
+		},
+		"too many requests are retried": {
+			status:   http.StatusTooManyRequests,
+			expected: outest.Counts{Failed: 2, TooMany: 2},
+		},
+		"bad request is dropped": {
+			status:   http.StatusBadRequest,
+			expected: outest.Counts{Dropped: 2},
+		},
+		"custom retryable status is retried": {
+			settings: map[string]interface{}{"retryable_status_codes": []int{409}},
+			status:   http.StatusConflict,
+			expected: outest.Counts{Failed: 2},
+		},
+		"status not configured as retryable is dropped": {
+			settings: map[string]interface{}{"retryable_status_codes": []int{409}},
+			status:   http.StatusServiceUnavailable,
+			expected: outest.Counts{Dropped: 2},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			client, observer := makeTestClient(t, test.settings, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, `{"error": "failed"}`)
+			})
+
+			batch := outest.NewMessageBatch(2)
+			err := client.Publish(context.Background(), batch)
+			outest.AssertPublished(t, batch, err, observer, test.expected)
+		})
+	}
+}
+
+func TestPublishConnectionFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	cfg := common.MustNewConfigFrom(map[string]interface{}{
+		"hosts":   []string{url},
+		"backoff": map[string]interface{}{"init": "1ms", "max": "1ms"},
+	})
+	group, err := makeHTTP(nil, beat.Info{Beat: "libbeat"}, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+
+	client := group.Clients[0].(outputs.NetworkClient)
+	batch := outest.NewMessageBatch(2)
+	assert.Error(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	assert.Len(t, batch.Signals[0].Events, 2)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	assert.Equal(t, 2*time.Minute, parseRetryAfter("120"))
+}
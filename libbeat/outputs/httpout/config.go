@@ -0,0 +1,140 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+)
+
+type httpConfig struct {
+	Protocol         string            `config:"protocol"`
+	Path             string            `config:"path"`
+	Method           string            `config:"method"`
+	Params           map[string]string `config:"parameters"`
+	Headers          map[string]string `config:"headers"`
+	Auth             httpauth.Config   `config:",inline"`
+	ProxyURL         string            `config:"proxy_url"`
+	ProxyDisable     bool              `config:"proxy_disable"`
+	LoadBalance      bool              `config:"loadbalance"`
+	CompressionLevel int               `config:"compression_level" validate:"min=0, max=9"`
+	TLS              *tlscommon.Config `config:"ssl"`
+	BulkMaxSize      int               `config:"bulk_max_size"`
+	MaxRetries       int               `config:"max_retries"`
+	Timeout          time.Duration     `config:"timeout"`
+	Backoff          backoffConfig     `config:"backoff"`
+	Format           string            `config:"format"`
+	Codec            codec.Config      `config:"codec"`
+
+	// RetryableStatus lists the response status codes a batch is retried
+	// for. The events of requests failed with any other status are dropped.
+	RetryableStatus []int `config:"retryable_status_codes"`
+}
+
+type backoffConfig struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
+}
+
+// Body formats of a batch of events.
+const (
+	formatJSONArray = "json_array"
+	formatNDJSON    = "ndjson"
+)
+
+const defaultBulkSize = 50
+
+func defaultConfig() httpConfig {
+	return httpConfig{
+		Protocol:         "",
+		Path:             "",
+		Method:           http.MethodPost,
+		ProxyURL:         "",
+		ProxyDisable:     false,
+		Params:           nil,
+		Timeout:          90 * time.Second,
+		MaxRetries:       3,
+		BulkMaxSize:      defaultBulkSize,
+		CompressionLevel: 0,
+		TLS:              nil,
+		LoadBalance:      true,
+		Backoff: backoffConfig{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+		Format:          formatJSONArray,
+		RetryableStatus: nil, // use defaultRetryableStatus
+	}
+}
+
+// defaultRetryableStatus lists the status codes of failures that can succeed
+// if retried.
+var defaultRetryableStatus = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+func (c *httpConfig) Validate() error {
+	if c.ProxyURL != "" && !c.ProxyDisable {
+		if _, err := common.ParseURL(c.ProxyURL); err != nil {
+			return err
+		}
+	}
+
+	switch strings.ToUpper(c.Method) {
+	case http.MethodPost, http.MethodPut:
+	default:
+		return fmt.Errorf("method '%v' not supported (try POST, PUT)", c.Method)
+	}
+
+	switch c.Format {
+	case formatJSONArray, formatNDJSON:
+	default:
+		return fmt.Errorf("format '%v' unknown (try %v, %v)", c.Format, formatJSONArray, formatNDJSON)
+	}
+
+	for _, status := range c.RetryableStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid status code %v in retryable_status_codes", status)
+		}
+	}
+
+	return nil
+}
+
+func readConfig(cfg *common.Config) (*httpConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	if len(c.RetryableStatus) == 0 {
+		c.RetryableStatus = defaultRetryableStatus
+	}
+	return &c, nil
+}
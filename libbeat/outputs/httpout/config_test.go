@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	c := common.MustNewConfigFrom(common.MapStr{"hosts": []string{"localhost"}})
+	cfg, err := readConfig(c)
+	require.NoError(t, err)
+
+	assert.Equal(t, "POST", cfg.Method)
+	assert.Equal(t, formatJSONArray, cfg.Format)
+	assert.Equal(t, defaultBulkSize, cfg.BulkMaxSize)
+	assert.Equal(t, defaultRetryableStatus, cfg.RetryableStatus)
+}
+
+func TestConfigAcceptValid(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"default config is valid": common.MapStr{},
+		"put with ndjson": common.MapStr{
+			"method": "put",
+			"format": "ndjson",
+		},
+		"gzip compression": common.MapStr{
+			"compression_level": 5,
+		},
+		"custom retryable status codes": common.MapStr{
+			"retryable_status_codes": []int{409, 503},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c := common.MustNewConfigFrom(test)
+			c.SetString("hosts", 0, "localhost")
+			if _, err := readConfig(c); err != nil {
+				t.Fatalf("Can not create test configuration: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"unsupported method": common.MapStr{
+			"method": "GET",
+		},
+		"unknown format": common.MapStr{
+			"format": "xml",
+		},
+		"compression level out of range": common.MapStr{
+			"compression_level": 10,
+		},
+		"invalid retryable status code": common.MapStr{
+			"retryable_status_codes": []int{1000},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c := common.MustNewConfigFrom(test)
+			c.SetString("hosts", 0, "localhost")
+			if _, err := readConfig(c); err == nil {
+				t.Fatalf("Can create test configuration from invalid input")
+			}
+		})
+	}
+}
@@ -19,8 +19,8 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -29,13 +29,12 @@ import (
 	"github.com/Shopify/sarama"
 	"github.com/eapache/go-resiliency/breaker"
 
-	"github.com/snappyflow/beats/v7/libbeat/beat"
-	"github.com/snappyflow/beats/v7/libbeat/common"
 	"github.com/snappyflow/beats/v7/libbeat/common/fmtstr"
 	"github.com/snappyflow/beats/v7/libbeat/common/transport"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
 	"github.com/snappyflow/beats/v7/libbeat/publisher"
 	"github.com/snappyflow/beats/v7/libbeat/testing"
@@ -98,7 +97,6 @@ func newKafkaClient(
 		config:   *cfg,
 		done:     make(chan struct{}),
 	}
-	// ignoretopics["trace-unwlgitz"] = struct{}{}
 	return c, nil
 }
 
@@ -152,152 +150,33 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 		batch:  batch,
 	}
 
-	var valueData map[string]interface{}
 	ch := c.producer.Input()
-	var newEvents []publisher.Event
 	for i := range events {
 		d := &events[i]
-		msg, err := c.getEventMessage(d)
-		if err != nil {
-			c.log.Errorf("Dropping event: %+v", err)
-			ref.done()
-			c.observer.Dropped(1)
-			continue
+		// Events carrying their target topic, like the events created by the
+		// split_trace_body processor, bypass the topic selection.
+		if topic, err := d.Content.Meta.GetValue("topic"); err == nil {
+			d.Cache.Put("topic", topic)
+			d.Content.Meta.Delete("topic")
 		}
-		json.Unmarshal(msg.value, &valueData)
-		if processor, ok := valueData["processor"]; ok {
-			eventType := processor.(map[string]interface{})["event"].(string)
-			c.log.Debugf("eventType: %v", eventType)
-			if eventType == "metric" {
-				ref.done()
-				c.observer.Dropped(1)
-				continue
-			}
-		}
-		labels, ok := valueData["labels"]
-		if !ok {
-			ref.done()
-			c.observer.Dropped(1)
-			continue
-		}
-		profileId, ok := labels.(map[string]interface{})["_tag_profileId"].(string)
-		if !ok {
+
+		msg, err := c.getEventMessage(d)
+		if err == errNoTopicsSelected {
+			// events not matched by any topic rule, like APM metrics, are
+			// not forwarded
+			c.log.Debugf("Dropping event: %+v", err)
 			ref.done()
 			c.observer.Dropped(1)
 			continue
 		}
-		projectName, ok := labels.(map[string]interface{})["_tag_projectName"].(string)
-		// Dropping record if project name is not found
-		if !ok {
+		if err != nil {
+			c.log.Errorf("Dropping event: %+v", err)
+			dlq.Add("kafka", dlq.ReasonEncoding, "", &d.Content, err)
 			ref.done()
 			c.observer.Dropped(1)
 			continue
 		}
-		// Delete Cookies from headers
-		d.Content.Fields.Delete("http.request.headers.Cookies")
-		d.Content.Fields.Delete("http.response.headers.Cookies")
-
-		redactBody := labels.(map[string]interface{})["_tag_redact_body"]
-		if redactBody != nil {
-			indexType := "log"
-			docType := "user-input"
-			tagIndexType := labels.(map[string]interface{})["_tag_IndexType"]
-			tagDocType := labels.(map[string]interface{})["_tag_documentType"]
-			if tagIndexType != nil {
-				if strings.Contains(strings.ToLower(tagIndexType.(string)), "metric") {
-					indexType = "metric"
-				}
-			}
-			if tagDocType != nil {
-				docType = tagDocType.(string)
-			}
-
-			var httpBodyString interface{} = nil
-			httpRequestBodyFound := false
-
-			if http, httpFound := valueData["http"]; httpFound {
-				if request, requestFound := http.(map[string]interface{})["request"]; requestFound {
-					httpBodyString = request.(map[string]interface{})["body"]
-					if httpBodyString != nil {
-						httpRequestBodyFound = true
-					}
-				}
-
-				if httpRequestBodyFound {
-					newEvent := publisher.Event{}
-					newEvent.Cache = events[i].Cache
-					newEvent.Flags = events[i].Flags
-					beatEvent := beat.Event{}
-					beatEvent.Meta = events[i].Content.Meta.Clone()
-					beatEvent.Private = events[i].Content.Private
-					beatEvent.Timestamp = events[i].Content.Timestamp
-					beatEvent.Fields = common.MapStr{}
-
-					traceBody := make(map[string]interface{})
-					if httpBodyString != nil {
-						httpBodyString = httpBodyString.(map[string]interface{})["original"]
-
-						c.log.Debugf("trace httpd body found : %+v ", httpBodyString)
-						httpBody := map[string]interface{}{}
-						switch v := httpBodyString.(type) {
-						case string:
-							if err := json.Unmarshal([]byte(httpBodyString.(string)), &httpBody); err == nil {
-								// traceBody["request_body"] = httpBody
-								beatEvent.Fields.Put("body_json", httpBody)
-							}
-						case map[string]interface{}:
-							// traceBody["request_body"] = httpBodyString
-							beatEvent.Fields.Put("body_json", httpBodyString)
-						default:
-							c.log.Debugf("Found unexpected type %+v", v)
-						}
-
-					} else {
-						// If httpd body is not found do not send log data
-						c.log.Debug("Trace http body not found")
-					}
-
-					traceBody["url"] = valueData["url"]
-					traceBody["service"] = valueData["service"]
-					traceBody["labels"] = valueData["labels"]
-					delete(traceBody["labels"].(map[string]interface{}), "_tag_documentType")
-					delete(traceBody["labels"].(map[string]interface{}), "_tag_IndexType")
-					delete(traceBody["labels"].(map[string]interface{}), "_tag_redact_body")
-					traceBody["processor"] = valueData["processor"]
-					traceBody["source"] = valueData["source"]
-					traceBody["agent"] = valueData["agent"]
-					traceBody["status"], _ = events[i].Content.Fields.GetValue("http.response.status_code")
-					traceBody["user_agent"], _ = events[i].Content.Fields.GetValue("user_agent")
-
-					if valueData["user"] == nil {
-						traceBody["user"] = make(map[string]interface{})
-					} else {
-						traceBody["user"] = valueData["user"]
-					}
-					// log.Debugf("new Index %+v", newIndex)
-					// log.Debugf("Inside trace http body :")
-					beatEvent.Fields.Put("details_json", traceBody)
-					beatEvent.Fields.Put("time", int(valueData["timestamp"].(map[string]interface{})["us"].(float64))/1000)
-
-					beatEvent.Fields.Put("_plugin", "trace_body")
-					beatEvent.Fields.Put("_documentType", docType)
-
-					beatEvent.Fields.Put("_tag_projectName", projectName)
-					beatEvent.Fields.Put("_tag_appName", labels.(map[string]interface{})["_tag_appName"].(string))
-
-					beatEvent.Fields.Put("message", "Trace request body")
-					beatEvent.Fields.Put("trace_id", valueData["trace"].(map[string]interface{})["id"])
-					beatEvent.Fields.Put("transaction_id", valueData["transaction"].(map[string]interface{})["id"])
-					beatEvent.Meta.Put("topic", indexType+"-"+profileId)
-					newEvent.Content = beatEvent
-
-					newEvents = append(newEvents, newEvent)
-
-				}
 
-			}
-		}
-		msg.topic = "trace-" + profileId
 		c.log.Debugf("Kafka Topic: %v", msg.topic)
 		if tval, ok := ignoretopics[msg.topic]; ok {
 			c.log.Debugf("Ignore Kafka Topic: %v events", msg.topic)
@@ -316,43 +195,6 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 		ch <- &msg.msg
 	}
 
-	c.observer.NewBatch(len(newEvents))
-
-	newRef := msgRef{
-		client: c,
-		count:  int32(len(newEvents)),
-		total:  len(newEvents),
-		failed: nil,
-		batch:  nil,
-	}
-
-	for i := range newEvents {
-		d := &newEvents[i]
-		topic, _ := d.Content.Meta.GetValue("topic")
-		d.Content.Meta.Delete("topic")
-		newMsg, err := c.getEventMessage(d)
-		if err == nil {
-			newMsg.topic = topic.(string)
-			if tval, ok := ignoretopics[newMsg.topic]; ok {
-				c.log.Debugf("Ignore Kafka Topic: %v events", newMsg.topic)
-				newRef.done()
-				c.observer.Dropped(1)
-				elapsed := time.Now().Unix() - tval
-				c.log.Debugf("Elapsed: %d", elapsed)
-				if elapsed > IGNOREDURATION {
-					delete(ignoretopics, newMsg.topic)
-				}
-			}
-
-			newMsg.ref = &newRef
-			newMsg.initProducerMessage()
-
-			ch <- &newMsg.msg
-		} else {
-			c.log.Debugf("Error occurred creating new msg for %+v ", err)
-		}
-	}
-
 	return nil
 }
 
@@ -374,29 +216,29 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 		}
 	}
 
-	// value, err = data.Cache.GetValue("topic")
-	// if err == nil {
-	// 	if c.log.IsDebug() {
-	// 		c.log.Debugf("got event.Meta[\"topic\"] = %v", value)
-	// 	}
-	// 	if topic, ok := value.(string); ok {
-	// 		msg.topic = topic
-	// 	}
-	// }
-
-	// if msg.topic == "" {
-	// 	topic, err := c.topic.Select(event)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("setting kafka topic failed with %v", err)
-	// 	}
-	// 	if topic == "" {
-	// 		return nil, errNoTopicsSelected
-	// 	}
-	// 	msg.topic = topic
-	// 	if _, err := data.Cache.Put("topic", topic); err != nil {
-	// 		return nil, fmt.Errorf("setting kafka topic in publisher event failed: %v", err)
-	// 	}
-	// }
+	value, err = data.Cache.GetValue("topic")
+	if err == nil {
+		if c.log.IsDebug() {
+			c.log.Debugf("got event.Meta[\"topic\"] = %v", value)
+		}
+		if topic, ok := value.(string); ok {
+			msg.topic = topic
+		}
+	}
+
+	if msg.topic == "" {
+		topic, err := c.topic.Select(event)
+		if err != nil {
+			return nil, fmt.Errorf("setting kafka topic failed with %v", err)
+		}
+		if topic == "" {
+			return nil, errNoTopicsSelected
+		}
+		msg.topic = topic
+		if _, err := data.Cache.Put("topic", topic); err != nil {
+			return nil, fmt.Errorf("setting kafka topic in publisher event failed: %v", err)
+		}
+	}
 
 	serializedEvent, err := c.codec.Encode(c.index, event)
 	if err != nil {
@@ -473,6 +315,7 @@ func (r *msgRef) fail(msg *message, err error) {
 	if !isRetriable(err) {
 		r.client.log.Errorf("Kafka (topic=%v, size=%v): unretriable error: %v", msg.topic, len(msg.key)+len(msg.value), err.Error())
 		r.client.observer.Dropped(1)
+		dlq.Add("kafka", dlq.ReasonRejected, msg.topic, &msg.data.Content, err)
 		if err == sarama.ErrUnknownTopicOrPartition {
 			r.client.log.Infof("Add topic=%v to Ignore Kafka topic list", msg.topic)
 			ignoretopics[msg.topic] = time.Now().Unix()
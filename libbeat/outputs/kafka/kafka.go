@@ -25,6 +25,7 @@ import (
 	"github.com/eapache/go-resiliency/breaker"
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/kafka"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
@@ -123,11 +124,5 @@ func makeKafka(
 }
 
 func buildTopicSelector(cfg *common.Config) (outil.Selector, error) {
-	return outil.BuildSelectorFromConfig(cfg, outil.Settings{
-		Key:              "topic",
-		MultiKey:         "topics",
-		EnableSingleOnly: true,
-		FailEmpty:        true,
-		Case:             outil.SelectorKeepCase,
-	})
+	return kafka.BuildTopicSelector(cfg)
 }
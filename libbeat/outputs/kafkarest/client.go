@@ -19,25 +19,24 @@ package kafkarest
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-
-	//"fmt"
+	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
-	//"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
 
-	"github.com/snappyflow/beats/v7/libbeat/common"
-
 	"github.com/snappyflow/beats/v7/libbeat/common/fmtstr"
-	//"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
-
-	//"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
 	"github.com/snappyflow/beats/v7/libbeat/publisher"
 	"github.com/snappyflow/beats/v7/libbeat/testing"
 )
@@ -45,20 +44,50 @@ import (
 type client struct {
 	log      *logp.Logger
 	observer outputs.Observer
-	hosts    []string
-	//topic    outil.Selector
-	key   *fmtstr.EventFormatString
-	index string
-	codec codec.Codec
-	//config   sarama.Config
-	config common.Config
-	//mux      sync.Mutex
+	url      string
+	topic    outil.Selector
+	key      *fmtstr.EventFormatString
+	index    string
+	codec    codec.Codec
+	format   apiFormat
+	encoder  contentEncoder
+
+	maxRequestBytes int
+	maxRecords      int
 
-	//producer sarama.AsyncProducer
+	http    *http.Client
+	tls     *tlscommon.TLSConfig
+	timeout time.Duration
 
-	//wg sync.WaitGroup
+	auth httpauth.Provider
 
-	token string
+	drops *dropStats
+}
+
+// clientSettings contains the settings for a client connecting to a single
+// REST Proxy host.
+type clientSettings struct {
+	URL          string
+	Proxy        *url.URL
+	ProxyDisable bool
+	TLS          *tlscommon.TLSConfig
+	Timeout      time.Duration
+
+	Index    string
+	Key      *fmtstr.EventFormatString
+	Topic    outil.Selector
+	Codec    codec.Codec
+	Format   apiFormat
+	Encoder  contentEncoder
+	Observer outputs.Observer
+
+	// MaxRequestBytes and MaxRecords limit the size of a single produce
+	// request. Records of a topic exceeding the limits are split into
+	// multiple requests. Zero disables the limit.
+	MaxRequestBytes int
+	MaxRecords      int
+	Auth            httpauth.Provider
+	Drops           *dropStats
 }
 
 type msgRef struct {
@@ -72,70 +101,68 @@ type msgRef struct {
 }
 
 var (
-	errNoTopicsSelected = errors.New("no topic could be selected")
+	errNoTopicsSelected   = errors.New("no topic could be selected")
+	errTempProduceFailure = errors.New("temporary failure producing records")
 )
 
-func newKafkaRestClient(
-	observer outputs.Observer,
-	hosts []string,
-	index string,
-	key *fmtstr.EventFormatString,
-	writer codec.Codec,
-	//cfg *sarama.Config,
-	cfg *common.Config,
-	token string,
-) (*client, error) {
+// dropError reports why an event cannot be published.
+type dropError struct {
+	reason dropReason
+	err    error
+}
+
+func (e *dropError) Error() string { return e.err.Error() }
+
+func newKafkaRestClient(s clientSettings) (*client, error) {
+	// The transport is shared by all requests send by the client, such that
+	// connections to the REST Proxy are reused between batches.
+	httpClient, err := transport.NewHTTPClient(transport.HTTPSettings{
+		Proxy:        s.Proxy,
+		ProxyDisable: s.ProxyDisable,
+		TLS:          s.TLS,
+		Timeout:      s.Timeout,
+		Stats:        s.Observer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	c := &client{
 		log:      logp.NewLogger(logSelector),
-		observer: observer,
-		hosts:    hosts,
-		key:      key,
-		index:    strings.ToLower(index),
-		codec:    writer,
-		config:   *cfg,
-		token:    token,
+		observer: s.Observer,
+		url:      s.URL,
+		topic:    s.Topic,
+		key:      s.Key,
+		index:    strings.ToLower(s.Index),
+		codec:    s.Codec,
+		format:   s.Format,
+		encoder:  s.Encoder,
+
+		maxRequestBytes: s.MaxRequestBytes,
+		maxRecords:      s.MaxRecords,
+
+		http:    httpClient,
+		tls:     s.TLS,
+		timeout: s.Timeout,
+		auth:    s.Auth,
+
+		drops: s.Drops,
 	}
 	return c, nil
 }
 
 func (c *client) Connect() error {
-	/*
-		c.mux.Lock()
-		defer c.mux.Unlock()
-
-		c.log.Debugf("connect: %v", c.hosts)
-
-		// try to connect
-		producer, err := sarama.NewAsyncProducer(c.hosts, &c.config)
-		if err != nil {
-			c.log.Errorf("Kafka connect fails with: %+v", err)
-			return err
-		}
-
-		c.producer = producer
-
-		c.wg.Add(2)
-		go c.successWorker(producer.Successes())
-		go c.errorWorker(producer.Errors())
-	*/
+	if c.auth != nil {
+		c.log.Debugf("connect: %v (auth: %v)", c.url, c.auth)
+	} else {
+		c.log.Debugf("connect: %v", c.url)
+	}
 	return nil
 }
 
 func (c *client) Close() error {
-	/*
-		c.mux.Lock()
-		defer c.mux.Unlock()
-		c.log.Debug("closed kafka client")
-
-		// producer was not created before the close() was called.
-		if c.producer == nil {
-			return nil
-		}
-
-		c.producer.AsyncClose()
-		c.wg.Wait()
-		c.producer = nil
-	*/
+	c.log.Debug("closed kafkarest client")
+	c.http.CloseIdleConnections()
 	return nil
 }
 
@@ -143,207 +170,88 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 	events := batch.Events()
 	c.observer.NewBatch(len(events))
 
-	data := make(map[string][]map[string]interface{})
+	data := make(map[string][]produceRecord)
 	eventsRecord := make(map[string][]publisher.Event)
-	failedEvents := events[:0]
 	var sendErr error
-	url := c.hosts[0]
-
-	ref := &msgRef{
-		client: c,
-		count:  int32(len(events)),
-		total:  len(events),
-		failed: nil,
-		batch:  batch,
-	}
 
 	dropped := 0
 
 	for i := range events {
-		var valueData map[string]interface{}
-		d := &events[i]
-		msg, err := c.getEventMessage(d)
+		msg, err := c.prepareEvent(&events[i])
 		if err != nil {
-			c.log.Errorf("Dropping event: %+v", err)
-			ref.done()
-			c.observer.Dropped(1)
+			dropped++
 			continue
 		}
 
-		json.Unmarshal(msg.value, &valueData)
-		if processor, ok := valueData["processor"]; ok {
-			eventType := processor.(map[string]interface{})["event"].(string)
-			c.log.Debugf("eventType: %v", eventType)
-			if eventType == "metric" {
-				dropped += 1
-				continue
-			}
-		}
-
-		if labels, ok := valueData["labels"]; ok {
-
-			profileId := labels.(map[string]interface{})["_tag_profileId"].(string)
-			redactBody := labels.(map[string]interface{})["_tag_redact_body"]
-			projectName, projFound := labels.(map[string]interface{})["_tag_projectName"].(string)
-			// Dropping record if project name is not found
-			if !projFound {
-				ref.done()
-				c.observer.Dropped(1)
-				continue
-			}
-
-			// Delete Cookies from headers
-			d.Content.Fields.Delete("http.request.headers.Cookies")
-			d.Content.Fields.Delete("http.response.headers.Cookies")
-
-			topics := make([]string, 0)
-			topics = append(topics, "trace-"+profileId)
-			indexType := "log"
-			docType := "user-input"
-			if redactBody != nil {
-				tagIndexType := labels.(map[string]interface{})["_tag_IndexType"]
-				tagDocType := labels.(map[string]interface{})["_tag_documentType"]
-				if tagIndexType != nil {
-					if strings.Contains(strings.ToLower(tagIndexType.(string)), "metric") {
-						indexType = "metric"
-					}
-				}
-				if tagDocType != nil {
-					docType = tagDocType.(string)
-				}
-			}
-			var httpBodyString interface{} = nil
-			httpRequestBodyFound := false
-
-			if http, httpFound := valueData["http"]; httpFound {
-				if request, requestFound := http.(map[string]interface{})["request"]; requestFound {
-					httpBodyString = request.(map[string]interface{})["body"]
-					if httpBodyString != nil {
-						httpRequestBodyFound = true
-						topics = append(topics, indexType+"-"+profileId)
-					}
-				}
-			}
-			// httpBodyString = valueData["http"].(map[string]interface{})["request"].(map[string]interface{})["body"]
-
-			for _, topic := range topics {
-				record := map[string]interface{}{"key": msg.key, "value": valueData}
-				// Only remove if redactBody Tag is present in labels
-				if redactBody != nil && httpRequestBodyFound {
-					if strings.Contains(topic, "trace") {
-						c.log.Debug("Deleteing http body from trace data ")
-						delete(valueData["http"].(map[string]interface{})["request"].(map[string]interface{}), "body")
-					}
-					if strings.Contains(topic, "log") || strings.Contains(topic, "metric") {
-						logData := make(map[string]interface{})
-						// logData["_traceBody"] = make(map[string]interface{})
-						traceBody := make(map[string]interface{})
-						if httpBodyString != nil {
-							httpBodyString = httpBodyString.(map[string]interface{})["original"]
-
-							c.log.Debugf("trace httpd body found : %+v ", httpBodyString)
-							httpBody := map[string]interface{}{}
-							switch v := httpBodyString.(type) {
-							case string:
-								if err := json.Unmarshal([]byte(httpBodyString.(string)), &httpBody); err == nil {
-									logData["body_json"] = httpBody
-									// traceBody["request_body"] = httpBody
-								}
-							case map[string]interface{}:
-								// traceBody["request_body"] = httpBodyString
-								logData["body_json"] = httpBodyString
-							default:
-								c.log.Debugf("Found unexpected type %+v", v)
-							}
-						} else {
-							// If httpd body is not found do not send log data
-							c.log.Debug("Trace http body not found")
-							continue
-						}
-						traceBody["url"] = valueData["url"]
-						traceBody["service"] = valueData["service"]
-						traceBody["labels"] = valueData["labels"]
-						delete(traceBody["labels"].(map[string]interface{}), "_tag_documentType")
-						delete(traceBody["labels"].(map[string]interface{}), "_tag_IndexType")
-						delete(traceBody["labels"].(map[string]interface{}), "_tag_redact_body")
-						traceBody["processor"] = valueData["processor"]
-						traceBody["source"] = valueData["source"]
-						traceBody["agent"] = valueData["agent"]
-						traceBody["status"] = valueData["http"].(map[string]interface{})["response"].(map[string]interface{})["status_code"]
-						traceBody["user_agent"] = valueData["user_agent"]
-
-						if valueData["user"] == nil {
-							traceBody["user"] = make(map[string]interface{})
-						} else {
-							traceBody["user"] = valueData["user"]
-						}
-
-						c.log.Debugf("Inside trace http body : %v", topic)
-						logData["details_json"] = traceBody
-						logData["time"] =
-							int(valueData["timestamp"].(map[string]interface{})["us"].(float64)) / 1000
-
-						logData["_plugin"] = "trace_body"
-						logData["_documentType"] = docType
-
-						logData["_tag_projectName"] = projectName
-						logData["_tag_appName"] = labels.(map[string]interface{})["_tag_appName"].(string)
-
-						logData["trace_id"] = valueData["trace"].(map[string]interface{})["id"]
-						logData["transaction_id"] = valueData["transaction"].(map[string]interface{})["id"]
-
-						logData["message"] = "Trace request body"
-						c.log.Debugf("Deleteing http body from trace data %+v , %+v", logData["_tag_projectName"], logData["_tag_appName"])
-
-						record["value"] = logData
-					}
-
-				}
-				if rec, exist := data[topic]; exist {
-					rec = append(rec, record)
-					data[topic] = rec
-					if evnts, exst := eventsRecord[topic]; exst {
-						evnts = append(evnts, events[i])
-						eventsRecord[topic] = evnts
-					}
-				} else {
-					rec := []map[string]interface{}{}
-					rec = append(rec, record)
-					data[topic] = rec
-					evnts := []publisher.Event{}
-					evnts = append(evnts, events[i])
-					eventsRecord[topic] = evnts
-				}
-			}
-
-		}
+		record := produceRecord{key: msg.key, value: msg.value}
+		data[msg.topic] = append(data[msg.topic], record)
+		eventsRecord[msg.topic] = append(eventsRecord[msg.topic], events[i])
 	}
-	c.observer.Dropped(dropped)
-
-	if len(data) > 0 {
-		for topic, records := range data {
-			sendErr = c.sendToDest(url, topic, records)
-			if sendErr != nil {
-				if evnts, ok := eventsRecord[topic]; ok {
-					for _, event := range evnts {
-						failedEvents = append(failedEvents, event)
-					}
-				}
-			}
+
+	var failedEvents []publisher.Event
+	var stats produceResultStats
+	for topic, records := range data {
+		failed, topicStats, err := c.publishTopic(topic, records, eventsRecord[topic])
+		if err != nil {
+			sendErr = err
 		}
+		failedEvents = append(failedEvents, failed...)
+		stats.add(topicStats)
 	}
 
-	if len(failedEvents) == 0 {
+	failed := len(failedEvents)
+	c.observer.Acked(stats.acked)
+	c.observer.Failed(failed)
+	c.observer.Dropped(dropped + stats.nonRetriable)
+	c.observer.ErrTooMany(stats.tooMany)
+
+	if failed == 0 {
 		batch.ACK()
-	} else {
-		batch.RetryEvents(failedEvents)
+		return nil
 	}
 
+	batch.RetryEvents(failedEvents)
+	if sendErr == nil {
+		sendErr = errTempProduceFailure
+	}
 	return sendErr
 }
 
 func (c *client) String() string {
-	return "kafka(" + strings.Join(c.hosts, ",") + ")"
+	return "kafkarest(" + c.url + ")"
+}
+
+// prepareEvent selects the topic of an event and encodes it. Events that
+// cannot be published are dropped with the reason of the failure.
+func (c *client) prepareEvent(d *publisher.Event) (*message, error) {
+	// Events carrying their target topic, like the events created by the
+	// split_trace_body processor, bypass the topic selection.
+	if topic, err := d.Content.Meta.GetValue("topic"); err == nil {
+		d.Content.Meta.Delete("topic")
+		if _, ok := topic.(string); !ok {
+			err := fmt.Errorf("invalid topic type %T in event metadata", topic)
+			c.log.Errorf("Dropping event: %+v", err)
+			c.dropEvent(dropInvalidTopic, "", d, err)
+			return nil, err
+		}
+		d.Cache.Put("topic", topic)
+	}
+
+	msg, err := c.getEventMessage(d)
+	if err == nil {
+		return msg, nil
+	}
+
+	reason := dropEncoding
+	if dropErr, ok := err.(*dropError); ok {
+		reason = dropErr.reason
+	}
+	// events not matched by any topic rule are not forwarded
+	if reason != dropNoTopic {
+		c.log.Errorf("Dropping event: %+v", err)
+	}
+	c.dropEvent(reason, "", d, err)
+	return nil, err
 }
 
 func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
@@ -369,21 +277,20 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 			msg.topic = topic
 		}
 	}
-	/*
-		if msg.topic == "" {
-			topic, err := c.topic.Select(event)
-			if err != nil {
-				return nil, fmt.Errorf("setting kafka topic failed with %v", err)
-			}
-			if topic == "" {
-				return nil, errNoTopicsSelected
-			}
-			msg.topic = topic
-			if _, err := data.Cache.Put("topic", topic); err != nil {
-				return nil, fmt.Errorf("setting kafka topic in publisher event failed: %v", err)
-			}
+	if msg.topic == "" {
+		topic, err := c.topic.Select(event)
+		if err != nil {
+			return nil, &dropError{dropInvalidTopic, fmt.Errorf("setting kafka topic failed with %v", err)}
 		}
-	*/
+		if topic == "" {
+			return nil, &dropError{dropNoTopic, errNoTopicsSelected}
+		}
+		msg.topic = topic
+		if _, err := data.Cache.Put("topic", topic); err != nil {
+			return nil, fmt.Errorf("setting kafka topic in publisher event failed: %v", err)
+		}
+	}
+
 	serializedEvent, err := c.codec.Encode(c.index, event)
 	if err != nil {
 		if c.log.IsDebug() {
@@ -483,17 +390,5 @@ func (r *msgRef) dec() {
 }
 
 func (c *client) Test(d testing.Driver) {
-	/*
-		if c.config.Net.TLS.Enable == true {
-			d.Warn("TLS", "Kafka output doesn't support TLS testing")
-		}
-		for _, host := range c.hosts {
-			d.Run("Kafka: "+host, func(d testing.Driver) {
-				netDialer := transport.TestNetDialer(d, c.config.Net.DialTimeout)
-				_, err := netDialer.Dial("tcp", host)
-				d.Error("dial up", err)
-			})
-		}
-
-	*/
+	transport.TestHTTPEndpoint(d, "kafkarest", c.url, c.tls, c.timeout)
 }
@@ -0,0 +1,363 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkarest
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+)
+
+func makeTestClient(t *testing.T, handler http.HandlerFunc) (outputs.Client, *outest.Observer) {
+	return makeTestClientWith(t, nil, handler)
+}
+
+func makeTestClientWith(
+	t *testing.T,
+	settings map[string]interface{},
+	handler http.HandlerFunc,
+) (outputs.Client, *outest.Observer) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	base := map[string]interface{}{
+		"hosts":   []string{strings.TrimPrefix(server.URL, "http://")},
+		"topic":   "test",
+		"backoff": map[string]interface{}{"init": "1ms", "max": "1ms"},
+	}
+	if _, ok := settings["topics"]; ok {
+		delete(base, "topic")
+	}
+	return outest.NewClient(t, makeKafkaRest, beat.Info{Beat: "libbeat"}, base, settings)
+}
+
+func TestPublishPartialFailure(t *testing.T) {
+	client, observer := makeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/test", r.URL.Path)
+		fmt.Fprint(w, `{"offsets": [
+			{"partition": 0, "offset": 1},
+			{"partition": null, "offset": null, "error_code": 2, "error": "leader not available"},
+			{"partition": null, "offset": null, "error_code": 1, "error": "record too large"}
+		]}`)
+	})
+
+	batch := outest.NewMessageBatch(4)
+	err := client.Publish(context.Background(), batch)
+	assert.Error(t, err)
+
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	retried := batch.Signals[0].Events
+	require.Len(t, retried, 2, "retriable record and record without result must be retried")
+	assert.Equal(t, "event 1", retried[0].Content.Fields["message"])
+	assert.Equal(t, "event 3", retried[1].Content.Fields["message"])
+
+	assert.Equal(t, 1, observer.Counts.Acked)
+	assert.Equal(t, 2, observer.Counts.Failed)
+	assert.Equal(t, 1, observer.Counts.Dropped)
+}
+
+func TestPublishStatusHandling(t *testing.T) {
+	cases := map[string]struct {
+		status   int
+		body     string
+		expected outest.Counts
+	}{
+		"server error is retried": {
+			status:   http.StatusServiceUnavailable,
+			body:     `{"error_code": 50301, "message": "unavailable"}`,
+			expected: outest.Counts{Failed: 2},
+		},
+		"too many requests are retried": {
+			status:   http.StatusTooManyRequests,
+			body:     `{"error_code": 42901, "message": "slow down"}`,
+			expected: outest.Counts{Failed: 2, TooMany: 2},
+		},
+		"unknown topic is dropped": {
+			status:   http.StatusNotFound,
+			body:     `{"error_code": 40401, "message": "Topic not found."}`,
+			expected: outest.Counts{Dropped: 2},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			client, observer := makeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			})
+
+			batch := outest.NewMessageBatch(2)
+			err := client.Publish(context.Background(), batch)
+			outest.AssertPublished(t, batch, err, observer, test.expected)
+		})
+	}
+}
+
+func TestPublishTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"offsets": [{"partition": 0, "offset": 1}]}`)
+	}))
+	defer server.Close()
+
+	client, _ := outest.NewClient(t, makeKafkaRest, beat.Info{Beat: "libbeat"}, map[string]interface{}{
+		"hosts": []string{strings.TrimPrefix(server.URL, "https://")},
+		"topic": "test",
+		"ssl":   map[string]interface{}{"verification_mode": "none"},
+	})
+	assert.Equal(t, "backoff(kafkarest("+server.URL+"))", client.String())
+
+	batch := outest.NewMessageBatch(1)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+}
+
+func TestPublishOAuth2TLS(t *testing.T) {
+	// Both servers use a self-signed certificate, such that tokens can only be
+	// requested with the ssl settings of the output.
+	tokenServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "abc", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer tokenServer.Close()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"offsets": [{"partition": 0, "offset": 1}]}`)
+	}))
+	defer server.Close()
+
+	client, _ := outest.NewClient(t, makeKafkaRest, beat.Info{Beat: "libbeat"}, map[string]interface{}{
+		"hosts": []string{strings.TrimPrefix(server.URL, "https://")},
+		"topic": "test",
+		"ssl":   map[string]interface{}{"verification_mode": "none"},
+		"auth.oauth2": map[string]interface{}{
+			"client.id":     "id",
+			"client.secret": "secret",
+			"token_url":     tokenServer.URL,
+		},
+	})
+
+	batch := outest.NewMessageBatch(1)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+}
+
+func TestLoadBalanceClients(t *testing.T) {
+	cases := map[string]struct {
+		loadbalance bool
+		clients     int
+	}{
+		"one client per host with loadbalance": {true, 2},
+		"failover client without loadbalance":  {false, 1},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := common.MustNewConfigFrom(map[string]interface{}{
+				"hosts":       []string{"proxy1:8082", "proxy2:8082"},
+				"topic":       "test",
+				"loadbalance": test.loadbalance,
+			})
+			group, err := makeKafkaRest(nil, beat.Info{Beat: "libbeat"}, outputs.NewNilObserver(), cfg)
+			require.NoError(t, err)
+			assert.Len(t, group.Clients, test.clients)
+			assert.Equal(t, 3, group.Retry)
+		})
+	}
+}
+
+// recordCountHandler replies to a v2 produce request with one successful
+// offset per record, recording the number of records of each request.
+func recordCountHandler(t *testing.T, mu *sync.Mutex, counts *[]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = gz
+		}
+		raw, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+
+		var req struct {
+			Records []json.RawMessage `json:"records"`
+		}
+		require.NoError(t, json.Unmarshal(raw, &req))
+
+		mu.Lock()
+		*counts = append(*counts, len(req.Records))
+		mu.Unlock()
+
+		offsets := make([]string, len(req.Records))
+		for i := range offsets {
+			offsets[i] = `{"partition": 0, "offset": 1}`
+		}
+		fmt.Fprintf(w, `{"offsets": [%v]}`, strings.Join(offsets, ","))
+	}
+}
+
+func TestPublishSplitsRequests(t *testing.T) {
+	cases := map[string]struct {
+		settings map[string]interface{}
+		events   int
+		want     []int
+		dropped  int
+	}{
+		"split by max_records_per_request": {
+			settings: map[string]interface{}{"max_records_per_request": 2},
+			events:   5,
+			want:     []int{2, 2, 1},
+		},
+		"bulk_max_size limits records per request": {
+			settings: map[string]interface{}{"max_records_per_request": 10, "bulk_max_size": 3},
+			events:   5,
+			want:     []int{3, 2},
+		},
+		"split by max_request_bytes": {
+			settings: map[string]interface{}{"max_request_bytes": 200},
+			events:   4,
+			want:     []int{1, 1, 1, 1},
+		},
+		"record exceeding max_request_bytes is dropped": {
+			settings: map[string]interface{}{"max_request_bytes": 10},
+			events:   2,
+			dropped:  2,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var counts []int
+			client, observer := makeTestClientWith(t, test.settings, recordCountHandler(t, &mu, &counts))
+
+			batch := outest.NewMessageBatch(test.events)
+			require.NoError(t, client.Publish(context.Background(), batch))
+			require.Len(t, batch.Signals, 1)
+			assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+			assert.Equal(t, test.want, counts)
+			assert.Equal(t, test.events-test.dropped, observer.Counts.Acked)
+			assert.Equal(t, test.dropped, observer.Counts.Dropped)
+		})
+	}
+}
+
+func TestPublishRetriesFailedRequestOnly(t *testing.T) {
+	requests := 0
+	client, observer := makeTestClientWith(t,
+		map[string]interface{}{"max_records_per_request": 2},
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, `{"error_code": 50301, "message": "unavailable"}`)
+				return
+			}
+			fmt.Fprint(w, `{"offsets": [{"partition": 0, "offset": 1}, {"partition": 0, "offset": 2}]}`)
+		})
+
+	batch := outest.NewMessageBatch(4)
+	assert.Error(t, client.Publish(context.Background(), batch))
+
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	retried := batch.Signals[0].Events
+	require.Len(t, retried, 2)
+	assert.Equal(t, "event 2", retried[0].Content.Fields["message"])
+	assert.Equal(t, "event 3", retried[1].Content.Fields["message"])
+	assert.Equal(t, 2, observer.Counts.Acked)
+	assert.Equal(t, 2, observer.Counts.Failed)
+}
+
+func TestPublishContentEncoding(t *testing.T) {
+	for _, encoding := range []string{"gzip", "zstd"} {
+		encoding := encoding
+		t.Run(encoding, func(t *testing.T) {
+			client, observer := makeTestClientWith(t,
+				map[string]interface{}{"content_encoding": encoding},
+				func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, encoding, r.Header.Get("Content-Encoding"))
+					raw, err := ioutil.ReadAll(r.Body)
+					require.NoError(t, err)
+					assert.False(t, json.Valid(raw), "body must be compressed")
+					fmt.Fprint(w, `{"offsets": [{"partition": 0, "offset": 1}]}`)
+				})
+
+			batch := outest.NewMessageBatch(1)
+			require.NoError(t, client.Publish(context.Background(), batch))
+			assert.Equal(t, 1, observer.Counts.Acked)
+		})
+	}
+}
+
+func TestPublishAuth(t *testing.T) {
+	cases := map[string]struct {
+		settings map[string]interface{}
+		want     string
+	}{
+		"static token": {
+			settings: map[string]interface{}{"auth.token": "Bearer abc"},
+			want:     "Bearer abc",
+		},
+		"basic auth": {
+			settings: map[string]interface{}{"auth.username": "user", "auth.password": "secret"},
+			want:     "Basic dXNlcjpzZWNyZXQ=",
+		},
+		"SASL credentials are not sent to the REST Proxy": {
+			settings: map[string]interface{}{"username": "user", "password": "secret"},
+			want:     "",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			client, observer := makeTestClientWith(t, test.settings, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, test.want, r.Header.Get("Authorization"))
+				fmt.Fprint(w, `{"offsets": [{"partition": 0, "offset": 1}]}`)
+			})
+
+			batch := outest.NewMessageBatch(1)
+			require.NoError(t, client.Publish(context.Background(), batch))
+			assert.Equal(t, 1, observer.Counts.Acked)
+		})
+	}
+}
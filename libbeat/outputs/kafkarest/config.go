@@ -31,6 +31,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/common/cfgwarn"
 	"github.com/snappyflow/beats/v7/libbeat/common/fmtstr"
 	"github.com/snappyflow/beats/v7/libbeat/common/kafka"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
 	"github.com/snappyflow/beats/v7/libbeat/common/transport/kerberos"
 	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
@@ -46,6 +47,11 @@ type backoffConfig struct {
 
 type kafkaConfig struct {
 	Hosts              []string                  `config:"hosts"               validate:"required"`
+	Protocol           string                    `config:"protocol"`
+	Path               string                    `config:"path"`
+	ProxyURL           string                    `config:"proxy_url"`
+	ProxyDisable       bool                      `config:"proxy_disable"`
+	LoadBalance        bool                      `config:"loadbalance"`
 	TLS                *tlscommon.Config         `config:"ssl"`
 	Kerberos           *kerberos.Config          `config:"kerberos"`
 	Timeout            time.Duration             `config:"timeout"             validate:"min=1"`
@@ -65,11 +71,19 @@ type kafkaConfig struct {
 	Backoff            backoffConfig             `config:"backoff"`
 	ClientID           string                    `config:"client_id"`
 	ChanBufferSize     int                       `config:"channel_buffer_size" validate:"min=1"`
+	Codec              codec.Config              `config:"codec"`
 	Username           string                    `config:"username"`
 	Password           string                    `config:"password"`
-	Codec              codec.Config              `config:"codec"`
 	Sasl               saslConfig                `config:"sasl"`
-	Token              string                    `config:"token"`
+	Auth               httpauth.Config           `config:"auth"`
+	APIVersion         string                    `config:"api_version"`
+	Format             string                    `config:"format"`
+	ClusterID          string                    `config:"cluster_id"`
+	ValueSchemaID      int                       `config:"value_schema_id"     validate:"min=0"`
+	KeySchemaID        int                       `config:"key_schema_id"       validate:"min=0"`
+	ContentEncoding    string                    `config:"content_encoding"`
+	MaxRequestBytes    int                       `config:"max_request_bytes"   validate:"min=0"`
+	MaxRecords         int                       `config:"max_records_per_request" validate:"min=0"`
 }
 
 type saslConfig struct {
@@ -110,6 +124,11 @@ const (
 func defaultConfig() kafkaConfig {
 	return kafkaConfig{
 		Hosts:              nil,
+		Protocol:           "",
+		Path:               "",
+		ProxyURL:           "",
+		ProxyDisable:       false,
+		LoadBalance:        false,
 		TLS:                nil,
 		Kerberos:           nil,
 		Timeout:            30 * time.Second,
@@ -135,11 +154,13 @@ func defaultConfig() kafkaConfig {
 			Init: 1 * time.Second,
 			Max:  60 * time.Second,
 		},
-		ClientID:       "beats",
-		ChanBufferSize: 256,
-		Username:       "",
-		Password:       "",
-		Token:          "",
+		ClientID:        "beats",
+		ChanBufferSize:  256,
+		APIVersion:      apiV2,
+		Format:          formatJSON,
+		ContentEncoding: encodingNone,
+		MaxRequestBytes: 1024 * 1024,
+		MaxRecords:      0, // use bulk_max_size
 	}
 }
 
@@ -194,6 +215,36 @@ func (c *kafkaConfig) Validate() error {
 		return fmt.Errorf("password must be set when username is configured")
 	}
 
+	switch strings.ToLower(c.APIVersion) {
+	case apiV2:
+	case apiV3:
+		if c.ClusterID == "" {
+			return fmt.Errorf("cluster_id must be set for REST Proxy API %v", apiV3)
+		}
+	default:
+		return fmt.Errorf("api_version '%v' unknown", c.APIVersion)
+	}
+
+	switch strings.ToLower(c.Format) {
+	case formatJSON, formatBinary:
+	case formatAvro, formatJSONSchema:
+		if c.ValueSchemaID == 0 {
+			return fmt.Errorf("value_schema_id must be set for format '%v'", c.Format)
+		}
+	default:
+		return fmt.Errorf("format '%v' unknown", c.Format)
+	}
+
+	switch strings.ToLower(c.ContentEncoding) {
+	case "", encodingNone, encodingZstd:
+	case encodingGzip:
+		if lvl := c.CompressionLevel; lvl < 0 || lvl > 9 {
+			return fmt.Errorf("compression_level must be between 0 and 9 for content_encoding '%v'", c.ContentEncoding)
+		}
+	default:
+		return fmt.Errorf("content_encoding '%v' unknown", c.ContentEncoding)
+	}
+
 	if c.Compression == "gzip" {
 		lvl := c.CompressionLevel
 		if lvl != sarama.CompressionLevelDefault && !(0 <= lvl && lvl <= 9) {
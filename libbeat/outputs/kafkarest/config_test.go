@@ -23,8 +23,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
 	"github.com/snappyflow/beats/v7/libbeat/internal/testutil"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 )
@@ -32,6 +36,15 @@ import (
 func TestConfigAcceptValid(t *testing.T) {
 	tests := map[string]common.MapStr{
 		"default config is valid": common.MapStr{},
+		"v3 with binary format": common.MapStr{
+			"api_version": "v3",
+			"cluster_id":  "abc",
+			"format":      "binary",
+		},
+		"jsonschema with value_schema_id": common.MapStr{
+			"format":          "jsonschema",
+			"value_schema_id": 1,
+		},
 		"lz4 with 0.11": common.MapStr{
 			"compression": "lz4",
 			"version":     "0.11",
@@ -80,6 +93,18 @@ func TestConfigAcceptValid(t *testing.T) {
 
 func TestConfigInvalid(t *testing.T) {
 	tests := map[string]common.MapStr{
+		"unknown api_version": common.MapStr{
+			"api_version": "v1",
+		},
+		"v3 without cluster_id": common.MapStr{
+			"api_version": "v3",
+		},
+		"unknown format": common.MapStr{
+			"format": "protobuf",
+		},
+		"avro without value_schema_id": common.MapStr{
+			"format": "avro",
+		},
 		"Kerberos with invalid auth_type": common.MapStr{
 			"kerberos": common.MapStr{
 				"auth_type":    "invalid_auth_type",
@@ -88,6 +113,12 @@ func TestConfigInvalid(t *testing.T) {
 				"realm":        "ELASTIC",
 			},
 		},
+		"SASL username without password": common.MapStr{
+			"username": "user",
+		},
+		"HTTP auth username without password": common.MapStr{
+			"auth.username": "user",
+		},
 	}
 
 	for name, test := range tests {
@@ -193,3 +224,24 @@ func TestTopicSelection(t *testing.T) {
 		})
 	}
 }
+
+func TestSASLConfig(t *testing.T) {
+	c := common.MustNewConfigFrom(map[string]interface{}{
+		"hosts":    []string{"localhost"},
+		"username": "user",
+		"password": "secret",
+	})
+	cfg, err := readConfig(c)
+	require.NoError(t, err)
+
+	saramaConfig, err := newSaramaConfig(logp.L(), cfg)
+	require.NoError(t, err)
+	assert.True(t, saramaConfig.Net.SASL.Enable)
+	assert.Equal(t, "user", saramaConfig.Net.SASL.User)
+	assert.Equal(t, "secret", saramaConfig.Net.SASL.Password)
+
+	// SASL credentials are not used to authenticate requests to the REST Proxy
+	auth, err := httpauth.NewProvider(cfg.Auth, nil)
+	require.NoError(t, err)
+	assert.Nil(t, auth)
+}
@@ -0,0 +1,100 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkarest
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// dropReason classifies events dropped by the output.
+type dropReason string
+
+const (
+	// dropNoTopic counts events not matched by any topic rule. These events
+	// are filtered on purpose and never written to the dead letter queue.
+	dropNoTopic dropReason = "no_topic"
+
+	dropInvalidTopic dropReason = "invalid_topic"
+	dropEncoding     dropReason = "encoding"
+	dropTooLarge     dropReason = "too_large"
+	dropRejected     dropReason = "rejected"
+)
+
+var dropReasons = []dropReason{
+	dropNoTopic,
+	dropInvalidTopic,
+	dropEncoding,
+	dropTooLarge,
+	dropRejected,
+}
+
+// dropStats counts dropped events per reason. The counters are registered
+// with the output metrics, for example as
+// libbeat.output.kafkarest.dropped.<reason>.
+type dropStats struct {
+	counters map[dropReason]*monitoring.Uint
+}
+
+func newDropStats(observer outputs.Observer) *dropStats {
+	reg := monitoring.NewRegistry()
+	if stats, ok := observer.(interface{ Registry() *monitoring.Registry }); ok && stats.Registry() != nil {
+		reg = stats.Registry()
+	}
+	// The registry is shared by the outputs created with the same observer,
+	// like the outputs created in tests.
+	if kafkarestReg := reg.GetRegistry("kafkarest"); kafkarestReg != nil {
+		kafkarestReg.Clear()
+		reg = kafkarestReg
+	} else {
+		reg = reg.NewRegistry("kafkarest")
+	}
+
+	s := &dropStats{counters: map[dropReason]*monitoring.Uint{}}
+	for _, reason := range dropReasons {
+		s.counters[reason] = monitoring.NewUint(reg, "dropped."+string(reason))
+	}
+	return s
+}
+
+func (s *dropStats) inc(reason dropReason) {
+	if s == nil {
+		return
+	}
+	if counter := s.counters[reason]; counter != nil {
+		counter.Inc()
+	}
+}
+
+func (s *dropStats) get(reason dropReason) uint64 {
+	if s == nil || s.counters[reason] == nil {
+		return 0
+	}
+	return s.counters[reason].Get()
+}
+
+// dropEvent counts a dropped event and hands it to the dead letter queue.
+func (c *client) dropEvent(reason dropReason, topic string, event *publisher.Event, cause error) {
+	c.drops.inc(reason)
+	if reason == dropNoTopic {
+		return
+	}
+	dlq.Add("kafkarest", string(reason), topic, &event.Content, cause)
+}
@@ -0,0 +1,143 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkarest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+)
+
+type failingCodec struct{}
+
+func (failingCodec) Encode(string, *beat.Event) ([]byte, error) {
+	return nil, errors.New("cannot encode event")
+}
+
+// recordingSink records the dead letters of the default dead letter queue.
+type recordingSink struct {
+	entries []dlq.Entry
+}
+
+func (s *recordingSink) Write(entry dlq.Entry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestPublishDropsMalformedEvents(t *testing.T) {
+	sink := &recordingSink{}
+	dlq.SetDefault(dlq.NewQueue(sink))
+	defer dlq.SetDefault(nil)
+
+	client, observer := makeTestClientWith(t,
+		map[string]interface{}{
+			"topics": []map[string]interface{}{
+				{"topic": "test", "when.has_fields": []string{"message"}},
+			},
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"offsets": [
+				{"partition": 0, "offset": 1},
+				{"partition": null, "offset": null, "error_code": 1, "error": "record too large"}
+			]}`)
+		})
+
+	batch := outest.NewBatch(
+		beat.Event{Fields: common.MapStr{"message": "ok"}},
+		beat.Event{Fields: common.MapStr{"message": "rejected"}},
+		beat.Event{
+			Meta:   common.MapStr{"topic": 42},
+			Fields: common.MapStr{"message": "invalid topic"},
+		},
+		beat.Event{Fields: common.MapStr{"other": "not routed"}},
+	)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+	assert.Equal(t, 1, observer.Counts.Acked)
+	assert.Equal(t, 3, observer.Counts.Dropped)
+
+	entries := sink.entries
+	require.Len(t, entries, 2, "events not matched by any topic rule must not be recorded")
+
+	assert.Equal(t, string(dropInvalidTopic), entries[0].Reason)
+	assert.Equal(t, "kafkarest", entries[0].Output)
+	assert.Equal(t, "invalid topic", entries[0].Event.Fields["message"])
+
+	assert.Equal(t, string(dropRejected), entries[1].Reason)
+	assert.Equal(t, "test", entries[1].Target)
+	assert.Equal(t, "rejected", entries[1].Event.Fields["message"])
+	assert.Contains(t, entries[1].Error, "record too large")
+}
+
+func TestPublishDropsUnencodableEvents(t *testing.T) {
+	topic, err := buildTopicSelector(common.MustNewConfigFrom(map[string]interface{}{"topic": "test"}))
+	require.NoError(t, err)
+	format, err := newAPIFormat(&kafkaConfig{APIVersion: apiV2, Format: formatJSON})
+	require.NoError(t, err)
+
+	observer := outest.NewObserver()
+	drops := newDropStats(observer)
+	client, err := newKafkaRestClient(clientSettings{
+		URL:      "http://localhost:8082",
+		Topic:    topic,
+		Codec:    failingCodec{},
+		Format:   format,
+		Observer: observer,
+		Drops:    drops,
+	})
+	require.NoError(t, err)
+
+	batch := outest.NewMessageBatch(1)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+	assert.Equal(t, 1, observer.Counts.Dropped)
+	assert.Equal(t, uint64(1), drops.get(dropEncoding))
+}
+
+func TestDropStatsPerOutput(t *testing.T) {
+	logsReg, metricsReg := monitoring.NewRegistry(), monitoring.NewRegistry()
+
+	logs := newDropStats(outputs.NewStats(logsReg))
+	logs.inc(dropEncoding)
+	metrics := newDropStats(outputs.NewStats(metricsReg))
+	metrics.inc(dropRejected)
+
+	snapshot := monitoring.CollectFlatSnapshot(logsReg, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["kafkarest.dropped.encoding"])
+	assert.Equal(t, int64(0), snapshot.Ints["kafkarest.dropped.rejected"])
+
+	snapshot = monitoring.CollectFlatSnapshot(metricsReg, monitoring.Full, false)
+	assert.Equal(t, int64(0), snapshot.Ints["kafkarest.dropped.encoding"])
+	assert.Equal(t, int64(1), snapshot.Ints["kafkarest.dropped.rejected"])
+}
@@ -0,0 +1,95 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkarest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings supported for produce requests.
+const (
+	encodingNone = "none"
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// contentEncoder compresses the body of produce requests.
+type contentEncoder interface {
+	// Name returns the value of the Content-Encoding header.
+	Name() string
+	Encode(body []byte) ([]byte, error)
+}
+
+type gzipContentEncoder struct {
+	level int
+}
+
+type zstdContentEncoder struct {
+	encoder *zstd.Encoder
+}
+
+// newContentEncoder creates the encoder for the configured content encoding.
+// It returns nil if requests are not compressed.
+func newContentEncoder(encoding string, level int) (contentEncoder, error) {
+	switch strings.ToLower(encoding) {
+	case "", encodingNone:
+		return nil, nil
+	case encodingGzip:
+		return &gzipContentEncoder{level: level}, nil
+	case encodingZstd:
+		enc, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdContentEncoder{encoder: enc}, nil
+	default:
+		return nil, fmt.Errorf("content_encoding '%v' unknown", encoding)
+	}
+}
+
+func (e *gzipContentEncoder) Name() string { return encodingGzip }
+
+func (e *gzipContentEncoder) Encode(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, e.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e *zstdContentEncoder) Name() string { return encodingZstd }
+
+// Encode compresses the body. EncodeAll is safe for concurrent use, such that
+// the encoder can be shared by all clients of the output.
+func (e *zstdContentEncoder) Encode(body []byte) ([]byte, error) {
+	return e.encoder.EncodeAll(body, make([]byte, 0, len(body)/2)), nil
+}
@@ -0,0 +1,266 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkarest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Embedded formats of the records value supported by the REST Proxy.
+const (
+	formatJSON       = "json"
+	formatBinary     = "binary"
+	formatAvro       = "avro"
+	formatJSONSchema = "jsonschema"
+)
+
+// REST Proxy API versions.
+const (
+	apiV2 = "v2"
+	apiV3 = "v3"
+)
+
+// produceRecord is a single record of a produce request.
+type produceRecord struct {
+	key   []byte
+	value []byte // JSON encoded event
+}
+
+// recordResult is the result of producing a single record reported by the
+// REST Proxy.
+type recordResult struct {
+	failed    bool
+	retriable bool
+	code      int
+	message   string
+}
+
+// apiFormat encodes produce requests and decodes their responses for a REST
+// Proxy API version and embedded format.
+type apiFormat interface {
+	Path(topic string) string
+	ContentType() string
+	EncodeRecords(records []produceRecord) ([]byte, error)
+
+	// DecodeResults parses the per record results of a successful produce
+	// request.
+	DecodeResults(body []byte) ([]recordResult, error)
+}
+
+type v2Format struct {
+	embedded      string
+	valueSchemaID int
+	keySchemaID   int
+}
+
+type v2Request struct {
+	KeySchemaID   int        `json:"key_schema_id,omitempty"`
+	ValueSchemaID int        `json:"value_schema_id,omitempty"`
+	Records       []v2Record `json:"records"`
+}
+
+type v2Record struct {
+	Key   interface{} `json:"key,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+type v3Format struct {
+	clusterID     string
+	embedded      string
+	valueSchemaID int
+	keySchemaID   int
+}
+
+type v3Record struct {
+	Key   *v3Data `json:"key,omitempty"`
+	Value *v3Data `json:"value"`
+}
+
+type v3Data struct {
+	Type     string      `json:"type,omitempty"`
+	SchemaID int         `json:"schema_id,omitempty"`
+	Data     interface{} `json:"data"`
+}
+
+type v3Result struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func newAPIFormat(config *kafkaConfig) (apiFormat, error) {
+	embedded := strings.ToLower(config.Format)
+	switch strings.ToLower(config.APIVersion) {
+	case apiV2:
+		return &v2Format{
+			embedded:      embedded,
+			valueSchemaID: config.ValueSchemaID,
+			keySchemaID:   config.KeySchemaID,
+		}, nil
+	case apiV3:
+		return &v3Format{
+			clusterID:     config.ClusterID,
+			embedded:      embedded,
+			valueSchemaID: config.ValueSchemaID,
+			keySchemaID:   config.KeySchemaID,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown REST Proxy API version '%v'", config.APIVersion)
+	}
+}
+
+func (f *v2Format) Path(topic string) string {
+	return "/topics/" + url.PathEscape(topic)
+}
+
+func (f *v2Format) ContentType() string {
+	return "application/vnd.kafka." + f.embedded + ".v2+json"
+}
+
+func (f *v2Format) EncodeRecords(records []produceRecord) ([]byte, error) {
+	req := v2Request{Records: make([]v2Record, len(records))}
+	if f.embedded == formatAvro || f.embedded == formatJSONSchema {
+		req.ValueSchemaID = f.valueSchemaID
+		req.KeySchemaID = f.keySchemaID
+	}
+
+	for i, rec := range records {
+		switch f.embedded {
+		case formatBinary:
+			req.Records[i].Value = rec.value
+			if len(rec.key) > 0 {
+				req.Records[i].Key = rec.key
+			}
+		default:
+			req.Records[i].Value = json.RawMessage(rec.value)
+			if len(rec.key) > 0 && (f.embedded == formatJSON || f.keySchemaID > 0) {
+				req.Records[i].Key = string(rec.key)
+			}
+		}
+	}
+	return json.Marshal(req)
+}
+
+func (f *v2Format) DecodeResults(body []byte) ([]recordResult, error) {
+	var resp produceResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([]recordResult, len(resp.Offsets))
+	for i, offset := range resp.Offsets {
+		if offset.ErrorCode == nil {
+			continue
+		}
+
+		results[i] = recordResult{
+			failed:    true,
+			retriable: *offset.ErrorCode != recordErrNonRetriable,
+			code:      *offset.ErrorCode,
+		}
+		if offset.Error != nil {
+			results[i].message = *offset.Error
+		}
+	}
+	return results, nil
+}
+
+func (f *v3Format) Path(topic string) string {
+	return "/v3/clusters/" + url.PathEscape(f.clusterID) + "/topics/" + url.PathEscape(topic) + "/records"
+}
+
+func (f *v3Format) ContentType() string {
+	return "application/json"
+}
+
+// EncodeRecords encodes the records as a stream of produce requests, such
+// that all records are produced using a single HTTP request.
+func (f *v3Format) EncodeRecords(records []produceRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(f.record(rec)); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *v3Format) record(rec produceRecord) v3Record {
+	var r v3Record
+	switch f.embedded {
+	case formatBinary:
+		r.Value = &v3Data{Type: "BINARY", Data: rec.value}
+	case formatJSON:
+		r.Value = &v3Data{Type: "JSON", Data: json.RawMessage(rec.value)}
+	default:
+		r.Value = &v3Data{SchemaID: f.valueSchemaID, Data: json.RawMessage(rec.value)}
+	}
+
+	if len(rec.key) == 0 {
+		return r
+	}
+	switch {
+	case f.embedded == formatJSON:
+		r.Key = &v3Data{Type: "JSON", Data: string(rec.key)}
+	case f.keySchemaID > 0 && f.embedded != formatBinary:
+		r.Key = &v3Data{SchemaID: f.keySchemaID, Data: string(rec.key)}
+	default:
+		r.Key = &v3Data{Type: "BINARY", Data: rec.key}
+	}
+	return r
+}
+
+func (f *v3Format) DecodeResults(body []byte) ([]recordResult, error) {
+	var results []recordResult
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var res v3Result
+		err := dec.Decode(&res)
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+
+		if res.ErrorCode == http.StatusOK {
+			results = append(results, recordResult{})
+			continue
+		}
+		results = append(results, recordResult{
+			failed:    true,
+			retriable: isRetriableStatus(res.ErrorCode),
+			code:      res.ErrorCode,
+			message:   res.Message,
+		})
+	}
+}
+
+// isRetriableStatus checks if a request failed with the HTTP status code can
+// succeed if retried.
+func isRetriableStatus(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status == http.StatusRequestTimeout ||
+		status >= 500
+}
@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkarest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+func makeTestFormat(t *testing.T, settings map[string]interface{}) apiFormat {
+	c := common.MustNewConfigFrom(settings)
+	c.SetString("hosts", 0, "localhost")
+	config, err := readConfig(c)
+	require.NoError(t, err)
+
+	format, err := newAPIFormat(config)
+	require.NoError(t, err)
+	return format
+}
+
+func TestV2FormatEncodeRecords(t *testing.T) {
+	records := []produceRecord{
+		{key: []byte("k"), value: []byte(`{"message":"hello"}`)},
+	}
+
+	cases := map[string]struct {
+		settings    map[string]interface{}
+		contentType string
+		want        string
+	}{
+		"json": {
+			settings:    map[string]interface{}{},
+			contentType: "application/vnd.kafka.json.v2+json",
+			want:        `{"records":[{"key":"k","value":{"message":"hello"}}]}`,
+		},
+		"binary": {
+			settings:    map[string]interface{}{"format": "binary"},
+			contentType: "application/vnd.kafka.binary.v2+json",
+			want:        `{"records":[{"key":"aw==","value":"eyJtZXNzYWdlIjoiaGVsbG8ifQ=="}]}`,
+		},
+		"avro": {
+			settings:    map[string]interface{}{"format": "avro", "value_schema_id": 7},
+			contentType: "application/vnd.kafka.avro.v2+json",
+			want:        `{"value_schema_id":7,"records":[{"value":{"message":"hello"}}]}`,
+		},
+		"jsonschema with key schema": {
+			settings:    map[string]interface{}{"format": "jsonschema", "value_schema_id": 7, "key_schema_id": 3},
+			contentType: "application/vnd.kafka.jsonschema.v2+json",
+			want:        `{"key_schema_id":3,"value_schema_id":7,"records":[{"key":"k","value":{"message":"hello"}}]}`,
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			format := makeTestFormat(t, test.settings)
+			assert.Equal(t, "/topics/test", format.Path("test"))
+			assert.Equal(t, test.contentType, format.ContentType())
+
+			body, err := format.EncodeRecords(records)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.want, string(body))
+		})
+	}
+}
+
+func TestV3FormatEncodeRecords(t *testing.T) {
+	format := makeTestFormat(t, map[string]interface{}{
+		"api_version": "v3",
+		"cluster_id":  "abc",
+	})
+	assert.Equal(t, "/v3/clusters/abc/topics/test/records", format.Path("test"))
+	assert.Equal(t, "application/json", format.ContentType())
+
+	body, err := format.EncodeRecords([]produceRecord{
+		{key: []byte("k"), value: []byte(`{"message":"a"}`)},
+		{value: []byte(`{"message":"b"}`)},
+	})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"key":{"type":"JSON","data":"k"},"value":{"type":"JSON","data":{"message":"a"}}}`, lines[0])
+	assert.JSONEq(t, `{"value":{"type":"JSON","data":{"message":"b"}}}`, lines[1])
+}
+
+func TestV3FormatEncodeSchemaRecords(t *testing.T) {
+	format := makeTestFormat(t, map[string]interface{}{
+		"api_version":     "v3",
+		"cluster_id":      "abc",
+		"format":          "avro",
+		"value_schema_id": 7,
+	})
+
+	body, err := format.EncodeRecords([]produceRecord{{value: []byte(`{"message":"a"}`)}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"value":{"schema_id":7,"data":{"message":"a"}}}`, string(body))
+}
+
+func TestV3FormatDecodeResults(t *testing.T) {
+	format := makeTestFormat(t, map[string]interface{}{
+		"api_version": "v3",
+		"cluster_id":  "abc",
+	})
+
+	body := `{"error_code":200,"partition_id":0,"offset":1}
+{"error_code":400,"message":"record too large"}
+{"error_code":500,"message":"leader not available"}
+`
+	results, err := format.DecodeResults([]byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, []recordResult{
+		{},
+		{failed: true, retriable: false, code: 400, message: "record too large"},
+		{failed: true, retriable: true, code: 500, message: "leader not available"},
+	}, results)
+}
+
+func TestV2FormatDecodeResults(t *testing.T) {
+	format := makeTestFormat(t, map[string]interface{}{})
+
+	var body json.RawMessage = []byte(`{"offsets": [
+		{"partition": 0, "offset": 1},
+		{"partition": null, "offset": null, "error_code": 2, "error": "leader not available"},
+		{"partition": null, "offset": null, "error_code": 1, "error": "record too large"}
+	]}`)
+	results, err := format.DecodeResults(body)
+	require.NoError(t, err)
+	assert.Equal(t, []recordResult{
+		{},
+		{failed: true, retriable: true, code: 2, message: "leader not available"},
+		{failed: true, retriable: false, code: 1, message: "record too large"},
+	}, results)
+}
@@ -19,12 +19,17 @@ package kafkarest
 
 import (
 	"errors"
+	"net/url"
 	"time"
 
 	"github.com/Shopify/sarama"
 
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/kafka"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
 	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
@@ -39,6 +44,9 @@ const (
 	defaultMaxWaitRetry = 60 * time.Second
 
 	logSelector = "kafkarest"
+
+	// defaultPort is the default port of the Confluent REST Proxy.
+	defaultPort = 8082
 )
 
 var (
@@ -65,12 +73,11 @@ func makeKafkaRest(
 	if err != nil {
 		return outputs.Fail(err)
 	}
-	/*
-		topic, err := buildTopicSelector(cfg)
-		if err != nil {
-			return outputs.Fail(err)
-		}
-	*/
+	topic, err := buildTopicSelector(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
 	/*
 		libCfg, err := newSaramaConfig(log, config)
 		if err != nil {
@@ -87,41 +94,98 @@ func makeKafkaRest(
 		return outputs.Fail(err)
 	}
 
-	token := getToken(cfg)
+	tlsConfig, err := tlscommon.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return outputs.Fail(err)
+	}
 
-	client, err := newKafkaRestClient(observer, hosts, beat.IndexPrefix, config.Key, codec, cfg, token)
+	var proxyURL *url.URL
+	if !config.ProxyDisable {
+		proxyURL, err = common.ParseURL(config.ProxyURL)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+		if proxyURL != nil {
+			log.Infof("Using proxy URL: %s", proxyURL)
+		}
+	}
+
+	protocol := config.Protocol
+	if protocol == "" && tlsConfig != nil {
+		protocol = "https"
+	}
+
+	format, err := newAPIFormat(config)
 	if err != nil {
 		return outputs.Fail(err)
 	}
 
-	retry := 0
-	if config.MaxRetries < 0 {
-		retry = -1
+	encoder, err := newContentEncoder(config.ContentEncoding, config.CompressionLevel)
+	if err != nil {
+		return outputs.Fail(err)
 	}
-	return outputs.Success(config.BulkMaxSize, retry, client)
-}
 
-func buildTopicSelector(cfg *common.Config) (outil.Selector, error) {
-	return outil.BuildSelectorFromConfig(cfg, outil.Settings{
-		Key:              "topic",
-		MultiKey:         "topics",
-		EnableSingleOnly: true,
-		FailEmpty:        true,
-		Case:             outil.SelectorKeepCase,
+	// A single request never holds more records than fit into a batch.
+	maxRecords := config.MaxRecords
+	if config.BulkMaxSize > 0 && (maxRecords == 0 || maxRecords > config.BulkMaxSize) {
+		maxRecords = config.BulkMaxSize
+	}
+
+	authClient, err := transport.NewHTTPClient(transport.HTTPSettings{
+		Proxy:        proxyURL,
+		ProxyDisable: config.ProxyDisable,
+		TLS:          tlsConfig,
+		Timeout:      config.Timeout,
 	})
-}
+	if err != nil {
+		return outputs.Fail(err)
+	}
+	auth, err := httpauth.NewProvider(config.Auth, authClient)
+	if err != nil {
+		return outputs.Fail(err)
+	}
 
-func getToken(cfg *common.Config) string {
-	config := struct {
-		Token string `config:"token"`
-	}{}
+	drops := newDropStats(observer)
 
-	err := cfg.Unpack(&config)
-	if err != nil {
-		return ""
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		restURL, err := common.MakeURL(protocol, config.Path, host, defaultPort)
+		if err != nil {
+			log.Errorf("Invalid host param set: %s, Error: %+v", host, err)
+			return outputs.Fail(err)
+		}
+
+		var client outputs.NetworkClient
+		client, err = newKafkaRestClient(clientSettings{
+			URL:          restURL,
+			Proxy:        proxyURL,
+			ProxyDisable: config.ProxyDisable,
+			TLS:          tlsConfig,
+			Timeout:      config.Timeout,
+			Index:        beat.IndexPrefix,
+			Key:          config.Key,
+			Topic:        topic,
+			Codec:        codec,
+			Format:       format,
+			Encoder:      encoder,
+			Observer:     observer,
+			Auth:         auth,
+			Drops:        drops,
+
+			MaxRequestBytes: config.MaxRequestBytes,
+			MaxRecords:      maxRecords,
+		})
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		client = outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max)
+		clients[i] = client
 	}
 
-	token := config.Token
+	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+}
 
-	return token
+func buildTopicSelector(cfg *common.Config) (outil.Selector, error) {
+	return kafka.BuildTopicSelector(cfg)
 }
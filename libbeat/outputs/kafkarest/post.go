@@ -20,54 +20,247 @@ package kafkarest
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
-	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
 )
 
-func (c *client) sendToDest(url string, topic string, kafkaRecords []map[string]interface{}) error {
-	if c.token != "" {
-		c.log.Debugf(c.token)
-	} else {
-		c.log.Debugf("No Auth token")
+// recordErrNonRetriable is the REST Proxy error code reported in the offsets
+// of a produce response for records that will fail again if retried. Records
+// failed with any other error code are retried.
+const recordErrNonRetriable = 1
+
+// produceResponse is the body of a REST Proxy reply to a produce request.
+// Error replies only carry the error code and message.
+type produceResponse struct {
+	Offsets   []produceOffset `json:"offsets"`
+	ErrorCode int             `json:"error_code"`
+	Message   string          `json:"message"`
+}
+
+type produceOffset struct {
+	Partition *int32  `json:"partition"`
+	Offset    *int64  `json:"offset"`
+	ErrorCode *int    `json:"error_code"`
+	Error     *string `json:"error"`
+}
+
+type produceResultStats struct {
+	acked        int // number of records written to kafka
+	fails        int // number of records to be retried
+	nonRetriable int // number of records rejected by the REST Proxy
+	tooMany      int // number of records rejected with 429
+}
+
+func (s *produceResultStats) add(other produceResultStats) {
+	s.acked += other.acked
+	s.fails += other.fails
+	s.nonRetriable += other.nonRetriable
+	s.tooMany += other.tooMany
+}
+
+// publishTopic produces the records of a topic. The records are split into
+// multiple requests if they exceed the configured request limits. Each request
+// is handled on its own, such that only the events of failed requests are
+// retried.
+func (c *client) publishTopic(
+	topic string,
+	records []produceRecord,
+	events []publisher.Event,
+) ([]publisher.Event, produceResultStats, error) {
+	var failed []publisher.Event
+	var stats produceResultStats
+	var lastErr error
+
+	step := len(records)
+	if c.maxRecords > 0 && c.maxRecords < step {
+		step = c.maxRecords
 	}
+	for start := 0; start < len(records); start += step {
+		end := start + step
+		if end > len(records) {
+			end = len(records)
+		}
 
-	kafkaUrl := "http://" + url + "/topics/" + topic
+		reqFailed, reqStats, err := c.sendToDest(topic, records[start:end], events[start:end])
+		if err != nil {
+			lastErr = err
+		}
+		failed = append(failed, reqFailed...)
+		stats.add(reqStats)
+	}
+	return failed, stats, lastErr
+}
 
-	records := make(map[string]interface{})
-	records["records"] = kafkaRecords
+// sendToDest posts the records of a topic to the REST Proxy. It returns the
+// events to be retried and the per record results. Records rejected with a
+// non-retriable error are dropped. If the encoded records exceed
+// max_request_bytes, the records are split in half and sent using separate
+// requests.
+func (c *client) sendToDest(
+	topic string,
+	records []produceRecord,
+	events []publisher.Event,
+) ([]publisher.Event, produceResultStats, error) {
+	kafkaUrl := c.url + c.format.Path(topic)
 
-	recordsData, err := json.Marshal(records)
+	recordsData, err := c.format.EncodeRecords(records)
 	if err != nil {
-		c.log.Errorf("Error: %+v", err)
-		return err
+		c.log.Errorf("Dropping records, failed to encode request: %+v", err)
+		c.dropEvents(dropEncoding, topic, events, err)
+		return nil, produceResultStats{nonRetriable: len(events)}, nil
+	}
+
+	if c.maxRequestBytes > 0 && len(recordsData) > c.maxRequestBytes {
+		if len(records) == 1 {
+			err := fmt.Errorf("request size %v exceeds max_request_bytes (%v)", len(recordsData), c.maxRequestBytes)
+			c.log.Errorf("Dropping record to topic %v, %v", topic, err)
+			c.dropEvents(dropTooLarge, topic, events, err)
+			return nil, produceResultStats{nonRetriable: 1}, nil
+		}
+
+		mid := len(records) / 2
+		failed, stats, err := c.sendToDest(topic, records[:mid], events[:mid])
+		tailFailed, tailStats, tailErr := c.sendToDest(topic, records[mid:], events[mid:])
+		stats.add(tailStats)
+		if tailErr != nil {
+			err = tailErr
+		}
+		return append(failed, tailFailed...), stats, err
 	}
 
-	c.log.Infof("No of records to be sent %d\n", len(kafkaRecords))
+	if c.encoder != nil {
+		recordsData, err = c.encoder.Encode(recordsData)
+		if err != nil {
+			c.log.Errorf("Dropping records, failed to compress request: %+v", err)
+			c.dropEvents(dropEncoding, topic, events, err)
+			return nil, produceResultStats{nonRetriable: len(events)}, nil
+		}
+	}
+
+	c.log.Debugf("No of records to be sent %d", len(records))
 	req, err := http.NewRequest("POST", kafkaUrl, bytes.NewBuffer(recordsData))
 	if err != nil {
 		c.log.Errorf("Error: %+v", err)
-		return err
+		return events, produceResultStats{fails: len(events)}, err
 	}
 
-	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
-	if c.token != "" {
-		req.Header.Set("Authorization", c.token)
+	req.Header.Set("Content-Type", c.format.ContentType())
+	if c.encoder != nil {
+		req.Header.Set("Content-Encoding", c.encoder.Name())
+	}
+	if c.auth != nil {
+		if err := c.auth.Authorize(req); err != nil {
+			c.log.Errorf("Failed to authorize request: %+v", err)
+			return events, produceResultStats{fails: len(events)}, err
+		}
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-
-	res, err := client.Do(req)
+	res, err := c.http.Do(req)
 	if err != nil {
 		c.log.Errorf("Error: %+v", err)
-		return err
+		c.observer.WriteError(err)
+		return events, produceResultStats{fails: len(events)}, err
 	}
 	defer res.Body.Close()
-	if res.StatusCode == 200 {
-		c.log.Infof("Successfully sent records to Kafka\n")
-	} else {
-		c.log.Errorf("Failed to send Kafka records", res.Status)
-		err = errors.New("Failed to send Kafka records")
+	c.observer.WriteBytes(len(recordsData))
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		c.observer.ReadError(err)
+		c.log.Errorf("Failed to read REST Proxy response: %+v", err)
+	}
+	c.observer.ReadBytes(len(body))
+
+	if res.StatusCode == http.StatusOK {
+		results, err := c.format.DecodeResults(body)
+		if err != nil {
+			c.log.Errorf("Failed to parse REST Proxy response: %v", err)
+		}
+		failed, stats := c.collectProduceFails(topic, results, records, events)
+		return failed, stats, nil
+	}
+
+	var resp produceResponse
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &resp); err != nil {
+			c.log.Errorf("Failed to parse REST Proxy response (status=%v): %v", res.StatusCode, err)
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		stats := produceResultStats{fails: len(events), tooMany: len(events)}
+		return events, stats, fmt.Errorf("REST Proxy rejected records with too many requests: %v", resp.Message)
+
+	case isRetriableStatus(res.StatusCode):
+		return events, produceResultStats{fails: len(events)},
+			fmt.Errorf("failed to send records to topic %v (status=%v, error_code=%v): %v",
+				topic, res.StatusCode, resp.ErrorCode, resp.Message)
+
+	default:
+		// Any other client error will fail again when retried.
+		c.log.Errorf("Dropping %v records, REST Proxy rejected request to topic %v (status=%v, error_code=%v): %v",
+			len(events), topic, res.StatusCode, resp.ErrorCode, resp.Message)
+		err := fmt.Errorf("request rejected (status=%v, error_code=%v): %v", res.StatusCode, resp.ErrorCode, resp.Message)
+		c.dropEvents(dropRejected, topic, events, err)
+		return nil, produceResultStats{nonRetriable: len(events)}, nil
+	}
+}
+
+// collectProduceFails checks the per record results of a successful produce
+// request, returning all events to be retried. Records the REST Proxy reports
+// no result for are retried.
+func (c *client) collectProduceFails(
+	topic string,
+	results []recordResult,
+	records []produceRecord,
+	events []publisher.Event,
+) ([]publisher.Event, produceResultStats) {
+	log := c.log
+	var failed []publisher.Event
+	stats := produceResultStats{}
+
+	if len(results) != len(events) {
+		log.Warnf("REST Proxy returned %v results for %v records to topic %v",
+			len(results), len(events), topic)
+	}
+
+	for i := range events {
+		if i >= len(results) {
+			stats.fails++
+			failed = append(failed, events[i])
+			continue
+		}
+
+		result := results[i]
+		if !result.failed {
+			stats.acked++
+			continue
+		}
+
+		if !result.retriable {
+			log.Warnf("Cannot produce event to topic %v (error_code=%v): %s", topic, result.code, result.message)
+			c.dropEvent(dropRejected, topic, &events[i], fmt.Errorf("record rejected (error_code=%v): %s", result.code, result.message))
+			stats.nonRetriable++
+			continue
+		}
+
+		log.Debugf("Producing record to topic %v failed (i=%v, error_code=%v): %s", topic, i, result.code, result.message)
+		if result.code == http.StatusTooManyRequests {
+			stats.tooMany++
+		}
+		stats.fails++
+		failed = append(failed, events[i])
+	}
+
+	return failed, stats
+}
+
+func (c *client) dropEvents(reason dropReason, topic string, events []publisher.Event, err error) {
+	for i := range events {
+		c.dropEvent(reason, topic, &events[i], err)
 	}
-	return nil
 }
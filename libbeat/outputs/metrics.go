@@ -22,6 +22,8 @@ import "github.com/snappyflow/beats/v7/libbeat/monitoring"
 // Stats implements the Observer interface, for collecting metrics on common
 // outputs events.
 type Stats struct {
+	registry *monitoring.Registry
+
 	//
 	// Output event stats
 	//
@@ -50,6 +52,8 @@ type Stats struct {
 // The registry must not be null.
 func NewStats(reg *monitoring.Registry) *Stats {
 	return &Stats{
+		registry: reg,
+
 		batches:    monitoring.NewUint(reg, "events.batches"),
 		events:     monitoring.NewUint(reg, "events.total"),
 		acked:      monitoring.NewUint(reg, "events.acked"),
@@ -67,6 +71,12 @@ func NewStats(reg *monitoring.Registry) *Stats {
 	}
 }
 
+// Registry returns the registry of the output metrics, for outputs
+// registering metrics of their own.
+func (s *Stats) Registry() *monitoring.Registry {
+	return s.registry
+}
+
 // NewBatch updates active batch and event metrics.
 func (s *Stats) NewBatch(n int) {
 	if s != nil {
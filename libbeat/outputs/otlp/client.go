@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/testing"
+)
+
+// exporter sends encoded export requests to an OTLP receiver.
+type exporter interface {
+	Connect() error
+	Close() error
+
+	// Export sends an export request. A permanentError is returned if the
+	// request has been rejected and must not be retried.
+	Export(ctx context.Context, s signal, body []byte) error
+
+	Test(d testing.Driver)
+	String() string
+}
+
+// permanentError reports that a receiver rejected an export request.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+type client struct {
+	log      *logp.Logger
+	observer outputs.Observer
+	exporter exporter
+	encoder  *encoder
+}
+
+func newClient(exp exporter, enc *encoder, observer outputs.Observer) *client {
+	if observer == nil {
+		observer = outputs.NewNilObserver()
+	}
+	return &client{
+		log:      logp.NewLogger(logSelector),
+		observer: observer,
+		exporter: exp,
+		encoder:  enc,
+	}
+}
+
+func (c *client) Connect() error {
+	c.log.Debugf("connect: %v", c.exporter)
+	return c.exporter.Connect()
+}
+
+func (c *client) Close() error {
+	return c.exporter.Close()
+}
+
+// Publish sends the events of the batch in one export request per signal.
+// Events of requests failed with a retryable error are retried, events of
+// rejected requests are dropped.
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	var failed []publisher.Event
+	var lastErr error
+	for _, req := range c.encoder.encode(events) {
+		err := c.exporter.Export(ctx, req.signal, req.body)
+
+		var permanent *permanentError
+		switch {
+		case err == nil:
+			c.observer.Acked(len(req.events))
+
+		case errors.As(err, &permanent):
+			c.log.Errorf("Dropping %v events, %v export rejected: %v", len(req.events), req.signal, err)
+			for i := range req.events {
+				dlq.Add("otlp", dlq.ReasonRejected, c.exporter.String(), &req.events[i].Content, err)
+			}
+			c.observer.Dropped(len(req.events))
+
+		default:
+			failed = append(failed, req.events...)
+			lastErr = fmt.Errorf("failed to export %v: %w", req.signal, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		c.observer.Failed(len(failed))
+		batch.RetryEvents(failed)
+		return lastErr
+	}
+	batch.ACK()
+	return nil
+}
+
+// logPartialSuccess logs the records or data points a receiver reported as
+// rejected in an export response.
+func logPartialSuccess(log *logp.Logger, s signal, body []byte) {
+	rejected, msg, err := readPartialSuccess(body)
+	if err != nil {
+		log.Debugf("Failed to read %v export response: %v", s, err)
+		return
+	}
+	if rejected > 0 || msg != "" {
+		log.Warnf("Receiver rejected %v of the exported %v: %s", rejected, s, msg)
+	}
+}
+
+func (c *client) String() string {
+	return "otlp(" + c.exporter.String() + ")"
+}
+
+func (c *client) Test(d testing.Driver) {
+	c.exporter.Test(d)
+}
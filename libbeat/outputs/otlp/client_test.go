@@ -0,0 +1,242 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+)
+
+// receivedRequest is an export request received by a test receiver.
+type receivedRequest struct {
+	path   string
+	header string
+	body   []byte
+}
+
+func makeTestClient(t *testing.T, settings map[string]interface{}) (outputs.Client, *outest.Observer) {
+	return outest.NewClient(t, makeOTLP, testInfo, map[string]interface{}{
+		"headers": map[string]interface{}{"X-Tenant": "acme"},
+		"backoff": map[string]interface{}{"init": "1ms", "max": "1ms"},
+	}, settings)
+}
+
+// makeTestBatch creates a batch of a metric event and two log events.
+func makeTestBatch() *outest.Batch {
+	return outest.NewBatchWithMessages(2, beat.Event{Fields: common.MapStr{
+		"host":      common.MapStr{"name": "a"},
+		"event":     common.MapStr{"dataset": "system.load"},
+		"metricset": common.MapStr{"name": "load"},
+		"system":    common.MapStr{"load": common.MapStr{"1": 0.5}},
+	}})
+}
+
+// startHTTPReceiver starts an OTLP/HTTP receiver responding with the given
+// status codes per path.
+func startHTTPReceiver(t *testing.T, statusByPath map[string]int) (string, func() []receivedRequest) {
+	var mu sync.Mutex
+	var received []receivedRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, contentTypeProtobuf, r.Header.Get("Content-Type"))
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = zr
+		}
+		raw, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+
+		mu.Lock()
+		received = append(received, receivedRequest{r.URL.Path, r.Header.Get("X-Tenant"), raw})
+		mu.Unlock()
+
+		if status := statusByPath[r.URL.Path]; status != 0 {
+			w.WriteHeader(status)
+			fmt.Fprint(w, "failed")
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedRequest(nil), received...)
+	}
+}
+
+type testServerCodec struct{ rawCodec }
+
+func (testServerCodec) String() string { return "proto" }
+
+// startGRPCReceiver starts an OTLP/gRPC receiver failing export calls with
+// the given status codes per method.
+func startGRPCReceiver(t *testing.T, codeByMethod map[string]codes.Code) (string, func() []receivedRequest) {
+	var mu sync.Mutex
+	var received []receivedRequest
+
+	handler := func(_ interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		var req rawMessage
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		header := ""
+		if values := md.Get("x-tenant"); len(values) > 0 {
+			header = values[0]
+		}
+
+		mu.Lock()
+		received = append(received, receivedRequest{method, header, req})
+		mu.Unlock()
+
+		if code := codeByMethod[method]; code != codes.OK {
+			return status.Error(code, "failed")
+		}
+		return stream.SendMsg(&rawMessage{})
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.CustomCodec(testServerCodec{}), grpc.UnknownServiceHandler(handler))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String(), func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedRequest(nil), received...)
+	}
+}
+
+func TestPublishHTTP(t *testing.T) {
+	for _, level := range []int{0, 5} {
+		t.Run(fmt.Sprintf("compression level %v", level), func(t *testing.T) {
+			url, received := startHTTPReceiver(t, nil)
+			client, observer := makeTestClient(t, map[string]interface{}{
+				"hosts":             []string{url},
+				"path":              "/otlp",
+				"compression_level": level,
+				"metrics.enabled":   true,
+			})
+
+			batch := makeTestBatch()
+			err := client.Publish(context.Background(), batch)
+			outest.AssertPublished(t, batch, err, observer, outest.Counts{Acked: 3})
+
+			requests := received()
+			require.Len(t, requests, 2)
+			assert.Equal(t, "/otlp/v1/logs", requests[0].path)
+			assert.Equal(t, "/otlp/v1/metrics", requests[1].path)
+			assert.Equal(t, "acme", requests[0].header)
+
+			_, records := resourceItems(t, requests[0].body)
+			require.Len(t, records, 1)
+			assert.Len(t, records[0], 2)
+			_, metrics := resourceItems(t, requests[1].body)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, "system.load.1", metrics[0][0].str(fieldMetricName))
+		})
+	}
+}
+
+func TestPublishHTTPStatusHandling(t *testing.T) {
+	url, _ := startHTTPReceiver(t, map[string]int{
+		"/v1/logs":    http.StatusServiceUnavailable,
+		"/v1/metrics": http.StatusBadRequest,
+	})
+	client, observer := makeTestClient(t, map[string]interface{}{
+		"hosts":           []string{url},
+		"metrics.enabled": true,
+	})
+
+	// The log records are retried, while the rejected metrics are dropped.
+	batch := makeTestBatch()
+	err := client.Publish(context.Background(), batch)
+	outest.AssertPublished(t, batch, err, observer, outest.Counts{Failed: 2, Dropped: 1})
+}
+
+func TestPublishGRPC(t *testing.T) {
+	for _, level := range []int{0, 5} {
+		t.Run(fmt.Sprintf("compression level %v", level), func(t *testing.T) {
+			address, received := startGRPCReceiver(t, nil)
+			client, observer := makeTestClient(t, map[string]interface{}{
+				"hosts":             []string{address},
+				"protocol":          "grpc",
+				"compression_level": level,
+				"metrics.enabled":   true,
+			})
+
+			batch := makeTestBatch()
+			err := client.Publish(context.Background(), batch)
+			outest.AssertPublished(t, batch, err, observer, outest.Counts{Acked: 3})
+
+			requests := received()
+			require.Len(t, requests, 2)
+			assert.Equal(t, grpcMethods[signalLogs], requests[0].path)
+			assert.Equal(t, grpcMethods[signalMetrics], requests[1].path)
+			assert.Equal(t, "acme", requests[0].header)
+
+			_, records := resourceItems(t, requests[0].body)
+			require.Len(t, records, 1)
+			assert.Len(t, records[0], 2)
+		})
+	}
+}
+
+func TestPublishGRPCStatusHandling(t *testing.T) {
+	address, _ := startGRPCReceiver(t, map[string]codes.Code{
+		grpcMethods[signalLogs]:    codes.Unavailable,
+		grpcMethods[signalMetrics]: codes.InvalidArgument,
+	})
+	client, observer := makeTestClient(t, map[string]interface{}{
+		"hosts":           []string{address},
+		"protocol":        "grpc",
+		"metrics.enabled": true,
+	})
+
+	// The log records are retried, while the rejected metrics are dropped.
+	batch := makeTestBatch()
+	err := client.Publish(context.Background(), batch)
+	outest.AssertPublished(t, batch, err, observer, outest.Counts{Failed: 2, Dropped: 1})
+}
@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+)
+
+type otlpConfig struct {
+	Protocol         string            `config:"protocol"`
+	Path             string            `config:"path"`
+	Headers          map[string]string `config:"headers"`
+	ProxyURL         string            `config:"proxy_url"`
+	ProxyDisable     bool              `config:"proxy_disable"`
+	LoadBalance      bool              `config:"loadbalance"`
+	CompressionLevel int               `config:"compression_level" validate:"min=0, max=9"`
+	TLS              *tlscommon.Config `config:"ssl"`
+	BulkMaxSize      int               `config:"bulk_max_size"`
+	MaxRetries       int               `config:"max_retries"`
+	Timeout          time.Duration     `config:"timeout"`
+	Backoff          backoffConfig     `config:"backoff"`
+	Metrics          metricsConfig     `config:"metrics"`
+}
+
+type backoffConfig struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
+}
+
+type metricsConfig struct {
+	// Enabled sends events of metricsets as OTLP metrics instead of log
+	// records.
+	Enabled bool `config:"enabled"`
+}
+
+// Transport protocols of OTLP exporters.
+const (
+	protocolHTTP = "http/protobuf"
+	protocolGRPC = "grpc"
+)
+
+const defaultBulkSize = 512
+
+func defaultConfig() otlpConfig {
+	return otlpConfig{
+		Protocol:         protocolHTTP,
+		Path:             "",
+		ProxyURL:         "",
+		ProxyDisable:     false,
+		Timeout:          30 * time.Second,
+		MaxRetries:       3,
+		BulkMaxSize:      defaultBulkSize,
+		CompressionLevel: 0,
+		TLS:              nil,
+		LoadBalance:      true,
+		Backoff: backoffConfig{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+	}
+}
+
+func (c *otlpConfig) Validate() error {
+	switch c.Protocol {
+	case protocolHTTP, "http":
+	case protocolGRPC:
+		if c.Path != "" {
+			return fmt.Errorf("path is not supported with protocol %v", protocolGRPC)
+		}
+	default:
+		return fmt.Errorf("protocol '%v' not supported (try %v, %v)", c.Protocol, protocolHTTP, protocolGRPC)
+	}
+
+	if c.ProxyURL != "" && !c.ProxyDisable {
+		if c.Protocol == protocolGRPC {
+			return fmt.Errorf("proxy_url is not supported with protocol %v", protocolGRPC)
+		}
+		if _, err := common.ParseURL(c.ProxyURL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readConfig(cfg *common.Config) (*otlpConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	if c.Protocol == "http" {
+		c.Protocol = protocolHTTP
+	}
+	return &c, nil
+}
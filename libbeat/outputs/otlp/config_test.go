@@ -0,0 +1,75 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+func TestConfigProtocol(t *testing.T) {
+	tests := map[string]struct {
+		settings common.MapStr
+		protocol string
+		fail     bool
+	}{
+		"default is http": {
+			settings: common.MapStr{},
+			protocol: protocolHTTP,
+		},
+		"http alias": {
+			settings: common.MapStr{"protocol": "http"},
+			protocol: protocolHTTP,
+		},
+		"grpc": {
+			settings: common.MapStr{"protocol": "grpc"},
+			protocol: protocolGRPC,
+		},
+		"unknown protocol": {
+			settings: common.MapStr{"protocol": "http/json"},
+			fail:     true,
+		},
+		"path with grpc": {
+			settings: common.MapStr{"protocol": "grpc", "path": "/otlp"},
+			fail:     true,
+		},
+		"proxy with grpc": {
+			settings: common.MapStr{"protocol": "grpc", "proxy_url": "http://proxy:3128"},
+			fail:     true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c := common.MustNewConfigFrom(test.settings)
+			c.SetString("hosts", 0, "localhost")
+			cfg, err := readConfig(c)
+			if test.fail {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.protocol, cfg.Protocol)
+		})
+	}
+}
@@ -0,0 +1,315 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// resourceFields are the top-level event fields describing the entity that
+// produced the event. They are sent as resource attributes.
+var resourceFields = map[string]bool{
+	"host":  true,
+	"agent": true,
+	"cloud": true,
+}
+
+// severityNumbers maps log levels to OTLP severity numbers.
+var severityNumbers = map[string]uint64{
+	"trace":         1,
+	"debug":         5,
+	"info":          9,
+	"informational": 9,
+	"notice":        10,
+	"warn":          13,
+	"warning":       13,
+	"error":         17,
+	"err":           17,
+	"critical":      21,
+	"crit":          21,
+	"alert":         22,
+	"fatal":         21,
+	"emergency":     23,
+	"emerg":         23,
+}
+
+type signal uint8
+
+const (
+	signalLogs signal = iota
+	signalMetrics
+)
+
+func (s signal) String() string {
+	if s == signalMetrics {
+		return "metrics"
+	}
+	return "logs"
+}
+
+// exportRequest is an encoded export request with the events it holds.
+type exportRequest struct {
+	signal signal
+	body   []byte
+	events []publisher.Event
+}
+
+type encoder struct {
+	scope   []byte
+	metrics bool
+	now     func() time.Time
+}
+
+func newEncoder(info beat.Info, metrics bool) *encoder {
+	scope := appendString(nil, fieldScopeName, info.Beat)
+	if info.Version != "" {
+		scope = appendString(scope, fieldScopeVersion, info.Version)
+	}
+	return &encoder{scope: scope, metrics: metrics, now: time.Now}
+}
+
+// encode encodes the events into an export request per signal. Metricset
+// events are sent as metrics if metrics are enabled, all other events are
+// sent as log records.
+func (e *encoder) encode(events []publisher.Event) []exportRequest {
+	logs := newRequestBuilder(e.scope)
+	metrics := newRequestBuilder(e.scope)
+	observed := uint64(e.now().UnixNano())
+
+	for i := range events {
+		event := &events[i].Content
+		resource, fields := splitResource(event.Fields)
+
+		if e.metrics {
+			if items := encodeMetrics(event, fields); len(items) > 0 {
+				metrics.add(resource, items, events[i])
+				continue
+			}
+		}
+		logs.add(resource, [][]byte{encodeLogRecord(event, fields, observed)}, events[i])
+	}
+
+	var requests []exportRequest
+	if len(logs.events) > 0 {
+		requests = append(requests, exportRequest{signalLogs, logs.build(), logs.events})
+	}
+	if len(metrics.events) > 0 {
+		requests = append(requests, exportRequest{signalMetrics, metrics.build(), metrics.events})
+	}
+	return requests
+}
+
+// requestBuilder groups encoded log records or metrics by resource.
+type requestBuilder struct {
+	scope  []byte
+	order  []string
+	items  map[string][][]byte
+	events []publisher.Event
+}
+
+func newRequestBuilder(scope []byte) *requestBuilder {
+	return &requestBuilder{scope: scope, items: map[string][][]byte{}}
+}
+
+func (r *requestBuilder) add(resource []byte, items [][]byte, event publisher.Event) {
+	key := string(resource)
+	if _, exists := r.items[key]; !exists {
+		r.order = append(r.order, key)
+	}
+	r.items[key] = append(r.items[key], items...)
+	r.events = append(r.events, event)
+}
+
+func (r *requestBuilder) build() []byte {
+	var body []byte
+	for _, key := range r.order {
+		scoped := appendMessage(nil, fieldScope, r.scope)
+		for _, item := range r.items[key] {
+			scoped = appendMessage(scoped, fieldItems, item)
+		}
+
+		resourceItems := appendMessage(nil, fieldResource, []byte(key))
+		resourceItems = appendMessage(resourceItems, fieldScopeItems, scoped)
+		body = appendMessage(body, fieldResourceItems, resourceItems)
+	}
+	return body
+}
+
+// splitResource encodes the resource fields of an event to a Resource
+// message, and returns the remaining fields.
+func splitResource(fields common.MapStr) ([]byte, common.MapStr) {
+	attrs := common.MapStr{}
+	rest := make(common.MapStr, len(fields))
+	for k, v := range fields {
+		if resourceFields[k] {
+			attrs[k] = v
+		} else {
+			rest[k] = v
+		}
+	}
+
+	attrs = attrs.Flatten()
+	// service.name is required by OpenTelemetry to identify the producer.
+	if _, exists := attrs["service.name"]; !exists {
+		if name, ok := attrs["agent.type"].(string); ok {
+			attrs["service.name"] = name
+		}
+	}
+	return appendAttributes(nil, fieldResourceAttributes, attrs), rest
+}
+
+// encodeLogRecord encodes an event to a LogRecord. The message is used as
+// body, all other fields are added as attributes.
+func encodeLogRecord(event *beat.Event, fields common.MapStr, observed uint64) []byte {
+	attrs := fields.Flatten()
+
+	var b []byte
+	if !event.Timestamp.IsZero() {
+		b = appendFixed64(b, fieldLogTimeUnixNano, uint64(event.Timestamp.UnixNano()))
+	}
+	b = appendFixed64(b, fieldLogObservedTimeUnixNano, observed)
+
+	if level, ok := attrs["log.level"].(string); ok {
+		delete(attrs, "log.level")
+		if n, ok := severityNumbers[strings.ToLower(level)]; ok {
+			b = appendVarint(b, fieldLogSeverityNumber, n)
+		}
+		b = appendString(b, fieldLogSeverityText, level)
+	}
+
+	if msg, ok := attrs["message"]; ok {
+		delete(attrs, "message")
+		b = appendMessage(b, fieldLogBody, appendAnyValue(nil, msg))
+	}
+
+	if id, ok := decodeID(attrs["trace.id"], 16); ok {
+		delete(attrs, "trace.id")
+		b = appendMessage(b, fieldLogTraceID, id)
+	}
+	if id, ok := decodeID(attrs["span.id"], 8); ok {
+		delete(attrs, "span.id")
+		b = appendMessage(b, fieldLogSpanID, id)
+	}
+
+	return appendAttributes(b, fieldLogAttributes, attrs)
+}
+
+// decodeID decodes a hex encoded trace or span ID of the given length.
+func decodeID(v interface{}, length int) ([]byte, bool) {
+	s, ok := v.(string)
+	if !ok || len(s) != 2*length {
+		return nil, false
+	}
+	id, err := hex.DecodeString(s)
+	return id, err == nil
+}
+
+// encodeMetrics encodes the numeric fields of a metricset event to gauges.
+// The metric names are the full field names, for example
+// system.cpu.total.pct. The other fields of the metricset and the labels of
+// the event are added as attributes to the data points. nil is returned for
+// events not created by a metricset.
+func encodeMetrics(event *beat.Event, fields common.MapStr) [][]byte {
+	if _, err := fields.GetValue("metricset.name"); err != nil {
+		return nil
+	}
+	dataset, _ := fields.GetValue("event.dataset")
+	namespace, _ := dataset.(string)
+	if namespace == "" {
+		return nil
+	}
+	v, err := fields.GetValue(namespace)
+	if err != nil {
+		return nil
+	}
+	metricset, ok := toMapStr(v)
+	if !ok {
+		return nil
+	}
+
+	values := map[string]interface{}{}
+	attrs := map[string]interface{}{}
+	for k, v := range metricset.Flatten() {
+		name := namespace + "." + k
+		if _, _, _, ok := toNumber(v); ok {
+			values[name] = v
+			continue
+		}
+		switch v.(type) {
+		case string, bool:
+			attrs[name] = v
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	if v, err := fields.GetValue("labels"); err == nil {
+		if labels, ok := toMapStr(v); ok {
+			for k, v := range labels.Flatten() {
+				attrs["labels."+k] = v
+			}
+		}
+	}
+	if address, err := fields.GetValue("service.address"); err == nil {
+		attrs["service.address"] = address
+	}
+	encodedAttrs := appendAttributes(nil, fieldPointAttributes, attrs)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([][]byte, 0, len(names))
+	for _, name := range names {
+		var point []byte
+		if !event.Timestamp.IsZero() {
+			point = appendFixed64(point, fieldPointTimeUnixNano, uint64(event.Timestamp.UnixNano()))
+		}
+		if i, f, isInt, _ := toNumber(values[name]); isInt {
+			point = appendFixed64(point, fieldPointAsInt, uint64(i))
+		} else {
+			point = appendDouble(point, fieldPointAsDouble, f)
+		}
+		point = append(point, encodedAttrs...)
+
+		gauge := appendMessage(nil, fieldGaugeDataPoints, point)
+		metric := appendString(nil, fieldMetricName, name)
+		metrics = append(metrics, appendMessage(metric, fieldMetricGauge, gauge))
+	}
+	return metrics
+}
+
+func toMapStr(v interface{}) (common.MapStr, bool) {
+	switch m := v.(type) {
+	case common.MapStr:
+		return m, true
+	case map[string]interface{}:
+		return common.MapStr(m), true
+	}
+	return nil, false
+}
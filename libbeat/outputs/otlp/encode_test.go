@@ -0,0 +1,285 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// testMessage is a decoded protobuf message. Values are uint64 for varint and
+// fixed fields, and []byte for length delimited fields.
+type testMessage map[protowire.Number][]interface{}
+
+func decodeMessage(t *testing.T, b []byte) testMessage {
+	m := testMessage{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n >= 0, "invalid tag")
+		b = b[n:]
+
+		var v interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("unexpected wire type %v of field %v", typ, num)
+		}
+		require.True(t, n >= 0, "invalid value of field %v", num)
+		b = b[n:]
+		m[num] = append(m[num], v)
+	}
+	return m
+}
+
+func (m testMessage) messages(t *testing.T, num protowire.Number) []testMessage {
+	var msgs []testMessage
+	for _, v := range m[num] {
+		msgs = append(msgs, decodeMessage(t, v.([]byte)))
+	}
+	return msgs
+}
+
+func (m testMessage) message(t *testing.T, num protowire.Number) testMessage {
+	msgs := m.messages(t, num)
+	require.Len(t, msgs, 1, "field %v", num)
+	return msgs[0]
+}
+
+func (m testMessage) str(num protowire.Number) string {
+	if len(m[num]) == 0 {
+		return ""
+	}
+	return string(m[num][0].([]byte))
+}
+
+func (m testMessage) uint(num protowire.Number) uint64 {
+	if len(m[num]) == 0 {
+		return 0
+	}
+	return m[num][0].(uint64)
+}
+
+func (m testMessage) attributes(t *testing.T, num protowire.Number) map[string]interface{} {
+	attrs := map[string]interface{}{}
+	for _, kv := range m.messages(t, num) {
+		attrs[kv.str(fieldKey)] = kv.message(t, fieldValue).anyValue(t)
+	}
+	return attrs
+}
+
+func (m testMessage) anyValue(t *testing.T) interface{} {
+	switch {
+	case len(m[fieldStringValue]) > 0:
+		return m.str(fieldStringValue)
+	case len(m[fieldBoolValue]) > 0:
+		return m.uint(fieldBoolValue) == 1
+	case len(m[fieldIntValue]) > 0:
+		return int64(m.uint(fieldIntValue))
+	case len(m[fieldDoubleValue]) > 0:
+		return math.Float64frombits(m.uint(fieldDoubleValue))
+	case len(m[fieldArrayValue]) > 0:
+		var values []interface{}
+		for _, v := range m.message(t, fieldArrayValue).messages(t, fieldValues) {
+			values = append(values, v.anyValue(t))
+		}
+		return values
+	case len(m[fieldKvlistValue]) > 0:
+		return m.message(t, fieldKvlistValue).attributes(t, fieldValues)
+	}
+	return nil
+}
+
+// resourceItems decodes an export request to the resource attributes and the
+// log records or metrics of each resource.
+func resourceItems(t *testing.T, body []byte) ([]map[string]interface{}, [][]testMessage) {
+	var resources []map[string]interface{}
+	var items [][]testMessage
+	for _, r := range decodeMessage(t, body).messages(t, fieldResourceItems) {
+		resources = append(resources, r.message(t, fieldResource).attributes(t, fieldResourceAttributes))
+
+		scoped := r.message(t, fieldScopeItems)
+		scope := scoped.message(t, fieldScope)
+		assert.Equal(t, "testbeat", scope.str(fieldScopeName))
+		assert.Equal(t, "9.9.9", scope.str(fieldScopeVersion))
+		items = append(items, scoped.messages(t, fieldItems))
+	}
+	return resources, items
+}
+
+var testInfo = beat.Info{Beat: "testbeat", Version: "9.9.9"}
+
+func makeEvents(events ...beat.Event) []publisher.Event {
+	out := make([]publisher.Event, len(events))
+	for i, event := range events {
+		out[i] = publisher.Event{Content: event}
+	}
+	return out
+}
+
+func TestEncodeLogs(t *testing.T) {
+	ts := time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+	host := func(name string) common.MapStr {
+		return common.MapStr{
+			"host":  common.MapStr{"name": name, "os": common.MapStr{"type": "linux"}},
+			"agent": common.MapStr{"type": "filebeat", "version": "7.9.0"},
+			"cloud": common.MapStr{"provider": "aws"},
+		}
+	}
+	withFields := func(fields, more common.MapStr) common.MapStr {
+		fields.DeepUpdate(more)
+		return fields
+	}
+
+	enc := newEncoder(testInfo, false)
+	enc.now = func() time.Time { return ts.Add(time.Second) }
+	requests := enc.encode(makeEvents(
+		beat.Event{Timestamp: ts, Fields: withFields(host("a"), common.MapStr{
+			"message": "hello",
+			"log":     common.MapStr{"level": "WARN", "file": common.MapStr{"path": "/var/log/app.log"}},
+			"trace":   common.MapStr{"id": "0af7651916cd43dd8448eb211c80319c"},
+			"span":    common.MapStr{"id": "b7ad6b7169203331"},
+			"tags":    []string{"x", "y"},
+			"count":   3,
+		})},
+		beat.Event{Timestamp: ts, Fields: withFields(host("b"), common.MapStr{"message": "other host"})},
+		beat.Event{Timestamp: ts, Fields: withFields(host("a"), common.MapStr{"message": "second"})},
+	))
+	require.Len(t, requests, 1)
+	assert.Equal(t, signalLogs, requests[0].signal)
+	assert.Len(t, requests[0].events, 3)
+
+	resources, records := resourceItems(t, requests[0].body)
+	require.Len(t, resources, 2)
+	assert.Equal(t, map[string]interface{}{
+		"host.name":      "a",
+		"host.os.type":   "linux",
+		"agent.type":     "filebeat",
+		"agent.version":  "7.9.0",
+		"cloud.provider": "aws",
+		"service.name":   "filebeat",
+	}, resources[0])
+	assert.Equal(t, "b", resources[1]["host.name"])
+
+	require.Len(t, records[0], 2)
+	require.Len(t, records[1], 1)
+
+	record := records[0][0]
+	assert.Equal(t, uint64(ts.UnixNano()), record.uint(fieldLogTimeUnixNano))
+	assert.Equal(t, uint64(ts.Add(time.Second).UnixNano()), record.uint(fieldLogObservedTimeUnixNano))
+	assert.Equal(t, uint64(13), record.uint(fieldLogSeverityNumber))
+	assert.Equal(t, "WARN", record.str(fieldLogSeverityText))
+	assert.Equal(t, "hello", record.message(t, fieldLogBody).anyValue(t))
+	assert.Len(t, record[fieldLogTraceID][0], 16)
+	assert.Len(t, record[fieldLogSpanID][0], 8)
+	assert.Equal(t, map[string]interface{}{
+		"log.file.path": "/var/log/app.log",
+		"tags":          []interface{}{"x", "y"},
+		"count":         int64(3),
+	}, record.attributes(t, fieldLogAttributes))
+
+	assert.Equal(t, "second", records[0][1].message(t, fieldLogBody).anyValue(t))
+	assert.Equal(t, "other host", records[1][0].message(t, fieldLogBody).anyValue(t))
+}
+
+func TestEncodeMetrics(t *testing.T) {
+	ts := time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+	metricsetEvent := beat.Event{
+		Timestamp: ts,
+		Fields: common.MapStr{
+			"host":      common.MapStr{"name": "a"},
+			"event":     common.MapStr{"module": "system", "dataset": "system.filesystem"},
+			"metricset": common.MapStr{"name": "filesystem", "period": 10000},
+			"service":   common.MapStr{"type": "system"},
+			"labels":    common.MapStr{"env": "prod"},
+			"system": common.MapStr{
+				"filesystem": common.MapStr{
+					"mount_point": "/",
+					"total":       uint64(1000),
+					"used":        common.MapStr{"pct": 0.25},
+				},
+			},
+		},
+	}
+	logEvent := beat.Event{Timestamp: ts, Fields: common.MapStr{"message": "log"}}
+
+	t.Run("metrics disabled", func(t *testing.T) {
+		requests := newEncoder(testInfo, false).encode(makeEvents(metricsetEvent, logEvent))
+		require.Len(t, requests, 1)
+		assert.Equal(t, signalLogs, requests[0].signal)
+		assert.Len(t, requests[0].events, 2)
+	})
+
+	t.Run("metrics enabled", func(t *testing.T) {
+		requests := newEncoder(testInfo, true).encode(makeEvents(metricsetEvent, logEvent))
+		require.Len(t, requests, 2)
+		assert.Equal(t, signalLogs, requests[0].signal)
+		assert.Len(t, requests[0].events, 1)
+		assert.Equal(t, signalMetrics, requests[1].signal)
+		assert.Len(t, requests[1].events, 1)
+
+		resources, metrics := resourceItems(t, requests[1].body)
+		require.Len(t, resources, 1)
+		assert.Equal(t, "a", resources[0]["host.name"])
+		require.Len(t, metrics[0], 2)
+
+		total := metrics[0][0]
+		assert.Equal(t, "system.filesystem.total", total.str(fieldMetricName))
+		point := total.message(t, fieldMetricGauge).message(t, fieldGaugeDataPoints)
+		assert.Equal(t, uint64(ts.UnixNano()), point.uint(fieldPointTimeUnixNano))
+		assert.Equal(t, uint64(1000), point.uint(fieldPointAsInt))
+		assert.Equal(t, map[string]interface{}{
+			"system.filesystem.mount_point": "/",
+			"labels.env":                    "prod",
+		}, point.attributes(t, fieldPointAttributes))
+
+		pct := metrics[0][1]
+		assert.Equal(t, "system.filesystem.used.pct", pct.str(fieldMetricName))
+		point = pct.message(t, fieldMetricGauge).message(t, fieldGaugeDataPoints)
+		assert.Equal(t, 0.25, math.Float64frombits(point.uint(fieldPointAsDouble)))
+	})
+}
+
+func TestReadPartialSuccess(t *testing.T) {
+	partial := appendVarint(nil, fieldRejected, 2)
+	partial = appendString(partial, fieldErrorMessage, "invalid records")
+	body := appendMessage(nil, fieldPartialSuccess, partial)
+
+	rejected, msg, err := readPartialSuccess(body)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rejected)
+	assert.Equal(t, "invalid records", msg)
+
+	rejected, msg, err = readPartialSuccess(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rejected)
+	assert.Equal(t, "", msg)
+}
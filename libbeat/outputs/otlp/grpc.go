@@ -0,0 +1,228 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/testing"
+)
+
+// grpcMethods are the methods of the OTLP collector services used to export
+// a signal.
+var grpcMethods = map[signal]string{
+	signalLogs:    "/opentelemetry.proto.collector.logs.v1.LogsService/Export",
+	signalMetrics: "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export",
+}
+
+// grpcRetryableCodes lists the status codes of failed export calls that are
+// retried, as defined by the OTLP specification.
+var grpcRetryableCodes = map[codes.Code]bool{
+	codes.Canceled:          true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.OutOfRange:        true,
+	codes.Unavailable:       true,
+	codes.DataLoss:          true,
+}
+
+// rawMessage is an already encoded protobuf message.
+type rawMessage []byte
+
+// rawCodec passes encoded protobuf messages as is, such that export requests
+// can be sent without generated message types.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*rawMessage), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*rawMessage) = append((*v.(*rawMessage))[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// grpcExporter sends export requests using OTLP/gRPC.
+type grpcExporter struct {
+	log      *logp.Logger
+	observer outputs.Observer
+	address  string
+	headers  metadata.MD
+	compress bool
+
+	dialer  transport.Dialer
+	tls     *tlscommon.TLSConfig
+	timeout time.Duration
+	conn    *grpc.ClientConn
+}
+
+type grpcSettings struct {
+	URL     string
+	Headers map[string]string
+	TLS     *tlscommon.TLSConfig
+	Timeout time.Duration
+
+	// CompressionLevel enables gzip compression if set. The level of the
+	// gzip compressor of gRPC can not be changed per connection.
+	CompressionLevel int
+	Observer         outputs.Observer
+}
+
+func newGRPCExporter(s grpcSettings) (*grpcExporter, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := transport.NetDialer(s.Timeout)
+	if st := s.Observer; st != nil {
+		dialer = transport.StatsDialer(dialer, st)
+	}
+
+	observer := s.Observer
+	if observer == nil {
+		observer = outputs.NewNilObserver()
+	}
+
+	tls := s.TLS
+	if tls == nil && u.Scheme == "https" {
+		tls = &tlscommon.TLSConfig{}
+	}
+
+	return &grpcExporter{
+		log:      logp.NewLogger(logSelector),
+		observer: observer,
+		address:  u.Host,
+		headers:  metadata.New(s.Headers),
+		compress: s.CompressionLevel > 0,
+		dialer:   dialer,
+		tls:      tls,
+		timeout:  s.Timeout,
+	}, nil
+}
+
+func (e *grpcExporter) Connect() error {
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(_ context.Context, address string) (net.Conn, error) {
+			return e.dialer.Dial("tcp", address)
+		}),
+	}
+	if e.tls != nil {
+		host, _, err := net.SplitHostPort(e.address)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(e.tls.BuildModuleConfig(host))))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.Dial(e.address, opts...)
+	if err != nil {
+		return err
+	}
+	e.conn = conn
+	return nil
+}
+
+func (e *grpcExporter) Close() error {
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+func (e *grpcExporter) Export(ctx context.Context, s signal, body []byte) error {
+	if e.conn == nil {
+		if err := e.Connect(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	if len(e.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, e.headers)
+	}
+
+	opts := []grpc.CallOption{grpc.ForceCodec(rawCodec{})}
+	if e.compress {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+
+	req, res := rawMessage(body), rawMessage(nil)
+	err := e.conn.Invoke(ctx, grpcMethods[s], &req, &res, opts...)
+	if err != nil {
+		e.observer.WriteError(err)
+		if grpcRetryableCodes[status.Code(err)] {
+			return err
+		}
+		return &permanentError{err}
+	}
+	e.observer.WriteBytes(len(body))
+	e.observer.ReadBytes(len(res))
+
+	logPartialSuccess(e.log, s, res)
+	return nil
+}
+
+func (e *grpcExporter) String() string {
+	return e.address
+}
+
+func (e *grpcExporter) Test(d testing.Driver) {
+	d.Run("otlp: "+e.address, func(d testing.Driver) {
+		d.Run("connection", func(d testing.Driver) {
+			netDialer := transport.TestNetDialer(d, e.timeout)
+			_, err := netDialer.Dial("tcp", e.address)
+			d.Fatal("dial up", err)
+		})
+
+		if e.tls == nil {
+			d.Warn("TLS", "secure connection disabled")
+		} else {
+			d.Run("TLS", func(d testing.Driver) {
+				netDialer := transport.NetDialer(e.timeout)
+				tlsDialer, err := transport.TestTLSDialer(d, netDialer, e.tls, e.timeout)
+				_, err = tlsDialer.Dial("tcp", e.address)
+				d.Fatal("dial up", err)
+			})
+		}
+	})
+}
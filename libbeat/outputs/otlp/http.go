@@ -0,0 +1,222 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/testing"
+)
+
+const contentTypeProtobuf = "application/x-protobuf"
+
+// httpRetryableStatus lists the status codes of OTLP/HTTP responses the
+// request is retried for.
+var httpRetryableStatus = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// httpPaths are the paths export requests are sent to, relative to the
+// configured path.
+var httpPaths = map[signal]string{
+	signalLogs:    "/v1/logs",
+	signalMetrics: "/v1/metrics",
+}
+
+// httpExporter sends export requests using OTLP/HTTP with binary protobuf
+// encoding.
+type httpExporter struct {
+	log      *logp.Logger
+	observer outputs.Observer
+	url      string
+	headers  map[string]string
+
+	compressionLevel int
+
+	http    *http.Client
+	tls     *tlscommon.TLSConfig
+	timeout time.Duration
+}
+
+type httpSettings struct {
+	URL          string
+	Headers      map[string]string
+	Proxy        *url.URL
+	ProxyDisable bool
+	TLS          *tlscommon.TLSConfig
+	Timeout      time.Duration
+
+	CompressionLevel int
+	Observer         outputs.Observer
+}
+
+func newHTTPExporter(s httpSettings) (*httpExporter, error) {
+	httpClient, err := transport.NewHTTPClient(transport.HTTPSettings{
+		Proxy:        s.Proxy,
+		ProxyDisable: s.ProxyDisable,
+		TLS:          s.TLS,
+		Timeout:      s.Timeout,
+		Stats:        s.Observer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	observer := s.Observer
+	if observer == nil {
+		observer = outputs.NewNilObserver()
+	}
+
+	return &httpExporter{
+		log:      logp.NewLogger(logSelector),
+		observer: observer,
+		url:      s.URL,
+		headers:  s.Headers,
+
+		compressionLevel: s.CompressionLevel,
+
+		http:    httpClient,
+		tls:     s.TLS,
+		timeout: s.Timeout,
+	}, nil
+}
+
+func (e *httpExporter) Connect() error {
+	return nil
+}
+
+func (e *httpExporter) Close() error {
+	e.http.CloseIdleConnections()
+	return nil
+}
+
+func (e *httpExporter) Export(ctx context.Context, s signal, body []byte) error {
+	contentEncoding := ""
+	if e.compressionLevel > 0 {
+		var buf bytes.Buffer
+		w, err := gzip.NewWriterLevel(&buf, e.compressionLevel)
+		if err == nil {
+			_, err = w.Write(body)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to compress request: %v", err)
+		}
+		body, contentEncoding = buf.Bytes(), "gzip"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url+httpPaths[s], bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", contentTypeProtobuf)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.http.Do(req)
+	if err != nil {
+		e.observer.WriteError(err)
+		return err
+	}
+	defer res.Body.Close()
+	e.observer.WriteBytes(len(body))
+
+	// Responses hold at most a short status message. The size is limited
+	// to not buffer unexpected large responses.
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		e.observer.ReadError(err)
+	}
+	n, _ := io.Copy(ioutil.Discard, res.Body)
+	e.observer.ReadBytes(len(resBody) + int(n))
+
+	switch {
+	case res.StatusCode < 300:
+		logPartialSuccess(e.log, s, resBody)
+		return nil
+	case httpRetryableStatus[res.StatusCode]:
+		return fmt.Errorf("status=%v: %s", res.StatusCode, statusMessage(res.Header, resBody))
+	default:
+		return &permanentError{fmt.Errorf("status=%v: %s", res.StatusCode, statusMessage(res.Header, resBody))}
+	}
+}
+
+// statusMessage returns the message of an error response. OTLP receivers
+// respond with a protobuf encoded google.rpc.Status message.
+func statusMessage(header http.Header, body []byte) string {
+	if strings.HasPrefix(header.Get("Content-Type"), contentTypeProtobuf) {
+		const fieldStatusMessage = 2
+		for b := body; len(b) > 0; {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				break
+			}
+			b = b[n:]
+			if num == fieldStatusMessage && typ == protowire.BytesType {
+				msg, n := protowire.ConsumeString(b)
+				if n < 0 {
+					break
+				}
+				return msg
+			}
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				break
+			}
+			b = b[n:]
+		}
+	}
+
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func (e *httpExporter) String() string {
+	return e.url
+}
+
+func (e *httpExporter) Test(d testing.Driver) {
+	transport.TestHTTPEndpoint(d, "otlp", e.url, e.tls, e.timeout)
+}
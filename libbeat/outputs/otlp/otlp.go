@@ -0,0 +1,126 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+)
+
+const logSelector = "otlp"
+
+// Default ports of OTLP receivers.
+const (
+	defaultHTTPPort = 4318
+	defaultGRPCPort = 4317
+)
+
+func init() {
+	outputs.RegisterType("otlp", makeOTLP)
+}
+
+func makeOTLP(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	log := logp.NewLogger(logSelector)
+
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	hosts, err := outputs.ReadHostList(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	tlsConfig, err := tlscommon.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	var proxyURL *url.URL
+	if !config.ProxyDisable {
+		proxyURL, err = common.ParseURL(config.ProxyURL)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+		if proxyURL != nil {
+			log.Infof("Using proxy URL: %s", proxyURL)
+		}
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	port := defaultHTTPPort
+	if config.Protocol == protocolGRPC {
+		port = defaultGRPCPort
+	}
+
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		hostURL, err := common.MakeURL(scheme, config.Path, host, port)
+		if err != nil {
+			log.Errorf("Invalid host param set: %s, Error: %+v", host, err)
+			return outputs.Fail(err)
+		}
+
+		var exp exporter
+		if config.Protocol == protocolGRPC {
+			exp, err = newGRPCExporter(grpcSettings{
+				URL:              hostURL,
+				Headers:          config.Headers,
+				TLS:              tlsConfig,
+				Timeout:          config.Timeout,
+				CompressionLevel: config.CompressionLevel,
+				Observer:         observer,
+			})
+		} else {
+			exp, err = newHTTPExporter(httpSettings{
+				URL:              strings.TrimSuffix(hostURL, "/"),
+				Headers:          config.Headers,
+				Proxy:            proxyURL,
+				ProxyDisable:     config.ProxyDisable,
+				TLS:              tlsConfig,
+				Timeout:          config.Timeout,
+				CompressionLevel: config.CompressionLevel,
+				Observer:         observer,
+			})
+		}
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		var client outputs.NetworkClient = newClient(exp, newEncoder(beat, config.Metrics.Enabled), observer)
+		client = outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max)
+		clients[i] = client
+	}
+
+	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+}
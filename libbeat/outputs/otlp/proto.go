@@ -0,0 +1,308 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+// Field numbers of the OTLP protobuf messages, as defined by
+// https://github.com/open-telemetry/opentelemetry-proto. Logs and metrics
+// messages share the layout of the request, resource and scope levels.
+const (
+	// ExportLogsServiceRequest.resource_logs,
+	// ExportMetricsServiceRequest.resource_metrics
+	fieldResourceItems = 1
+
+	// ResourceLogs, ResourceMetrics
+	fieldResource   = 1
+	fieldScopeItems = 2
+
+	// Resource
+	fieldResourceAttributes = 1
+
+	// ScopeLogs, ScopeMetrics
+	fieldScope = 1
+	fieldItems = 2
+
+	// InstrumentationScope
+	fieldScopeName    = 1
+	fieldScopeVersion = 2
+
+	// LogRecord
+	fieldLogTimeUnixNano         = 1
+	fieldLogSeverityNumber       = 2
+	fieldLogSeverityText         = 3
+	fieldLogBody                 = 5
+	fieldLogAttributes           = 6
+	fieldLogTraceID              = 9
+	fieldLogSpanID               = 10
+	fieldLogObservedTimeUnixNano = 11
+
+	// Metric
+	fieldMetricName  = 1
+	fieldMetricGauge = 5
+
+	// Gauge
+	fieldGaugeDataPoints = 1
+
+	// NumberDataPoint
+	fieldPointTimeUnixNano = 3
+	fieldPointAsDouble     = 4
+	fieldPointAsInt        = 6
+	fieldPointAttributes   = 7
+
+	// KeyValue
+	fieldKey   = 1
+	fieldValue = 2
+
+	// AnyValue
+	fieldStringValue = 1
+	fieldBoolValue   = 2
+	fieldIntValue    = 3
+	fieldDoubleValue = 4
+	fieldArrayValue  = 5
+	fieldKvlistValue = 6
+	fieldBytesValue  = 7
+
+	// ArrayValue, KeyValueList
+	fieldValues = 1
+
+	// ExportLogsServiceResponse, ExportMetricsServiceResponse
+	fieldPartialSuccess = 1
+
+	// ExportLogsPartialSuccess, ExportMetricsPartialSuccess
+	fieldRejected     = 1
+	fieldErrorMessage = 2
+)
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	return appendFixed64(b, num, math.Float64bits(v))
+}
+
+// appendAttributes appends a KeyValue message with field number num for each
+// entry of attrs. Keys are sorted, such that equal attributes are encoded to
+// equal bytes.
+func appendAttributes(b []byte, num protowire.Number, attrs map[string]interface{}) []byte {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		kv := appendString(nil, fieldKey, k)
+		kv = appendMessage(kv, fieldValue, appendAnyValue(nil, attrs[k]))
+		b = appendMessage(b, num, kv)
+	}
+	return b
+}
+
+// appendAnyValue appends the fields of an AnyValue message holding v. Values
+// of unknown types are encoded as strings.
+func appendAnyValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return b
+	case string:
+		return appendString(b, fieldStringValue, v)
+	case bool:
+		return appendVarint(b, fieldBoolValue, protowire.EncodeBool(v))
+	case []byte:
+		b = protowire.AppendTag(b, fieldBytesValue, protowire.BytesType)
+		return protowire.AppendBytes(b, v)
+	case time.Time:
+		return appendString(b, fieldStringValue, v.UTC().Format(time.RFC3339Nano))
+	case common.MapStr:
+		return appendMessage(b, fieldKvlistValue, appendAttributes(nil, fieldValues, v))
+	case map[string]interface{}:
+		return appendMessage(b, fieldKvlistValue, appendAttributes(nil, fieldValues, v))
+	}
+
+	if i, f, isInt, ok := toNumber(v); ok {
+		if isInt {
+			return appendVarint(b, fieldIntValue, uint64(i))
+		}
+		return appendDouble(b, fieldDoubleValue, f)
+	}
+
+	if s, ok := v.(fmt.Stringer); ok {
+		return appendString(b, fieldStringValue, s.String())
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		var values []byte
+		for i := 0; i < rv.Len(); i++ {
+			values = appendMessage(values, fieldValues, appendAnyValue(nil, rv.Index(i).Interface()))
+		}
+		return appendMessage(b, fieldArrayValue, values)
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, rv.Len())
+			for _, k := range rv.MapKeys() {
+				m[k.String()] = rv.MapIndex(k).Interface()
+			}
+			return appendMessage(b, fieldKvlistValue, appendAttributes(nil, fieldValues, m))
+		}
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return b
+		}
+		return appendAnyValue(b, rv.Elem().Interface())
+	}
+	return appendString(b, fieldStringValue, fmt.Sprint(v))
+}
+
+// toNumber reports if v is a number. Integers that fit into an int64 are
+// returned as int, all other numbers as float.
+func toNumber(v interface{}) (i int64, f float64, isInt bool, ok bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), 0, true, true
+	case int8:
+		return int64(v), 0, true, true
+	case int16:
+		return int64(v), 0, true, true
+	case int32:
+		return int64(v), 0, true, true
+	case int64:
+		return v, 0, true, true
+	case uint:
+		return toNumber(uint64(v))
+	case uint8:
+		return int64(v), 0, true, true
+	case uint16:
+		return int64(v), 0, true, true
+	case uint32:
+		return int64(v), 0, true, true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, float64(v), false, true
+		}
+		return int64(v), 0, true, true
+	case float32:
+		return 0, float64(v), false, true
+	case float64:
+		return 0, v, false, true
+	case common.Float:
+		return 0, float64(v), false, true
+	}
+	return 0, 0, false, false
+}
+
+// readPartialSuccess reads the partial_success field of an export response.
+// The number of rejected records or data points is 0 if the response does not
+// report a partial success.
+func readPartialSuccess(b []byte) (rejected int64, msg string, err error) {
+	partial, err := readMessageField(b, fieldPartialSuccess)
+	if err != nil || partial == nil {
+		return 0, "", err
+	}
+
+	for len(partial) > 0 {
+		num, typ, n := protowire.ConsumeTag(partial)
+		if n < 0 {
+			return 0, "", protowire.ParseError(n)
+		}
+		partial = partial[n:]
+
+		switch {
+		case num == fieldRejected && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(partial)
+			if n < 0 {
+				return 0, "", protowire.ParseError(n)
+			}
+			rejected = int64(v)
+			partial = partial[n:]
+		case num == fieldErrorMessage && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(partial)
+			if n < 0 {
+				return 0, "", protowire.ParseError(n)
+			}
+			msg = v
+			partial = partial[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, partial)
+			if n < 0 {
+				return 0, "", protowire.ParseError(n)
+			}
+			partial = partial[n:]
+		}
+	}
+	return rejected, msg, nil
+}
+
+// readMessageField returns the contents of the last embedded message with
+// field number num.
+func readMessageField(b []byte, num protowire.Number) ([]byte, error) {
+	var msg []byte
+	for len(b) > 0 {
+		fieldNum, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if fieldNum == num && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			msg = v
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(fieldNum, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return msg, nil
+}
@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+)
+
+// Observer counts the events reported by an output. Unimplemented
+// observer methods are ignored.
+type Observer struct {
+	outputs.Observer
+	Counts Counts
+}
+
+// Counts holds the number of events reported to an Observer.
+type Counts struct {
+	Acked, Failed, Dropped, TooMany int
+}
+
+// NewObserver creates an Observer with all counts set to 0.
+func NewObserver() *Observer {
+	return &Observer{Observer: outputs.NewNilObserver()}
+}
+
+func (o *Observer) Acked(n int)      { o.Counts.Acked += n }
+func (o *Observer) Failed(n int)     { o.Counts.Failed += n }
+func (o *Observer) Dropped(n int)    { o.Counts.Dropped += n }
+func (o *Observer) ErrTooMany(n int) { o.Counts.TooMany += n }
+
+// NewClient creates an output with the factory from the settings, merged in
+// order, and connects the first client of the output. The client is closed
+// once the test has finished.
+func NewClient(
+	t *testing.T,
+	factory outputs.Factory,
+	info beat.Info,
+	settings ...map[string]interface{},
+) (outputs.Client, *Observer) {
+	t.Helper()
+
+	cfg := common.NewConfig()
+	for _, s := range settings {
+		if s != nil {
+			require.NoError(t, cfg.Merge(s))
+		}
+	}
+
+	observer := NewObserver()
+	group, err := factory(nil, info, observer, cfg)
+	require.NoError(t, err)
+
+	client := group.Clients[0].(outputs.NetworkClient)
+	require.NoError(t, client.Connect())
+	t.Cleanup(func() { client.Close() })
+	return client, observer
+}
+
+// NewMessageBatch creates a batch of n events with the message field set to
+// "event <i>".
+func NewMessageBatch(n int) *Batch {
+	return NewBatchWithMessages(n)
+}
+
+// NewBatchWithMessages creates a batch of the given events, followed by n
+// events with the message field set to "event <i>".
+func NewBatchWithMessages(n int, events ...beat.Event) *Batch {
+	for i := 0; i < n; i++ {
+		events = append(events, beat.Event{Fields: common.MapStr{"message": fmt.Sprintf("event %v", i)}})
+	}
+	return NewBatch(events...)
+}
+
+// AssertPublished checks the outcome of a single Publish call of an output.
+// If expected.Failed is set, the batch must have been returned with as many
+// events for retry and the call must have failed. Otherwise the batch must
+// have been ACKed. All observer counts must match expected.
+func AssertPublished(t *testing.T, batch *Batch, err error, observer *Observer, expected Counts) {
+	t.Helper()
+
+	require.Len(t, batch.Signals, 1)
+	if expected.Failed > 0 {
+		assert.Error(t, err)
+		assert.Equal(t, BatchRetryEvents, batch.Signals[0].Tag)
+		assert.Len(t, batch.Signals[0].Events, expected.Failed)
+	} else {
+		assert.NoError(t, err)
+		assert.Equal(t, BatchACK, batch.Signals[0].Tag)
+	}
+	assert.Equal(t, expected, observer.Counts)
+}
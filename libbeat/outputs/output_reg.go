@@ -48,6 +48,12 @@ type IndexSelector interface {
 	Select(event *beat.Event) (string, error)
 }
 
+// DataStreamSelector is an optional interface of IndexSelector. It reports
+// if the selected indices are data streams, which only accept new documents.
+type DataStreamSelector interface {
+	DataStream() bool
+}
+
 // Group configures and combines multiple clients into load-balanced group of clients
 // being managed by the publisher pipeline.
 type Group struct {
@@ -0,0 +1,228 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/dlq"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/testing"
+)
+
+const remoteWriteVersion = "0.1.0"
+
+type client struct {
+	log      *logp.Logger
+	observer outputs.Observer
+	url      string
+	headers  map[string]string
+	mapper   *seriesMapper
+
+	http    *http.Client
+	tls     *tlscommon.TLSConfig
+	timeout time.Duration
+	auth    httpauth.Provider
+}
+
+type clientSettings struct {
+	URL          string
+	Headers      map[string]string
+	Proxy        *url.URL
+	ProxyDisable bool
+	TLS          *tlscommon.TLSConfig
+	Timeout      time.Duration
+	Auth         httpauth.Provider
+	Mapper       *seriesMapper
+	Observer     outputs.Observer
+}
+
+func newClient(s clientSettings) (*client, error) {
+	httpClient, err := transport.NewHTTPClient(transport.HTTPSettings{
+		Proxy:        s.Proxy,
+		ProxyDisable: s.ProxyDisable,
+		TLS:          s.TLS,
+		Timeout:      s.Timeout,
+		Stats:        s.Observer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	observer := s.Observer
+	if observer == nil {
+		observer = outputs.NewNilObserver()
+	}
+
+	return &client{
+		log:      logp.NewLogger(logSelector),
+		observer: observer,
+		url:      s.URL,
+		headers:  s.Headers,
+		mapper:   s.Mapper,
+
+		http:    httpClient,
+		tls:     s.TLS,
+		timeout: s.Timeout,
+		auth:    s.Auth,
+	}, nil
+}
+
+func (c *client) Connect() error {
+	if c.auth != nil {
+		c.log.Debugf("connect: %v (auth: %v)", c.url, c.auth)
+	} else {
+		c.log.Debugf("connect: %v", c.url)
+	}
+	return nil
+}
+
+func (c *client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// Publish sends the samples of all events of the batch in a single write
+// request. Events without numeric metricset fields are dropped.
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	var series []prompb.TimeSeries
+	okEvents := events[:0:0]
+	for i := range events {
+		eventSeries := c.mapper.timeSeries(&events[i].Content)
+		if len(eventSeries) == 0 {
+			c.log.Debugf("Dropping event without metrics: %v", events[i].Content.Fields)
+			continue
+		}
+		series = append(series, eventSeries...)
+		okEvents = append(okEvents, events[i])
+	}
+	dropped := len(events) - len(okEvents)
+	if len(okEvents) == 0 {
+		c.observer.Dropped(dropped)
+		batch.ACK()
+		return nil
+	}
+
+	status, msg, err := c.send(ctx, buildWriteRequest(series))
+	switch {
+	case err != nil:
+		c.observer.Dropped(dropped)
+		c.observer.Failed(len(okEvents))
+		batch.RetryEvents(okEvents)
+		return err
+
+	case status < 300:
+		c.observer.Dropped(dropped)
+		c.observer.Acked(len(okEvents))
+		batch.ACK()
+		return nil
+
+	case status == http.StatusTooManyRequests || status >= 500:
+		if status == http.StatusTooManyRequests {
+			c.observer.ErrTooMany(len(okEvents))
+		}
+		c.observer.Dropped(dropped)
+		c.observer.Failed(len(okEvents))
+		batch.RetryEvents(okEvents)
+		return fmt.Errorf("failed to write %v time series (status=%v): %s", len(series), status, msg)
+
+	default:
+		// Remote write receivers reject the whole request if a single sample
+		// is invalid, for example if it is out of order.
+		err := fmt.Errorf("write request rejected (status=%v): %s", status, msg)
+		c.log.Errorf("Dropping %v events: %v", len(okEvents), err)
+		for i := range okEvents {
+			dlq.Add("prometheus_remote_write", dlq.ReasonRejected, c.url, &okEvents[i].Content, err)
+		}
+		c.observer.Dropped(dropped + len(okEvents))
+		batch.ACK()
+		return nil
+	}
+}
+
+func (c *client) send(ctx context.Context, writeReq *prompb.WriteRequest) (int, string, error) {
+	data, err := proto.Marshal(writeReq)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to encode write request: %v", err)
+	}
+	body := snappy.Encode(nil, data)
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if c.auth != nil {
+		if err := c.auth.Authorize(req); err != nil {
+			return 0, "", fmt.Errorf("failed to authorize request: %v", err)
+		}
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.observer.WriteError(err)
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	c.observer.WriteBytes(len(body))
+
+	// Only the beginning of the response is kept for error messages.
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		c.observer.ReadError(err)
+	}
+	n, _ := io.Copy(ioutil.Discard, res.Body)
+	c.observer.ReadBytes(len(resBody) + int(n))
+
+	return res.StatusCode, strings.TrimSpace(string(resBody)), nil
+}
+
+func (c *client) String() string {
+	return "prometheus_remote_write(" + c.url + ")"
+}
+
+func (c *client) Test(d testing.Driver) {
+	transport.TestHTTPEndpoint(d, "prometheus_remote_write", c.url, c.tls, c.timeout)
+}
@@ -0,0 +1,163 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+)
+
+func makeTestClient(t *testing.T, handler http.HandlerFunc) (outputs.Client, *outest.Observer) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return outest.NewClient(t, makeRemoteWrite, beat.Info{Beat: "metricbeat"}, map[string]interface{}{
+		"hosts":   []string{server.URL},
+		"path":    "/api/v1/push",
+		"headers": map[string]interface{}{"X-Scope-OrgID": "tenant-1"},
+		"backoff": map[string]interface{}{"init": "1ms", "max": "1ms"},
+	})
+}
+
+// makeTestBatch creates a batch of two metric events and an event that is
+// not a metric.
+func makeTestBatch() *outest.Batch {
+	return outest.NewBatchWithMessages(1,
+		makeMetricEvent(testTime, "/", 0.25),
+		makeMetricEvent(testTime, "/data", 0.5),
+	)
+}
+
+// decodeWriteRequest decodes the snappy compressed remote write request sent
+// by the output.
+func decodeWriteRequest(t *testing.T, r *http.Request) prompb.WriteRequest {
+	var req prompb.WriteRequest
+	compressed, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	data, err := snappy.Decode(nil, compressed)
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(data, &req))
+	return req
+}
+
+func TestPublish(t *testing.T) {
+	var req prompb.WriteRequest
+	client, observer := makeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/push", r.URL.Path)
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, remoteWriteVersion, r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		assert.Equal(t, "tenant-1", r.Header.Get("X-Scope-OrgID"))
+
+		req = decodeWriteRequest(t, r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	batch := makeTestBatch()
+	err := client.Publish(context.Background(), batch)
+	outest.AssertPublished(t, batch, err, observer, outest.Counts{Acked: 2, Dropped: 1})
+
+	assert.Len(t, req.Timeseries, 4)
+	for _, s := range req.Timeseries {
+		require.Len(t, s.Samples, 1)
+		assert.Equal(t, testTime.UnixNano()/1e6, s.Samples[0].Timestamp)
+	}
+}
+
+func TestPublishStatusHandling(t *testing.T) {
+	// Events without metrics are dropped before the request is sent, such
+	// that only the 2 metric events of the batch can be retried.
+	cases := map[string]struct {
+		status   int
+		expected outest.Counts
+	}{
+		"server error is retried": {
+			status:   http.StatusInternalServerError,
+			expected: outest.Counts{Failed: 2, Dropped: 1},
+		},
+		"too many requests are retried": {
+			status:   http.StatusTooManyRequests,
+			expected: outest.Counts{Failed: 2, Dropped: 1, TooMany: 2},
+		},
+		"out of order samples are dropped": {
+			status:   http.StatusBadRequest,
+			expected: outest.Counts{Dropped: 3},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			client, observer := makeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, "out of order sample")
+			})
+
+			batch := makeTestBatch()
+			err := client.Publish(context.Background(), batch)
+			outest.AssertPublished(t, batch, err, observer, test.expected)
+
+			if test.expected.Failed > 0 {
+				for _, event := range batch.Signals[0].Events {
+					assert.NotContains(t, event.Content.Fields, "message", "event without metrics must not be retried")
+				}
+			}
+		})
+	}
+}
+
+func TestPublishRetrySendsSameSeries(t *testing.T) {
+	var requests []prompb.WriteRequest
+	client, observer := makeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, decodeWriteRequest(t, r))
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	batch := makeTestBatch()
+	assert.Error(t, client.Publish(context.Background(), batch))
+	require.Len(t, batch.Signals, 1)
+
+	var retried []beat.Event
+	for _, event := range batch.Signals[0].Events {
+		retried = append(retried, event.Content)
+	}
+	retry := outest.NewBatch(retried...)
+	require.NoError(t, client.Publish(context.Background(), retry))
+
+	require.Len(t, requests, 2)
+	assert.ElementsMatch(t, requests[0].Timeseries, requests[1].Timeseries)
+	assert.Equal(t, outest.Counts{Acked: 2, Failed: 2, Dropped: 1}, observer.Counts)
+}
@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+)
+
+type remoteWriteConfig struct {
+	Protocol     string            `config:"protocol"`
+	Path         string            `config:"path"`
+	Headers      map[string]string `config:"headers"`
+	Auth         httpauth.Config   `config:",inline"`
+	ProxyURL     string            `config:"proxy_url"`
+	ProxyDisable bool              `config:"proxy_disable"`
+	LoadBalance  bool              `config:"loadbalance"`
+	TLS          *tlscommon.Config `config:"ssl"`
+	BulkMaxSize  int               `config:"bulk_max_size"`
+	MaxRetries   int               `config:"max_retries"`
+	Timeout      time.Duration     `config:"timeout"`
+	Backoff      backoffConfig     `config:"backoff"`
+
+	// Namespace is prepended to all metric names.
+	Namespace string        `config:"namespace"`
+	Metrics   metricsConfig `config:"metrics"`
+	Labels    labelsConfig  `config:"labels"`
+}
+
+type backoffConfig struct {
+	Init time.Duration `config:"init"`
+	Max  time.Duration `config:"max"`
+}
+
+type metricsConfig struct {
+	// Exclude lists patterns of numeric fields not sent as samples.
+	Exclude []string `config:"exclude"`
+}
+
+type labelsConfig struct {
+	// Include lists patterns of fields outside of the metricset that are
+	// added as labels.
+	Include []string `config:"include"`
+
+	// Exclude lists patterns of fields never added as labels.
+	Exclude []string `config:"exclude"`
+
+	// Rename sets the label names of fields.
+	Rename []renameConfig `config:"rename"`
+}
+
+type renameConfig struct {
+	From string `config:"from" validate:"required"`
+	To   string `config:"to" validate:"required"`
+}
+
+const defaultBulkSize = 500
+
+func defaultConfig() remoteWriteConfig {
+	return remoteWriteConfig{
+		Protocol:     "",
+		Path:         "/api/v1/write",
+		ProxyURL:     "",
+		ProxyDisable: false,
+		Timeout:      30 * time.Second,
+		MaxRetries:   3,
+		BulkMaxSize:  defaultBulkSize,
+		TLS:          nil,
+		LoadBalance:  true,
+		Backoff: backoffConfig{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+		Labels: labelsConfig{
+			Include: nil, // use defaultLabelInclude
+		},
+	}
+}
+
+// defaultLabelInclude lists the fields outside of the metricset that are added
+// as labels by default.
+var defaultLabelInclude = []string{"host.name", "service.address", "labels.*"}
+
+func (c *remoteWriteConfig) Validate() error {
+	if c.ProxyURL != "" && !c.ProxyDisable {
+		if _, err := common.ParseURL(c.ProxyURL); err != nil {
+			return err
+		}
+	}
+
+	patterns := map[string][]string{
+		"metrics.exclude": c.Metrics.Exclude,
+		"labels.include":  c.Labels.Include,
+		"labels.exclude":  c.Labels.Exclude,
+	}
+	for name, list := range patterns {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern '%v' in %v: %v", pattern, name, err)
+			}
+		}
+	}
+
+	for _, rename := range c.Labels.Rename {
+		if sanitizeLabelName(rename.To) != rename.To {
+			return fmt.Errorf("invalid label name '%v' for field %v", rename.To, rename.From)
+		}
+	}
+
+	return nil
+}
+
+func readConfig(cfg *common.Config) (*remoteWriteConfig, error) {
+	c := defaultConfig()
+	if err := cfg.Unpack(&c); err != nil {
+		return nil, err
+	}
+	if len(c.Labels.Include) == 0 {
+		c.Labels.Include = defaultLabelInclude
+	}
+	return &c, nil
+}
@@ -0,0 +1,49 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"testing"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+func TestConfigInvalid(t *testing.T) {
+	tests := map[string]common.MapStr{
+		"invalid pattern": common.MapStr{
+			"labels.include": []string{"host.[name"},
+		},
+		"invalid label name": common.MapStr{
+			"labels.rename": []common.MapStr{{"from": "host.name", "to": "host.name"}},
+		},
+		"rename without target": common.MapStr{
+			"labels.rename": []common.MapStr{{"from": "host.name"}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			c := common.MustNewConfigFrom(test)
+			c.SetString("hosts", 0, "localhost")
+			if _, err := readConfig(c); err == nil {
+				t.Fatalf("Can create test configuration from invalid input")
+			}
+		})
+	}
+}
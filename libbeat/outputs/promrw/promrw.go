@@ -0,0 +1,125 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"net/url"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/httpauth"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport/tlscommon"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+)
+
+const logSelector = "prometheus_remote_write"
+
+func init() {
+	outputs.RegisterType("prometheus_remote_write", makeRemoteWrite)
+}
+
+func makeRemoteWrite(
+	_ outputs.IndexManager,
+	_ beat.Info,
+	observer outputs.Observer,
+	cfg *common.Config,
+) (outputs.Group, error) {
+	log := logp.NewLogger(logSelector)
+
+	config, err := readConfig(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	hosts, err := outputs.ReadHostList(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	tlsConfig, err := tlscommon.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	var proxyURL *url.URL
+	if !config.ProxyDisable {
+		proxyURL, err = common.ParseURL(config.ProxyURL)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+		if proxyURL != nil {
+			log.Infof("Using proxy URL: %s", proxyURL)
+		}
+	}
+
+	authClient, err := transport.NewHTTPClient(transport.HTTPSettings{
+		Proxy:        proxyURL,
+		ProxyDisable: config.ProxyDisable,
+		TLS:          tlsConfig,
+		Timeout:      config.Timeout,
+	})
+	if err != nil {
+		return outputs.Fail(err)
+	}
+	auth, err := httpauth.NewProvider(config.Auth, authClient)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	protocol := config.Protocol
+	if protocol == "" && tlsConfig != nil {
+		protocol = "https"
+	}
+	defaultPort := 80
+	if protocol == "https" {
+		defaultPort = 443
+	}
+
+	mapper := newSeriesMapper(config)
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		hostURL, err := common.MakeURL(protocol, config.Path, host, defaultPort)
+		if err != nil {
+			log.Errorf("Invalid host param set: %s, Error: %+v", host, err)
+			return outputs.Fail(err)
+		}
+
+		var client outputs.NetworkClient
+		client, err = newClient(clientSettings{
+			URL:          hostURL,
+			Headers:      config.Headers,
+			Proxy:        proxyURL,
+			ProxyDisable: config.ProxyDisable,
+			TLS:          tlsConfig,
+			Timeout:      config.Timeout,
+			Auth:         auth,
+			Mapper:       mapper,
+			Observer:     observer,
+		})
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		client = outputs.WithBackoff(client, config.Backoff.Init, config.Backoff.Max)
+		clients[i] = client
+	}
+
+	return outputs.SuccessNet(config.LoadBalance, config.BulkMaxSize, config.MaxRetries, clients)
+}
@@ -0,0 +1,305 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+const metricNameLabel = "__name__"
+
+// seriesMapper maps the numeric fields of metric events to time series.
+type seriesMapper struct {
+	namespace     string
+	metricExclude []string
+	labelInclude  []string
+	labelExclude  []string
+	rename        map[string]string
+}
+
+func newSeriesMapper(c *remoteWriteConfig) *seriesMapper {
+	rename := make(map[string]string, len(c.Labels.Rename))
+	for _, r := range c.Labels.Rename {
+		rename[r.From] = r.To
+	}
+	return &seriesMapper{
+		namespace:     c.Namespace,
+		metricExclude: c.Metrics.Exclude,
+		labelInclude:  c.Labels.Include,
+		labelExclude:  c.Labels.Exclude,
+		rename:        rename,
+	}
+}
+
+// timeSeries returns a time series with a single sample for each numeric
+// field of the metricset that created the event. The metricset is read from
+// the event.dataset field. The labels of the series are the other fields of
+// the metricset and the fields matching the label rules.
+func (m *seriesMapper) timeSeries(event *beat.Event) []prompb.TimeSeries {
+	dataset, _ := event.Fields.GetValue("event.dataset")
+	namespace, _ := dataset.(string)
+	if namespace == "" {
+		return nil
+	}
+	v, err := event.Fields.GetValue(namespace)
+	if err != nil {
+		return nil
+	}
+	metricset, ok := toMapStr(v)
+	if !ok {
+		return nil
+	}
+
+	values := map[string]float64{}
+	dimensions := map[string]string{}
+	for k, v := range metricset.Flatten() {
+		field := namespace + "." + k
+		if value, ok := toFloat(v); ok {
+			if !matchAny(m.metricExclude, field) {
+				values[field] = value
+			}
+			continue
+		}
+		if value, ok := labelValue(v); ok {
+			dimensions[field] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	for field, v := range event.Fields.Flatten() {
+		if strings.HasPrefix(field, namespace+".") || !matchAny(m.labelInclude, field) {
+			continue
+		}
+		if value, ok := labelValue(v); ok {
+			dimensions[field] = value
+		}
+	}
+	labels := m.labels(namespace, dimensions)
+
+	timestamp := event.Timestamp.UnixNano() / 1e6
+	series := make([]prompb.TimeSeries, 0, len(values))
+	for field, value := range values {
+		seriesLabels := make([]*prompb.Label, 0, len(labels)+1)
+		seriesLabels = append(seriesLabels, &prompb.Label{Name: metricNameLabel, Value: m.metricName(field)})
+		seriesLabels = append(seriesLabels, labels...)
+		sortLabels(seriesLabels)
+
+		series = append(series, prompb.TimeSeries{
+			Labels:  seriesLabels,
+			Samples: []prompb.Sample{{Value: value, Timestamp: timestamp}},
+		})
+	}
+	return series
+}
+
+// labels converts the dimensions to labels. Labels are named after the
+// fields, without the metricset namespace or the labels prefix, unless they
+// are renamed. If multiple fields map to the same label name, the first
+// field in alphabetical order is used.
+func (m *seriesMapper) labels(namespace string, dimensions map[string]string) []*prompb.Label {
+	fields := make([]string, 0, len(dimensions))
+	for field := range dimensions {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	names := map[string]bool{metricNameLabel: true}
+	labels := make([]*prompb.Label, 0, len(fields))
+	for _, field := range fields {
+		if matchAny(m.labelExclude, field) {
+			continue
+		}
+
+		name, renamed := m.rename[field]
+		if !renamed {
+			name = strings.TrimPrefix(field, namespace+".")
+			name = strings.TrimPrefix(name, "labels.")
+			name = sanitizeLabelName(name)
+		}
+		if names[name] {
+			continue
+		}
+		names[name] = true
+		labels = append(labels, &prompb.Label{Name: name, Value: dimensions[field]})
+	}
+	return labels
+}
+
+func (m *seriesMapper) metricName(field string) string {
+	if m.namespace != "" {
+		field = m.namespace + "_" + field
+	}
+	return sanitizeMetricName(field)
+}
+
+// sanitizeMetricName replaces all characters not allowed in Prometheus metric
+// names with underscores.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName replaces all characters not allowed in Prometheus label
+// names with underscores.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		case r == ':' && allowColon:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func sortLabels(labels []*prompb.Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+}
+
+// seriesKey returns a key identifying a time series by its labels.
+func seriesKey(labels []*prompb.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+// buildWriteRequest merges samples of series with equal labels, such that
+// each series is sent once per request with samples sorted by time.
+func buildWriteRequest(series []prompb.TimeSeries) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{}
+	byKey := map[string]*prompb.TimeSeries{}
+	for i := range series {
+		key := seriesKey(series[i].Labels)
+		if existing, ok := byKey[key]; ok {
+			existing.Samples = append(existing.Samples, series[i].Samples...)
+			continue
+		}
+		s := series[i]
+		byKey[key] = &s
+		req.Timeseries = append(req.Timeseries, &s)
+	}
+
+	for _, s := range req.Timeseries {
+		samples := s.Samples
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Timestamp < samples[j].Timestamp
+		})
+	}
+	return req
+}
+
+func matchAny(patterns []string, field string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case common.Float:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// labelValue returns the label value of a field. Strings, bools and numbers
+// can be used as labels. Numeric fields of the metricset are sent as samples
+// instead.
+func labelValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, v != ""
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	if f, ok := toFloat(v); ok {
+		return strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+func toMapStr(v interface{}) (common.MapStr, bool) {
+	switch m := v.(type) {
+	case common.MapStr:
+		return m, true
+	case map[string]interface{}:
+		return common.MapStr(m), true
+	}
+	return nil, false
+}
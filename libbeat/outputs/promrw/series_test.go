@@ -0,0 +1,178 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package promrw
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+var testTime = time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+
+func makeMetricEvent(ts time.Time, mountPoint string, used float64) beat.Event {
+	return beat.Event{
+		Timestamp: ts,
+		Fields: common.MapStr{
+			"host":      common.MapStr{"name": "web-1", "architecture": "x86_64"},
+			"event":     common.MapStr{"module": "system", "dataset": "system.filesystem", "duration": 1000},
+			"metricset": common.MapStr{"name": "filesystem", "period": 10000},
+			"service":   common.MapStr{"type": "system"},
+			"labels":    common.MapStr{"env": "prod", "team.name": "ops"},
+			"system": common.MapStr{
+				"filesystem": common.MapStr{
+					"mount_point": mountPoint,
+					"type":        "ext4",
+					"total":       uint64(1000),
+					"used":        common.MapStr{"pct": used},
+				},
+			},
+		},
+	}
+}
+
+func mapper(t *testing.T, settings common.MapStr) *seriesMapper {
+	cfg, err := readConfig(common.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return newSeriesMapper(cfg)
+}
+
+// seriesByName indexes series by metric name and converts labels to maps.
+func seriesByName(series []prompb.TimeSeries) map[string]map[string]string {
+	out := map[string]map[string]string{}
+	for _, s := range series {
+		labels := map[string]string{}
+		var name string
+		for _, l := range s.Labels {
+			labels[l.Name] = l.Value
+			if l.Name == metricNameLabel {
+				name = l.Value
+			}
+		}
+		out[name] = labels
+	}
+	return out
+}
+
+func TestTimeSeries(t *testing.T) {
+	event := makeMetricEvent(testTime, "/", 0.25)
+	series := mapper(t, common.MapStr{}).timeSeries(&event)
+	require.Len(t, series, 2)
+
+	for _, s := range series {
+		require.Len(t, s.Samples, 1)
+		assert.Equal(t, testTime.UnixNano()/1e6, s.Samples[0].Timestamp)
+		for i := 1; i < len(s.Labels); i++ {
+			assert.True(t, s.Labels[i-1].Name < s.Labels[i].Name, "labels must be sorted")
+		}
+	}
+
+	byName := seriesByName(series)
+	assert.Equal(t, map[string]string{
+		"__name__":    "system_filesystem_total",
+		"mount_point": "/",
+		"type":        "ext4",
+		"host_name":   "web-1",
+		"env":         "prod",
+		"team_name":   "ops",
+	}, byName["system_filesystem_total"])
+	assert.Contains(t, byName, "system_filesystem_used_pct")
+}
+
+func TestTimeSeriesRules(t *testing.T) {
+	event := makeMetricEvent(testTime, "/", 0.25)
+	series := mapper(t, common.MapStr{
+		"namespace":       "beats",
+		"metrics.exclude": []string{"*.total"},
+		"labels": common.MapStr{
+			"include": []string{"host.*", "event.module"},
+			"exclude": []string{"*.type"},
+			"rename": []common.MapStr{
+				{"from": "host.name", "to": "instance"},
+				{"from": "event.module", "to": "job"},
+			},
+		},
+	}).timeSeries(&event)
+	require.Len(t, series, 1)
+
+	assert.Equal(t, map[string]string{
+		"__name__":          "beats_system_filesystem_used_pct",
+		"mount_point":       "/",
+		"instance":          "web-1",
+		"job":               "system",
+		"host_architecture": "x86_64",
+	}, seriesByName(series)["beats_system_filesystem_used_pct"])
+}
+
+func TestTimeSeriesWithoutMetrics(t *testing.T) {
+	events := []beat.Event{
+		{Fields: common.MapStr{"message": "not a metric"}},
+		{Fields: common.MapStr{
+			"event":   common.MapStr{"dataset": "system.process"},
+			"system":  common.MapStr{"process": common.MapStr{"name": "beat"}},
+			"message": "no numeric fields",
+		}},
+	}
+	m := mapper(t, common.MapStr{})
+	for _, event := range events {
+		assert.Empty(t, m.timeSeries(&event))
+	}
+}
+
+func TestBuildWriteRequest(t *testing.T) {
+	m := mapper(t, common.MapStr{})
+	var series []prompb.TimeSeries
+	for _, event := range []beat.Event{
+		makeMetricEvent(testTime.Add(time.Second), "/", 0.3),
+		makeMetricEvent(testTime, "/", 0.2),
+		makeMetricEvent(testTime, "/data", 0.5),
+	} {
+		event := event
+		series = append(series, m.timeSeries(&event)...)
+	}
+
+	req := buildWriteRequest(series)
+	require.Len(t, req.Timeseries, 4, "series with equal labels must be merged")
+	for _, s := range req.Timeseries {
+		for i := 1; i < len(s.Samples); i++ {
+			assert.True(t, s.Samples[i-1].Timestamp <= s.Samples[i].Timestamp, "samples must be sorted")
+		}
+	}
+}
+
+func TestSanitizeNames(t *testing.T) {
+	tests := []struct {
+		name, metric, label string
+	}{
+		{"system.cpu.total.pct", "system_cpu_total_pct", "system_cpu_total_pct"},
+		{"ns:requests", "ns:requests", "ns_requests"},
+		{"1xx-responses", "_1xx_responses", "_1xx_responses"},
+		{"héllo wörld", "h_llo_w_rld", "h_llo_w_rld"},
+		{"", "_", "_"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.metric, sanitizeMetricName(test.name), test.name)
+		assert.Equal(t, test.label, sanitizeLabelName(test.name), test.name)
+	}
+}
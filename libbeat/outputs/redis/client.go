@@ -51,7 +51,7 @@ type client struct {
 	*transport.Client
 	observer outputs.Observer
 	index    string
-	dataType redisDataType
+	command  redisCommand
 	db       int
 	key      outil.Selector
 	password string
@@ -65,6 +65,7 @@ type redisDataType uint16
 const (
 	redisListType redisDataType = iota
 	redisChannelType
+	redisStreamType
 )
 
 func newClient(
@@ -72,7 +73,7 @@ func newClient(
 	observer outputs.Observer,
 	timeout time.Duration,
 	pass string,
-	db int, key outil.Selector, dt redisDataType,
+	db int, key outil.Selector, command redisCommand,
 	index string, codec codec.Codec,
 ) *client {
 	return &client{
@@ -83,7 +84,7 @@ func newClient(
 		password: pass,
 		index:    strings.ToLower(index),
 		db:       db,
-		dataType: dt,
+		command:  command,
 		key:      key,
 		codec:    codec,
 	}
@@ -146,7 +147,7 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 	events := batch.Events()
 	c.observer.NewBatch(len(events))
 	rest, err := c.publish(c.key, events)
-	if rest != nil {
+	if len(rest) > 0 {
 		c.observer.Failed(len(rest))
 		batch.RetryEvents(rest)
 		return err
@@ -163,8 +164,11 @@ func (c *client) String() string {
 func (c *client) makePublish(
 	conn redis.Conn,
 ) (publishFn, error) {
-	if c.dataType == redisChannelType {
+	switch c.command.dataType {
+	case redisChannelType:
 		return c.makePublishPUBLISH(conn)
+	case redisStreamType:
+		return c.makePublishXADD(conn)
 	}
 	return c.makePublishRPUSH(conn)
 }
@@ -220,6 +224,10 @@ func (c *client) makePublishPUBLISH(conn redis.Conn) (publishFn, error) {
 	return c.publishEventsPipeline(conn, "PUBLISH"), nil
 }
 
+func (c *client) makePublishXADD(conn redis.Conn) (publishFn, error) {
+	return c.publishEventsPipeline(conn, "XADD"), nil
+}
+
 func (c *client) publishEventsBulk(conn redis.Conn, command string) publishFn {
 	// XXX: requires key.IsConst() == true
 	dest, _ := c.key.Select(&beat.Event{Fields: common.MapStr{}})
@@ -267,7 +275,8 @@ func (c *client) publishEventsPipeline(conn redis.Conn, command string) publishF
 			}
 
 			data = append(data, okEvents[i])
-			if err := conn.Send(command, eventKey, serializedEvent); err != nil {
+			args := c.command.args(eventKey, &okEvents[i].Content, serializedEvent.([]byte))
+			if err := conn.Send(command, args...); err != nil {
 				c.log.Errorf("Failed to execute %v: %+v", command, err)
 				return okEvents, err
 			}
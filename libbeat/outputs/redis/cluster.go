@@ -0,0 +1,445 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+const clusterSlots = 16384
+
+var errTooManyRedirects = errors.New("too many redis cluster redirections")
+
+// clusterSeed is a configured host used to discover the cluster.
+type clusterSeed struct {
+	addr      string
+	transport transport.Config
+	password  string
+}
+
+// clusterClient publishes events to a Redis Cluster. Events are routed to the
+// node serving the hash slot of their key. The slot table is loaded using
+// CLUSTER SLOTS on connect and updated on MOVED redirections.
+type clusterClient struct {
+	log          *logp.Logger
+	observer     outputs.Observer
+	seeds        []clusterSeed
+	timeout      time.Duration
+	key          outil.Selector
+	command      redisCommand
+	index        string
+	codec        codec.Codec
+	maxRedirects int
+
+	seed  clusterSeed // seed the cluster was discovered with
+	slots []string    // node address by slot
+	nodes map[string]*clusterNode
+}
+
+type clusterNode struct {
+	client *transport.Client
+	conn   redis.Conn
+}
+
+// clusterEntry is a serialized event waiting to be sent to the cluster.
+type clusterEntry struct {
+	event publisher.Event
+	slot  int
+	args  []interface{}
+	ask   string // node to send the command to after an ASK redirection
+}
+
+func newClusterClient(
+	seeds []clusterSeed,
+	observer outputs.Observer,
+	timeout time.Duration,
+	key outil.Selector, command redisCommand,
+	index string, codec codec.Codec,
+	maxRedirects int,
+) *clusterClient {
+	return &clusterClient{
+		log:          logp.NewLogger("redis"),
+		observer:     observer,
+		seeds:        seeds,
+		timeout:      timeout,
+		key:          key,
+		command:      command,
+		index:        strings.ToLower(index),
+		codec:        codec,
+		maxRedirects: maxRedirects,
+		nodes:        map[string]*clusterNode{},
+	}
+}
+
+func (c *clusterClient) Connect() error {
+	c.log.Debug("connect to cluster")
+
+	var err error
+	for _, seed := range c.seeds {
+		if err = c.discover(seed); err == nil {
+			return nil
+		}
+		c.log.Errorf("Failed to discover redis cluster using %v: %+v", seed.addr, err)
+	}
+	return err
+}
+
+func (c *clusterClient) discover(seed clusterSeed) error {
+	c.seed = seed
+	node, err := c.node(seed.addr)
+	if err != nil {
+		return err
+	}
+
+	slots, err := readClusterSlots(node.conn, seed.addr)
+	if err != nil {
+		c.closeNode(seed.addr)
+		return err
+	}
+	c.slots = slots
+	return nil
+}
+
+// readClusterSlots reads the address of the master serving each slot.
+func readClusterSlots(conn redis.Conn, addr string) ([]string, error) {
+	reply, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]string, clusterSlots)
+	for _, r := range reply {
+		info, err := redis.Values(r, nil)
+		if err != nil || len(info) < 3 {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS entry: %v", r)
+		}
+
+		start, err1 := redis.Int(info[0], nil)
+		end, err2 := redis.Int(info[1], nil)
+		master, err3 := redis.Values(info[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(master) < 2 {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS entry: %v", r)
+		}
+		if start < 0 || end >= clusterSlots || start > end {
+			return nil, fmt.Errorf("invalid slot range %v-%v", start, end)
+		}
+
+		host, err1 := redis.String(master[0], nil)
+		port, err2 := redis.Int(master[1], nil)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS node: %v", master)
+		}
+
+		nodeAddr := joinNodeAddr(addr, host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = nodeAddr
+		}
+	}
+	return slots, nil
+}
+
+// joinNodeAddr builds a node address as reported by the cluster. An empty
+// host refers to the host of the node that reported the address.
+func joinNodeAddr(from, host, port string) string {
+	if host == "" {
+		host, _, _ = net.SplitHostPort(from)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (c *clusterClient) node(addr string) (*clusterNode, error) {
+	if node := c.nodes[addr]; node != nil {
+		return node, nil
+	}
+
+	tc, err := transport.NewClient(c.seed.transport, "tcp", addr, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+	if err := tc.Connect(); err != nil {
+		return nil, err
+	}
+
+	conn := redis.NewConn(tc, c.timeout, c.timeout)
+	if err := initRedisConn(conn, c.seed.password, 0); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	node := &clusterNode{client: tc, conn: conn}
+	c.nodes[addr] = node
+	return node, nil
+}
+
+func (c *clusterClient) closeNode(addr string) error {
+	node := c.nodes[addr]
+	if node == nil {
+		return nil
+	}
+	delete(c.nodes, addr)
+	return node.conn.Close()
+}
+
+func (c *clusterClient) Close() error {
+	c.log.Debug("close cluster connections")
+
+	var lastErr error
+	for addr := range c.nodes {
+		if err := c.closeNode(addr); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (c *clusterClient) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+	rest, err := c.publishEvents(events)
+	if len(rest) > 0 {
+		c.observer.Failed(len(rest))
+		batch.RetryEvents(rest)
+		return err
+	}
+
+	batch.ACK()
+	return err
+}
+
+func (c *clusterClient) String() string {
+	addrs := make([]string, len(c.seeds))
+	for i, seed := range c.seeds {
+		addrs[i] = seed.addr
+	}
+	return "redis-cluster(" + strings.Join(addrs, ",") + ")"
+}
+
+func (c *clusterClient) publishEvents(data []publisher.Event) ([]publisher.Event, error) {
+	okEvents, serialized := serializeEvents(c.log, nil, 0, data, c.index, c.codec)
+	c.observer.Dropped(len(data) - len(okEvents))
+
+	pending := make([]clusterEntry, 0, len(okEvents))
+	dropped := 0
+	for i := range okEvents {
+		event := &okEvents[i].Content
+		key, err := c.key.Select(event)
+		if err != nil {
+			c.log.Errorf("Failed to set redis key: %+v", err)
+			dropped++
+			continue
+		}
+
+		pending = append(pending, clusterEntry{
+			event: okEvents[i],
+			slot:  keySlot(key),
+			args:  c.command.args(key, event, serialized[i].([]byte)),
+		})
+	}
+	c.observer.Dropped(dropped)
+
+	var (
+		failed  []publisher.Event
+		lastErr error
+		acked   int
+	)
+	for redirects := 0; len(pending) > 0; redirects++ {
+		if redirects > c.maxRedirects {
+			failed = append(failed, entryEvents(pending)...)
+			lastErr = errTooManyRedirects
+			break
+		}
+
+		var redirected []clusterEntry
+		for addr, entries := range c.route(pending) {
+			if addr == "" {
+				failed = append(failed, entryEvents(entries)...)
+				lastErr = errors.New("no redis cluster node serving the slot")
+				continue
+			}
+
+			n, moved, rest, err := c.send(addr, entries)
+			acked += n
+			redirected = append(redirected, moved...)
+			failed = append(failed, rest...)
+			if err != nil {
+				lastErr = err
+			}
+		}
+		pending = redirected
+	}
+
+	c.observer.Acked(acked)
+	return failed, lastErr
+}
+
+// route groups the entries by the node they must be sent to.
+func (c *clusterClient) route(entries []clusterEntry) map[string][]clusterEntry {
+	routes := map[string][]clusterEntry{}
+	for _, e := range entries {
+		addr := e.ask
+		if addr == "" {
+			addr = c.slots[e.slot]
+		}
+		routes[addr] = append(routes[addr], e)
+	}
+	return routes
+}
+
+// send pipelines the entries to a single node. It returns the number of
+// events acknowledged, the entries redirected to another node and the failed
+// events.
+func (c *clusterClient) send(addr string, entries []clusterEntry) (
+	acked int,
+	redirected []clusterEntry,
+	failed []publisher.Event,
+	err error,
+) {
+	node, err := c.node(addr)
+	if err != nil {
+		c.log.Errorf("Failed to connect to redis cluster node %v: %+v", addr, err)
+		return 0, nil, entryEvents(entries), err
+	}
+
+	command := c.command.name()
+	for _, e := range entries {
+		if e.ask != "" {
+			err = node.conn.Send("ASKING")
+		}
+		if err == nil {
+			err = node.conn.Send(command, e.args...)
+		}
+		if err != nil {
+			c.log.Errorf("Failed to execute %v: %+v", command, err)
+			c.closeNode(addr)
+			return 0, nil, entryEvents(entries), err
+		}
+	}
+	if err := node.conn.Flush(); err != nil {
+		c.closeNode(addr)
+		return 0, nil, entryEvents(entries), err
+	}
+
+	var lastErr error
+	for i, e := range entries {
+		if e.ask != "" {
+			// Errors of ASKING are reported by the command as well.
+			if _, err := node.conn.Receive(); err != nil && !isRedisError(err) {
+				c.closeNode(addr)
+				return acked, redirected, append(failed, entryEvents(entries[i:])...), err
+			}
+		}
+
+		_, err := node.conn.Receive()
+		if err == nil {
+			acked++
+			continue
+		}
+
+		if !isRedisError(err) {
+			c.log.Errorf("Failed to %v multiple events to %v with %+v", command, addr, err)
+			c.closeNode(addr)
+			return acked, redirected, append(failed, entryEvents(entries[i:])...), err
+		}
+
+		if kind, target, ok := parseRedirect(err, addr); ok {
+			if kind == "MOVED" {
+				c.slots[e.slot] = target
+				e.ask = ""
+			} else {
+				e.ask = target
+			}
+			redirected = append(redirected, e)
+			continue
+		}
+
+		c.log.Errorf("Failed to %v event to %v with %+v", command, addr, err)
+		failed = append(failed, e.event)
+		lastErr = err
+	}
+	return acked, redirected, failed, lastErr
+}
+
+func entryEvents(entries []clusterEntry) []publisher.Event {
+	events := make([]publisher.Event, len(entries))
+	for i := range entries {
+		events[i] = entries[i].event
+	}
+	return events
+}
+
+func isRedisError(err error) bool {
+	_, ok := err.(redis.Error)
+	return ok
+}
+
+// parseRedirect parses MOVED and ASK errors of the form
+// `MOVED <slot> <host>:<port>`.
+func parseRedirect(err error, from string) (kind, addr string, ok bool) {
+	fields := strings.Fields(err.Error())
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", "", false
+	}
+
+	host, port, splitErr := net.SplitHostPort(fields[2])
+	if splitErr != nil {
+		return "", "", false
+	}
+	return fields[0], joinNodeAddr(from, host, port), true
+}
+
+// keySlot returns the hash slot of a key. Only the hash tag is hashed if the
+// key contains a non-empty substring enclosed in {}.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 implements CRC-16/XMODEM as used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
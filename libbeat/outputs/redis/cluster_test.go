@@ -0,0 +1,303 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
+)
+
+// fakeRedis is a minimal RESP server recording all commands received.
+type fakeRedis struct {
+	t      *testing.T
+	ln     net.Listener
+	handle func(args []string) interface{}
+
+	mu       sync.Mutex
+	commands [][]string
+}
+
+type redisErrorReply string
+
+func newFakeRedis(t *testing.T, handle func(args []string) interface{}) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeRedis{t: t, ln: ln, handle: handle}
+	go s.serve()
+	return s
+}
+
+func (s *fakeRedis) Addr() string { return s.ln.Addr().String() }
+
+func (s *fakeRedis) Close() { s.ln.Close() }
+
+func (s *fakeRedis) Commands(name string) [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cmds [][]string
+	for _, cmd := range s.commands {
+		if cmd[0] == name {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		s.mu.Unlock()
+
+		var reply interface{}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "PONG"
+		case "ASKING":
+			reply = "OK"
+		default:
+			reply = s.handle(args)
+		}
+		writeReply(w, reply)
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "+%v\r\n", v)
+	case redisErrorReply:
+		fmt.Fprintf(w, "-%v\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%v\r\n", v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%v\r\n", len(v))
+		for _, elem := range v {
+			writeReply(w, elem)
+		}
+	}
+}
+
+func slotsReply(ranges ...interface{}) []interface{} {
+	var reply []interface{}
+	for i := 0; i < len(ranges); i += 3 {
+		host, port, _ := net.SplitHostPort(ranges[i+2].(string))
+		p, _ := strconv.Atoi(port)
+		reply = append(reply, []interface{}{ranges[i], ranges[i+1], []interface{}{host, p}})
+	}
+	return reply
+}
+
+func TestKeySlot(t *testing.T) {
+	assert.Equal(t, uint16(0x31C3), crc16("123456789"))
+
+	tests := map[string]int{
+		"":                     0,
+		"foo":                  12182,
+		"bar":                  5061,
+		"{foo}.events":         12182,
+		"logs-{foo}":           12182,
+		"{}.foo":               keySlot("{}.foo"),
+		"{bar":                 keySlot("{bar"),
+		"{user1000}.following": keySlot("user1000"),
+	}
+	for key, slot := range tests {
+		assert.Equal(t, slot, keySlot(key), key)
+	}
+	assert.NotEqual(t, keySlot("foo"), keySlot("{}.foo"))
+}
+
+func TestParseRedirect(t *testing.T) {
+	kind, addr, ok := parseRedirect(fmt.Errorf("MOVED 3999 127.0.0.1:6381"), "10.0.0.1:6379")
+	assert.True(t, ok)
+	assert.Equal(t, "MOVED", kind)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+
+	kind, addr, ok = parseRedirect(fmt.Errorf("ASK 3999 :6381"), "10.0.0.1:6379")
+	assert.True(t, ok)
+	assert.Equal(t, "ASK", kind)
+	assert.Equal(t, "10.0.0.1:6381", addr)
+
+	_, _, ok = parseRedirect(fmt.Errorf("ERR unknown command"), "10.0.0.1:6379")
+	assert.False(t, ok)
+}
+
+func TestClusterPublish(t *testing.T) {
+	var nodeA, nodeB *fakeRedis
+	nodeB = newFakeRedis(t, func(args []string) interface{} {
+		return 1
+	})
+	defer nodeB.Close()
+
+	// node A reports to serve all slots, but redirects keys of the second
+	// half to node B.
+	nodeA = newFakeRedis(t, func(args []string) interface{} {
+		switch args[0] {
+		case "CLUSTER":
+			return slotsReply(0, clusterSlots-1, nodeA.Addr())
+		case "RPUSH":
+			if slot := keySlot(args[1]); slot >= clusterSlots/2 {
+				return redisErrorReply(fmt.Sprintf("MOVED %v %v", slot, nodeB.Addr()))
+			}
+			return 1
+		}
+		return redisErrorReply("ERR unknown command")
+	})
+	defer nodeA.Close()
+
+	key, err := buildKeySelector(common.MustNewConfigFrom(map[string]interface{}{
+		"key": "%{[key]}",
+	}))
+	require.NoError(t, err)
+
+	seeds := []clusterSeed{
+		{addr: "127.0.0.1:1"}, // unreachable seeds are skipped
+		{addr: nodeA.Addr(), transport: transport.Config{Timeout: time.Second}},
+	}
+	client := newClusterClient(seeds, outputs.NewNilObserver(), time.Second,
+		key, redisCommand{dataType: redisListType}, "test", json.New("1.2.3", json.Config{}), 5)
+	require.NoError(t, client.Connect())
+	defer client.Close()
+
+	var events []beat.Event
+	for _, k := range []string{"foo", "bar", "foo", "bar", "{foo}.x"} {
+		events = append(events, beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"key": k},
+		})
+	}
+	batch := outest.NewBatch(events...)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	keys := func(cmds [][]string) []string {
+		var keys []string
+		for _, cmd := range cmds {
+			keys = append(keys, cmd[1])
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"foo", "bar", "foo", "bar", "{foo}.x"}, keys(nodeA.Commands("RPUSH")))
+	assert.Equal(t, []string{"foo", "foo", "{foo}.x"}, keys(nodeB.Commands("RPUSH")))
+
+	// the slot table was updated, keys of node B are sent to B directly.
+	batch = outest.NewBatch(events[0])
+	require.NoError(t, client.Publish(context.Background(), batch))
+	assert.Len(t, nodeA.Commands("RPUSH"), 5)
+	assert.Len(t, nodeB.Commands("RPUSH"), 4)
+}
+
+func TestClusterTooManyRedirects(t *testing.T) {
+	var node *fakeRedis
+	node = newFakeRedis(t, func(args []string) interface{} {
+		if args[0] == "CLUSTER" {
+			return slotsReply(0, clusterSlots-1, node.Addr())
+		}
+		return redisErrorReply(fmt.Sprintf("ASK %v %v", keySlot(args[1]), node.Addr()))
+	})
+	defer node.Close()
+
+	key := outil.MakeSelector(outil.ConstSelectorExpr("events", outil.SelectorKeepCase))
+	seeds := []clusterSeed{{addr: node.Addr(), transport: transport.Config{Timeout: time.Second}}}
+	client := newClusterClient(seeds, outputs.NewNilObserver(), time.Second,
+		key, redisCommand{dataType: redisListType}, "test", json.New("1.2.3", json.Config{}), 2)
+	require.NoError(t, client.Connect())
+	defer client.Close()
+
+	batch := outest.NewBatch(beat.Event{Fields: common.MapStr{"message": "test"}})
+	assert.Equal(t, errTooManyRedirects, client.Publish(context.Background(), batch))
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	assert.Len(t, node.Commands("RPUSH"), 3)
+	assert.Len(t, node.Commands("ASKING"), 2)
+}
@@ -18,6 +18,7 @@
 package redis
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -40,6 +41,13 @@ type redisConfig struct {
 	Db          int                   `config:"db"`
 	DataType    string                `config:"datatype"`
 	Backoff     backoff               `config:"backoff"`
+	Stream      streamConfig          `config:"stream"`
+	Cluster     clusterConfig         `config:"cluster"`
+}
+
+type clusterConfig struct {
+	Enabled      bool `config:"enabled"`
+	MaxRedirects int  `config:"max_redirects" validate:"min=0"`
 }
 
 type backoff struct {
@@ -60,15 +68,23 @@ var (
 			Init: 1 * time.Second,
 			Max:  60 * time.Second,
 		},
+		Stream: defaultStreamConfig,
+		Cluster: clusterConfig{
+			MaxRedirects: 5,
+		},
 	}
 )
 
 func (c *redisConfig) Validate() error {
 	switch c.DataType {
-	case "", "list", "channel":
+	case "", "list", "channel", "stream":
 	default:
 		return fmt.Errorf("redis data type %v not supported", c.DataType)
 	}
 
+	if c.Cluster.Enabled && c.Db != 0 {
+		return errors.New("redis cluster only supports db 0")
+	}
+
 	return nil
 }
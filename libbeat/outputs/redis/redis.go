@@ -82,12 +82,14 @@ func makeRedis(
 		return outputs.Fail(err)
 	}
 
-	var dataType redisDataType
+	command := redisCommand{stream: config.Stream}
 	switch config.DataType {
 	case "", "list":
-		dataType = redisListType
+		command.dataType = redisListType
 	case "channel":
-		dataType = redisChannelType
+		command.dataType = redisChannelType
+	case "stream":
+		command.dataType = redisStreamType
 	default:
 		return outputs.Fail(errors.New("Bad Redis data type"))
 	}
@@ -107,63 +109,38 @@ func makeRedis(
 		return outputs.Fail(err)
 	}
 
-	clients := make([]outputs.NetworkClient, len(hosts))
+	seeds := make([]clusterSeed, len(hosts))
 	for i, h := range hosts {
-		hasScheme := true
-		if parts := strings.SplitN(h, "://", 2); len(parts) != 2 {
-			h = fmt.Sprintf("%s://%s", redisScheme, h)
-			hasScheme = false
-		}
-
-		hostUrl, err := url.Parse(h)
+		seeds[i], err = makeSeed(h, &config, tls, observer)
 		if err != nil {
 			return outputs.Fail(err)
 		}
+	}
 
-		if hostUrl.Host == "" {
-			return outputs.Fail(fmt.Errorf("invalid redis url host %s", hostUrl.Host))
-		}
-
-		if hostUrl.Scheme != redisScheme && hostUrl.Scheme != tlsRedisScheme {
-			return outputs.Fail(fmt.Errorf("invalid redis url scheme %s", hostUrl.Scheme))
-		}
-
-		transp := transport.Config{
-			Timeout: config.Timeout,
-			Proxy:   &config.Proxy,
-			TLS:     tls,
-			Stats:   observer,
-		}
-
-		switch hostUrl.Scheme {
-		case redisScheme:
-			if hasScheme {
-				transp.TLS = nil // disable TLS if user explicitely set `redis` scheme
-			}
-		case tlsRedisScheme:
-			if transp.TLS == nil {
-				transp.TLS = &tlscommon.TLSConfig{} // enable with system default if TLS was not configured
-			}
-		}
-
-		conn, err := transport.NewClient(transp, "tcp", hostUrl.Host, defaultPort)
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, seed := range seeds {
+		enc, err := codec.CreateEncoder(beat, config.Codec)
 		if err != nil {
 			return outputs.Fail(err)
 		}
 
-		pass := config.Password
-		hostPass, passSet := hostUrl.User.Password()
-		if passSet {
-			pass = hostPass
+		if config.Cluster.Enabled {
+			// Every client discovers the cluster starting with its own host,
+			// falling back to the other hosts.
+			order := append(append([]clusterSeed{}, seeds[i:]...), seeds[:i]...)
+			client := newClusterClient(order, observer, config.Timeout,
+				key, command, config.Index, enc, config.Cluster.MaxRedirects)
+			clients[i] = newBackoffClient(client, config.Backoff.Init, config.Backoff.Max)
+			continue
 		}
 
-		enc, err := codec.CreateEncoder(beat, config.Codec)
+		conn, err := transport.NewClient(seed.transport, "tcp", seed.addr, defaultPort)
 		if err != nil {
 			return outputs.Fail(err)
 		}
 
 		client := newClient(conn, observer, config.Timeout,
-			pass, config.Db, key, dataType, config.Index, enc)
+			seed.password, config.Db, key, command, config.Index, enc)
 		clients[i] = newBackoffClient(client, config.Backoff.Init, config.Backoff.Max)
 	}
 
@@ -179,3 +156,57 @@ func buildKeySelector(cfg *common.Config) (outil.Selector, error) {
 		Case:             outil.SelectorKeepCase,
 	})
 }
+
+// makeSeed parses a host entry into the address, transport settings and
+// password used to connect to it.
+func makeSeed(
+	h string,
+	config *redisConfig,
+	tls *tlscommon.TLSConfig,
+	observer outputs.Observer,
+) (clusterSeed, error) {
+	hasScheme := true
+	if parts := strings.SplitN(h, "://", 2); len(parts) != 2 {
+		h = fmt.Sprintf("%s://%s", redisScheme, h)
+		hasScheme = false
+	}
+
+	hostUrl, err := url.Parse(h)
+	if err != nil {
+		return clusterSeed{}, err
+	}
+
+	if hostUrl.Host == "" {
+		return clusterSeed{}, fmt.Errorf("invalid redis url host %s", hostUrl.Host)
+	}
+
+	if hostUrl.Scheme != redisScheme && hostUrl.Scheme != tlsRedisScheme {
+		return clusterSeed{}, fmt.Errorf("invalid redis url scheme %s", hostUrl.Scheme)
+	}
+
+	transp := transport.Config{
+		Timeout: config.Timeout,
+		Proxy:   &config.Proxy,
+		TLS:     tls,
+		Stats:   observer,
+	}
+
+	switch hostUrl.Scheme {
+	case redisScheme:
+		if hasScheme {
+			transp.TLS = nil // disable TLS if user explicitely set `redis` scheme
+		}
+	case tlsRedisScheme:
+		if transp.TLS == nil {
+			transp.TLS = &tlscommon.TLSConfig{} // enable with system default if TLS was not configured
+		}
+	}
+
+	pass := config.Password
+	hostPass, passSet := hostUrl.User.Password()
+	if passSet {
+		pass = hostPass
+	}
+
+	return clusterSeed{addr: hostUrl.Host, transport: transp, password: pass}, nil
+}
@@ -0,0 +1,129 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+// streamConfig configures the entries added to a stream by the `stream` data
+// type.
+type streamConfig struct {
+	// MaxLen trims the stream to about MaxLen entries on every XADD. 0 disables
+	// trimming.
+	MaxLen      int  `config:"max_len" validate:"min=0"`
+	Approximate bool `config:"approximate"`
+
+	// Field is the name of the entry field holding the encoded event. The
+	// encoded event is not added if Field is empty.
+	Field string `config:"field"`
+
+	// Fields maps additional entry fields to event fields.
+	Fields map[string]string `config:"fields"`
+}
+
+var defaultStreamConfig = streamConfig{
+	Approximate: true,
+	Field:       "event",
+}
+
+func (c *streamConfig) Validate() error {
+	if c.Field == "" && len(c.Fields) == 0 {
+		return errors.New("stream requires field or fields to be set")
+	}
+	if _, exists := c.Fields[c.Field]; exists && c.Field != "" {
+		return fmt.Errorf("stream field '%v' is also used in fields", c.Field)
+	}
+	return nil
+}
+
+// redisCommand builds the command publishing a single event for the
+// configured data type.
+type redisCommand struct {
+	dataType redisDataType
+	stream   streamConfig
+}
+
+func (r *redisCommand) name() string {
+	switch r.dataType {
+	case redisChannelType:
+		return "PUBLISH"
+	case redisStreamType:
+		return "XADD"
+	default:
+		return "RPUSH"
+	}
+}
+
+func (r *redisCommand) args(key string, event *beat.Event, payload []byte) []interface{} {
+	if r.dataType != redisStreamType {
+		return []interface{}{key, payload}
+	}
+
+	args := make([]interface{}, 0, 6+2*len(r.stream.Fields))
+	args = append(args, key)
+	if r.stream.MaxLen > 0 {
+		args = append(args, "MAXLEN")
+		if r.stream.Approximate {
+			args = append(args, "~")
+		}
+		args = append(args, r.stream.MaxLen)
+	}
+	args = append(args, "*")
+
+	if r.stream.Field != "" {
+		args = append(args, r.stream.Field, payload)
+	}
+	for name, field := range r.stream.Fields {
+		value, err := event.GetValue(field)
+		if err != nil || value == nil {
+			continue
+		}
+		args = append(args, name, streamValue(value))
+	}
+	return args
+}
+
+// streamValue converts an event field into the string stored in a stream
+// entry. Objects and arrays are stored as JSON.
+func streamValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case common.Time:
+		return time.Time(v).UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,122 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/transport"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outest"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/outil"
+)
+
+func TestStreamArgs(t *testing.T) {
+	event := &beat.Event{Fields: common.MapStr{
+		"message": "hello",
+		"host":    common.MapStr{"name": "web-1"},
+		"count":   42,
+		"tags":    []string{"a", "b"},
+	}}
+	payload := []byte(`{"message":"hello"}`)
+
+	tests := map[string]struct {
+		stream streamConfig
+		want   []interface{}
+	}{
+		"defaults": {
+			stream: defaultStreamConfig,
+			want:   []interface{}{"events", "*", "event", payload},
+		},
+		"exact trimming": {
+			stream: streamConfig{MaxLen: 100, Field: "event"},
+			want:   []interface{}{"events", "MAXLEN", 100, "*", "event", payload},
+		},
+		"approximate trimming with mapped fields": {
+			stream: streamConfig{
+				MaxLen:      1000,
+				Approximate: true,
+				Fields:      map[string]string{"host": "host.name"},
+			},
+			want: []interface{}{"events", "MAXLEN", "~", 1000, "*", "host", "web-1"},
+		},
+		"mapped values": {
+			stream: streamConfig{Fields: map[string]string{"count": "count"}},
+			want:   []interface{}{"events", "*", "count", "42"},
+		},
+		"array values": {
+			stream: streamConfig{Fields: map[string]string{"tags": "tags"}},
+			want:   []interface{}{"events", "*", "tags", `["a","b"]`},
+		},
+		"missing fields are skipped": {
+			stream: streamConfig{Field: "event", Fields: map[string]string{"missing": "missing"}},
+			want:   []interface{}{"events", "*", "event", payload},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			command := redisCommand{dataType: redisStreamType, stream: test.stream}
+			assert.Equal(t, "XADD", command.name())
+			assert.Equal(t, test.want, command.args("events", event, payload))
+		})
+	}
+}
+
+func TestPublishStream(t *testing.T) {
+	server := newFakeRedis(t, func(args []string) interface{} {
+		return "1-0"
+	})
+	defer server.Close()
+
+	conn, err := transport.NewClient(transport.Config{Timeout: time.Second}, "tcp", server.Addr(), defaultPort)
+	require.NoError(t, err)
+
+	stream := defaultStreamConfig
+	stream.MaxLen = 10
+	client := newClient(conn, outputs.NewNilObserver(), time.Second, "", 0,
+		outil.MakeSelector(outil.ConstSelectorExpr("events", outil.SelectorKeepCase)),
+		redisCommand{dataType: redisStreamType, stream: stream},
+		"test", json.New("1.2.3", json.Config{}))
+	require.NoError(t, client.Connect())
+	defer client.Close()
+
+	batch := outest.NewBatch(
+		beat.Event{Fields: common.MapStr{"message": "first"}},
+		beat.Event{Fields: common.MapStr{"message": "second"}},
+	)
+	require.NoError(t, client.Publish(context.Background(), batch))
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	cmds := server.Commands("XADD")
+	require.Len(t, cmds, 2)
+	for i, message := range []string{"first", "second"} {
+		assert.Equal(t, []string{"XADD", "events", "MAXLEN", "~", "10", "*", "event"}, cmds[i][:7])
+		assert.Contains(t, cmds[i][7], `"message":"`+message+`"`)
+	}
+}
@@ -0,0 +1,52 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package processors
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+)
+
+// derivedEventsKey is the @metadata key used to hand events created by a
+// processor to the publisher pipeline. The key is removed from the event by
+// TakeDerivedEvents before the event is published.
+const derivedEventsKey = "_derived_events"
+
+// AddDerivedEvent attaches a new event, derived from event, to event. The
+// publisher pipeline publishes derived events right after the event they have
+// been attached to. Derived events do not pass through the remaining
+// processors and are dropped if event gets dropped.
+func AddDerivedEvent(event *beat.Event, derived beat.Event) {
+	if event.Meta == nil {
+		event.Meta = common.MapStr{}
+	}
+
+	list, _ := event.Meta[derivedEventsKey].([]beat.Event)
+	event.Meta[derivedEventsKey] = append(list, derived)
+}
+
+// TakeDerivedEvents removes all events derived from event and returns them.
+func TakeDerivedEvents(event *beat.Event) []beat.Event {
+	if event == nil || event.Meta == nil {
+		return nil
+	}
+
+	list, _ := event.Meta[derivedEventsKey].([]beat.Event)
+	delete(event.Meta, derivedEventsKey)
+	return list
+}
@@ -0,0 +1,116 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	modeDrop   = "drop"
+	modeSample = "sample"
+)
+
+// config for the rate_limit processor.
+type config struct {
+	Limit       rate          `config:"limit"`                         // Number of events per period, e.g. 1000/s
+	Burst       int           `config:"burst" validate:"min=0"`        // Bucket size, defaults to the number of events of the limit
+	Fields      []string      `config:"fields"`                        // Fields the limits are keyed by
+	Mode        string        `config:"mode"`                          // drop or sample
+	SampleEvery int           `config:"sample_every" validate:"min=1"` // Keeps one of every N events over the limit in sample mode
+	MaxKeys     int           `config:"max_keys" validate:"min=1"`     // Maximum number of keys with a limit of their own
+	Summary     summaryConfig `config:"summary"`
+}
+
+type summaryConfig struct {
+	// Interval of the summary events. 0 disables summary events.
+	Interval time.Duration `config:"interval" validate:"min=0"`
+}
+
+// rate is a number of events per period, configured as "<events>/<unit>",
+// where unit is one of s, m or h.
+type rate struct {
+	events float64
+	period time.Duration
+}
+
+func defaultConfig() config {
+	return config{
+		Mode:        modeDrop,
+		SampleEvery: 10,
+		MaxKeys:     10000,
+		Summary: summaryConfig{
+			Interval: 1 * time.Minute,
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	if c.Limit.events == 0 {
+		return errors.New("limit is required")
+	}
+
+	switch c.Mode {
+	case modeDrop, modeSample:
+	default:
+		return fmt.Errorf("invalid mode '%v', mode must be drop or sample", c.Mode)
+	}
+	return nil
+}
+
+// Unpack parses the rate from a string like 1000/s.
+func (r *rate) Unpack(s string) error {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid rate '%v', expected format <events>/<s|m|h>", s)
+	}
+
+	events, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || events <= 0 {
+		return fmt.Errorf("invalid number of events in rate '%v'", s)
+	}
+
+	var period time.Duration
+	switch strings.TrimSpace(parts[1]) {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return fmt.Errorf("invalid unit in rate '%v', unit must be s, m or h", s)
+	}
+
+	*r = rate{events: events, period: period}
+	return nil
+}
+
+// perSecond returns the number of events per second.
+func (r rate) perSecond() float64 {
+	return r.events / r.period.Seconds()
+}
+
+func (r rate) String() string {
+	unit := map[time.Duration]string{time.Second: "s", time.Minute: "m", time.Hour: "h"}[r.period]
+	return strconv.FormatFloat(r.events, 'f', -1, 64) + "/" + unit
+}
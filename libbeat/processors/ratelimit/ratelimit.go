@@ -0,0 +1,325 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/processors"
+)
+
+func init() {
+	processors.RegisterPlugin(processorName, New)
+}
+
+const (
+	processorName = "rate_limit"
+
+	// numShards is the number of independently locked bucket maps, reducing
+	// lock contention between clients sharing the processor.
+	numShards = 16
+
+	// cleanupInterval is used to remove idle buckets if summary events are
+	// disabled.
+	cleanupInterval = 1 * time.Minute
+)
+
+type rateLimit struct {
+	config   config
+	log      *logp.Logger
+	now      func() time.Time
+	rate     float64 // tokens added per second
+	burst    float64
+	interval time.Duration
+
+	shards [numShards]shard
+	keys   atomic.Int64 // number of buckets in all shards
+
+	overflowMu sync.Mutex
+	overflow   *bucket // shared by all keys once max_keys is reached
+
+	nextTick atomic.Int64 // time of the next summary in unix nanoseconds
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is the token bucket of a key.
+type bucket struct {
+	values   []interface{} // values of the key fields
+	overflow bool          // bucket is shared by all keys once max_keys is reached
+	tokens   float64
+	last     time.Time
+
+	excess  uint64 // events over the limit, selecting the events to sample
+	dropped uint64 // events dropped since the last summary
+	sampled uint64 // events over the limit kept since the last summary
+}
+
+// New constructs a new rate_limit processor.
+func New(cfg *common.Config) (processors.Processor, error) {
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("failed to unpack the %v configuration: %v", processorName, err)
+	}
+
+	return newRateLimit(config, time.Now), nil
+}
+
+func newRateLimit(config config, now func() time.Time) *rateLimit {
+	p := &rateLimit{
+		config:   config,
+		log:      logp.NewLogger(processorName),
+		now:      now,
+		rate:     config.Limit.perSecond(),
+		burst:    float64(config.Burst),
+		interval: config.Summary.Interval,
+	}
+	if p.burst <= 0 {
+		p.burst = config.Limit.events
+	}
+	if p.burst < 1 {
+		p.burst = 1
+	}
+	if p.interval <= 0 {
+		p.interval = cleanupInterval
+	}
+
+	for i := range p.shards {
+		p.shards[i].buckets = map[string]*bucket{}
+	}
+	p.nextTick.Store(now().Add(p.interval).UnixNano())
+	return p
+}
+
+// Run drops events exceeding the limit of their key. In sample mode, one of
+// every sample_every events exceeding the limit is kept. Once per summary
+// interval, an event counting the dropped events is attached to the event
+// for each key having dropped events. The counters are kept until an event
+// passes the processor to attach the summary events to.
+func (p *rateLimit) Run(event *beat.Event) (*beat.Event, error) {
+	now := p.now()
+
+	key, values := p.key(event)
+	if !p.allow(key, values, now) {
+		return nil, nil
+	}
+
+	// Summary events are attached to events being published only, as
+	// derived events are dropped with the event they are attached to.
+	p.tick(event, now)
+	return event, nil
+}
+
+// key returns the values of the key fields, and a string identifying the
+// values. Each value is prefixed by its length, such that missing fields and
+// empty values have different keys.
+func (p *rateLimit) key(event *beat.Event) (string, []interface{}) {
+	if len(p.config.Fields) == 0 {
+		return "", nil
+	}
+
+	values := make([]interface{}, len(p.config.Fields))
+	var sb strings.Builder
+	for i, field := range p.config.Fields {
+		v, err := event.GetValue(field)
+		if err != nil {
+			sb.WriteByte('-')
+			continue
+		}
+
+		values[i] = v
+		s := fmt.Sprint(v)
+		sb.WriteString(strconv.Itoa(len(s)))
+		sb.WriteByte(':')
+		sb.WriteString(s)
+	}
+	return sb.String(), values
+}
+
+func (p *rateLimit) allow(key string, values []interface{}, now time.Time) bool {
+	s := &p.shards[shardOf(key)]
+	s.mu.Lock()
+
+	b := s.buckets[key]
+	if b == nil {
+		// the key count is shared by all shards, reserve the key before
+		// creating its bucket
+		if p.keys.Inc() <= int64(p.config.MaxKeys) {
+			b = &bucket{values: values, tokens: p.burst, last: now}
+			s.buckets[key] = b
+		} else {
+			p.keys.Dec()
+		}
+	}
+	if b != nil {
+		defer s.mu.Unlock()
+		return p.take(b, now)
+	}
+	s.mu.Unlock()
+
+	// max_keys is reached, new keys share the overflow bucket
+	p.overflowMu.Lock()
+	defer p.overflowMu.Unlock()
+	if p.overflow == nil {
+		p.overflow = &bucket{overflow: true, tokens: p.burst, last: now}
+	}
+	return p.take(p.overflow, now)
+}
+
+// take removes a token from the bucket. If the bucket is empty, the event
+// is dropped or sampled.
+func (p *rateLimit) take(b *bucket, now time.Time) bool {
+	p.refill(b, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+
+	b.excess++
+	if p.config.Mode == modeSample && b.excess%uint64(p.config.SampleEvery) == 0 {
+		b.sampled++
+		return true
+	}
+	b.dropped++
+	return false
+}
+
+func (p *rateLimit) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * p.rate
+		if b.tokens > p.burst {
+			b.tokens = p.burst
+		}
+		b.last = now
+	}
+}
+
+// tick collects the counters of all buckets once per interval, attaching the
+// summary events to event. Only one caller collects the counters per
+// interval.
+func (p *rateLimit) tick(event *beat.Event, now time.Time) {
+	next := p.nextTick.Load()
+	if now.UnixNano() < next {
+		return
+	}
+	if !p.nextTick.CAS(next, now.Add(p.interval).UnixNano()) {
+		return
+	}
+
+	summaries := p.collect(now)
+	if p.config.Summary.Interval <= 0 {
+		return
+	}
+	for _, summary := range summaries {
+		processors.AddDerivedEvent(event, summary)
+	}
+}
+
+// collect resets the counters of all buckets, returning a summary event for
+// each bucket having exceeded its limit. Buckets being full are removed, as
+// a new bucket behaves the same.
+func (p *rateLimit) collect(now time.Time) []beat.Event {
+	var summaries []beat.Event
+
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			if summary, ok := p.summarize(b, now); ok {
+				summaries = append(summaries, summary)
+			} else if b.tokens >= p.burst {
+				delete(s.buckets, key)
+				p.keys.Dec()
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	p.overflowMu.Lock()
+	if b := p.overflow; b != nil {
+		if summary, ok := p.summarize(b, now); ok {
+			summaries = append(summaries, summary)
+		} else if b.tokens >= p.burst {
+			p.overflow = nil
+		}
+	}
+	p.overflowMu.Unlock()
+
+	if len(summaries) > 0 {
+		p.log.Debugf("Rate limit exceeded for %v keys", len(summaries))
+	}
+	return summaries
+}
+
+// summarize resets the counters of a bucket. If the limit of the bucket has
+// been exceeded, a summary event is returned.
+func (p *rateLimit) summarize(b *bucket, now time.Time) (beat.Event, bool) {
+	p.refill(b, now)
+
+	dropped, sampled := b.dropped, b.sampled
+	b.dropped, b.sampled, b.excess = 0, 0, 0
+	if dropped == 0 && sampled == 0 {
+		return beat.Event{}, false
+	}
+
+	info := common.MapStr{
+		"dropped": dropped,
+		"limit":   p.config.Limit.String(),
+	}
+	if p.config.Mode == modeSample {
+		info["sampled"] = sampled
+	}
+	if b.overflow {
+		info["overflow"] = true
+	}
+
+	fields := common.MapStr{
+		"message":    fmt.Sprintf("Rate limit of %v exceeded, dropped %v events", p.config.Limit, dropped),
+		"rate_limit": info,
+	}
+	for i, v := range b.values {
+		if v != nil {
+			fields.Put(p.config.Fields[i], v)
+		}
+	}
+
+	return beat.Event{Timestamp: now, Fields: fields}, true
+}
+
+func shardOf(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % numShards
+}
+
+func (p *rateLimit) String() string {
+	return fmt.Sprintf("%v=[limit=%v, fields=%v, mode=%v]",
+		processorName, p.config.Limit, p.config.Fields, p.config.Mode)
+}
@@ -0,0 +1,325 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/processors"
+)
+
+func TestDropOverLimit(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{"limit": "10/s"})
+
+	assert.Equal(t, 10, runEvents(t, p, 15, "a"))
+
+	clock.advance(500 * time.Millisecond)
+	assert.Equal(t, 5, runEvents(t, p, 15, "a"))
+
+	// the bucket does not fill beyond the burst
+	clock.advance(time.Hour)
+	assert.Equal(t, 10, runEvents(t, p, 15, "a"))
+}
+
+func TestKeyedLimits(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":  "100/m",
+		"burst":  5,
+		"fields": []string{"kubernetes.pod.uid"},
+	})
+
+	assert.Equal(t, 5, runEvents(t, p, 10, "a"))
+	assert.Equal(t, 5, runEvents(t, p, 10, "b"))
+	assert.Equal(t, 0, runEvents(t, p, 10, "a"))
+}
+
+func TestSampleMode(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":        "2/s",
+		"mode":         "sample",
+		"sample_every": 3,
+	})
+
+	// 2 events within the limit, 3 of the 9 events exceeding the limit
+	assert.Equal(t, 5, runEvents(t, p, 11, "a"))
+}
+
+func TestSummaryEvents(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":            "10/m",
+		"fields":           []string{"kubernetes.pod.uid"},
+		"summary.interval": "1m",
+	})
+
+	assert.Equal(t, 10, runEvents(t, p, 25, "a"))
+	assert.Equal(t, 10, runEvents(t, p, 10, "b"))
+
+	clock.advance(time.Minute)
+	event, err := p.Run(makeEvent("c"))
+	require.NoError(t, err)
+	require.NotNil(t, event)
+
+	summaries := processors.TakeDerivedEvents(event)
+	require.Len(t, summaries, 1)
+	fields := summaries[0].Fields
+	assert.Equal(t, "a", mustGet(t, fields, "kubernetes.pod.uid"))
+	assert.Equal(t, uint64(15), mustGet(t, fields, "rate_limit.dropped"))
+	assert.Equal(t, "10/m", mustGet(t, fields, "rate_limit.limit"))
+	assert.Equal(t, clock.now(), summaries[0].Timestamp)
+
+	// buckets filled up again are removed, counters are reset
+	assert.Equal(t, int64(2), p.keys.Load())
+	clock.advance(time.Minute)
+	event, err = p.Run(makeEvent("c"))
+	require.NoError(t, err)
+	assert.Empty(t, processors.TakeDerivedEvents(event))
+	assert.Equal(t, int64(1), p.keys.Load())
+}
+
+func TestSummaryKeptWhileDropping(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":            "10/h",
+		"fields":           []string{"kubernetes.pod.uid"},
+		"summary.interval": "1m",
+	})
+
+	assert.Equal(t, 10, runEvents(t, p, 25, "a"))
+
+	// no event passes, the summary is due but can not be published yet
+	clock.advance(time.Minute)
+	assert.Equal(t, 0, runEvents(t, p, 5, "a"))
+
+	event, err := p.Run(makeEvent("b"))
+	require.NoError(t, err)
+	require.NotNil(t, event)
+
+	summaries := processors.TakeDerivedEvents(event)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "a", mustGet(t, summaries[0].Fields, "kubernetes.pod.uid"))
+	assert.Equal(t, uint64(20), mustGet(t, summaries[0].Fields, "rate_limit.dropped"))
+}
+
+func TestMissingAndEmptyKeys(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":  "1/h",
+		"fields": []string{"kubernetes.pod.uid", "kubernetes.pod.name"},
+	})
+
+	run := func(fields common.MapStr) bool {
+		event, err := p.Run(&beat.Event{Fields: fields})
+		require.NoError(t, err)
+		return event != nil
+	}
+
+	// missing fields and empty values have limits of their own
+	assert.True(t, run(common.MapStr{"kubernetes.pod.uid": ""}))
+	assert.True(t, run(common.MapStr{"kubernetes.pod.name": ""}))
+	assert.True(t, run(common.MapStr{}))
+	assert.False(t, run(common.MapStr{"kubernetes.pod.uid": ""}))
+	assert.Equal(t, int64(3), p.keys.Load())
+}
+
+func TestMaxKeys(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":    "1/h",
+		"fields":   []string{"kubernetes.pod.uid"},
+		"max_keys": 2,
+	})
+
+	assert.Equal(t, 1, runEvents(t, p, 2, "a"))
+	assert.Equal(t, 1, runEvents(t, p, 2, "b"))
+
+	// new keys share a single bucket
+	assert.Equal(t, 1, runEvents(t, p, 2, "c"))
+	assert.Equal(t, 0, runEvents(t, p, 2, "d"))
+	assert.Equal(t, int64(2), p.keys.Load())
+
+	clock.advance(time.Minute)
+	summaries := p.collect(clock.now())
+	require.Len(t, summaries, 3)
+	overflow := 0
+	for _, summary := range summaries {
+		if v, err := summary.Fields.GetValue("rate_limit.overflow"); err == nil && v == true {
+			overflow++
+			assert.Equal(t, uint64(3), mustGet(t, summary.Fields, "rate_limit.dropped"))
+		}
+	}
+	assert.Equal(t, 1, overflow)
+}
+
+func TestConcurrentClients(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":  "100/h",
+		"fields": []string{"kubernetes.pod.uid"},
+	})
+
+	var published atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				key := "a"
+				if j%2 == 0 {
+					key = "b"
+				}
+				event, err := p.Run(makeEvent(key))
+				if err == nil && event != nil {
+					published.Inc()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(200), published.Load())
+}
+
+func TestConcurrentMaxKeys(t *testing.T) {
+	clock := newTestClock()
+	p := newTestRateLimit(t, clock, common.MapStr{
+		"limit":    "1/h",
+		"fields":   []string{"kubernetes.pod.uid"},
+		"max_keys": 10,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.Run(makeEvent(fmt.Sprintf("%v-%v", i, j)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	buckets := 0
+	for i := range p.shards {
+		buckets += len(p.shards[i].buckets)
+	}
+	assert.Equal(t, 10, buckets)
+	assert.Equal(t, int64(10), p.keys.Load())
+}
+
+func TestConfig(t *testing.T) {
+	tests := map[string]struct {
+		config common.MapStr
+		err    bool
+	}{
+		"per second":     {common.MapStr{"limit": "1000/s"}, false},
+		"per hour":       {common.MapStr{"limit": "0.5/h"}, false},
+		"missing limit":  {common.MapStr{"fields": []string{"a"}}, true},
+		"missing unit":   {common.MapStr{"limit": "1000"}, true},
+		"invalid unit":   {common.MapStr{"limit": "1000/d"}, true},
+		"invalid events": {common.MapStr{"limit": "-1/s"}, true},
+		"invalid mode":   {common.MapStr{"limit": "1/s", "mode": "delay"}, true},
+		"invalid sample": {common.MapStr{"limit": "1/s", "sample_every": 0}, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(common.MustNewConfigFrom(test.config))
+			if test.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestRateLimit(t *testing.T, clock *testClock, settings common.MapStr) *rateLimit {
+	config := defaultConfig()
+	if err := common.MustNewConfigFrom(settings).Unpack(&config); err != nil {
+		t.Fatal(err)
+	}
+	return newRateLimit(config, clock.now)
+}
+
+func makeEvent(pod string) *beat.Event {
+	return &beat.Event{
+		Fields: common.MapStr{
+			"message":    "hello",
+			"kubernetes": common.MapStr{"pod": common.MapStr{"uid": pod}},
+		},
+	}
+}
+
+// runEvents runs n events of a pod through the processor, returning the
+// number of events not dropped.
+func runEvents(t *testing.T, p processors.Processor, n int, pod string) int {
+	published := 0
+	for i := 0; i < n; i++ {
+		event, err := p.Run(makeEvent(pod))
+		require.NoError(t, err)
+		if event != nil {
+			published++
+		}
+	}
+	return published
+}
+
+func mustGet(t *testing.T, fields common.MapStr, key string) interface{} {
+	v, err := fields.GetValue(key)
+	require.NoError(t, err)
+	return v
+}
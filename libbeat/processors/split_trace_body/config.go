@@ -0,0 +1,46 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package split_trace_body
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/common/fmtstr"
+)
+
+// config for the split_trace_body processor.
+type config struct {
+	RedactField         string                    `config:"redact_field"`          // Label enabling the split
+	IndexTypeField      string                    `config:"index_type_field"`      // Label selecting the index type of the body event
+	DocumentTypeField   string                    `config:"document_type_field"`   // Label selecting the document type of the body event
+	BodyField           string                    `config:"body_field"`            // Field holding the request body
+	DefaultDocumentType string                    `config:"default_document_type"` // Document type used if the label is missing
+	Topic               *fmtstr.EventFormatString `config:"topic"`                 // Target topic of the body event
+	Index               *fmtstr.EventFormatString `config:"index"`                 // Target index of the body event
+	DropFields          []string                  `config:"drop_fields"`           // Fields removed from all trace events
+}
+
+func defaultConfig() config {
+	return config{
+		RedactField:         "labels._tag_redact_body",
+		IndexTypeField:      "labels._tag_IndexType",
+		DocumentTypeField:   "labels._tag_documentType",
+		BodyField:           "http.request.body",
+		DefaultDocumentType: "user-input",
+		Topic:               fmtstr.MustCompileEvent("%{[@metadata.index_type]}-%{[labels._tag_profileId]}"),
+		DropFields:          []string{"http.request.headers.Cookies", "http.response.headers.Cookies"},
+	}
+}
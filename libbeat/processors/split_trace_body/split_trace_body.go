@@ -0,0 +1,239 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package split_trace_body
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/processors"
+)
+
+func init() {
+	processors.RegisterPlugin(processorName, New)
+}
+
+const (
+	processorName = "split_trace_body"
+
+	labelsPrefix = "labels."
+)
+
+// detailFields are copied from the trace event into the details of the body event.
+var detailFields = []string{"url", "service", "processor", "source", "agent", "user_agent"}
+
+type splitTraceBody struct {
+	config config
+	log    *logp.Logger
+}
+
+// New constructs a new split_trace_body processor.
+func New(cfg *common.Config) (processors.Processor, error) {
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("failed to unpack the %v configuration: %v", processorName, err)
+	}
+
+	return &splitTraceBody{
+		config: config,
+		log:    logp.NewLogger(processorName),
+	}, nil
+}
+
+// Run removes the configured fields, like cookies, from all events. The request
+// body is removed from trace events having the redact label set and attached
+// as a new "trace_body" event to the trace event.
+func (p *splitTraceBody) Run(event *beat.Event) (*beat.Event, error) {
+	for _, field := range p.config.DropFields {
+		event.Delete(field)
+	}
+
+	if redact, err := event.GetValue(p.config.RedactField); err != nil || redact == nil {
+		return event, nil
+	}
+
+	body, err := event.GetValue(p.config.BodyField)
+	if err != nil || body == nil {
+		return event, nil
+	}
+
+	derived, err := p.makeBodyEvent(event, body)
+	if err != nil {
+		return event, err
+	}
+
+	event.Delete(p.config.BodyField)
+	processors.AddDerivedEvent(event, derived)
+	return event, nil
+}
+
+func (p *splitTraceBody) makeBodyEvent(event *beat.Event, body interface{}) (beat.Event, error) {
+	indexType := "log"
+	if v, err := event.GetValue(p.config.IndexTypeField); err == nil {
+		if s, ok := v.(string); ok && strings.Contains(strings.ToLower(s), "metric") {
+			indexType = "metric"
+		}
+	}
+
+	docType := p.config.DefaultDocumentType
+	if v, err := event.GetValue(p.config.DocumentTypeField); err == nil {
+		if s, ok := v.(string); ok {
+			docType = s
+		}
+	}
+
+	details := common.MapStr{}
+	for _, field := range detailFields {
+		if v, err := event.GetValue(field); err == nil {
+			details[field] = v
+		}
+	}
+	details["labels"] = p.bodyLabels(event)
+	details["status"], _ = event.GetValue("http.response.status_code")
+	details["user"] = common.MapStr{}
+	if v, err := event.GetValue("user"); err == nil && v != nil {
+		details["user"] = v
+	}
+
+	fields := common.MapStr{
+		"details_json":  details,
+		"time":          eventTimeMillis(event),
+		"_plugin":       "trace_body",
+		"_documentType": docType,
+		"message":       "Trace request body",
+	}
+	if v, ok := decodeBody(body); ok {
+		fields["body_json"] = v
+	} else {
+		p.log.Debugf("Unexpected request body of type %T", body)
+	}
+	for target, source := range map[string]string{
+		"_tag_projectName": "labels._tag_projectName",
+		"_tag_appName":     "labels._tag_appName",
+		"trace_id":         "trace.id",
+		"transaction_id":   "transaction.id",
+	} {
+		if v, err := event.GetValue(source); err == nil {
+			fields[target] = v
+		}
+	}
+
+	// The body event only gets the metadata selecting its destination. Other
+	// metadata of the trace event, like raw_index or derived events, must not
+	// be copied.
+	derived := beat.Event{
+		Timestamp: event.Timestamp,
+		Meta:      common.MapStr{"index_type": indexType},
+		Fields:    fields,
+	}
+
+	// Targets are formatted from the trace event fields and metadata, such
+	// that the labels removed from the body event can still be used.
+	targetMeta := event.Meta.Clone()
+	if targetMeta == nil {
+		targetMeta = common.MapStr{}
+	}
+	targetMeta["index_type"] = indexType
+	target := &beat.Event{Timestamp: event.Timestamp, Meta: targetMeta, Fields: event.Fields}
+	if p.config.Topic != nil {
+		topic, err := p.config.Topic.Run(target)
+		if err != nil {
+			return beat.Event{}, fmt.Errorf("failed to format topic of the body event: %v", err)
+		}
+		derived.Meta["topic"] = topic
+	}
+	if p.config.Index != nil {
+		index, err := p.config.Index.Run(target)
+		if err != nil {
+			return beat.Event{}, fmt.Errorf("failed to format index of the body event: %v", err)
+		}
+		derived.Meta["raw_index"] = index
+	}
+
+	return derived, nil
+}
+
+// bodyLabels returns a copy of the event labels without the labels
+// controlling the split.
+func (p *splitTraceBody) bodyLabels(event *beat.Event) common.MapStr {
+	labels := common.MapStr{}
+	if v, err := event.GetValue("labels"); err == nil {
+		switch m := v.(type) {
+		case common.MapStr:
+			labels = m.Clone()
+		case map[string]interface{}:
+			labels = common.MapStr(m).Clone()
+		}
+	}
+
+	for _, field := range []string{p.config.RedactField, p.config.IndexTypeField, p.config.DocumentTypeField} {
+		if strings.HasPrefix(field, labelsPrefix) {
+			labels.Delete(strings.TrimPrefix(field, labelsPrefix))
+		}
+	}
+	return labels
+}
+
+// decodeBody returns the original request body, decoding it if it has been
+// recorded as JSON string.
+func decodeBody(body interface{}) (interface{}, bool) {
+	switch b := body.(type) {
+	case common.MapStr:
+		body = b["original"]
+	case map[string]interface{}:
+		body = b["original"]
+	}
+
+	switch b := body.(type) {
+	case string:
+		decoded := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(b), &decoded); err != nil {
+			return nil, false
+		}
+		return decoded, true
+	case common.MapStr, map[string]interface{}:
+		return b, true
+	}
+	return nil, false
+}
+
+// eventTimeMillis returns the APM timestamp of the event in milliseconds,
+// falling back to the event timestamp.
+func eventTimeMillis(event *beat.Event) int64 {
+	v, err := event.GetValue("timestamp.us")
+	if err == nil {
+		switch us := v.(type) {
+		case int64:
+			return us / 1000
+		case int:
+			return int64(us) / 1000
+		case float64:
+			return int64(us) / 1000
+		}
+	}
+	return event.Timestamp.UnixNano() / int64(1e6)
+}
+
+func (p *splitTraceBody) String() string {
+	return fmt.Sprintf("%v=[redact_field=%v, body_field=%v]",
+		processorName, p.config.RedactField, p.config.BodyField)
+}
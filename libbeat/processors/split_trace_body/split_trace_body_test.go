@@ -0,0 +1,178 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package split_trace_body
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/processors"
+)
+
+func traceEvent(body interface{}) *beat.Event {
+	return &beat.Event{
+		Timestamp: time.Unix(1600000000, 0),
+		Fields: common.MapStr{
+			"labels": common.MapStr{
+				"_tag_profileId":    "p1",
+				"_tag_projectName":  "MyProject",
+				"_tag_appName":      "app",
+				"_tag_redact_body":  "true",
+				"_tag_IndexType":    "Metric",
+				"_tag_documentType": "orders",
+			},
+			"http": common.MapStr{
+				"request":  common.MapStr{"body": body},
+				"response": common.MapStr{"status_code": 200},
+			},
+			"timestamp":   common.MapStr{"us": float64(1600000000123456)},
+			"trace":       common.MapStr{"id": "t1"},
+			"transaction": common.MapStr{"id": "tx1"},
+			"url":         common.MapStr{"path": "/orders"},
+		},
+	}
+}
+
+func TestSplitTraceBody(t *testing.T) {
+	p, err := New(common.NewConfig())
+	require.NoError(t, err)
+
+	event, err := p.Run(traceEvent(common.MapStr{"original": `{"id": 1}`}))
+	require.NoError(t, err)
+
+	_, err = event.GetValue("http.request.body")
+	assert.Error(t, err, "request body must be removed from the trace event")
+
+	derived := processors.TakeDerivedEvents(event)
+	require.Len(t, derived, 1)
+
+	body := derived[0]
+	assert.Equal(t, "metric-p1", body.Meta["topic"])
+	assert.Equal(t, "metric", body.Meta["index_type"])
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, body.Fields["body_json"])
+	assert.Equal(t, "orders", body.Fields["_documentType"])
+	assert.Equal(t, "trace_body", body.Fields["_plugin"])
+	assert.Equal(t, "MyProject", body.Fields["_tag_projectName"])
+	assert.Equal(t, "t1", body.Fields["trace_id"])
+	assert.Equal(t, int64(1600000000123), body.Fields["time"])
+
+	labels, err := body.GetValue("details_json.labels")
+	require.NoError(t, err)
+	assert.Equal(t, common.MapStr{
+		"_tag_profileId":   "p1",
+		"_tag_projectName": "MyProject",
+		"_tag_appName":     "app",
+	}, labels)
+
+	// labels of the trace event must not be modified
+	v, err := event.GetValue("labels._tag_redact_body")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", v)
+}
+
+func TestSplitTraceBodyIndex(t *testing.T) {
+	p, err := New(common.MustNewConfigFrom(common.MapStr{
+		"topic": "body-%{[labels._tag_profileId]}",
+		"index": "%{[@metadata.index_type]}-%{[labels._tag_appName]}",
+	}))
+	require.NoError(t, err)
+
+	event, err := p.Run(traceEvent(common.MapStr{"original": map[string]interface{}{"a": "b"}}))
+	require.NoError(t, err)
+
+	derived := processors.TakeDerivedEvents(event)
+	require.Len(t, derived, 1)
+	assert.Equal(t, "body-p1", derived[0].Meta["topic"])
+	assert.Equal(t, "metric-app", derived[0].Meta["raw_index"])
+	assert.Equal(t, map[string]interface{}{"a": "b"}, derived[0].Fields["body_json"])
+}
+
+func TestSplitTraceBodyParentMeta(t *testing.T) {
+	p, err := New(common.NewConfig())
+	require.NoError(t, err)
+
+	trace := traceEvent(common.MapStr{"original": `{"id": 1}`})
+	trace.Meta = common.MapStr{
+		"raw_index": "traces-p1",
+		"pipeline":  "traces",
+	}
+	processors.AddDerivedEvent(trace, beat.Event{Fields: common.MapStr{"message": "other"}})
+
+	event, err := p.Run(trace)
+	require.NoError(t, err)
+
+	derived := processors.TakeDerivedEvents(event)
+	require.Len(t, derived, 2)
+
+	// the body event is routed by its index type, not by the trace index
+	body := derived[1]
+	assert.Equal(t, common.MapStr{
+		"index_type": "metric",
+		"topic":      "metric-p1",
+	}, body.Meta)
+	assert.Equal(t, "traces-p1", event.Meta["raw_index"])
+}
+
+func TestSplitTraceBodySkipped(t *testing.T) {
+	p, err := New(common.NewConfig())
+	require.NoError(t, err)
+
+	cases := map[string]*beat.Event{
+		"no body": traceEvent(nil),
+		"no redact label": func() *beat.Event {
+			e := traceEvent(common.MapStr{"original": "{}"})
+			e.Delete("labels._tag_redact_body")
+			return e
+		}(),
+		"no labels": {Fields: common.MapStr{"message": "hello"}},
+	}
+
+	for name, event := range cases {
+		t.Run(name, func(t *testing.T) {
+			before := event.Fields.Clone()
+			out, err := p.Run(event)
+			require.NoError(t, err)
+			assert.Equal(t, before, out.Fields)
+			assert.Empty(t, processors.TakeDerivedEvents(out))
+		})
+	}
+}
+
+func TestSplitTraceBodyDropsCookies(t *testing.T) {
+	p, err := New(common.NewConfig())
+	require.NoError(t, err)
+
+	event := traceEvent(nil)
+	event.Fields.Delete("labels._tag_redact_body")
+	event.PutValue("http.request.headers.Cookies", []string{"session=secret"})
+	event.PutValue("http.response.headers.Cookies", []string{"session=secret"})
+
+	event, err = p.Run(event)
+	require.NoError(t, err)
+
+	_, err = event.GetValue("http.request.headers.Cookies")
+	assert.Error(t, err)
+	_, err = event.GetValue("http.response.headers.Cookies")
+	assert.Error(t, err)
+	assert.Empty(t, processors.TakeDerivedEvents(event))
+}
@@ -19,15 +19,24 @@ package includes
 
 import (
 	// import queue types
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/avro"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/cbor"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/format"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/json"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/codec/protobuf"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/console"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/elasticsearch"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/fileout"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/httpout"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/kafka"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/kafkarest"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/logstash"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/otlp"
+	_ "github.com/snappyflow/beats/v7/libbeat/outputs/promrw"
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/redis"
+	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/diskqueue"
+	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/hybridqueue"
 	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
+	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/priorityqueue"
 	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/spool"
 )
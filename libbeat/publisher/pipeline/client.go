@@ -24,6 +24,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/processors"
 	"github.com/snappyflow/beats/v7/libbeat/publisher"
 	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
 )
@@ -83,6 +84,7 @@ func (c *client) publish(e beat.Event) {
 	var (
 		event   = &e
 		publish = true
+		derived []beat.Event
 		log     = c.pipeline.monitors.Logger
 	)
 
@@ -107,6 +109,7 @@ func (c *client) publish(e beat.Event) {
 	}
 
 	if event != nil {
+		derived = processors.TakeDerivedEvents(event)
 		e = *event
 	}
 
@@ -116,7 +119,19 @@ func (c *client) publish(e beat.Event) {
 		return
 	}
 
-	e = *event
+	c.enqueue(e)
+
+	// Events derived by processors are accounted for as if they had been
+	// published by the client itself.
+	for _, d := range derived {
+		c.onNewEvent()
+		c.acker.AddEvent(d, true)
+		c.enqueue(d)
+	}
+}
+
+// enqueue forwards an already processed event to the queue.
+func (c *client) enqueue(e beat.Event) {
 	pubEvent := publisher.Event{
 		Content: e,
 		Flags:   c.eventFlags,
@@ -24,8 +24,10 @@ import (
 	"time"
 
 	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
 	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/processors"
 	"github.com/snappyflow/beats/v7/libbeat/publisher"
 	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
 	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
@@ -205,3 +207,97 @@ func TestClientWaitClose(t *testing.T) {
 		}
 	})
 }
+
+func TestClientPublishDerivedEvents(t *testing.T) {
+	q := memqueue.NewQueue(logp.L(), memqueue.Settings{Events: 10})
+	pipeline, err := New(beat.Info{},
+		Monitors{},
+		func(queue.ACKListener) (queue.Queue, error) { return q, nil },
+		outputs.Group{},
+		Settings{Processors: derivingSupporter{}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipeline.Close()
+
+	client, err := pipeline.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Publish(beat.Event{Fields: common.MapStr{"message": "original"}})
+
+	var events []publisher.Event
+	consumer := q.Consumer()
+	for len(events) < 2 {
+		batch, err := consumer.Get(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, batch.Events()...)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", len(events))
+	}
+	if _, exists := events[0].Content.Meta["_derived_events"]; exists {
+		t.Error("derived events must be removed from the original event")
+	}
+	if msg := events[1].Content.Fields["message"]; msg != "derived" {
+		t.Errorf("unexpected derived event message: %v", msg)
+	}
+}
+
+func TestClientPriority(t *testing.T) {
+	q := &producerConfigQueue{}
+	pipeline, err := New(beat.Info{},
+		Monitors{},
+		func(queue.ACKListener) (queue.Queue, error) { return q, nil },
+		outputs.Group{},
+		Settings{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipeline.Close()
+
+	client, err := pipeline.ConnectWith(beat.ClientConfig{Priority: beat.HighPriority})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if len(q.configs) != 1 || q.configs[0].Priority != beat.HighPriority {
+		t.Errorf("expected producer with high priority, got %v", q.configs)
+	}
+
+	if _, err := pipeline.ConnectWith(beat.ClientConfig{Priority: beat.HighPriority + 1}); err == nil {
+		t.Error("expected error for unknown priority")
+	}
+}
+
+// producerConfigQueue records the configuration of the producers created.
+type producerConfigQueue struct {
+	mockQueue
+	configs []queue.ProducerConfig
+}
+
+func (q *producerConfigQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	q.configs = append(q.configs, cfg)
+	return mockProducer{}
+}
+
+type derivingSupporter struct{}
+
+func (derivingSupporter) Create(_ beat.ProcessingConfig, _ bool) (beat.Processor, error) {
+	return derivingProcessor{}, nil
+}
+
+type derivingProcessor struct{}
+
+func (derivingProcessor) String() string { return "deriving" }
+func (derivingProcessor) Run(event *beat.Event) (*beat.Event, error) {
+	processors.AddDerivedEvent(event, beat.Event{Fields: common.MapStr{"message": "derived"}})
+	return event, nil
+}
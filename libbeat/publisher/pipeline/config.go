@@ -23,6 +23,7 @@ import (
 
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/conditions"
 	"github.com/snappyflow/beats/v7/libbeat/processors"
 )
 
@@ -34,6 +35,42 @@ type Config struct {
 
 	// Event queue
 	Queue common.ConfigNamespace `config:"queue"`
+
+	// Named outputs, each receiving the events matching its condition.
+	Outputs []RouteConfig `config:"outputs"`
+}
+
+// RouteConfig configures a named output. Events matching the condition are
+// published to the output using a queue of its own.
+type RouteConfig struct {
+	Name      string             `config:"name" validate:"required"`
+	Condition *conditions.Config `config:"when"`
+
+	// Blocking makes publishing block if the queue of the output is full,
+	// for clients dropping events if the queue is full. Other clients always
+	// block, as their events must not be lost.
+	Blocking bool `config:"blocking"`
+
+	// Queue overwrites the queue settings of the pipeline.
+	Queue  common.ConfigNamespace `config:"queue"`
+	Output common.ConfigNamespace `config:"output"`
+}
+
+// Validate checks the named outputs have unique names and an output
+// configured.
+func (c *Config) Validate() error {
+	names := map[string]bool{}
+	for _, route := range c.Outputs {
+		if names[route.Name] {
+			return fmt.Errorf("duplicate output name '%v'", route.Name)
+		}
+		names[route.Name] = true
+
+		if !route.Output.IsSet() {
+			return fmt.Errorf("no output configured for '%v'", route.Name)
+		}
+	}
+	return nil
 }
 
 // validateClientConfig checks a ClientConfig can be used with (*Pipeline).ConnectWith.
@@ -48,6 +85,10 @@ func validateClientConfig(c *beat.ClientConfig) error {
 		return fmt.Errorf("unknown publish mode %v", m)
 	}
 
+	if c.Priority > beat.HighPriority {
+		return fmt.Errorf("unknown priority %v", c.Priority)
+	}
+
 	// ACK handlers can not be registered DropIfFull is set, as dropping events
 	// due to full broker can not be accounted for in the clients acker.
 	if c.ACKHandler != nil && withDrop {
@@ -18,6 +18,7 @@
 package pipeline
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 
@@ -96,8 +97,22 @@ func LoadWithSettings(
 
 	name := beatInfo.Name
 
+	if len(config.Outputs) > 0 {
+		if settings.RouteFactory == nil {
+			return nil, errors.New("named outputs are not supported")
+		}
+
+		p, err := NewRouted(beatInfo, monitors, config, settings.RouteFactory, settings)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Beat name: %s", name)
+		return p, nil
+	}
+
 	fmt.Errorf("  99    ...LoadWithSettings........")
-	queueBuilder, err := createQueueBuilder(config.Queue, monitors)
+	queueBuilder, err := createQueueBuilder(config.Queue, monitors, subRegistry(monitors.Metrics, "pipeline"))
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +190,7 @@ func loadOutput(
 func createQueueBuilder(
 	config common.ConfigNamespace,
 	monitors Monitors,
+	metrics *monitoring.Registry,
 ) (func(queue.ACKListener) (queue.Queue, error), error) {
 	queueType := defaultQueueType
 	if b := config.Name(); b != "" {
@@ -197,6 +213,28 @@ func createQueueBuilder(
 	}
 
 	return func(ackListener queue.ACKListener) (queue.Queue, error) {
-		return queueFactory(ackListener, monitors.Logger, queueConfig)
+		q, err := queueFactory(ackListener, monitors.Logger, queueConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// queues reporting internal metrics share the `queue` namespace with
+		// the pipeline queue metrics
+		if reporter, ok := q.(queue.MetricsReporter); ok && metrics != nil {
+			reporter.RegisterMetrics(subRegistry(metrics, "queue"))
+		}
+		return q, nil
 	}, nil
 }
+
+// subRegistry returns the registry name in parent, creating it if it does
+// not exist yet.
+func subRegistry(parent *monitoring.Registry, name string) *monitoring.Registry {
+	if parent == nil {
+		return nil
+	}
+	if reg := parent.GetRegistry(name); reg != nil {
+		return reg
+	}
+	return parent.NewRegistry(name)
+}
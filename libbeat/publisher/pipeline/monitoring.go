@@ -39,6 +39,7 @@ type clientObserver interface {
 	filteredEvent()
 	publishedEvent()
 	failedPublishEvent()
+	unroutedEvent()
 }
 
 type queueObserver interface {
@@ -68,6 +69,7 @@ type metricsObserver struct {
 
 	// events publish/dropped stats
 	events, filtered, published, failed *monitoring.Uint
+	unrouted                            *monitoring.Uint // events matching no named output
 	dropped, retry                      *monitoring.Uint // (retryer) drop/retry counters
 	activeEvents                        *monitoring.Uint
 
@@ -89,6 +91,7 @@ func newMetricsObserver(metrics *monitoring.Registry) *metricsObserver {
 		filtered:  monitoring.NewUint(reg, "events.filtered"),
 		published: monitoring.NewUint(reg, "events.published"),
 		failed:    monitoring.NewUint(reg, "events.failed"),
+		unrouted:  monitoring.NewUint(reg, "events.unrouted"),
 		dropped:   monitoring.NewUint(reg, "events.dropped"),
 		retry:     monitoring.NewUint(reg, "events.retry"),
 
@@ -144,6 +147,11 @@ func (o *metricsObserver) failedPublishEvent() {
 	o.activeEvents.Dec()
 }
 
+// (client) event has been published, but matched no named output
+func (o *metricsObserver) unroutedEvent() {
+	o.unrouted.Inc()
+}
+
 //
 // queue events
 //
@@ -192,6 +200,7 @@ func (*emptyObserver) newEvent()           {}
 func (*emptyObserver) filteredEvent()      {}
 func (*emptyObserver) publishedEvent()     {}
 func (*emptyObserver) failedPublishEvent() {}
+func (*emptyObserver) unroutedEvent()      {}
 func (*emptyObserver) queueACKed(n int)    {}
 func (*emptyObserver) updateOutputGroup()  {}
 func (*emptyObserver) eventsFailed(int)    {}
@@ -61,6 +61,10 @@ type Pipeline struct {
 	queue  queue.Queue
 	output *outputController
 
+	// named outputs with queues of their own. If set, queue and output are
+	// not used.
+	routes []*route
+
 	observer observer
 
 	eventer pipelineEventer
@@ -89,6 +93,9 @@ type Settings struct {
 	WaitCloseMode WaitCloseMode
 
 	Processors processing.Supporter
+
+	// RouteFactory creates the named outputs configured in Config.Outputs.
+	RouteFactory RouteFactory
 }
 
 // WaitCloseMode enumerates the possible behaviors of WaitClose in a pipeline.
@@ -145,6 +152,60 @@ func New(
 ) (*Pipeline, error) {
 	var err error
 
+	p := newPipeline(beat, monitors, settings)
+	p.queue, err = queueFactory(&p.eventer)
+	if err != nil {
+		return nil, err
+	}
+
+	maxEvents := p.queue.BufferConfig().MaxEvents
+	if maxEvents <= 0 {
+		// Maximum number of events until acker starts blocking.
+		// Only active if pipeline can drop events.
+		maxEvents = 64000
+	}
+	p.eventSema = newSema(maxEvents)
+
+	p.output = newOutputController(beat, p.monitors, p.observer, p.queue)
+	p.output.Set(out)
+
+	return p, nil
+}
+
+// NewRouted creates a new Pipeline publishing events to the named outputs
+// configured in config.Outputs. Every output has a queue of its own and
+// receives the events matching its condition. The outputs are created using
+// makeOutput.
+func NewRouted(
+	beat beat.Info,
+	monitors Monitors,
+	config Config,
+	makeOutput RouteFactory,
+	settings Settings,
+) (*Pipeline, error) {
+	p := newPipeline(beat, monitors, settings)
+
+	routes, err := newRoutes(beat, p.monitors, config, makeOutput)
+	if err != nil {
+		return nil, err
+	}
+	p.routes = routes
+
+	maxEvents := 0
+	for _, r := range routes {
+		if n := r.queue.BufferConfig().MaxEvents; n > maxEvents {
+			maxEvents = n
+		}
+	}
+	if maxEvents <= 0 {
+		maxEvents = 64000
+	}
+	p.eventSema = newSema(maxEvents)
+
+	return p, nil
+}
+
+func newPipeline(beat beat.Info, monitors Monitors, settings Settings) *Pipeline {
 	if monitors.Logger == nil {
 		monitors.Logger = logp.NewLogger("publish")
 	}
@@ -171,23 +232,7 @@ func New(
 		p.eventer.waitClose = p.waitCloser
 	}
 
-	p.queue, err = queueFactory(&p.eventer)
-	if err != nil {
-		return nil, err
-	}
-
-	maxEvents := p.queue.BufferConfig().MaxEvents
-	if maxEvents <= 0 {
-		// Maximum number of events until acker starts blocking.
-		// Only active if pipeline can drop events.
-		maxEvents = 64000
-	}
-	p.eventSema = newSema(maxEvents)
-
-	p.output = newOutputController(beat, monitors, p.observer, p.queue)
-	p.output.Set(out)
-
-	return p, nil
+	return p
 }
 
 // Close stops the pipeline, outputs and queue.
@@ -218,13 +263,21 @@ func (p *Pipeline) Close() error {
 
 	// TODO: close/disconnect still active clients
 
-	// close output before shutting down queue
-	p.output.Close()
+	if p.routes != nil {
+		for _, r := range p.routes {
+			if err := r.close(); err != nil {
+				log.Errorf("pipeline queue shutdown error for output '%v': %v", r.name, err)
+			}
+		}
+	} else {
+		// close output before shutting down queue
+		p.output.Close()
 
-	// shutdown queue
-	err := p.queue.Close()
-	if err != nil {
-		log.Error("pipeline queue shutdown error: ", err)
+		// shutdown queue
+		err := p.queue.Close()
+		if err != nil {
+			log.Error("pipeline queue shutdown error: ", err)
+		}
 	}
 
 	p.observer.cleanup()
@@ -297,7 +350,7 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 
 	ackHandler := cfg.ACKHandler
 
-	producerCfg := queue.ProducerConfig{}
+	producerCfg := queue.ProducerConfig{Priority: cfg.Priority}
 
 	if reportEvents || cfg.Events != nil {
 		producerCfg.OnDrop = func(event beat.Event) {
@@ -331,7 +384,7 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 
 	client.acker = ackHandler
 	client.waiter = waiter
-	client.producer = p.queue.Producer(producerCfg)
+	client.producer = p.newProducer(producerCfg)
 
 	p.observer.clientConnected()
 
@@ -342,6 +395,22 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 	return client, nil
 }
 
+func (p *Pipeline) newProducer(cfg queue.ProducerConfig) queue.Producer {
+	if p.routes == nil {
+		return p.queue.Producer(cfg)
+	}
+
+	// The queues of the routes report ACKs to the routing producer only. ACKs
+	// are reported to the pipeline once all routes ACKed an event.
+	onACK := func(n int) {
+		if cfg.ACK != nil {
+			cfg.ACK(n)
+		}
+		p.eventer.OnACK(n)
+	}
+	return newRoutingProducer(p.routes, cfg, onACK, p.observer.unroutedEvent)
+}
+
 func (p *Pipeline) registerSignalPropagation(c *client) {
 	p.guardStartSigPropagation.Do(func() {
 		p.sigNewClient = make(chan *client, 1)
@@ -451,5 +520,8 @@ func (e *waitCloser) wait() {
 
 // OutputReloader returns a reloadable object for the output section of this pipeline
 func (p *Pipeline) OutputReloader() OutputReloader {
+	if p.routes != nil {
+		return routedOutputReloader{}
+	}
 	return p.output
 }
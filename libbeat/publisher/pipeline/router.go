@@ -0,0 +1,453 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/reload"
+	"github.com/snappyflow/beats/v7/libbeat/conditions"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+)
+
+// route is a named output with its own queue and output controller, receiving
+// the events matching its condition.
+type route struct {
+	name      string
+	condition conditions.Condition
+	blocking  bool
+
+	queue    queue.Queue
+	eventer  *pipelineEventer
+	output   *outputController
+	observer *routeObserver
+}
+
+// routeObserver reports the metrics of a named output.
+type routeObserver struct {
+	routed    *monitoring.Uint // events published to the queue
+	queueFull *monitoring.Uint // events not published due to the queue being full
+	acked     *monitoring.Uint // events ACKed by the queue
+	dropped   *monitoring.Uint // events dropped by the output after retrying
+	retry     *monitoring.Uint // events retried by the output
+}
+
+// RouteFactory creates the output of a named output.
+type RouteFactory func(outputs.Observer, common.ConfigNamespace) (outputs.Group, error)
+
+// newRoutes creates the queues and outputs of all named outputs. Metrics of
+// each output are reported under `outputs.<name>`.
+func newRoutes(
+	beat beat.Info,
+	monitors Monitors,
+	config Config,
+	makeOutput RouteFactory,
+) ([]*route, error) {
+	var parent *monitoring.Registry
+	if monitors.Metrics != nil {
+		parent = monitors.Metrics.GetRegistry("outputs")
+		if parent != nil {
+			parent.Clear()
+		} else {
+			parent = monitors.Metrics.NewRegistry("outputs")
+		}
+	}
+
+	var routes []*route
+	closeAll := func() {
+		for _, r := range routes {
+			r.close()
+		}
+	}
+
+	var names []string
+	for _, cfg := range config.Outputs {
+		r, err := newRoute(beat, monitors, parent, config.Queue, cfg, makeOutput)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to create output '%v': %+v", cfg.Name, err)
+		}
+		routes = append(routes, r)
+		names = append(names, cfg.Name+":"+cfg.Output.Name())
+	}
+
+	if monitors.Telemetry != nil {
+		telemetry := monitors.Telemetry.GetRegistry("output")
+		if telemetry != nil {
+			telemetry.Clear()
+		} else {
+			telemetry = monitors.Telemetry.NewRegistry("output")
+		}
+		monitoring.NewString(telemetry, "name").Set(strings.Join(names, ","))
+	}
+
+	return routes, nil
+}
+
+func newRoute(
+	beat beat.Info,
+	monitors Monitors,
+	parent *monitoring.Registry,
+	queueConfig common.ConfigNamespace,
+	config RouteConfig,
+	makeOutput RouteFactory,
+) (*route, error) {
+	r := &route{name: config.Name, blocking: config.Blocking}
+
+	if config.Condition != nil {
+		cond, err := conditions.NewCondition(config.Condition)
+		if err != nil {
+			return nil, err
+		}
+		r.condition = cond
+	}
+
+	routeMonitors := Monitors{
+		Logger: monitors.Logger.Named(config.Name),
+		Tracer: monitors.Tracer,
+	}
+	if parent != nil {
+		routeMonitors.Metrics = parent.NewRegistry(config.Name)
+	}
+	r.observer = newRouteObserver(routeMonitors.Metrics)
+
+	if config.Queue.IsSet() {
+		queueConfig = config.Queue
+	}
+	queueBuilder, err := createQueueBuilder(queueConfig, routeMonitors, routeMonitors.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := loadOutput(routeMonitors, func(stats outputs.Observer) (string, outputs.Group, error) {
+		out, err := makeOutput(stats, config.Output)
+		return config.Output.Name(), out, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.eventer = &pipelineEventer{observer: r.observer}
+	r.queue, err = queueBuilder(r.eventer)
+	if err != nil {
+		for _, client := range out.Clients {
+			client.Close()
+		}
+		return nil, err
+	}
+
+	r.output = newOutputController(beat, routeMonitors, r.observer, r.queue)
+	r.output.Set(out)
+	return r, nil
+}
+
+func newRouteObserver(reg *monitoring.Registry) *routeObserver {
+	if reg == nil {
+		return &routeObserver{
+			routed:    &monitoring.Uint{},
+			queueFull: &monitoring.Uint{},
+			acked:     &monitoring.Uint{},
+			dropped:   &monitoring.Uint{},
+			retry:     &monitoring.Uint{},
+		}
+	}
+
+	return &routeObserver{
+		routed:    monitoring.NewUint(reg, "events.routed"),
+		queueFull: monitoring.NewUint(reg, "events.queue_full"),
+		acked:     monitoring.NewUint(reg, "queue.acked"),
+		dropped:   monitoring.NewUint(reg, "events.dropped"),
+		retry:     monitoring.NewUint(reg, "events.retry"),
+	}
+}
+
+func (o *routeObserver) queueACKed(n int)    { o.acked.Add(uint64(n)) }
+func (o *routeObserver) updateOutputGroup()  {}
+func (o *routeObserver) eventsFailed(int)    {}
+func (o *routeObserver) eventsDropped(n int) { o.dropped.Add(uint64(n)) }
+func (o *routeObserver) eventsRetry(n int)   { o.retry.Add(uint64(n)) }
+func (o *routeObserver) outBatchSend(int)    {}
+func (o *routeObserver) outBatchACKed(int)   {}
+
+func (r *route) matches(event *beat.Event) bool {
+	return r.condition == nil || r.condition.Check(event)
+}
+
+// close stops the output before shutting down the queue.
+func (r *route) close() error {
+	r.output.Close()
+	return r.queue.Close()
+}
+
+// routingProducer publishes events to the queues of all routes matching the
+// event. An event is ACKed once all queues that accepted the event ACKed it,
+// keeping the order events have been published in. Events not matching any
+// route are ACKed right away. A route rejecting an event, or dropping it
+// after it has been accepted, counts as a drop for the route only. Events
+// delivered by no route are not ACKed: events rejected by all routes are
+// reported as not published, events dropped by all routes that accepted them
+// are reported to OnDrop, once per event.
+type routingProducer struct {
+	routes       []*route
+	producers    []queue.Producer
+	dropOnCancel bool
+	onACK        func(int)
+	onDrop       func(beat.Event)
+	onUnrouted   func()
+
+	mu         sync.Mutex
+	pending    []*routedEvent   // events not yet ACKed, in publish order
+	inFlight   [][]*routedEvent // events waiting for an ACK per route
+	publishing []bool           // routes being published to by publish
+}
+
+type routedEvent struct {
+	content   beat.Event // kept for OnDrop only
+	remaining int        // number of routes not having ACKed or dropped the event yet
+	delivered bool       // event ACKed by at least one route, or not routed at all
+}
+
+func newRoutingProducer(
+	routes []*route,
+	cfg queue.ProducerConfig,
+	onACK func(int),
+	onUnrouted func(),
+) *routingProducer {
+	p := &routingProducer{
+		routes:       routes,
+		producers:    make([]queue.Producer, len(routes)),
+		dropOnCancel: cfg.DropOnCancel,
+		onACK:        onACK,
+		onDrop:       cfg.OnDrop,
+		onUnrouted:   onUnrouted,
+		inFlight:     make([][]*routedEvent, len(routes)),
+		publishing:   make([]bool, len(routes)),
+	}
+
+	for i, r := range routes {
+		i := i
+		p.producers[i] = r.queue.Producer(queue.ProducerConfig{
+			ACK:          func(n int) { p.ack(i, n) },
+			OnDrop:       func(beat.Event) { p.dropped(i) },
+			DropOnCancel: cfg.DropOnCancel,
+			Priority:     cfg.Priority,
+		})
+	}
+	return p
+}
+
+func (p *routingProducer) Publish(event publisher.Event) bool {
+	return p.publish(event, true)
+}
+
+func (p *routingProducer) TryPublish(event publisher.Event) bool {
+	return p.publish(event, false)
+}
+
+func (p *routingProducer) publish(event publisher.Event, block bool) bool {
+	var matched []int
+	for i, r := range p.routes {
+		if r.matches(&event.Content) {
+			matched = append(matched, i)
+		}
+	}
+
+	// events not matching any route are ACKed right away
+	routed := &routedEvent{remaining: len(matched), delivered: len(matched) == 0}
+	if p.onDrop != nil {
+		routed.content = event.Content
+	}
+	p.mu.Lock()
+	p.pending = append(p.pending, routed)
+	for _, i := range matched {
+		p.inFlight[i] = append(p.inFlight[i], routed)
+	}
+	p.mu.Unlock()
+
+	if len(matched) == 0 {
+		p.onUnrouted()
+		p.mu.Lock()
+		p.advance()
+		p.mu.Unlock()
+		return true
+	}
+
+	accepted := 0
+	for n, i := range matched {
+		r := p.routes[i]
+
+		routeEvent := event
+		if n > 0 {
+			// outputs might modify events, every route gets a copy of its own
+			routeEvent.Content = copyEvent(event.Content)
+		}
+
+		// Clients not dropping events block on all routes, as events
+		// rejected by all routes can not be ACKed.
+		p.setPublishing(i, true)
+		var ok bool
+		if block || r.blocking {
+			ok = p.producers[i].Publish(routeEvent)
+		} else {
+			ok = p.producers[i].TryPublish(routeEvent)
+		}
+		p.setPublishing(i, false)
+
+		if ok {
+			r.observer.routed.Inc()
+			accepted++
+			continue
+		}
+
+		// The event can only be the last one published to the route, as the
+		// producer is used by a single client.
+		r.observer.queueFull.Inc()
+		p.mu.Lock()
+		p.inFlight[i] = p.inFlight[i][:len(p.inFlight[i])-1]
+		routed.remaining--
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.advance()
+	p.mu.Unlock()
+
+	return accepted > 0
+}
+
+func (p *routingProducer) setPublishing(route int, publishing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.publishing[route] = publishing
+}
+
+func (p *routingProducer) ack(route, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := p.inFlight[route]
+	if n > len(events) {
+		// ACKs received after Cancel
+		n = len(events)
+	}
+	for _, e := range events[:n] {
+		e.remaining--
+		e.delivered = true
+	}
+	p.inFlight[route] = events[n:]
+	p.advance()
+}
+
+// dropped handles an event the queue of a route dropped after accepting it.
+// Queues drop the events published after the producer has been cancelled, so
+// the event is the last one waiting for an ACK of the route. Events dropped
+// while being published are rejected, and are handled by publish.
+func (p *routingProducer) dropped(route int) {
+	p.mu.Lock()
+
+	events := p.inFlight[route]
+	if p.publishing[route] || len(events) == 0 {
+		p.mu.Unlock()
+		return
+	}
+
+	e := events[len(events)-1]
+	p.inFlight[route] = events[:len(events)-1]
+	p.routes[route].observer.queueFull.Inc()
+	e.remaining--
+	lost := e.remaining == 0 && !e.delivered
+	p.advance()
+	p.mu.Unlock()
+
+	if lost && p.onDrop != nil {
+		p.onDrop(e.content)
+	}
+}
+
+// advance reports all events at the head of the pending list that have been
+// ACKed by all routes having accepted them. Events not delivered by any route
+// are removed without being reported. The lock must be held.
+func (p *routingProducer) advance() {
+	n, acked := 0, 0
+	for n < len(p.pending) && p.pending[n].remaining == 0 {
+		if p.pending[n].delivered {
+			acked++
+		}
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	p.pending = p.pending[n:]
+	if acked > 0 {
+		p.onACK(acked)
+	}
+}
+
+func (p *routingProducer) Cancel() int {
+	for _, producer := range p.producers {
+		producer.Cancel()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Events still pending in the queues are ACKed later, unless the queues
+	// drop them on cancel. Events not delivered by any route have been
+	// accounted for already.
+	n := 0
+	if p.dropOnCancel {
+		for _, e := range p.pending {
+			if e.remaining > 0 || e.delivered {
+				n++
+			}
+		}
+		p.pending = nil
+		for i := range p.inFlight {
+			p.inFlight[i] = nil
+		}
+	}
+	return n
+}
+
+func copyEvent(e beat.Event) beat.Event {
+	e.Fields = e.Fields.Clone()
+	if e.Meta != nil {
+		e.Meta = e.Meta.Clone()
+	}
+	return e
+}
+
+// routedOutputReloader rejects output reloading if named outputs are used.
+type routedOutputReloader struct{}
+
+func (routedOutputReloader) Reload(
+	_ *reload.ConfigWithMeta,
+	_ func(outputs.Observer, common.ConfigNamespace) (outputs.Group, error),
+) error {
+	return errors.New("output reloading is not supported with named outputs")
+}
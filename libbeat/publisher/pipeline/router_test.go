@@ -0,0 +1,422 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/acker"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/feature"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/outputs"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
+)
+
+type routeRecorder struct {
+	mu     sync.Mutex
+	events map[string][]beat.Event
+	block  map[string]chan struct{}
+}
+
+func (r *routeRecorder) factory(_ outputs.Observer, cfg common.ConfigNamespace) (outputs.Group, error) {
+	name := cfg.Name()
+	client := newMockClient(func(batch publisher.Batch) error {
+		if ch := r.block[name]; ch != nil {
+			<-ch
+		}
+
+		r.mu.Lock()
+		for _, e := range batch.Events() {
+			r.events[name] = append(r.events[name], e.Content)
+		}
+		r.mu.Unlock()
+
+		batch.ACK()
+		return nil
+	})
+	return outputs.Success(0, 0, client)
+}
+
+func (r *routeRecorder) count(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events[name])
+}
+
+func loadRouted(t *testing.T, settings map[string]interface{}, recorder *routeRecorder) (*Pipeline, *monitoring.Registry) {
+	config := Config{}
+	require.NoError(t, common.MustNewConfigFrom(settings).Unpack(&config))
+
+	reg := monitoring.NewRegistry()
+	p, err := NewRouted(beat.Info{}, Monitors{Metrics: reg}, config, recorder.factory, Settings{})
+	require.NoError(t, err)
+	return p, reg
+}
+
+func TestRoutedPipeline(t *testing.T) {
+	recorder := &routeRecorder{events: map[string][]beat.Event{}}
+	p, reg := loadRouted(t, map[string]interface{}{
+		"outputs": []map[string]interface{}{
+			{
+				"name":                           "logs",
+				"when.not.equals.event.category": "security",
+				"output.logs":                    map[string]interface{}{},
+			},
+			{
+				"name":           "security",
+				"when.equals":    map[string]interface{}{"event.category": "security"},
+				"blocking":       true,
+				"output.archive": map[string]interface{}{},
+			},
+			{
+				"name":        "all",
+				"output.copy": map[string]interface{}{},
+			},
+		},
+	}, recorder)
+	defer p.Close()
+
+	var acked atomic.Int
+	client, err := p.ConnectWith(beat.ClientConfig{
+		ACKHandler: acker.RawCounting(func(n int) { acked.Add(n) }),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	for _, category := range []string{"web", "security", "web", "security", "database"} {
+		client.Publish(beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"event": common.MapStr{"category": category}},
+		})
+	}
+
+	require.True(t, waitUntilTrue(5*time.Second, func() bool { return acked.Load() == 5 }))
+	assert.Equal(t, 3, recorder.count("logs"))
+	assert.Equal(t, 2, recorder.count("archive"))
+	assert.Equal(t, 5, recorder.count("copy"))
+
+	// every output receives a copy of its own
+	recorder.events["copy"][1].Fields.Put("event.category", "modified")
+	assert.Equal(t, "security", recorder.events["archive"][0].Fields["event"].(common.MapStr)["category"])
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(3), snapshot.Ints["outputs.logs.events.routed"])
+	assert.Equal(t, int64(2), snapshot.Ints["outputs.security.events.routed"])
+	assert.Equal(t, int64(2), snapshot.Ints["outputs.security.queue.acked"])
+	assert.Equal(t, int64(5), snapshot.Ints["pipeline.queue.acked"])
+	assert.Equal(t, int64(0), snapshot.Ints["pipeline.events.active"])
+	assert.Equal(t, "copy", snapshot.Strings["outputs.all.output.type"])
+}
+
+func TestRoutedPipelineSlowOutput(t *testing.T) {
+	recorder := &routeRecorder{
+		events: map[string][]beat.Event{},
+		block:  map[string]chan struct{}{"slow": make(chan struct{})},
+	}
+	p, reg := loadRouted(t, map[string]interface{}{
+		"queue.mem": map[string]interface{}{"events": 32, "flush.min_events": 0},
+		"outputs": []map[string]interface{}{
+			{"name": "fast", "blocking": true, "output.fast": map[string]interface{}{}},
+			{"name": "slow", "output.slow": map[string]interface{}{}},
+		},
+	}, recorder)
+	defer p.Close()
+
+	client, err := p.ConnectWith(beat.ClientConfig{PublishMode: beat.DropIfFull})
+	require.NoError(t, err)
+	defer client.Close()
+
+	// the slow output must not block the fast one
+	for i := 0; i < 200; i++ {
+		client.Publish(beat.Event{Fields: common.MapStr{"n": i}})
+	}
+	require.True(t, waitUntilTrue(5*time.Second, func() bool { return recorder.count("fast") == 200 }))
+
+	// events are ACKed in order, once the slow output ACKed its events
+	acked := func() int64 {
+		snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+		return snapshot.Ints["pipeline.queue.acked"]
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(0), acked())
+
+	close(recorder.block["slow"])
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	routed := snapshot.Ints["outputs.slow.events.routed"]
+	queueFull := snapshot.Ints["outputs.slow.events.queue_full"]
+	assert.True(t, queueFull > 0)
+	assert.Equal(t, int64(200), routed+queueFull)
+	assert.Equal(t, int64(0), snapshot.Ints["outputs.fast.events.queue_full"])
+
+	// events rejected by the slow output only are ACKed, as the fast output
+	// delivered them
+	require.True(t, waitUntilTrue(5*time.Second, func() bool { return acked() == 200 }))
+	assert.Equal(t, int(routed), recorder.count("slow"))
+
+	snapshot = monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(0), snapshot.Ints["pipeline.events.failed"])
+}
+
+func TestRoutedPipelineFullQueueBlocks(t *testing.T) {
+	recorder := &routeRecorder{
+		events: map[string][]beat.Event{},
+		block:  map[string]chan struct{}{"slow": make(chan struct{})},
+	}
+	p, reg := loadRouted(t, map[string]interface{}{
+		"queue.mem": map[string]interface{}{"events": 32, "flush.min_events": 0},
+		"outputs": []map[string]interface{}{
+			{"name": "fast", "output.fast": map[string]interface{}{}},
+			{"name": "slow", "output.slow": map[string]interface{}{}},
+		},
+	}, recorder)
+	defer p.Close()
+
+	var acked atomic.Int
+	client, err := p.ConnectWith(beat.ClientConfig{
+		ACKHandler: acker.RawCounting(func(n int) { acked.Add(n) }),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	var published atomic.Int
+	go func() {
+		for i := 0; i < 200; i++ {
+			client.Publish(beat.Event{Fields: common.MapStr{"n": i}})
+			published.Inc()
+		}
+	}()
+
+	// the client blocks on the full queue of the slow output, no event is
+	// dropped or ACKed
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, published.Load() < 200)
+	assert.Equal(t, 0, acked.Load())
+
+	close(recorder.block["slow"])
+	require.True(t, waitUntilTrue(5*time.Second, func() bool { return acked.Load() == 200 }))
+	assert.Equal(t, 200, recorder.count("slow"))
+	assert.Equal(t, 200, recorder.count("fast"))
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(0), snapshot.Ints["outputs.slow.events.queue_full"])
+	assert.Equal(t, int64(200), snapshot.Ints["outputs.slow.events.routed"])
+}
+
+func TestRoutedPipelineUnrouted(t *testing.T) {
+	recorder := &routeRecorder{events: map[string][]beat.Event{}}
+	p, reg := loadRouted(t, map[string]interface{}{
+		"outputs": []map[string]interface{}{
+			{"name": "errors", "when.has_fields": []string{"error"}, "output.errors": map[string]interface{}{}},
+		},
+	}, recorder)
+	defer p.Close()
+
+	var acked atomic.Int
+	client, err := p.ConnectWith(beat.ClientConfig{
+		ACKHandler: acker.RawCounting(func(n int) { acked.Add(n) }),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	client.Publish(beat.Event{Fields: common.MapStr{"message": "ok"}})
+	client.Publish(beat.Event{Fields: common.MapStr{"error": "failed"}})
+	client.Publish(beat.Event{Fields: common.MapStr{"message": "ok"}})
+
+	require.True(t, waitUntilTrue(5*time.Second, func() bool { return acked.Load() == 3 }))
+	assert.Equal(t, 1, recorder.count("errors"))
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(2), snapshot.Ints["pipeline.events.unrouted"])
+}
+
+func TestRouteConfigValidate(t *testing.T) {
+	tests := map[string][]map[string]interface{}{
+		"duplicate names": {
+			{"name": "a", "output.console": map[string]interface{}{}},
+			{"name": "a", "output.file": map[string]interface{}{}},
+		},
+		"missing name": {
+			{"output.console": map[string]interface{}{}},
+		},
+		"missing output": {
+			{"name": "a"},
+		},
+	}
+
+	for name, outputs := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := Config{}
+			err := common.MustNewConfigFrom(map[string]interface{}{"outputs": outputs}).Unpack(&config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+type stubQueue struct {
+	queue.Queue
+	accept    bool
+	producers []*stubProducer
+}
+
+type stubProducer struct {
+	queue  *stubQueue
+	cfg    queue.ProducerConfig
+	events []publisher.Event
+}
+
+func (q *stubQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	p := &stubProducer{queue: q, cfg: cfg}
+	q.producers = append(q.producers, p)
+	return p
+}
+
+func (p *stubProducer) Publish(event publisher.Event) bool { return p.TryPublish(event) }
+func (p *stubProducer) Cancel() int                        { return 0 }
+
+func (p *stubProducer) TryPublish(event publisher.Event) bool {
+	if !p.queue.accept {
+		return false
+	}
+	p.events = append(p.events, event)
+	return true
+}
+
+// drop drops the last event published, as queues do for events published
+// after the producer has been cancelled.
+func (p *stubProducer) drop() {
+	event := p.events[len(p.events)-1]
+	p.events = p.events[:len(p.events)-1]
+	p.cfg.OnDrop(event.Content)
+}
+
+type routingTest struct {
+	producer *routingProducer
+	queues   []*stubQueue
+	acked    int
+	dropped  []beat.Event
+}
+
+func newRoutingTest(accept ...bool) *routingTest {
+	test := &routingTest{}
+	var routes []*route
+	for _, ok := range accept {
+		q := &stubQueue{accept: ok}
+		test.queues = append(test.queues, q)
+		routes = append(routes, &route{queue: q, observer: newRouteObserver(nil)})
+	}
+	test.producer = newRoutingProducer(routes, queue.ProducerConfig{
+		OnDrop: func(event beat.Event) { test.dropped = append(test.dropped, event) },
+	}, func(n int) { test.acked += n }, func() {})
+	return test
+}
+
+func (test *routingTest) route(i int) *stubProducer {
+	return test.queues[i].producers[0]
+}
+
+func TestRoutingProducerPartialReject(t *testing.T) {
+	test := newRoutingTest(true, false)
+
+	assert.True(t, test.producer.Publish(publisher.Event{Content: beat.Event{Fields: common.MapStr{"n": 1}}}))
+	assert.Equal(t, uint64(1), test.producer.routes[1].observer.queueFull.Get())
+	assert.Equal(t, 0, test.acked)
+
+	test.route(0).cfg.ACK(1)
+	assert.Equal(t, 1, test.acked)
+	assert.Empty(t, test.dropped)
+}
+
+func TestRoutingProducerAllReject(t *testing.T) {
+	test := newRoutingTest(false, false)
+
+	// the client reports events not published
+	assert.False(t, test.producer.TryPublish(publisher.Event{}))
+	assert.Equal(t, 0, test.acked)
+	assert.Empty(t, test.dropped)
+	assert.Empty(t, test.producer.pending)
+}
+
+func TestRoutingProducerDrop(t *testing.T) {
+	test := newRoutingTest(true, true)
+
+	for i := 0; i < 3; i++ {
+		require.True(t, test.producer.Publish(publisher.Event{Content: beat.Event{Fields: common.MapStr{"n": i}}}))
+	}
+
+	// the last event is dropped by all routes, OnDrop is called once
+	test.route(0).drop()
+	assert.Empty(t, test.dropped)
+	test.route(1).drop()
+	require.Len(t, test.dropped, 1)
+	assert.Equal(t, 2, test.dropped[0].Fields["n"])
+
+	// the second event is dropped by one route only, and ACKed once the
+	// other route ACKed it
+	test.route(1).drop()
+	test.route(0).cfg.ACK(1)
+	assert.Equal(t, 0, test.acked)
+	test.route(1).cfg.ACK(1)
+	assert.Equal(t, 1, test.acked)
+	test.route(0).cfg.ACK(1)
+	assert.Equal(t, 2, test.acked)
+	assert.Len(t, test.dropped, 1)
+	assert.Empty(t, test.producer.pending)
+}
+
+type metricsQueue struct {
+	queue.Queue
+}
+
+func (q *metricsQueue) RegisterMetrics(reg *monitoring.Registry) {
+	monitoring.NewInt(reg, "registered").Set(1)
+}
+
+func TestRouteQueueMetrics(t *testing.T) {
+	queue.RegisterQueueType("metrics_test",
+		func(ackListener queue.ACKListener, logger *logp.Logger, cfg *common.Config) (queue.Queue, error) {
+			return &metricsQueue{memqueue.NewQueue(logger, memqueue.Settings{ACKListener: ackListener, Events: 32})}, nil
+		},
+		feature.MakeDetails("metrics test queue", "", feature.Undefined))
+
+	recorder := &routeRecorder{events: map[string][]beat.Event{}}
+	p, reg := loadRouted(t, map[string]interface{}{
+		"outputs": []map[string]interface{}{
+			{
+				"name":               "logs",
+				"queue.metrics_test": map[string]interface{}{},
+				"output.logs":        map[string]interface{}{},
+			},
+		},
+	}, recorder)
+	defer p.Close()
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["outputs.logs.queue.registered"])
+}
@@ -0,0 +1,138 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/elastic/go-structform/cborl"
+	"github.com/elastic/go-structform/gotype"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/outputs/codec"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// entry is the serialized form of an event stored in a frame.
+type entry struct {
+	Timestamp int64
+	Flags     uint8
+	Meta      common.MapStr
+	Fields    common.MapStr
+}
+
+const flagGuaranteed uint8 = 1 << 0
+
+type encoder struct {
+	buf    bytes.Buffer
+	folder *gotype.Iterator
+}
+
+type decoder struct {
+	parser   *cborl.Parser
+	unfolder *gotype.Unfolder
+}
+
+func newEncoder() *encoder {
+	e := &encoder{}
+	e.reset()
+	return e
+}
+
+func (e *encoder) reset() {
+	folder, err := gotype.NewIterator(cborl.NewVisitor(&e.buf),
+		gotype.Folders(
+			codec.MakeTimestampEncoder(),
+			codec.MakeBCTimestampEncoder(),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+	e.folder = folder
+}
+
+// encode serializes the event into a frame, including the frame header.
+func (e *encoder) encode(event *publisher.Event) ([]byte, error) {
+	e.buf.Reset()
+	e.buf.Write(make([]byte, frameHeaderSize))
+
+	var flags uint8
+	if (event.Flags & publisher.GuaranteedSend) == publisher.GuaranteedSend {
+		flags = flagGuaranteed
+	}
+
+	err := e.folder.Fold(entry{
+		Timestamp: event.Content.Timestamp.UTC().UnixNano(),
+		Flags:     flags,
+		Meta:      event.Content.Meta,
+		Fields:    event.Content.Fields,
+	})
+	if err != nil {
+		e.reset()
+		return nil, err
+	}
+
+	frame := e.buf.Bytes()
+	putFrameHeader(frame)
+	return frame, nil
+}
+
+func newDecoder() *decoder {
+	d := &decoder{}
+	d.reset()
+	return d
+}
+
+func (d *decoder) reset() {
+	unfolder, err := gotype.NewUnfolder(nil)
+	if err != nil {
+		panic(err) // can not happen
+	}
+	d.unfolder = unfolder
+	d.parser = cborl.NewParser(unfolder)
+}
+
+// decode deserializes the payload of a frame.
+func (d *decoder) decode(payload []byte) (publisher.Event, error) {
+	var to entry
+
+	d.unfolder.SetTarget(&to)
+	defer d.unfolder.Reset()
+
+	if err := d.parser.Parse(payload); err != nil {
+		d.reset() // reset parser just in case
+		return publisher.Event{}, err
+	}
+
+	var flags publisher.EventFlags
+	if (to.Flags & flagGuaranteed) != 0 {
+		flags |= publisher.GuaranteedSend
+	}
+
+	return publisher.Event{
+		Flags: flags,
+		Content: beat.Event{
+			Timestamp: time.Unix(0, to.Timestamp),
+			Fields:    to.Fields,
+			Meta:      to.Meta,
+		},
+	}, nil
+}
@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/joeshaw/multierror"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/cfgtype"
+)
+
+type config struct {
+	Path         string           `config:"path"`
+	Permissions  os.FileMode      `config:"permissions"`
+	MaxSize      cfgtype.ByteSize `config:"max_size"`
+	SegmentSize  cfgtype.ByteSize `config:"segment_size"`
+	WhenFull     fullPolicy       `config:"when_full"`
+	SyncInterval time.Duration    `config:"sync_interval"`
+}
+
+// fullPolicy configures the behavior of producers if the queue has reached
+// its maximum size.
+type fullPolicy uint8
+
+const (
+	blockWhenFull fullPolicy = iota
+	rejectWhenFull
+)
+
+const minSegmentSize = humanize.MiByte
+
+func defaultConfig() config {
+	return config{
+		Path:         "",
+		Permissions:  0600,
+		MaxSize:      10 * humanize.GiByte,
+		SegmentSize:  100 * humanize.MiByte,
+		WhenFull:     blockWhenFull,
+		SyncInterval: 1 * time.Second,
+	}
+}
+
+func (c *config) Validate() error {
+	var errs multierror.Errors
+
+	if c.SegmentSize < minSegmentSize {
+		errs = append(errs, errors.New("segment_size must be at least 1MiB"))
+	}
+	if c.MaxSize < 2*c.SegmentSize {
+		errs = append(errs, fmt.Errorf("max_size (%v) must be at least twice the segment_size (%v)", c.MaxSize, c.SegmentSize))
+	}
+	if c.SyncInterval < 0 {
+		errs = append(errs, errors.New("sync_interval must not be negative"))
+	}
+
+	if !c.Permissions.IsRegular() {
+		errs = append(errs, fmt.Errorf("permissions %v are not regular file permissions", c.Permissions.String()))
+	} else {
+		m := c.Permissions.Perm()
+		if (m & 0400) == 0 {
+			errs = append(errs, errors.New("file must be readable by current user"))
+		}
+		if (m & 0200) == 0 {
+			errs = append(errs, errors.New("file must be writable by current user"))
+		}
+	}
+
+	return errs.Err()
+}
+
+func (p *fullPolicy) Unpack(value string) error {
+	policies := map[string]fullPolicy{
+		"block":  blockWhenFull,
+		"reject": rejectWhenFull,
+	}
+
+	policy, exists := policies[strings.ToLower(value)]
+	if !exists {
+		return fmt.Errorf("when_full policy '%v' not available, use 'block' or 'reject'", value)
+	}
+
+	*p = policy
+	return nil
+}
@@ -0,0 +1,224 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"errors"
+	"io"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+)
+
+type consumer struct {
+	queue  *diskQueue
+	closed atomic.Bool
+}
+
+type batch struct {
+	queue  *diskQueue
+	events []publisher.Event
+	refs   []*frameRef
+	state  ackState
+}
+
+type ackState uint8
+
+const (
+	batchActive ackState = iota
+	batchACK
+)
+
+// readFrame holds a frame read from a segment before the frame is passed to
+// a consumer.
+type readFrame struct {
+	start  position
+	ref    *frameRef
+	event  publisher.Event
+	broken bool
+}
+
+func newConsumer(q *diskQueue) *consumer {
+	return &consumer{queue: q}
+}
+
+func (c *consumer) Get(sz int) (queue.Batch, error) {
+	if c.closed.Load() {
+		return nil, io.EOF
+	}
+
+	b, err := c.queue.get(c, sz)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (c *consumer) Close() error {
+	if c.closed.Swap(true) {
+		return errors.New("already closed")
+	}
+
+	// wake up a consumer blocked in Get
+	q := c.queue
+	q.mu.Lock()
+	q.dataCond.Broadcast()
+	q.mu.Unlock()
+	return nil
+}
+
+func (b *batch) Events() []publisher.Event {
+	if b.state != batchActive {
+		panic("Get Events from inactive batch")
+	}
+	return b.events
+}
+
+func (b *batch) ACK() {
+	if b.state != batchActive {
+		switch b.state {
+		case batchACK:
+			panic("Can not acknowledge already acknowledged batch")
+		default:
+			panic("inactive batch")
+		}
+	}
+
+	b.state = batchACK
+	b.queue.ack(b.refs)
+}
+
+// get reads up to sz events from the segments, blocking until events are
+// available.
+func (q *diskQueue) get(c *consumer, sz int) (*batch, error) {
+	if sz <= 0 || sz > maxReadBatch {
+		sz = maxReadBatch
+	}
+
+	q.readMu.Lock()
+	defer q.readMu.Unlock()
+
+	for {
+		end, ok := q.waitReadable(c)
+		if !ok {
+			return nil, io.EOF
+		}
+
+		frames, err := q.readFrames(end, sz)
+		if err != nil {
+			q.log.Errorf("Failed to read disk queue segment %v, skipping remaining events: %v",
+				q.readPos.Segment, err)
+			q.closeReader()
+		}
+
+		b := q.assignFrames(frames, end, err != nil)
+		if b != nil {
+			return b, nil
+		}
+
+		// all frames have been dropped, advance ACK position
+		q.ack(nil)
+	}
+}
+
+// readFrames reads up to sz frames from the segment at the read position.
+// The read lock must be held.
+func (q *diskQueue) readFrames(end int64, sz int) ([]readFrame, error) {
+	segID := q.readPos.Segment
+	if q.reader == nil {
+		reader, err := openSegment(q.settings.Path, segID, q.readPos.Offset)
+		if err == errInvalidSegment {
+			q.log.Warnf("Disk queue segment %v has an invalid header, trying to recover events", segID)
+		} else if err != nil {
+			return nil, err
+		}
+		q.reader = reader
+	}
+
+	var frames []readFrame
+	for len(frames) < sz {
+		payload, skipped, err := q.reader.next(end)
+		if skipped > 0 {
+			q.log.Warnf("Skipped %v bytes of corrupted data in disk queue segment %v", skipped, segID)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return frames, err
+		}
+
+		frameSize := int64(frameHeaderSize + len(payload))
+		frame := readFrame{
+			start: position{Segment: segID, Offset: q.reader.offset - frameSize},
+			ref:   &frameRef{end: position{Segment: segID, Offset: q.reader.offset}},
+		}
+		frame.event, err = q.dec.decode(payload)
+		if err != nil {
+			q.log.Errorf("Failed to decode event from disk queue segment %v: %v", segID, err)
+			frame.broken = true
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// assignFrames updates the read position and associates the frames with their
+// producers. Frames of canceled producers are dropped. Returns nil if no
+// event is to be passed to the consumer.
+func (q *diskQueue) assignFrames(frames []readFrame, end int64, failed bool) *batch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if failed || q.reader == nil {
+		q.readPos.Offset = end
+	} else {
+		q.readPos.Offset = q.reader.offset
+	}
+
+	var (
+		events []publisher.Event
+		refs   []*frameRef
+	)
+	for i := range frames {
+		frame := &frames[i]
+		ref := frame.ref
+		ref.session = frame.start.Segment >= q.sessionStart
+
+		if p := q.producers[frame.start]; p != nil {
+			delete(q.producers, frame.start)
+			p.unread--
+			ref.producer = p
+			ref.dropped = p.canceled && p.dropOnCancel
+		}
+		q.inFlight = append(q.inFlight, ref)
+
+		if ref.dropped || frame.broken {
+			ref.acked = true
+			continue
+		}
+		events = append(events, frame.event)
+		refs = append(refs, ref)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+	return &batch{queue: q, events: events, refs: refs}
+}
@@ -0,0 +1,245 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+// Store provides offline access to the segments of a disk queue. It is used
+// to inspect and repair the disk queue of a stopped beat.
+type Store struct {
+	path string
+}
+
+// StoreStats describes the events stored in a disk queue.
+type StoreStats struct {
+	Segments       int    // number of segment files
+	Size           uint64 // total size of all segment files in bytes
+	Events         int    // number of events not yet ACKed
+	CorruptedBytes int64  // bytes of corrupted frames
+}
+
+var errStopScan = errors.New("stop scan")
+
+// OpenStore opens the disk queue in the directory path.
+func OpenStore(path string) (*Store, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("disk queue path '%v' is no directory", path)
+	}
+	return &Store{path: path}, nil
+}
+
+// Close releases the store.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Stats scans all segments, counting the events not yet ACKed.
+func (s *Store) Stats() (StoreStats, error) {
+	var st StoreStats
+
+	err := s.scan(
+		func(id uint64, size int64) error {
+			st.Segments++
+			st.Size += uint64(size)
+			return nil
+		},
+		func(_ []byte, _ position) error {
+			st.Events++
+			return nil
+		},
+		func(_ uint64, _, n int64) error {
+			st.CorruptedBytes += n
+			return nil
+		},
+	)
+	return st, err
+}
+
+// Read decodes all events not yet ACKed, in the order they have been written.
+// Events failing to decode and corrupted frames are passed to fn as errors.
+// Reading stops if fn returns an error.
+func (s *Store) Read(fn func(publisher.Event, error) error) error {
+	dec := newDecoder()
+	return s.scan(
+		nil,
+		func(payload []byte, _ position) error {
+			return fn(dec.decode(payload))
+		},
+		func(id uint64, offset, n int64) error {
+			return fn(publisher.Event{}, fmt.Errorf(
+				"skipped %v bytes of corrupted data in segment %v at offset %v", n, id, offset))
+		},
+	)
+}
+
+// Truncate removes up to n events from the head of the queue. Segments not
+// holding any remaining event are deleted. The number of removed events is
+// returned.
+func (s *Store) Truncate(n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	var (
+		count int
+		pos   position
+	)
+	err := s.scan(nil, func(_ []byte, end position) error {
+		count++
+		pos = end
+		if count == n {
+			return errStopScan
+		}
+		return nil
+	}, nil)
+	if err != nil && err != errStopScan {
+		return 0, err
+	}
+
+	ids, err := listSegments(s.path)
+	if err != nil {
+		return 0, err
+	}
+
+	if count < n {
+		// all events have been removed
+		for _, id := range ids {
+			if err := os.Remove(segmentPath(s.path, id)); err != nil {
+				return 0, err
+			}
+		}
+		err := os.Remove(filepath.Join(s.path, stateFileName))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		return count, nil
+	}
+
+	if err := writeState(s.path, pos, 0600); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if id >= pos.Segment {
+			break
+		}
+		if err := os.Remove(segmentPath(s.path, id)); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// scan reads all frames not yet ACKed. The callbacks are optional. onSegment
+// is called for every segment before its frames are read, onFrame for every
+// valid frame and onCorrupt for every region of corrupted data.
+func (s *Store) scan(
+	onSegment func(id uint64, size int64) error,
+	onFrame func(payload []byte, end position) error,
+	onCorrupt func(id uint64, offset, n int64) error,
+) error {
+	ids, err := listSegments(s.path)
+	if err != nil {
+		return err
+	}
+
+	pos, found, err := readState(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if found && id < pos.Segment {
+			continue
+		}
+
+		offset := segmentHeaderSize
+		if found && id == pos.Segment {
+			offset = pos.Offset
+		}
+
+		if err := s.scanSegment(id, offset, onSegment, onFrame, onCorrupt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) scanSegment(
+	id uint64,
+	offset int64,
+	onSegment func(id uint64, size int64) error,
+	onFrame func(payload []byte, end position) error,
+	onCorrupt func(id uint64, offset, n int64) error,
+) error {
+	info, err := os.Stat(segmentPath(s.path, id))
+	if err != nil {
+		return err
+	}
+	if onSegment != nil {
+		if err := onSegment(id, info.Size()); err != nil {
+			return err
+		}
+	}
+
+	reader, err := openSegment(s.path, id, offset)
+	if err == errInvalidSegment {
+		if onCorrupt != nil {
+			if err := onCorrupt(id, 0, segmentHeaderSize); err != nil {
+				reader.Close()
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		start := reader.offset
+		payload, skipped, err := reader.next(info.Size())
+		if skipped > 0 && onCorrupt != nil {
+			if err := onCorrupt(id, start, skipped); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if onFrame != nil {
+			if err := onFrame(payload, position{Segment: id, Offset: reader.offset}); err != nil {
+				return err
+			}
+		}
+	}
+}
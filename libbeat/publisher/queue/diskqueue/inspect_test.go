@@ -0,0 +1,96 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package diskqueue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+)
+
+func TestInspectStore(t *testing.T) {
+	path := tempDir(t)
+
+	q := newTestQueue(t, path, testSettings())
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 0, 100)
+	assert.Equal(t, seq(0, 10), consumeValues(t, q, 10))
+	require.NoError(t, q.Close())
+
+	store, err := OpenStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 90, stats.Events)
+	assert.Equal(t, int64(0), stats.CorruptedBytes)
+	assert.True(t, stats.Segments > 1)
+
+	readValues := func() []int {
+		var values []int
+		err := store.Read(func(event publisher.Event, err error) error {
+			require.NoError(t, err)
+			v, err := event.Content.Fields.GetValue("value")
+			require.NoError(t, err)
+			values = append(values, toInt(v))
+			return nil
+		})
+		require.NoError(t, err)
+		return values
+	}
+	assert.Equal(t, seq(10, 100), readValues())
+
+	n, err := store.Truncate(50)
+	require.NoError(t, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, seq(60, 100), readValues())
+
+	stats, err = store.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 40, stats.Events)
+
+	// the queue resumes from the truncated position
+	q = newTestQueue(t, path, testSettings())
+	assert.Equal(t, seq(60, 70), consumeValues(t, q, 10))
+	require.NoError(t, q.Close())
+
+	n, err = store.Truncate(100)
+	require.NoError(t, err)
+	assert.Equal(t, 30, n)
+	assert.Empty(t, readValues())
+
+	ids, err := listSegments(path)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	q = newTestQueue(t, path, testSettings())
+	defer q.Close()
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 0, 5)
+	assert.Equal(t, seq(0, 5), consumeValues(t, q, 5))
+}
+
+func TestOpenStoreMissingPath(t *testing.T) {
+	_, err := OpenStore("/does/not/exist")
+	assert.Error(t, err)
+}
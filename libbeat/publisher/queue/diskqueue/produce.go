@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+)
+
+type producer struct {
+	queue        *diskQueue
+	enc          *encoder
+	ack          func(count int)
+	dropCB       func(beat.Event)
+	dropOnCancel bool
+
+	// fields protected by the queue lock
+	canceled bool
+	unread   int // number of frames written, but not yet read by a consumer
+}
+
+func newProducer(q *diskQueue, ack func(int), dropCB func(beat.Event), dropOnCancel bool) *producer {
+	return &producer{
+		queue:        q,
+		enc:          newEncoder(),
+		ack:          ack,
+		dropCB:       dropCB,
+		dropOnCancel: dropOnCancel,
+	}
+}
+
+func (p *producer) Publish(event publisher.Event) bool {
+	return p.publish(event, !p.queue.settings.RejectWhenFull)
+}
+
+func (p *producer) TryPublish(event publisher.Event) bool {
+	return p.publish(event, false)
+}
+
+func (p *producer) publish(event publisher.Event, block bool) bool {
+	if p.isCanceled() {
+		p.queue.log.Debugf("cancelled producer - ignore event: %v", event)
+		if p.dropCB != nil {
+			p.dropCB(event.Content)
+		}
+		return false
+	}
+
+	frame, err := p.enc.encode(&event)
+	if err != nil {
+		p.queue.log.Errorf("Failed to encode event for the disk queue: %v", err)
+		return false
+	}
+
+	return p.queue.write(p, frame, block)
+}
+
+// Cancel stops the producer. Blocked Publish calls return immediately. If the
+// producer drops its events on cancel, frames not yet read by a consumer are
+// skipped and the number of skipped frames is returned.
+func (p *producer) Cancel() int {
+	q := p.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if p.canceled {
+		return 0
+	}
+	p.canceled = true
+	q.spaceCond.Broadcast()
+
+	if p.dropOnCancel {
+		return p.unread
+	}
+	return 0
+}
+
+func (p *producer) isCanceled() bool {
+	p.queue.mu.Lock()
+	defer p.queue.mu.Unlock()
+	return p.canceled
+}
+
+// tracked reports if the frames written by the producer must be associated
+// with the producer when being read.
+func (p *producer) tracked() bool {
+	return p.ack != nil || p.dropOnCancel
+}
@@ -0,0 +1,608 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package diskqueue provides a queue.Queue persisting events to append only
+// segment files on disk.
+//
+// Events are written as checksummed frames to the active segment. Once a
+// segment reaches its configured size, a new segment is started. Segments are
+// deleted once all events stored in the segment have been ACKed. The position
+// of the oldest not yet ACKed event is stored in a separate state file, such
+// that the queue can continue after a restart. Corrupted frames, for example
+// frames partially written before a crash, are skipped when reading.
+package diskqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/cfgwarn"
+	"github.com/snappyflow/beats/v7/libbeat/feature"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/paths"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+)
+
+// Settings configures a disk queue.
+type Settings struct {
+	ACKListener queue.ACKListener
+
+	// Path of the directory holding the segment files and the queue state.
+	Path string
+
+	// Permissions used when creating segment and state files.
+	Permissions os.FileMode
+
+	// MaxSize is the maximum number of bytes used by all segments.
+	MaxSize uint64
+
+	// SegmentSize is the maximum size of a single segment file.
+	SegmentSize uint64
+
+	// RejectWhenFull configures Publish to fail instead of blocking if the
+	// queue is full.
+	RejectWhenFull bool
+
+	// SyncInterval configures how often the active segment is synced to
+	// disk and the ACK position is persisted. If 0, segments are only synced
+	// on rotation and shutdown, and the ACK position only on shutdown.
+	SyncInterval time.Duration
+}
+
+// Queue is a disk queue as returned by NewQueue.
+type Queue interface {
+	queue.Queue
+
+	// Stats reports the current disk usage of the queue.
+	Stats() Stats
+}
+
+// Stats describes the disk usage of a queue.
+type Stats struct {
+	Segments    int    // number of segment files
+	Size        uint64 // total size of all segment files in bytes
+	MaxSize     uint64 // configured maximum size in bytes
+	UnreadBytes uint64 // bytes of segments not yet read by a consumer
+}
+
+// maxReadBatch limits the number of events returned by Get if the consumer
+// does not request a batch size.
+const maxReadBatch = 4096
+
+type diskQueue struct {
+	log      *logp.Logger
+	settings Settings
+
+	// readMu serializes consumers reading from the segments.
+	readMu sync.Mutex
+	reader *segmentReader
+	dec    *decoder
+
+	// ackMu serializes ACK handling, such that ACK callbacks are executed in
+	// order.
+	ackMu sync.Mutex
+
+	mu        sync.Mutex
+	spaceCond *sync.Cond // signaled when segments are removed
+	dataCond  *sync.Cond // signaled when frames are written
+	closed    bool
+
+	segments     []*segment // oldest first, the last segment is written to
+	size         uint64     // total size of all segments
+	sessionStart uint64     // id of the first segment written by this instance
+	writer       *os.File
+
+	readPos   position               // next frame to be read
+	ackPos    position               // oldest frame not yet ACKed
+	ackDirty  bool                   // ackPos has not been persisted yet
+	inFlight  []*frameRef            // frames read, but not yet ACKed, in read order
+	producers map[position]*producer // producers of unread frames by frame start
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type segment struct {
+	id       uint64
+	end      int64 // size of the segment file
+	complete bool  // no more frames are added to a complete segment
+}
+
+// frameRef tracks the ACK state of a frame returned by a consumer.
+type frameRef struct {
+	end      position // position right after the frame
+	producer *producer
+	session  bool // frame was written by this queue instance
+	dropped  bool
+	acked    bool
+}
+
+// ackResult collects the ACK signals to be send after frames have been
+// ACKed.
+type ackResult struct {
+	total     int
+	producers []producerACK
+	remove    []uint64
+}
+
+type producerACK struct {
+	producer *producer
+	count    int
+}
+
+func init() {
+	queue.RegisterQueueType(
+		"disk",
+		create,
+		feature.MakeDetails(
+			"Disk queue",
+			"Buffer events in segment files on disk before sending to the output.",
+			feature.Beta))
+}
+
+func create(
+	ackListener queue.ACKListener, logger *logp.Logger, cfg *common.Config,
+) (queue.Queue, error) {
+	cfgwarn.Beta("The disk queue is beta")
+
+	settings, err := SettingsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.ACKListener = ackListener
+	return NewQueue(logger, settings)
+}
+
+// SettingsFromConfig unpacks the `queue.disk` configuration into Settings.
+func SettingsFromConfig(cfg *common.Config) (Settings, error) {
+	config := defaultConfig()
+	if cfg != nil {
+		if err := cfg.Unpack(&config); err != nil {
+			return Settings{}, err
+		}
+	}
+
+	path := config.Path
+	if path == "" {
+		path = paths.Resolve(paths.Data, "diskqueue")
+	}
+
+	return Settings{
+		Path:           path,
+		Permissions:    config.Permissions,
+		MaxSize:        uint64(config.MaxSize),
+		SegmentSize:    uint64(config.SegmentSize),
+		RejectWhenFull: config.WhenFull == rejectWhenFull,
+		SyncInterval:   config.SyncInterval,
+	}, nil
+}
+
+// NewQueue opens the disk queue in settings.Path. Events not ACKed before the
+// queue was closed are returned to consumers again.
+func NewQueue(logger *logp.Logger, settings Settings) (Queue, error) {
+	if logger == nil {
+		logger = logp.NewLogger("diskqueue")
+	}
+	if settings.Permissions == 0 {
+		settings.Permissions = 0600
+	}
+
+	if err := os.MkdirAll(settings.Path, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create disk queue directory: %v", err)
+	}
+
+	ids, err := listSegments(settings.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk queue segments: %v", err)
+	}
+
+	pos, found, err := readState(settings.Path)
+	if err != nil {
+		logger.Errorf("Failed to read disk queue state, reading all segments: %v", err)
+		found = false
+	}
+
+	q := &diskQueue{
+		log:       logger,
+		settings:  settings,
+		dec:       newDecoder(),
+		producers: map[position]*producer{},
+		done:      make(chan struct{}),
+	}
+	q.spaceCond = sync.NewCond(&q.mu)
+	q.dataCond = sync.NewCond(&q.mu)
+
+	for _, id := range ids {
+		path := segmentPath(settings.Path, id)
+		if found && id < pos.Segment {
+			// Segment has been ACKed, but was not removed before shutdown.
+			if err := os.Remove(path); err != nil {
+				logger.Errorf("Failed to remove ACKed segment %v: %v", path, err)
+			}
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment %v: %v", path, err)
+		}
+		q.segments = append(q.segments, &segment{id: id, end: info.Size(), complete: true})
+		q.size += uint64(info.Size())
+	}
+
+	q.sessionStart = 1
+	if len(ids) > 0 {
+		q.sessionStart = ids[len(ids)-1] + 1
+	}
+	if err := q.startSegment(q.sessionStart); err != nil {
+		return nil, err
+	}
+
+	first := q.segments[0]
+	q.readPos = position{Segment: first.id, Offset: segmentHeaderSize}
+	if found && pos.Segment == first.id && pos.Offset > segmentHeaderSize {
+		q.readPos.Offset = pos.Offset
+	}
+	q.ackPos = q.readPos
+
+	if settings.SyncInterval > 0 {
+		q.wg.Add(1)
+		go q.syncLoop()
+	}
+
+	return q, nil
+}
+
+// Close syncs the active segment and persists the queue state. Frames not
+// ACKed yet are returned to consumers once the queue is opened again.
+func (q *diskQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.spaceCond.Broadcast()
+	q.dataCond.Broadcast()
+	q.mu.Unlock()
+
+	close(q.done)
+	q.wg.Wait()
+
+	q.readMu.Lock()
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	q.readMu.Unlock()
+
+	q.ackMu.Lock()
+	defer q.ackMu.Unlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.writer.Sync(); err != nil {
+		q.log.Errorf("Failed to sync disk queue segment: %v", err)
+	}
+	if err := q.writer.Close(); err != nil {
+		q.log.Errorf("Failed to close disk queue segment: %v", err)
+	}
+
+	// do not leave empty segments behind
+	active := q.segments[len(q.segments)-1]
+	if active.end == segmentHeaderSize {
+		os.Remove(segmentPath(q.settings.Path, active.id))
+	}
+
+	return writeState(q.settings.Path, q.ackPos, q.settings.Permissions)
+}
+
+func (q *diskQueue) BufferConfig() queue.BufferConfig {
+	return queue.BufferConfig{MaxEvents: -1}
+}
+
+func (q *diskQueue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	st := Stats{
+		Segments: len(q.segments),
+		Size:     q.size,
+		MaxSize:  q.settings.MaxSize,
+	}
+	for _, seg := range q.segments {
+		switch {
+		case seg.id == q.readPos.Segment && q.readPos.Offset < seg.end:
+			st.UnreadBytes += uint64(seg.end - q.readPos.Offset)
+		case seg.id > q.readPos.Segment:
+			st.UnreadBytes += uint64(seg.end - segmentHeaderSize)
+		}
+	}
+	return st
+}
+
+func (q *diskQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	return newProducer(q, cfg.ACK, cfg.OnDrop, cfg.DropOnCancel)
+}
+
+func (q *diskQueue) Consumer() queue.Consumer {
+	return newConsumer(q)
+}
+
+// startSegment creates a new segment and makes it the active segment.
+// The queue lock must be held if the queue is in use.
+func (q *diskQueue) startSegment(id uint64) error {
+	f, err := createSegment(q.settings.Path, id, q.settings.Permissions)
+	if err != nil {
+		return fmt.Errorf("failed to create disk queue segment: %v", err)
+	}
+
+	if q.writer != nil {
+		if err := q.writer.Sync(); err != nil {
+			q.log.Errorf("Failed to sync disk queue segment: %v", err)
+		}
+		q.writer.Close()
+		q.segments[len(q.segments)-1].complete = true
+	}
+
+	q.writer = f
+	q.segments = append(q.segments, &segment{id: id, end: segmentHeaderSize})
+	q.size += uint64(segmentHeaderSize)
+	return nil
+}
+
+// write appends the frame to the active segment. If the queue is full, write
+// blocks until enough segments have been removed if block is set.
+func (q *diskQueue) write(p *producer, frame []byte, block bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	sz := uint64(len(frame))
+	if sz+uint64(segmentHeaderSize) > q.settings.MaxSize {
+		q.log.Errorf("Dropping event of %v bytes exceeding the disk queue size", sz)
+		return false
+	}
+
+	for !q.closed && !p.canceled && q.size+sz > q.settings.MaxSize {
+		if !block {
+			return false
+		}
+		q.spaceCond.Wait()
+	}
+	if q.closed || p.canceled {
+		return false
+	}
+
+	active := q.segments[len(q.segments)-1]
+	if uint64(active.end)+sz > q.settings.SegmentSize && active.end > segmentHeaderSize {
+		if err := q.startSegment(active.id + 1); err != nil {
+			q.log.Errorf("Failed to rotate disk queue segment: %v", err)
+			return false
+		}
+		q.dataCond.Broadcast()
+		active = q.segments[len(q.segments)-1]
+	}
+
+	if _, err := q.writer.WriteAt(frame, active.end); err != nil {
+		q.log.Errorf("Failed to write to disk queue segment: %v", err)
+		// remove partially written frame
+		q.writer.Truncate(active.end)
+		return false
+	}
+
+	start := position{Segment: active.id, Offset: active.end}
+	active.end += int64(sz)
+	q.size += sz
+	if p.tracked() {
+		q.producers[start] = p
+		p.unread++
+	}
+
+	q.dataCond.Broadcast()
+	return true
+}
+
+// waitReadable blocks until the segment at the read position has unread
+// frames. It returns the current end of the segment. The read lock must be
+// held.
+func (q *diskQueue) waitReadable(c *consumer) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.closed || c.closed.Load() {
+			return 0, false
+		}
+
+		seg, next := q.findSegment(q.readPos.Segment)
+		if seg != nil && q.readPos.Offset < seg.end {
+			return seg.end, true
+		}
+		if seg == nil || seg.complete {
+			if next != nil {
+				q.readPos = position{Segment: next.id, Offset: segmentHeaderSize}
+				q.closeReader()
+				continue
+			}
+		}
+
+		q.dataCond.Wait()
+	}
+}
+
+// findSegment returns the segment with the given id and the segment
+// following it. If no segment with the given id exists, the oldest segment
+// with a larger id is returned as next segment.
+func (q *diskQueue) findSegment(id uint64) (seg, next *segment) {
+	for i, s := range q.segments {
+		if s.id == id {
+			if i+1 < len(q.segments) {
+				next = q.segments[i+1]
+			}
+			return s, next
+		}
+		if s.id > id {
+			return nil, s
+		}
+	}
+	return nil, nil
+}
+
+// closeReader closes the current segment reader. The read lock must be held.
+func (q *diskQueue) closeReader() {
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+}
+
+// ack marks the frames as ACKed, advances the ACK position, removes segments
+// no longer required and executes the ACK callbacks.
+func (q *diskQueue) ack(refs []*frameRef) {
+	q.ackMu.Lock()
+	defer q.ackMu.Unlock()
+
+	q.mu.Lock()
+	for _, ref := range refs {
+		ref.acked = true
+	}
+	st, changed := q.advanceACK()
+	q.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	for _, id := range st.remove {
+		if err := os.Remove(segmentPath(q.settings.Path, id)); err != nil {
+			q.log.Errorf("Failed to remove disk queue segment: %v", err)
+		}
+	}
+	for _, pa := range st.producers {
+		pa.producer.ack(pa.count)
+	}
+	if st.total > 0 && q.settings.ACKListener != nil {
+		q.settings.ACKListener.OnACK(st.total)
+	}
+}
+
+// advanceACK removes all ACKed frames from the head of the in flight list.
+// The queue lock must be held.
+func (q *diskQueue) advanceACK() (st ackResult, changed bool) {
+	n := 0
+	for n < len(q.inFlight) && q.inFlight[n].acked {
+		ref := q.inFlight[n]
+		q.inFlight[n] = nil
+		n++
+
+		q.ackPos = ref.end
+		if ref.dropped {
+			continue
+		}
+
+		if ref.session {
+			st.total++
+		}
+		if p := ref.producer; p != nil && p.ack != nil {
+			if l := len(st.producers); l > 0 && st.producers[l-1].producer == p {
+				st.producers[l-1].count++
+			} else {
+				st.producers = append(st.producers, producerACK{producer: p, count: 1})
+			}
+		}
+	}
+	if n == 0 {
+		return st, false
+	}
+	q.inFlight = q.inFlight[n:]
+
+	// Move the ACK position to the next segment if all frames of a complete
+	// segment have been ACKed.
+	for {
+		seg, next := q.findSegment(q.ackPos.Segment)
+		if seg == nil || !seg.complete || next == nil || q.ackPos.Offset < seg.end || q.readPos.Segment == seg.id {
+			break
+		}
+		q.ackPos = position{Segment: next.id, Offset: segmentHeaderSize}
+	}
+
+	for len(q.segments) > 1 && q.segments[0].id < q.ackPos.Segment {
+		seg := q.segments[0]
+		q.segments = q.segments[1:]
+		q.size -= uint64(seg.end)
+		st.remove = append(st.remove, seg.id)
+	}
+	if len(st.remove) > 0 {
+		q.spaceCond.Broadcast()
+	}
+
+	q.ackDirty = true
+	return st, true
+}
+
+func (q *diskQueue) syncLoop() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.settings.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		writer := q.writer
+		q.mu.Unlock()
+
+		// The segment might be closed concurrently due to rotation, in which
+		// case it has already been synced.
+		if err := writer.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			q.log.Errorf("Failed to sync disk queue segment: %v", err)
+		}
+
+		q.persistACK()
+	}
+}
+
+// persistACK writes the ACK position to the state file if it changed since
+// the last write. Frames ACKed after the last write are returned again to
+// consumers if the process crashes.
+func (q *diskQueue) persistACK() {
+	q.mu.Lock()
+	pos, dirty := q.ackPos, q.ackDirty
+	q.ackDirty = false
+	q.mu.Unlock()
+
+	if !dirty {
+		return
+	}
+	if err := writeState(q.settings.Path, pos, q.settings.Permissions); err != nil {
+		q.log.Errorf("Failed to write disk queue state: %v", err)
+
+		q.mu.Lock()
+		q.ackDirty = true
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,343 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package diskqueue
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/queuetest"
+)
+
+var seed int64
+
+func init() {
+	flag.Int64Var(&seed, "seed", time.Now().UnixNano(), "test random seed")
+}
+
+func TestProduceConsumer(t *testing.T) {
+	maxEvents := 4096
+	minEvents := 32
+
+	rand.Seed(seed)
+	events := rand.Intn(maxEvents-minEvents) + minEvents
+	batchSize := rand.Intn(events-8) + 4
+
+	t.Log("seed: ", seed)
+	t.Log("events: ", events)
+	t.Log("batchSize: ", batchSize)
+
+	factory := func(t *testing.T) queue.Queue {
+		return newTestQueue(t, tempDir(t), testSettings())
+	}
+
+	t.Run("single", func(t *testing.T) {
+		queuetest.TestSingleProducerConsumer(t, events, batchSize, factory)
+	})
+	t.Run("multi", func(t *testing.T) {
+		queuetest.TestMultiProducerConsumer(t, events, batchSize, factory)
+	})
+}
+
+func TestProducerCancelDropsEvents(t *testing.T) {
+	q := newTestQueue(t, tempDir(t), testSettings())
+	defer q.Close()
+
+	producer := q.Producer(queue.ProducerConfig{
+		ACK:          func(int) {},
+		DropOnCancel: true,
+	})
+	publishValues(t, producer, 0, 3)
+	assert.Equal(t, 3, producer.Cancel())
+	assert.False(t, producer.Publish(makeEvent(3)))
+
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 3, 10)
+
+	assert.Equal(t, []int{3, 4, 5, 6, 7, 8, 9}, consumeValues(t, q, 7))
+}
+
+func TestACKCallbacks(t *testing.T) {
+	listener := &queuetest.CountingListener{}
+	settings := testSettings()
+	settings.ACKListener = listener
+
+	q := newTestQueue(t, tempDir(t), settings)
+	defer q.Close()
+
+	var acked int
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked += n }})
+	publishValues(t, producer, 0, 5)
+
+	consumer := q.Consumer()
+	first, err := consumer.Get(2)
+	require.NoError(t, err)
+	second, err := consumer.Get(3)
+	require.NoError(t, err)
+
+	// ACKs are reported in order
+	second.ACK()
+	assert.Equal(t, 0, acked)
+	first.ACK()
+	assert.Equal(t, 5, acked)
+	assert.Equal(t, 5, listener.Count())
+}
+
+func TestResumeAfterRestart(t *testing.T) {
+	path := tempDir(t)
+
+	q := newTestQueue(t, path, testSettings())
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 0, 100)
+	assert.Equal(t, seq(0, 40), consumeValues(t, q, 40))
+
+	// read, but not ACKed events are returned again after restart
+	batch, err := q.Consumer().Get(10)
+	require.NoError(t, err)
+	assert.Len(t, batch.Events(), 10)
+	require.NoError(t, q.Close())
+
+	q = newTestQueue(t, path, testSettings())
+	defer q.Close()
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 100, 110)
+	assert.True(t, q.(Queue).Stats().UnreadBytes > 0)
+	assert.Equal(t, seq(40, 110), consumeValues(t, q, 70))
+	assert.Equal(t, uint64(0), q.(Queue).Stats().UnreadBytes)
+
+	// all segments, but the active one have been removed
+	ids, err := listSegments(path)
+	require.NoError(t, err)
+	assert.Len(t, ids, 1)
+}
+
+func TestPersistACKPosition(t *testing.T) {
+	path := tempDir(t)
+	settings := testSettings()
+	settings.SyncInterval = 10 * time.Millisecond
+
+	q := newTestQueue(t, path, settings)
+	defer q.Close()
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 0, 10)
+	assert.Equal(t, seq(0, 4), consumeValues(t, q, 4))
+
+	// the ACK position is written by the sync loop, without closing the queue
+	require.Eventually(t, func() bool {
+		pos, found, _ := readState(path)
+		return found && pos.Offset > segmentHeaderSize
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := os.Stat(filepath.Join(path, stateFileName+".new"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSkipCorruptedFrames(t *testing.T) {
+	path := tempDir(t)
+
+	q := newTestQueue(t, path, testSettings())
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 0, 10)
+	require.NoError(t, q.Close())
+
+	ids, err := listSegments(path)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	segPath := segmentPath(path, ids[0])
+
+	// find the start offset of the frames
+	reader, err := openSegment(path, ids[0], 0)
+	require.NoError(t, err)
+	info, err := os.Stat(segPath)
+	require.NoError(t, err)
+	var offsets []int64
+	for {
+		offset := reader.offset
+		_, _, err := reader.next(info.Size())
+		if err != nil {
+			break
+		}
+		offsets = append(offsets, offset)
+	}
+	reader.Close()
+	require.Len(t, offsets, 10)
+
+	f, err := os.OpenFile(segPath, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	// corrupt the payload of the 4th event and the header of the 7th event
+	_, err = f.WriteAt([]byte("garbage"), offsets[3]+frameHeaderSize+2)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0, 0, 0, 0}, offsets[6])
+	require.NoError(t, err)
+	// simulate a partially written frame
+	_, err = f.WriteAt(append([]byte{}, frameMagic...), info.Size())
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	q = newTestQueue(t, path, testSettings())
+	defer q.Close()
+	publishValues(t, q.Producer(queue.ProducerConfig{}), 10, 12)
+	assert.Equal(t, []int{0, 1, 2, 4, 5, 7, 8, 9, 10, 11}, consumeValues(t, q, 10))
+}
+
+func TestWhenFull(t *testing.T) {
+	frame, err := newEncoder().encode(eventPtr(makeEvent(0)))
+	require.NoError(t, err)
+	frameSize := uint64(len(frame))
+
+	settings := testSettings()
+	settings.SegmentSize = 4*frameSize + uint64(segmentHeaderSize)
+	settings.MaxSize = 2 * settings.SegmentSize
+
+	t.Run("reject", func(t *testing.T) {
+		settings := settings
+		settings.RejectWhenFull = true
+		q := newTestQueue(t, tempDir(t), settings)
+		defer q.Close()
+
+		producer := q.Producer(queue.ProducerConfig{})
+		publishValues(t, producer, 0, 8)
+		assert.False(t, producer.Publish(makeEvent(8)))
+		assert.False(t, producer.TryPublish(makeEvent(8)))
+
+		// ACKing the events of the first segment frees up space
+		assert.Equal(t, seq(0, 5), consumeValues(t, q, 5))
+		assert.True(t, producer.Publish(makeEvent(8)))
+	})
+
+	t.Run("block", func(t *testing.T) {
+		q := newTestQueue(t, tempDir(t), settings)
+		defer q.Close()
+
+		producer := q.Producer(queue.ProducerConfig{})
+		publishValues(t, producer, 0, 8)
+		assert.False(t, producer.TryPublish(makeEvent(8)))
+
+		done := make(chan bool)
+		go func() {
+			done <- producer.Publish(makeEvent(8))
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("publish did not block on full queue")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		assert.Equal(t, seq(0, 5), consumeValues(t, q, 5))
+		assert.True(t, <-done)
+
+		// canceling the producer unblocks Publish
+		publishValues(t, producer, 9, 12)
+		go func() {
+			done <- producer.Publish(makeEvent(12))
+		}()
+		producer.Cancel()
+		assert.False(t, <-done)
+	})
+}
+
+func testSettings() Settings {
+	return Settings{
+		MaxSize:     64 * 1024,
+		SegmentSize: 4 * 1024,
+	}
+}
+
+func newTestQueue(t *testing.T, path string, settings Settings) queue.Queue {
+	settings.Path = path
+	q, err := NewQueue(nil, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func tempDir(t *testing.T) string {
+	path, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(path) })
+	return path
+}
+
+func makeEvent(value int) publisher.Event {
+	return publisher.Event{
+		Content: beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"value": value},
+		},
+	}
+}
+
+func eventPtr(event publisher.Event) *publisher.Event {
+	return &event
+}
+
+func publishValues(t *testing.T, producer queue.Producer, from, to int) {
+	for i := from; i < to; i++ {
+		require.True(t, producer.Publish(makeEvent(i)), "failed to publish event %v", i)
+	}
+}
+
+// consumeValues reads and ACKs n events, returning the event values.
+func consumeValues(t *testing.T, q queue.Queue, n int) []int {
+	consumer := q.Consumer()
+	defer consumer.Close()
+
+	var values []int
+	for len(values) < n {
+		batch, err := consumer.Get(n - len(values))
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			v, err := event.Content.Fields.GetValue("value")
+			require.NoError(t, err)
+			values = append(values, toInt(v))
+		}
+		batch.ACK()
+	}
+	return values
+}
+
+func toInt(v interface{}) int {
+	i, err := strconv.Atoi(fmt.Sprint(v))
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+func seq(from, to int) []int {
+	var values []int
+	for i := from; i < to; i++ {
+		values = append(values, i)
+	}
+	return values
+}
@@ -0,0 +1,288 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/file"
+)
+
+// Segment files start with segmentMagic followed by a sequence of frames.
+// Each frame has a fixed size header (frame magic, payload length and CRC32
+// checksum of the payload, little endian) followed by the payload. The frame
+// magic is used to resynchronize the reader after a corrupted frame.
+const (
+	segmentMagic      = "BDQ1"
+	segmentHeaderSize = int64(len(segmentMagic))
+	frameHeaderSize   = 12
+	segmentSuffix     = ".seg"
+	stateFileName     = "state.json"
+	resyncChunkSize   = 64 * 1024
+)
+
+var (
+	frameMagic = []byte{0xfe, 0xed, 0xbe, 0xa7}
+	crcTable   = crc32.MakeTable(crc32.Castagnoli)
+
+	errInvalidSegment = errors.New("invalid segment header")
+)
+
+// position identifies a byte offset within a segment.
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+func (p position) less(o position) bool {
+	return p.Segment < o.Segment || (p.Segment == o.Segment && p.Offset < o.Offset)
+}
+
+// putFrameHeader computes the frame header for the payload following the
+// header in frame.
+func putFrameHeader(frame []byte) {
+	payload := frame[frameHeaderSize:]
+	copy(frame, frameMagic)
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[8:], crc32.Checksum(payload, crcTable))
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+// listSegments returns the IDs of all segment files in dir, oldest first.
+func listSegments(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, info := range files {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// createSegment creates a new empty segment file and writes the segment
+// header.
+func createSegment(dir string, id uint64, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(segmentPath(dir, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Write([]byte(segmentMagic)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// segmentReader reads frames sequentially from a segment file. Corrupted or
+// truncated frames are skipped by scanning for the next frame magic.
+type segmentReader struct {
+	file   *os.File
+	offset int64
+	header [frameHeaderSize]byte
+	buf    []byte
+}
+
+// openSegment opens the segment file for reading, starting at offset. The
+// segment header is validated if the reader starts at the beginning of the
+// segment. An invalid segment header returns errInvalidSegment together with
+// the reader, such that frames can still be recovered from the segment.
+func openSegment(dir string, id uint64, offset int64) (*segmentReader, error) {
+	f, err := os.Open(segmentPath(dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < segmentHeaderSize {
+		offset = segmentHeaderSize
+	}
+	r := &segmentReader{file: f, offset: offset}
+
+	var magic [len(segmentMagic)]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil || string(magic[:]) != segmentMagic {
+		return r, errInvalidSegment
+	}
+	return r, nil
+}
+
+func (r *segmentReader) Close() error {
+	return r.file.Close()
+}
+
+// next returns the payload of the next valid frame ending before end. The
+// returned payload is only valid until the next call to next. Next returns
+// the number of bytes skipped due to corruption and io.EOF if no more valid
+// frame is available.
+func (r *segmentReader) next(end int64) ([]byte, int64, error) {
+	var skipped int64
+
+	for r.offset < end {
+		payload, err := r.readFrame(end)
+		if err != nil {
+			return nil, skipped, err
+		}
+		if payload != nil {
+			return payload, skipped, nil
+		}
+
+		next, err := r.findMagic(r.offset+1, end)
+		if err != nil {
+			return nil, skipped, err
+		}
+		skipped += next - r.offset
+		r.offset = next
+	}
+
+	return nil, skipped, io.EOF
+}
+
+// readFrame reads and validates the frame at the current offset. It returns a
+// nil payload without error if the frame is corrupted.
+func (r *segmentReader) readFrame(end int64) ([]byte, error) {
+	if end-r.offset < frameHeaderSize {
+		return nil, nil
+	}
+
+	if _, err := r.file.ReadAt(r.header[:], r.offset); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(r.header[:4], frameMagic) {
+		return nil, nil
+	}
+
+	length := int64(binary.LittleEndian.Uint32(r.header[4:]))
+	if length > end-r.offset-frameHeaderSize {
+		return nil, nil
+	}
+
+	if int64(cap(r.buf)) < length {
+		r.buf = make([]byte, length)
+	}
+	payload := r.buf[:length]
+	if _, err := r.file.ReadAt(payload, r.offset+frameHeaderSize); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(r.header[8:]) {
+		return nil, nil
+	}
+
+	r.offset += frameHeaderSize + length
+	return payload, nil
+}
+
+// findMagic returns the offset of the next frame magic in [from, end), or end
+// if no frame magic is found.
+func (r *segmentReader) findMagic(from, end int64) (int64, error) {
+	overlap := int64(len(frameMagic) - 1)
+	chunk := make([]byte, resyncChunkSize)
+
+	for from < end {
+		n := end - from
+		if n > resyncChunkSize {
+			n = resyncChunkSize
+		}
+
+		if _, err := r.file.ReadAt(chunk[:n], from); err != nil {
+			return 0, err
+		}
+		if idx := bytes.Index(chunk[:n], frameMagic); idx >= 0 {
+			return from + int64(idx), nil
+		}
+
+		if from+n >= end {
+			break
+		}
+		from += n - overlap
+	}
+	return end, nil
+}
+
+// readState reads the position of the oldest not yet ACKed frame. Found is
+// false if no state has been written yet.
+func readState(dir string) (pos position, found bool, err error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, stateFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pos, false, nil
+		}
+		return pos, false, err
+	}
+
+	if err := json.Unmarshal(contents, &pos); err != nil {
+		return pos, false, fmt.Errorf("failed to parse queue state: %v", err)
+	}
+	return pos, true, nil
+}
+
+// writeState atomically replaces the state file with the given position. The
+// new file and its directory are synced before returning.
+func writeState(dir string, pos position, mode os.FileMode) error {
+	contents, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, stateFileName)
+	tmp := path + ".new"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return file.SafeFileRotate(path, tmp)
+}
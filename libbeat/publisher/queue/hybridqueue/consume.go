@@ -0,0 +1,101 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"io"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/internal/lanes"
+)
+
+// consumer reads batches from both tiers concurrently, preferring batches
+// read from disk.
+type consumer struct {
+	queue   *hybridQueue
+	forward *lanes.Forwarder
+
+	memBatches  <-chan queue.Batch
+	diskBatches <-chan queue.Batch
+}
+
+func newConsumer(q *hybridQueue) *consumer {
+	forward := lanes.NewForwarder([]lanes.Source{
+		memTier:  {Consumer: q.mem.Consumer()},
+		diskTier: {Consumer: q.disk.Consumer(), OnRead: q.updateSpilling},
+	}, q.unread)
+
+	return &consumer{
+		queue:       q,
+		forward:     forward,
+		memBatches:  forward.Batches(memTier),
+		diskBatches: forward.Batches(diskTier),
+	}
+}
+
+func (c *consumer) Get(sz int) (queue.Batch, error) {
+	if c.forward.Closed() {
+		return nil, io.EOF
+	}
+
+	// batches read by a closed consumer come first
+	if batch := c.queue.unread.Take(); batch != nil {
+		return batch, nil
+	}
+
+	c.forward.Start(sz)
+
+	for c.memBatches != nil || c.diskBatches != nil {
+		// drain the disk first
+		select {
+		case batch, ok := <-c.diskBatches:
+			if !ok {
+				c.diskBatches = nil
+				continue
+			}
+			return batch, nil
+		default:
+		}
+
+		select {
+		case batch, ok := <-c.diskBatches:
+			if !ok {
+				c.diskBatches = nil
+				continue
+			}
+			return batch, nil
+		case batch, ok := <-c.memBatches:
+			if !ok {
+				c.memBatches = nil
+				continue
+			}
+			return batch, nil
+		case <-c.queue.unread.Signal():
+			if batch := c.queue.unread.Take(); batch != nil {
+				return batch, nil
+			}
+		case <-c.forward.Done():
+			return nil, io.EOF
+		}
+	}
+	return nil, io.EOF
+}
+
+func (c *consumer) Close() error {
+	return c.forward.Close()
+}
@@ -0,0 +1,106 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/internal/lanes"
+)
+
+// producer publishes events to the memory tier, or to the disk tier if the
+// queue is spilling. ACKs of both tiers are combined, such that the ACK
+// handler of the producer sees events being ACKed in publish order.
+type producer struct {
+	queue *hybridQueue
+	mem   queue.Producer
+	disk  queue.Producer
+
+	acks         *lanes.ACKTracker
+	dropOnCancel bool
+}
+
+const (
+	memTier = iota
+	diskTier
+	numTiers
+)
+
+func newProducer(q *hybridQueue, cfg queue.ProducerConfig) *producer {
+	p := &producer{
+		queue:        q,
+		acks:         lanes.NewACKTracker(numTiers, cfg.ACK),
+		dropOnCancel: cfg.DropOnCancel,
+	}
+
+	memCfg, diskCfg := cfg, cfg
+	if cfg.ACK != nil {
+		memCfg.ACK = func(n int) { p.acks.ACK(memTier, n) }
+		diskCfg.ACK = func(n int) { p.acks.ACK(diskTier, n) }
+	}
+	p.mem = q.mem.Producer(memCfg)
+	p.disk = q.disk.Producer(diskCfg)
+	return p
+}
+
+func (p *producer) Publish(event publisher.Event) bool {
+	return p.publish(event, p.disk.Publish)
+}
+
+func (p *producer) TryPublish(event publisher.Event) bool {
+	return p.publish(event, p.disk.TryPublish)
+}
+
+func (p *producer) publish(event publisher.Event, toDisk func(publisher.Event) bool) bool {
+	q := p.queue
+
+	if !q.spilling() && !q.stalled() {
+		p.acks.Track(memTier)
+		if p.mem.TryPublish(event) {
+			if q.memEvents.Inc() == 1 {
+				// the spill timeout starts with the first event in memory
+				q.madeProgress()
+			}
+			return true
+		}
+		p.acks.Untrack()
+	}
+
+	p.acks.Track(diskTier)
+	if toDisk(event) {
+		q.diskEvents.Inc()
+		q.spilledEvent()
+		return true
+	}
+	p.acks.Untrack()
+	return false
+}
+
+func (p *producer) Cancel() int {
+	memDropped := p.mem.Cancel()
+	diskDropped := p.disk.Cancel()
+	p.queue.memEvents.Sub(int64(memDropped))
+	p.queue.diskEvents.Sub(int64(diskDropped))
+
+	if p.dropOnCancel {
+		// ACKs of events still being processed by the outputs are discarded
+		// by the pipeline client.
+		p.acks.Reset()
+	}
+	return memDropped + diskDropped
+}
@@ -0,0 +1,276 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package hybridqueue provides a queue.Queue buffering events in memory,
+// spilling events to disk if the memory buffer is full.
+//
+// In normal operation events are passed through the memory queue. Once the
+// memory queue is full, or the output has not ACKed any events for the spill
+// timeout, new events are written to a disk queue. Events keep being written
+// to disk until all events on disk have been read by the consumer. The consumer
+// prefers events from the disk queue, such that the disk is drained first
+// once the output recovers.
+package hybridqueue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/joeshaw/multierror"
+
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/common/cfgwarn"
+	"github.com/snappyflow/beats/v7/libbeat/feature"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/internal/lanes"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
+)
+
+// Settings configures the memory and disk tier of a hybrid queue. The
+// ACKListener of the tier settings is ignored.
+type Settings struct {
+	ACKListener queue.ACKListener
+	Mem         memqueue.Settings
+	Disk        diskqueue.Settings
+
+	// SpillTimeout makes the queue spill events to disk if events are
+	// buffered in memory, but the output has not ACKed any events for the
+	// given duration. The memory tier is only spilled once full if zero.
+	SpillTimeout time.Duration
+}
+
+type hybridQueue struct {
+	log      *logp.Logger
+	listener queue.ACKListener
+
+	mem         queue.Queue
+	disk        diskqueue.Queue
+	memCapacity int
+
+	memEvents  atomic.Int64  // events buffered in memory
+	diskEvents atomic.Int64  // events spilled to disk, not yet ACKed
+	spilled    atomic.Uint64 // total number of events spilled to disk
+
+	// progress holds the time in nanoseconds events have been ACKed last,
+	// or the memory tier has received events after being empty. The queue
+	// spills to disk if there is no progress for spillTimeout.
+	spillTimeout time.Duration
+	progress     atomic.Int64
+
+	// spill caches if the disk holds events not read by the consumer yet. It
+	// is updated with spillMu held, after writing to the disk and after
+	// reading from the disk.
+	spillMu sync.Mutex
+	spill   atomic.Bool
+
+	// batches read by a consumer that has been closed before returning them
+	unread *lanes.Unread
+}
+
+// tierListener keeps track of the events buffered by a tier and forwards
+// ACKs to the queue ACKListener.
+type tierListener struct {
+	queue  *hybridQueue
+	events *atomic.Int64
+}
+
+func init() {
+	queue.RegisterQueueType(
+		"hybrid",
+		create,
+		feature.MakeDetails(
+			"Hybrid queue",
+			"Buffer events in memory, spilling events to disk if memory is full.",
+			feature.Beta))
+}
+
+func create(
+	ackListener queue.ACKListener, logger *logp.Logger, cfg *common.Config,
+) (queue.Queue, error) {
+	cfgwarn.Beta("The hybrid queue is beta")
+
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	diskSettings, err := diskqueue.SettingsFromConfig(config.Disk)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewQueue(logger, Settings{
+		ACKListener: ackListener,
+		Mem: memqueue.Settings{
+			Events:         config.Mem.Events,
+			FlushMinEvents: config.Mem.FlushMinEvents,
+			FlushTimeout:   config.Mem.FlushTimeout,
+		},
+		Disk:         diskSettings,
+		SpillTimeout: config.SpillTimeout,
+	})
+}
+
+// NewQueue creates a hybrid queue from a memory queue and a disk queue.
+func NewQueue(logger *logp.Logger, settings Settings) (queue.Queue, error) {
+	if logger == nil {
+		logger = logp.NewLogger("hybridqueue")
+	}
+
+	q := &hybridQueue{
+		log:         logger,
+		listener:    settings.ACKListener,
+		memCapacity: settings.Mem.Events,
+		unread:      lanes.NewUnread(),
+
+		spillTimeout: settings.SpillTimeout,
+	}
+
+	diskSettings := settings.Disk
+	diskSettings.ACKListener = &tierListener{queue: q, events: &q.diskEvents}
+	disk, err := diskqueue.NewQueue(logger.Named("disk"), diskSettings)
+	if err != nil {
+		return nil, err
+	}
+	q.disk = disk
+
+	memSettings := settings.Mem
+	memSettings.ACKListener = &tierListener{queue: q, events: &q.memEvents}
+	q.mem = memqueue.NewQueue(logger.Named("mem"), memSettings)
+
+	// events left on disk by the previous run are read first
+	q.updateSpilling()
+
+	return q, nil
+}
+
+// Close shuts down both tiers. Events buffered in memory are lost, events
+// spilled to disk are read again after restart.
+func (q *hybridQueue) Close() error {
+	var errs multierror.Errors
+	if err := q.mem.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := q.disk.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.Err()
+}
+
+func (q *hybridQueue) BufferConfig() queue.BufferConfig {
+	return queue.BufferConfig{MaxEvents: -1}
+}
+
+func (q *hybridQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	return newProducer(q, cfg)
+}
+
+func (q *hybridQueue) Consumer() queue.Consumer {
+	return newConsumer(q)
+}
+
+// spilling reports if events must be written to disk, because the disk still
+// holds events not read by the consumer yet.
+func (q *hybridQueue) spilling() bool {
+	return q.spill.Load()
+}
+
+// stalled reports if events are buffered in memory, but the output has not
+// ACKed any events for the spill timeout, for example because it is
+// unavailable.
+func (q *hybridQueue) stalled() bool {
+	if q.spillTimeout <= 0 || q.memEvents.Load() == 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, q.progress.Load())) > q.spillTimeout
+}
+
+// madeProgress resets the spill timeout.
+func (q *hybridQueue) madeProgress() {
+	q.progress.Store(time.Now().UnixNano())
+}
+
+// spilled marks the queue as spilling after an event has been written to disk.
+func (q *hybridQueue) spilledEvent() {
+	q.spilled.Inc()
+
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+	q.spill.Store(true)
+}
+
+// updateSpilling reads the fill level of the disk after events have been read
+// from disk.
+func (q *hybridQueue) updateSpilling() {
+	q.spillMu.Lock()
+	defer q.spillMu.Unlock()
+	q.spill.Store(q.disk.Stats().UnreadBytes > 0)
+}
+
+// RegisterMetrics reports the fill level of the memory and the disk tier.
+func (q *hybridQueue) RegisterMetrics(reg *monitoring.Registry) {
+	reg.Remove("mem")
+	reg.Remove("disk")
+
+	monitoring.NewFunc(reg, "mem", q.reportMem, monitoring.Report)
+	monitoring.NewFunc(reg, "disk", q.reportDisk, monitoring.Report)
+}
+
+func (q *hybridQueue) reportMem(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	events := q.memEvents.Load()
+	monitoring.ReportInt(V, "events", events)
+	monitoring.ReportInt(V, "max_events", int64(q.memCapacity))
+	monitoring.ReportFloat(V, "fill_pct", fillPct(uint64(events), uint64(q.memCapacity)))
+}
+
+func (q *hybridQueue) reportDisk(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	stats := q.disk.Stats()
+	monitoring.ReportInt(V, "events", q.diskEvents.Load())
+	monitoring.ReportInt(V, "spilled", int64(q.spilled.Load()))
+	monitoring.ReportInt(V, "segments", int64(stats.Segments))
+	monitoring.ReportInt(V, "bytes", int64(stats.Size))
+	monitoring.ReportInt(V, "max_bytes", int64(stats.MaxSize))
+	monitoring.ReportFloat(V, "fill_pct", fillPct(stats.Size, stats.MaxSize))
+}
+
+func fillPct(used, capacity uint64) float64 {
+	if capacity == 0 {
+		return 0
+	}
+	if used > capacity {
+		return 1
+	}
+	return float64(used) / float64(capacity)
+}
+
+func (l *tierListener) OnACK(n int) {
+	l.events.Sub(int64(n))
+	l.queue.madeProgress()
+	if listener := l.queue.listener; listener != nil {
+		listener.OnACK(n)
+	}
+}
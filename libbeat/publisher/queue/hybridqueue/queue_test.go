@@ -0,0 +1,253 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package hybridqueue
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/queuetest"
+)
+
+var seed int64
+
+func init() {
+	flag.Int64Var(&seed, "seed", time.Now().UnixNano(), "test random seed")
+}
+
+func TestProduceConsumer(t *testing.T) {
+	maxEvents := 4096
+	minEvents := 32
+
+	rand.Seed(seed)
+	events := rand.Intn(maxEvents-minEvents) + minEvents
+	batchSize := rand.Intn(events-8) + 4
+
+	t.Log("seed: ", seed)
+	t.Log("events: ", events)
+	t.Log("batchSize: ", batchSize)
+
+	factory := func(t *testing.T) queue.Queue {
+		return newTestQueue(t, nil, 0)
+	}
+
+	t.Run("single", func(t *testing.T) {
+		queuetest.TestSingleProducerConsumer(t, events, batchSize, factory)
+	})
+	t.Run("multi", func(t *testing.T) {
+		queuetest.TestMultiProducerConsumer(t, events, batchSize, factory)
+	})
+}
+
+func TestSpillToDisk(t *testing.T) {
+	listener := &queuetest.CountingListener{}
+	q := newTestQueue(t, listener, 0)
+	defer q.Close()
+
+	reg := monitoring.NewRegistry()
+	q.(queue.MetricsReporter).RegisterMetrics(reg)
+
+	var acked atomic.Int64
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked.Add(int64(n)) }})
+	for i := 0; i < 200; i++ {
+		require.True(t, producer.Publish(makeEvent(i)))
+	}
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	memEvents := snapshot.Ints["mem.events"]
+	assert.True(t, memEvents > 0 && memEvents < 200, "events in memory: %v", memEvents)
+	assert.Equal(t, 200-memEvents, snapshot.Ints["disk.events"])
+	assert.Equal(t, 200-memEvents, snapshot.Ints["disk.spilled"])
+	assert.Equal(t, int64(32), snapshot.Ints["mem.max_events"])
+	assert.True(t, snapshot.Floats["mem.fill_pct"] > 0)
+	assert.True(t, snapshot.Floats["disk.fill_pct"] > 0)
+
+	consumer := q.Consumer()
+	defer consumer.Close()
+
+	seen := map[int]bool{}
+	for len(seen) < 200 {
+		batch, err := consumer.Get(50)
+		require.NoError(t, err)
+		for _, event := range batch.Events() {
+			v, err := event.Content.Fields.GetValue("value")
+			require.NoError(t, err)
+			seen[toInt(v)] = true
+		}
+		batch.ACK()
+	}
+
+	queuetest.WaitUntil(t, func() bool { return acked.Load() == 200 && listener.Count() == 200 })
+	snapshot = monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(0), snapshot.Ints["mem.events"])
+	assert.Equal(t, int64(0), snapshot.Ints["disk.events"])
+
+	// the disk has been drained, new events are buffered in memory again
+	require.True(t, producer.Publish(makeEvent(200)))
+	snapshot = monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["mem.events"])
+	assert.Equal(t, 200-memEvents, snapshot.Ints["disk.spilled"])
+}
+
+func TestConsumerCloseKeepsBatches(t *testing.T) {
+	q := newTestQueue(t, nil, 0)
+	defer q.Close()
+
+	var acked atomic.Int64
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked.Add(int64(n)) }})
+	for i := 0; i < 10; i++ {
+		require.True(t, producer.Publish(makeEvent(i)))
+	}
+
+	consumer := q.Consumer()
+	batch, err := consumer.Get(5)
+	require.NoError(t, err)
+	batches := []queue.Batch{batch}
+
+	// give the consumer time to read the next batch before closing it
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, consumer.Close())
+
+	consumer = q.Consumer()
+	defer consumer.Close()
+
+	count := len(batch.Events())
+	for count < 10 {
+		batch, err := consumer.Get(5)
+		require.NoError(t, err)
+		batches = append(batches, batch)
+		count += len(batch.Events())
+	}
+	for _, batch := range batches {
+		batch.ACK()
+	}
+	queuetest.WaitUntil(t, func() bool { return acked.Load() == 10 })
+}
+
+func TestSpillOnStalledOutput(t *testing.T) {
+	q := newTestQueue(t, nil, 100*time.Millisecond)
+	defer q.Close()
+
+	reg := monitoring.NewRegistry()
+	q.(queue.MetricsReporter).RegisterMetrics(reg)
+	snapshot := func() monitoring.FlatSnapshot {
+		return monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	}
+
+	var acked atomic.Int64
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked.Add(int64(n)) }})
+	require.True(t, producer.Publish(makeEvent(0)))
+	require.True(t, producer.Publish(makeEvent(1)))
+	assert.Equal(t, int64(2), snapshot().Ints["mem.events"])
+
+	// the output reads events, but does not ACK them
+	consumer := q.Consumer()
+	defer consumer.Close()
+	var stalled []queue.Batch
+	for count := 0; count < 2; {
+		batch, err := consumer.Get(2)
+		require.NoError(t, err)
+		stalled = append(stalled, batch)
+		count += len(batch.Events())
+	}
+
+	// the memory tier has room, but the events are spilled to disk once the
+	// output has not ACKed events for the spill timeout
+	time.Sleep(150 * time.Millisecond)
+	require.True(t, producer.Publish(makeEvent(2)))
+	assert.Equal(t, int64(2), snapshot().Ints["mem.events"])
+	assert.Equal(t, int64(1), snapshot().Ints["disk.spilled"])
+
+	// once the output recovers, the events on disk are read and new events
+	// are buffered in memory again
+	for _, batch := range stalled {
+		batch.ACK()
+	}
+	batch, err := consumer.Get(2)
+	require.NoError(t, err)
+	require.Len(t, batch.Events(), 1)
+	assert.Equal(t, 2, toInt(batch.Events()[0].Content.Fields["value"]))
+	batch.ACK()
+	queuetest.WaitUntil(t, func() bool { return acked.Load() == 3 })
+
+	require.True(t, producer.Publish(makeEvent(3)))
+	assert.Equal(t, int64(1), snapshot().Ints["mem.events"])
+	assert.Equal(t, int64(1), snapshot().Ints["disk.spilled"])
+}
+
+func newTestQueue(t *testing.T, listener queue.ACKListener, spillTimeout time.Duration) queue.Queue {
+	path, err := ioutil.TempDir("", "hybridqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(path) })
+
+	q, err := NewQueue(nil, Settings{
+		ACKListener: listener,
+		Mem: memqueue.Settings{
+			Events:         32,
+			FlushMinEvents: 1,
+		},
+		Disk: diskqueue.Settings{
+			Path:        path,
+			MaxSize:     1024 * 1024,
+			SegmentSize: 16 * 1024,
+		},
+		SpillTimeout: spillTimeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func makeEvent(value int) publisher.Event {
+	return publisher.Event{
+		Content: beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"value": value},
+		},
+	}
+}
+
+func toInt(v interface{}) int {
+	i, err := strconv.Atoi(fmt.Sprint(v))
+	if err != nil {
+		return -1
+	}
+	return i
+}
@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package lanes provides the building blocks shared by queues that combine
+// multiple inner queues, the lanes, into a single queue.
+package lanes
+
+import "sync"
+
+// ACKTracker combines the ACKs of multiple lanes, such that the ACK handler
+// of a producer sees events being ACKed in publish order. A tracker created
+// without ACK handler does not track events.
+type ACKTracker struct {
+	onACK func(int)
+
+	mu      sync.Mutex
+	pending []run // lanes of events not yet ACKed, in publish order
+	acked   []int // ACKs received per lane, not yet reported
+}
+
+// run is a sequence of consecutive events published to the same lane.
+type run struct {
+	lane  int
+	count int
+}
+
+// NewACKTracker creates a tracker for numLanes lanes, reporting ACKs to onACK.
+func NewACKTracker(numLanes int, onACK func(int)) *ACKTracker {
+	return &ACKTracker{
+		onACK: onACK,
+		acked: make([]int, numLanes),
+	}
+}
+
+// Track adds an event to the pending list before publishing the event, such
+// that an ACK received right after publishing can be accounted for.
+func (t *ACKTracker) Track(lane int) {
+	if t.onACK == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if n := len(t.pending); n > 0 && t.pending[n-1].lane == lane {
+		t.pending[n-1].count++
+	} else {
+		t.pending = append(t.pending, run{lane: lane, count: 1})
+	}
+}
+
+// Untrack removes the last event from the pending list if publishing the
+// event failed. The event can not have been ACKed yet.
+func (t *ACKTracker) Untrack() {
+	if t.onACK == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.pending)
+	if n == 0 {
+		return
+	}
+	if t.pending[n-1].count--; t.pending[n-1].count == 0 {
+		t.pending = t.pending[:n-1]
+	}
+}
+
+// ACK records n events ACKed by a lane, reporting all events ACKed in publish
+// order.
+func (t *ACKTracker) ACK(lane, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.acked[lane] += n
+
+	count := 0
+	for len(t.pending) > 0 {
+		r := &t.pending[0]
+		k := r.count
+		if acked := t.acked[r.lane]; acked < k {
+			k = acked
+		}
+		if k == 0 {
+			break
+		}
+
+		r.count -= k
+		t.acked[r.lane] -= k
+		count += k
+		if r.count > 0 {
+			break
+		}
+		t.pending = t.pending[1:]
+	}
+
+	if count > 0 {
+		t.onACK(count)
+	}
+}
+
+// Reset discards all pending events, after the producer has been cancelled.
+func (t *ACKTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = nil
+	for i := range t.acked {
+		t.acked[i] = 0
+	}
+}
@@ -0,0 +1,168 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package lanes
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+)
+
+// Source is a lane read by a Forwarder.
+type Source struct {
+	Consumer queue.Consumer
+
+	// OnRead is run after each batch read from the lane, if set.
+	OnRead func()
+}
+
+// Forwarder reads batches from all lanes concurrently, passing the batches
+// to a channel per lane. Batches read while the forwarder is closing are
+// handed back to the Unread store, so their events are not lost.
+type Forwarder struct {
+	sources []Source
+	batches []chan queue.Batch
+	unread  *Unread
+
+	batchSize atomic.Int64
+	start     sync.Once
+	done      chan struct{}
+	closed    atomic.Bool
+}
+
+// NewForwarder creates a forwarder reading from sources. Batches not passed
+// on before Close are put into unread.
+func NewForwarder(sources []Source, unread *Unread) *Forwarder {
+	f := &Forwarder{
+		sources: sources,
+		batches: make([]chan queue.Batch, len(sources)),
+		unread:  unread,
+		done:    make(chan struct{}),
+	}
+	for i := range sources {
+		f.batches[i] = make(chan queue.Batch)
+	}
+	return f
+}
+
+// Start sets the batch size to read from the lanes, starting to read the
+// lanes on first use.
+func (f *Forwarder) Start(batchSize int) {
+	f.batchSize.Store(int64(batchSize))
+	f.start.Do(func() {
+		for i, s := range f.sources {
+			go f.forward(s, f.batches[i])
+		}
+	})
+}
+
+// Batches returns the channel of batches read from lane i. The channel is
+// closed once reading the lane fails.
+func (f *Forwarder) Batches(i int) <-chan queue.Batch {
+	return f.batches[i]
+}
+
+// Done returns a channel closed by Close.
+func (f *Forwarder) Done() <-chan struct{} {
+	return f.done
+}
+
+// Closed reports if the forwarder has been closed.
+func (f *Forwarder) Closed() bool {
+	return f.closed.Load()
+}
+
+// Close stops reading and closes the consumers of all lanes.
+func (f *Forwarder) Close() error {
+	if f.closed.Swap(true) {
+		return errors.New("already closed")
+	}
+
+	close(f.done)
+	for _, s := range f.sources {
+		s.Consumer.Close()
+	}
+	return nil
+}
+
+// forward reads batches from a lane until the forwarder is closed.
+func (f *Forwarder) forward(from Source, to chan<- queue.Batch) {
+	defer close(to)
+
+	for {
+		batch, err := from.Consumer.Get(int(f.batchSize.Load()))
+		if err != nil {
+			return
+		}
+		if from.OnRead != nil {
+			from.OnRead()
+		}
+
+		select {
+		case to <- batch:
+		case <-f.done:
+			f.unread.Put(batch)
+			return
+		}
+	}
+}
+
+// Unread holds batches read from the lanes by a consumer that has been
+// closed before returning them, to be returned by the next consumer.
+type Unread struct {
+	mu      sync.Mutex
+	batches []queue.Batch
+	signal  chan struct{}
+}
+
+// NewUnread creates an empty store.
+func NewUnread() *Unread {
+	return &Unread{signal: make(chan struct{}, 1)}
+}
+
+// Put keeps a batch, waking up a consumer waiting on Signal.
+func (u *Unread) Put(batch queue.Batch) {
+	u.mu.Lock()
+	u.batches = append(u.batches, batch)
+	u.mu.Unlock()
+
+	select {
+	case u.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Take returns a batch kept by Put, or nil.
+func (u *Unread) Take() queue.Batch {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.batches) == 0 {
+		return nil
+	}
+	batch := u.batches[0]
+	u.batches = u.batches[1:]
+	return batch
+}
+
+// Signal returns a channel receiving a value after batches have been put.
+func (u *Unread) Signal() <-chan struct{} {
+	return u.signal
+}
@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package lanes
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/queuetest"
+)
+
+func TestACKTrackerOrder(t *testing.T) {
+	var acked []int
+	tracker := NewACKTracker(3, func(n int) { acked = append(acked, n) })
+
+	// 2 events on lane 0, 3 on lane 2, 1 on lane 0
+	tracker.Track(0)
+	tracker.Track(0)
+	tracker.Track(2)
+	tracker.Track(2)
+	tracker.Track(2)
+	tracker.Track(0)
+
+	tracker.ACK(2, 3)
+	assert.Empty(t, acked)
+
+	tracker.ACK(0, 1)
+	assert.Equal(t, []int{1}, acked)
+
+	tracker.ACK(0, 2)
+	assert.Equal(t, []int{1, 5}, acked)
+	assert.Empty(t, tracker.pending)
+}
+
+func TestACKTrackerUntrack(t *testing.T) {
+	var acked int
+	tracker := NewACKTracker(2, func(n int) { acked += n })
+
+	tracker.Track(0)
+	tracker.Track(1)
+	tracker.Untrack()
+	tracker.Track(0)
+
+	tracker.ACK(0, 2)
+	assert.Equal(t, 2, acked)
+	assert.Empty(t, tracker.pending)
+}
+
+func TestACKTrackerWithoutHandler(t *testing.T) {
+	tracker := NewACKTracker(2, nil)
+	tracker.Track(0)
+	tracker.Untrack()
+	assert.Empty(t, tracker.pending)
+}
+
+func TestForwarderHandsBackBatches(t *testing.T) {
+	source := &testConsumer{batches: make(chan queue.Batch, 2), done: make(chan struct{})}
+	source.batches <- &testBatch{id: 1}
+	source.batches <- &testBatch{id: 2}
+
+	var reads int
+	unread := NewUnread()
+	forwarder := NewForwarder([]Source{{Consumer: source, OnRead: func() { reads++ }}}, unread)
+	forwarder.Start(10)
+
+	first := <-forwarder.Batches(0)
+	require.Equal(t, &testBatch{id: 1}, first)
+
+	// the second batch is read, but not taken before closing
+	queuetest.WaitUntil(t, func() bool { return len(source.batches) == 0 })
+	require.NoError(t, forwarder.Close())
+	assert.Error(t, forwarder.Close())
+
+	<-unread.Signal()
+	assert.Equal(t, &testBatch{id: 2}, unread.Take())
+	assert.Nil(t, unread.Take())
+	assert.Equal(t, 2, reads)
+
+	_, ok := <-forwarder.Batches(0)
+	assert.False(t, ok)
+}
+
+type testConsumer struct {
+	batches chan queue.Batch
+	done    chan struct{}
+}
+
+func (c *testConsumer) Get(int) (queue.Batch, error) {
+	select {
+	case batch := <-c.batches:
+		return batch, nil
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
+
+func (c *testConsumer) Close() error {
+	close(c.done)
+	return nil
+}
+
+type testBatch struct{ id int }
+
+func (*testBatch) Events() []publisher.Event { return nil }
+func (*testBatch) ACK()                      {}
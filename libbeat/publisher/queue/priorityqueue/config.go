@@ -0,0 +1,83 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package priorityqueue
+
+import (
+	"errors"
+	"time"
+)
+
+type config struct {
+	Events         int             `config:"events" validate:"min=32"`
+	FlushMinEvents int             `config:"flush.min_events" validate:"min=0"`
+	FlushTimeout   time.Duration   `config:"flush.timeout"`
+	Weights        weightsConfig   `config:"weights"`
+	DropWatermark  watermarkConfig `config:"drop_watermark"`
+}
+
+// weightsConfig sets the share of batches forwarded to the outputs per
+// priority class.
+type weightsConfig struct {
+	High   int `config:"high" validate:"min=1"`
+	Normal int `config:"normal" validate:"min=1"`
+	Low    int `config:"low" validate:"min=1"`
+}
+
+// watermarkConfig sets the fill level of the queue from which on events of
+// a priority class are not accepted, keeping room for events of higher
+// priority classes.
+type watermarkConfig struct {
+	Normal float64 `config:"normal"`
+	Low    float64 `config:"low"`
+}
+
+func defaultConfig() config {
+	return config{
+		Events:         4 * 1024,
+		FlushMinEvents: 2 * 1024,
+		FlushTimeout:   1 * time.Second,
+		Weights: weightsConfig{
+			High:   8,
+			Normal: 4,
+			Low:    1,
+		},
+		DropWatermark: watermarkConfig{
+			Normal: 0.9,
+			Low:    0.6,
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	if c.FlushMinEvents > c.Events {
+		return errors.New("flush.min_events must be less events")
+	}
+
+	return nil
+}
+
+func (c *watermarkConfig) Validate() error {
+	if c.Low <= 0 || c.Normal > 1 {
+		return errors.New("drop_watermark must be between 0 and 1")
+	}
+	if c.Low > c.Normal {
+		return errors.New("drop_watermark.low must be less than drop_watermark.normal")
+	}
+
+	return nil
+}
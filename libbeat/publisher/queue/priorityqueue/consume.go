@@ -0,0 +1,200 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package priorityqueue
+
+import (
+	"io"
+	"sync"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/internal/lanes"
+)
+
+// consumer reads batches from all lanes concurrently. If batches of multiple
+// lanes are available, the lanes are selected using smooth weighted round
+// robin, such that each lane gets its share of batches according to its
+// weight, while lanes without events do not delay other lanes.
+type consumer struct {
+	queue   *priorityQueue
+	forward *lanes.Forwarder
+	batches [numLanes]<-chan queue.Batch
+
+	mu      sync.Mutex
+	ready   [numLanes]queue.Batch // batches read from the lanes, not yet returned
+	current [numLanes]int         // round robin state per lane
+}
+
+func newConsumer(q *priorityQueue) *consumer {
+	var sources []lanes.Source
+	for _, l := range q.lanes {
+		sources = append(sources, lanes.Source{Consumer: l.queue.Consumer()})
+	}
+
+	c := &consumer{
+		queue:   q,
+		forward: lanes.NewForwarder(sources, q.unread),
+	}
+	for i := range c.batches {
+		c.batches[i] = c.forward.Batches(i)
+	}
+	return c
+}
+
+func (c *consumer) Get(sz int) (queue.Batch, error) {
+	if c.forward.Closed() {
+		return nil, io.EOF
+	}
+
+	// batches read by a closed consumer come first
+	if batch := c.queue.unread.Take(); batch != nil {
+		return batch, nil
+	}
+
+	c.forward.Start(sz)
+
+	for !c.poll() {
+		batch, ok := c.wait()
+		if !ok {
+			return nil, io.EOF
+		}
+		if batch != nil {
+			return batch, nil
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the ready batches have been handed back by Close
+	if c.forward.Closed() {
+		return nil, io.EOF
+	}
+
+	i := c.next()
+	batch := c.ready[i]
+	c.ready[i] = nil
+	return batch, nil
+}
+
+// poll collects the batches available without blocking. It returns true if
+// any batch is ready.
+func (c *consumer) poll() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found := false
+	for i, ch := range c.batches {
+		if c.ready[i] == nil && ch != nil {
+			select {
+			case batch, ok := <-ch:
+				if ok {
+					c.keep(i, batch)
+				} else {
+					c.batches[i] = nil
+				}
+			default:
+			}
+		}
+		found = found || c.ready[i] != nil
+	}
+	return found
+}
+
+// wait blocks until a batch is available from any lane. It returns false if
+// the consumer is closed or all lanes are closed. A batch handed back by a
+// closed consumer is returned directly.
+func (c *consumer) wait() (queue.Batch, bool) {
+	var (
+		batch queue.Batch
+		ok    bool
+		i     int
+	)
+
+	if c.batches[0] == nil && c.batches[1] == nil && c.batches[2] == nil {
+		return nil, false
+	}
+
+	select {
+	case batch, ok = <-c.batches[0]:
+		i = 0
+	case batch, ok = <-c.batches[1]:
+		i = 1
+	case batch, ok = <-c.batches[2]:
+		i = 2
+	case <-c.queue.unread.Signal():
+		return c.queue.unread.Take(), true
+	case <-c.forward.Done():
+		return nil, false
+	}
+
+	if ok {
+		c.mu.Lock()
+		c.keep(i, batch)
+		c.mu.Unlock()
+	} else {
+		c.batches[i] = nil
+	}
+	return nil, true
+}
+
+// keep stores a batch read from a lane, or hands the batch back if the
+// consumer has been closed meanwhile. The lock must be held.
+func (c *consumer) keep(i int, batch queue.Batch) {
+	if c.forward.Closed() {
+		c.queue.unread.Put(batch)
+		return
+	}
+	c.ready[i] = batch
+}
+
+// next selects the lane to return a batch from, among the lanes having a
+// batch ready. The lock must be held.
+func (c *consumer) next() int {
+	total, best := 0, -1
+	for i, l := range c.queue.lanes {
+		if c.ready[i] == nil {
+			continue
+		}
+
+		c.current[i] += l.weight
+		total += l.weight
+		if best < 0 || c.current[i] > c.current[best] {
+			best = i
+		}
+	}
+	c.current[best] -= total
+	return best
+}
+
+func (c *consumer) Close() error {
+	if err := c.forward.Close(); err != nil {
+		return err
+	}
+
+	// batches read from the lanes, but not returned yet, are kept for the
+	// next consumer
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, batch := range c.ready {
+		if batch != nil {
+			c.queue.unread.Put(batch)
+			c.ready[i] = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package priorityqueue
+
+import (
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/internal/lanes"
+)
+
+// producer publishes events to the lane of their priority class. ACKs of all
+// lanes are combined, such that the ACK handler of the producer sees events
+// being ACKed in publish order.
+type producer struct {
+	queue     *priorityQueue
+	priority  beat.Priority
+	producers [numLanes]queue.Producer
+
+	acks         *lanes.ACKTracker
+	dropOnCancel bool
+	cancelled    bool // protected by the queue mutex
+}
+
+func newProducer(q *priorityQueue, cfg queue.ProducerConfig) *producer {
+	p := &producer{
+		queue:        q,
+		priority:     cfg.Priority,
+		acks:         lanes.NewACKTracker(numLanes, cfg.ACK),
+		dropOnCancel: cfg.DropOnCancel,
+	}
+
+	for i, l := range q.lanes {
+		i, l := i, l
+
+		laneCfg := cfg
+		if cfg.ACK != nil {
+			laneCfg.ACK = func(n int) { p.acks.ACK(i, n) }
+		}
+		// events dropped by the lane after the producer has been cancelled
+		// are not ACKed.
+		laneCfg.OnDrop = func(event beat.Event) {
+			q.release(l, 1)
+			if cfg.OnDrop != nil {
+				cfg.OnDrop(event)
+			}
+		}
+		p.producers[i] = l.queue.Producer(laneCfg)
+	}
+	return p
+}
+
+func (p *producer) Publish(event publisher.Event) bool {
+	return p.publish(event, true)
+}
+
+func (p *producer) TryPublish(event publisher.Event) bool {
+	return p.publish(event, false)
+}
+
+func (p *producer) publish(event publisher.Event, block bool) bool {
+	q := p.queue
+	i := laneOf(p.priority, &event)
+	l := q.lanes[i]
+
+	if !q.reserve(l, block, &p.cancelled) {
+		return false
+	}
+
+	// The lanes can hold all events the queue has reserved capacity for, so
+	// publishing to the lane does not block for long.
+	p.acks.Track(i)
+	ok := p.producers[i].Publish(event)
+	if !ok {
+		p.acks.Untrack()
+		q.release(l, 1)
+	}
+	return ok
+}
+
+func (p *producer) Cancel() int {
+	q := p.queue
+
+	// wake up Publish calls waiting for the queue to have room
+	q.mu.Lock()
+	p.cancelled = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	dropped := 0
+	for i, producer := range p.producers {
+		n := producer.Cancel()
+		q.release(q.lanes[i], n)
+		dropped += n
+	}
+
+	if p.dropOnCancel {
+		// ACKs of events still being processed by the outputs are discarded
+		// by the pipeline client.
+		p.acks.Reset()
+	}
+	return dropped
+}
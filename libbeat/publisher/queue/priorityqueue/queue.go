@@ -0,0 +1,303 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package priorityqueue provides a queue.Queue buffering events in memory,
+// with one lane per priority class.
+//
+// Events are published to the lane of their priority class. The priority is
+// read from @metadata.priority, falling back to the priority of the
+// producer. The consumer reads batches from all lanes using weighted round
+// robin, such that a flood of low priority events can not delay high
+// priority events. Events are accepted until the queue has filled up to the
+// drop watermark of the event's priority class, keeping room for events of
+// higher priority classes. Producers not waiting for the queue drop events
+// above the watermark, other producers wait for the queue to drain below the
+// watermark.
+package priorityqueue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/joeshaw/multierror"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/beat/events"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/common/cfgwarn"
+	"github.com/snappyflow/beats/v7/libbeat/feature"
+	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/internal/lanes"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
+)
+
+// Settings configures a priority queue. The queue holds up to Events events
+// across all priority classes.
+type Settings struct {
+	ACKListener    queue.ACKListener
+	Events         int
+	FlushMinEvents int
+	FlushTimeout   time.Duration
+
+	// Weights sets the share of batches forwarded to the outputs per
+	// priority class. Classes not set use a weight of 1.
+	Weights map[beat.Priority]int
+
+	// DropWatermarks sets the fill level of the queue, between 0 and 1, up to
+	// which events of a priority class are accepted. Above the watermark
+	// TryPublish rejects events of the class and Publish waits. Classes not
+	// set accept events until the queue is full.
+	DropWatermarks map[beat.Priority]float64
+}
+
+type priorityQueue struct {
+	log      *logp.Logger
+	listener queue.ACKListener
+	capacity int
+	lanes    [numLanes]*lane
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int // events buffered in all lanes
+	closed bool
+
+	// batches read by a consumer that has been closed before returning them
+	unread *lanes.Unread
+}
+
+// lane buffers the events of a single priority class.
+type lane struct {
+	priority beat.Priority
+	queue    queue.Queue
+	weight   int
+	limit    int // number of buffered events from which on events are not accepted
+
+	events   atomic.Int64  // events buffered in the lane
+	rejected atomic.Uint64 // events rejected by TryPublish
+}
+
+// laneListener releases the capacity used by ACKed events and forwards ACKs
+// to the queue ACKListener.
+type laneListener struct {
+	queue *priorityQueue
+	lane  *lane
+}
+
+const numLanes = 3
+
+// lanePriorities lists the priority classes of the lanes, highest first.
+var lanePriorities = [numLanes]beat.Priority{
+	beat.HighPriority,
+	beat.NormalPriority,
+	beat.LowPriority,
+}
+
+func init() {
+	queue.RegisterQueueType(
+		"priority",
+		create,
+		feature.MakeDetails(
+			"Priority queue",
+			"Buffer events in memory, preferring events of higher priority.",
+			feature.Beta))
+}
+
+func create(
+	ackListener queue.ACKListener, logger *logp.Logger, cfg *common.Config,
+) (queue.Queue, error) {
+	cfgwarn.Beta("The priority queue is beta")
+
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, err
+	}
+
+	return NewQueue(logger, Settings{
+		ACKListener:    ackListener,
+		Events:         config.Events,
+		FlushMinEvents: config.FlushMinEvents,
+		FlushTimeout:   config.FlushTimeout,
+		Weights: map[beat.Priority]int{
+			beat.HighPriority:   config.Weights.High,
+			beat.NormalPriority: config.Weights.Normal,
+			beat.LowPriority:    config.Weights.Low,
+		},
+		DropWatermarks: map[beat.Priority]float64{
+			beat.NormalPriority: config.DropWatermark.Normal,
+			beat.LowPriority:    config.DropWatermark.Low,
+		},
+	}), nil
+}
+
+// NewQueue creates a priority queue, using a memory queue per priority class.
+func NewQueue(logger *logp.Logger, settings Settings) queue.Queue {
+	if logger == nil {
+		logger = logp.NewLogger("priorityqueue")
+	}
+
+	q := &priorityQueue{
+		log:      logger,
+		listener: settings.ACKListener,
+		capacity: settings.Events,
+		unread:   lanes.NewUnread(),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	for i, priority := range lanePriorities {
+		l := &lane{
+			priority: priority,
+			weight:   1,
+			limit:    settings.Events,
+		}
+		if w := settings.Weights[priority]; w > 0 {
+			l.weight = w
+		}
+		if wm, ok := settings.DropWatermarks[priority]; ok && wm < 1 {
+			l.limit = int(wm * float64(settings.Events))
+			if l.limit < 1 {
+				l.limit = 1
+			}
+		}
+
+		// The lanes can hold all events, the capacity of the queue is
+		// enforced by the producers.
+		l.queue = memqueue.NewQueue(logger.Named(priority.String()), memqueue.Settings{
+			ACKListener:    &laneListener{queue: q, lane: l},
+			Events:         settings.Events,
+			FlushMinEvents: settings.FlushMinEvents,
+			FlushTimeout:   settings.FlushTimeout,
+		})
+		q.lanes[i] = l
+	}
+
+	return q
+}
+
+// Close shuts down all lanes. Events still buffered are lost.
+func (q *priorityQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	var errs multierror.Errors
+	for _, l := range q.lanes {
+		if err := l.queue.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.Err()
+}
+
+func (q *priorityQueue) BufferConfig() queue.BufferConfig {
+	return queue.BufferConfig{MaxEvents: q.capacity}
+}
+
+func (q *priorityQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	return newProducer(q, cfg)
+}
+
+func (q *priorityQueue) Consumer() queue.Consumer {
+	return newConsumer(q)
+}
+
+// laneOf returns the index of the lane of an event. The priority of the
+// event takes precedence over the priority of the producer.
+func laneOf(producerPriority beat.Priority, event *publisher.Event) int {
+	priority := events.GetPriority(event.Content)
+	if priority == beat.DefaultPriority {
+		priority = producerPriority
+	}
+
+	switch priority {
+	case beat.HighPriority:
+		return 0
+	case beat.LowPriority:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// reserve takes capacity for an event to be published to a lane, if the
+// queue has not filled up to the limit of the lane. If block is set, reserve
+// waits for the queue to drain below the limit, until the queue or the
+// producer is closed. Otherwise reserve fails.
+func (q *priorityQueue) reserve(l *lane, block bool, cancelled *bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if block {
+		for q.active >= l.limit && !q.closed && !*cancelled {
+			q.cond.Wait()
+		}
+	} else if q.active >= l.limit {
+		l.rejected.Inc()
+		return false
+	}
+	if q.closed || *cancelled {
+		return false
+	}
+
+	q.active++
+	l.events.Inc()
+	return true
+}
+
+// release returns the capacity of events ACKed or dropped by a lane.
+func (q *priorityQueue) release(l *lane, n int) {
+	if n <= 0 {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.active -= n
+	l.events.Sub(int64(n))
+	q.cond.Broadcast()
+}
+
+// RegisterMetrics reports the events buffered per priority class.
+func (q *priorityQueue) RegisterMetrics(reg *monitoring.Registry) {
+	for _, l := range q.lanes {
+		l := l
+		name := l.priority.String()
+		reg.Remove(name)
+		monitoring.NewFunc(reg, name, l.report, monitoring.Report)
+	}
+}
+
+func (l *lane) report(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	monitoring.ReportInt(V, "events", l.events.Load())
+	monitoring.ReportInt(V, "rejected", int64(l.rejected.Load()))
+	monitoring.ReportInt(V, "weight", int64(l.weight))
+}
+
+func (l *laneListener) OnACK(n int) {
+	l.queue.release(l.lane, n)
+	if listener := l.queue.listener; listener != nil {
+		listener.OnACK(n)
+	}
+}
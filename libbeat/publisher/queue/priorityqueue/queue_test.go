@@ -0,0 +1,317 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package priorityqueue
+
+import (
+	"flag"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue/queuetest"
+)
+
+var seed int64
+
+func init() {
+	flag.Int64Var(&seed, "seed", time.Now().UnixNano(), "test random seed")
+}
+
+func TestProduceConsumer(t *testing.T) {
+	maxEvents := 1024
+	minEvents := 32
+
+	rand.Seed(seed)
+	events := rand.Intn(maxEvents-minEvents) + minEvents
+	batchSize := rand.Intn(events-8) + 4
+
+	t.Log("seed: ", seed)
+	t.Log("events: ", events)
+	t.Log("batchSize: ", batchSize)
+
+	factory := func(t *testing.T) queue.Queue {
+		return newTestQueue(nil, 4096)
+	}
+
+	t.Run("single", func(t *testing.T) {
+		queuetest.TestSingleProducerConsumer(t, events, batchSize, factory)
+	})
+	t.Run("multi", func(t *testing.T) {
+		queuetest.TestMultiProducerConsumer(t, events, batchSize, factory)
+	})
+}
+
+func TestDropLowPriorityFirst(t *testing.T) {
+	q := newTestQueue(nil, 100)
+	defer q.Close()
+
+	reg := monitoring.NewRegistry()
+	q.(queue.MetricsReporter).RegisterMetrics(reg)
+
+	tryPublish := func(priority beat.Priority, n int) int {
+		producer := q.Producer(queue.ProducerConfig{Priority: priority})
+		published := 0
+		for i := 0; i < n; i++ {
+			if producer.TryPublish(makeEvent(i, beat.DefaultPriority)) {
+				published++
+			}
+		}
+		return published
+	}
+
+	assert.Equal(t, 50, tryPublish(beat.LowPriority, 60))
+	assert.Equal(t, 30, tryPublish(beat.DefaultPriority, 40))
+	assert.Equal(t, 20, tryPublish(beat.HighPriority, 30))
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(50), snapshot.Ints["low.events"])
+	assert.Equal(t, int64(10), snapshot.Ints["low.rejected"])
+	assert.Equal(t, int64(30), snapshot.Ints["normal.events"])
+	assert.Equal(t, int64(10), snapshot.Ints["normal.rejected"])
+	assert.Equal(t, int64(20), snapshot.Ints["high.events"])
+	assert.Equal(t, int64(10), snapshot.Ints["high.rejected"])
+	assert.Equal(t, int64(1), snapshot.Ints["low.weight"])
+
+	// consuming events frees the capacity for all priorities
+	consumer := q.Consumer()
+	defer consumer.Close()
+	for n := 0; n < 100; {
+		batch, err := consumer.Get(100)
+		require.NoError(t, err)
+		n += len(batch.Events())
+		batch.ACK()
+	}
+	queuetest.WaitUntil(t, func() bool {
+		snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+		return snapshot.Ints["low.events"]+snapshot.Ints["normal.events"]+snapshot.Ints["high.events"] == 0
+	})
+	assert.Equal(t, 5, tryPublish(beat.LowPriority, 5))
+}
+
+func TestEventPriority(t *testing.T) {
+	q := newTestQueue(nil, 100)
+	defer q.Close()
+
+	reg := monitoring.NewRegistry()
+	q.(queue.MetricsReporter).RegisterMetrics(reg)
+
+	producer := q.Producer(queue.ProducerConfig{Priority: beat.LowPriority})
+	require.True(t, producer.Publish(makeEvent(0, beat.DefaultPriority)))
+	require.True(t, producer.Publish(makeEvent(1, beat.HighPriority)))
+	require.True(t, producer.Publish(makeEvent(2, beat.NormalPriority)))
+
+	snapshot := monitoring.CollectFlatSnapshot(reg, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["low.events"])
+	assert.Equal(t, int64(1), snapshot.Ints["normal.events"])
+	assert.Equal(t, int64(1), snapshot.Ints["high.events"])
+}
+
+func TestPublishBlocksWhenFull(t *testing.T) {
+	q := newTestQueue(nil, 32)
+	defer q.Close()
+
+	producer := q.Producer(queue.ProducerConfig{})
+	for i := 0; i < 32; i++ {
+		require.True(t, producer.Publish(makeEvent(i, beat.HighPriority)))
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- producer.Publish(makeEvent(32, beat.HighPriority))
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("publish did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	producer.Cancel()
+	assert.False(t, <-done)
+}
+
+func TestPublishKeepsRoomForHigherPriority(t *testing.T) {
+	q := newTestQueue(nil, 100)
+	defer q.Close()
+
+	low := q.Producer(queue.ProducerConfig{Priority: beat.LowPriority})
+	for i := 0; i < 50; i++ {
+		require.True(t, low.Publish(makeEvent(i, beat.DefaultPriority)))
+	}
+
+	// low priority events wait at the drop watermark of 0.5
+	done := make(chan bool)
+	go func() {
+		done <- low.Publish(makeEvent(50, beat.DefaultPriority))
+	}()
+	select {
+	case <-done:
+		t.Fatal("publish did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// high priority events use the room left
+	high := q.Producer(queue.ProducerConfig{Priority: beat.HighPriority})
+	for i := 0; i < 50; i++ {
+		require.True(t, high.Publish(makeEvent(i, beat.DefaultPriority)))
+	}
+
+	consumer := q.Consumer()
+	defer consumer.Close()
+	for n := 0; n < 100; {
+		batch, err := consumer.Get(100)
+		require.NoError(t, err)
+		n += len(batch.Events())
+		batch.ACK()
+	}
+
+	select {
+	case ok := <-done:
+		assert.True(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish still blocked")
+	}
+}
+
+func TestConsumerCloseKeepsBatches(t *testing.T) {
+	q := newTestQueue(nil, 100)
+	defer q.Close()
+
+	var acked atomic.Int64
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked.Add(int64(n)) }})
+	for i := 0; i < 30; i++ {
+		require.True(t, producer.Publish(makeEvent(i, lanePriorities[i%numLanes])))
+	}
+
+	consumer := q.Consumer()
+	batch, err := consumer.Get(5)
+	require.NoError(t, err)
+	batches := []queue.Batch{batch}
+
+	// give the consumer time to read batches of all lanes before closing it
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, consumer.Close())
+
+	consumer = q.Consumer()
+	defer consumer.Close()
+
+	count := len(batch.Events())
+	for count < 30 {
+		batch, err := consumer.Get(5)
+		require.NoError(t, err)
+		batches = append(batches, batch)
+		count += len(batch.Events())
+	}
+	for _, batch := range batches {
+		batch.ACK()
+	}
+	queuetest.WaitUntil(t, func() bool { return acked.Load() == 30 })
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	q := newTestQueue(nil, 100).(*priorityQueue)
+	defer q.Close()
+	c := &consumer{queue: q}
+
+	count := func(ready []int, n int) [numLanes]int {
+		var counts [numLanes]int
+		for i := 0; i < n; i++ {
+			for _, lane := range ready {
+				c.ready[lane] = &testBatch{}
+			}
+			lane := c.next()
+			c.ready = [numLanes]queue.Batch{}
+			counts[lane]++
+		}
+		return counts
+	}
+
+	// weights are 4, 2, 1
+	assert.Equal(t, [numLanes]int{8, 4, 2}, count([]int{0, 1, 2}, 14))
+	assert.Equal(t, [numLanes]int{0, 4, 2}, count([]int{1, 2}, 6))
+	assert.Equal(t, [numLanes]int{0, 0, 3}, count([]int{2}, 3))
+}
+
+func TestACKListener(t *testing.T) {
+	listener := &queuetest.CountingListener{}
+	q := newTestQueue(listener, 100)
+	defer q.Close()
+
+	var acked atomic.Int64
+	producer := q.Producer(queue.ProducerConfig{ACK: func(n int) { acked.Add(int64(n)) }})
+	for i := 0; i < 30; i++ {
+		require.True(t, producer.Publish(makeEvent(i, lanePriorities[i%numLanes])))
+	}
+
+	consumer := q.Consumer()
+	defer consumer.Close()
+	for n := 0; n < 30; {
+		batch, err := consumer.Get(10)
+		require.NoError(t, err)
+		n += len(batch.Events())
+		batch.ACK()
+	}
+
+	queuetest.WaitUntil(t, func() bool { return acked.Load() == 30 && listener.Count() == 30 })
+}
+
+type testBatch struct{}
+
+func (*testBatch) Events() []publisher.Event { return nil }
+func (*testBatch) ACK()                      {}
+
+func newTestQueue(listener queue.ACKListener, events int) queue.Queue {
+	return NewQueue(nil, Settings{
+		ACKListener:    listener,
+		Events:         events,
+		FlushMinEvents: 1,
+		Weights: map[beat.Priority]int{
+			beat.HighPriority:   4,
+			beat.NormalPriority: 2,
+			beat.LowPriority:    1,
+		},
+		DropWatermarks: map[beat.Priority]float64{
+			beat.NormalPriority: 0.8,
+			beat.LowPriority:    0.5,
+		},
+	})
+}
+
+func makeEvent(value int, priority beat.Priority) publisher.Event {
+	event := publisher.Event{
+		Content: beat.Event{
+			Timestamp: time.Now(),
+			Fields:    common.MapStr{"value": value},
+		},
+	}
+	if priority != beat.DefaultPriority {
+		event.Content.Meta = common.MapStr{"priority": priority.String()}
+	}
+	return event
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/snappyflow/beats/v7/libbeat/beat"
 	"github.com/snappyflow/beats/v7/libbeat/common"
 	"github.com/snappyflow/beats/v7/libbeat/logp"
+	"github.com/snappyflow/beats/v7/libbeat/monitoring"
 	"github.com/snappyflow/beats/v7/libbeat/publisher"
 )
 
@@ -52,6 +53,13 @@ type Queue interface {
 	Consumer() Consumer
 }
 
+// MetricsReporter is an optional interface implemented by queues reporting
+// their internal state. The pipeline calls RegisterMetrics once after the
+// queue has been created, passing the registry holding the queue metrics.
+type MetricsReporter interface {
+	RegisterMetrics(reg *monitoring.Registry)
+}
+
 // BufferConfig returns the pipelines buffering settings,
 // for the pipeline to use.
 // In case of the pipeline itself storing events for reporting ACKs to clients,
@@ -80,6 +88,11 @@ type ProducerConfig struct {
 	// DropOnCancel is a hint to the queue to drop events if the producer disconnects
 	// via Cancel.
 	DropOnCancel bool
+
+	// Priority sets the priority class of the events published by the
+	// producer. Events can overwrite the priority by setting
+	// @metadata.priority. Queues not supporting priorities ignore the setting.
+	Priority beat.Priority
 }
 
 // Producer is an interface to be used by the pipelines client to forward
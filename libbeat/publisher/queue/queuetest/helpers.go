@@ -0,0 +1,50 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package queuetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snappyflow/beats/v7/libbeat/common/atomic"
+)
+
+// CountingListener is a queue.ACKListener counting the ACKed events.
+type CountingListener struct {
+	count atomic.Int64
+}
+
+// OnACK adds n to the number of ACKed events.
+func (l *CountingListener) OnACK(n int) { l.count.Add(int64(n)) }
+
+// Count returns the number of ACKed events.
+func (l *CountingListener) Count() int { return int(l.count.Load()) }
+
+// WaitUntil polls cond for up to one second, failing the test if cond does
+// not become true.
+func WaitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for condition")
+}
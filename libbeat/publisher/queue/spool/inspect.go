@@ -0,0 +1,159 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package spool
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/elastic/go-txfile"
+	"github.com/elastic/go-txfile/pq"
+)
+
+// File provides offline access to the events stored in a spool file. It is
+// used to inspect and repair the spool of a stopped beat.
+type File struct {
+	path     string
+	file     *txfile.File
+	delegate pq.Delegate
+	queue    *pq.Queue
+}
+
+// FileStats describes the state of a spool file.
+type FileStats struct {
+	Size     int64 // file size in bytes
+	PageSize int   // page size of the file
+	Events   int   // number of events not yet ACKed
+}
+
+// OpenFile opens an existing spool file. The file is locked until Close is
+// called.
+func OpenFile(path string) (*File, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	f, err := txfile.Open(path, 0600, txfile.Options{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open spool file at path '%s'", path)
+	}
+
+	ok := false
+	defer ifNotOK(&ok, ignoreErr(f.Close))
+
+	delegate, err := pq.NewStandaloneDelegate(f)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := pq.New(delegate, pq.Settings{})
+	if err != nil {
+		return nil, err
+	}
+
+	ok = true
+	return &File{path: path, file: f, delegate: delegate, queue: queue}, nil
+}
+
+// Close closes the spool file.
+func (f *File) Close() error {
+	err := f.queue.Close()
+	f.file.Close()
+	return err
+}
+
+// Stats reports the size of the spool file and the number of events stored.
+func (f *File) Stats() (FileStats, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return FileStats{}, err
+	}
+
+	events, err := f.queue.Pending()
+	if err != nil {
+		return FileStats{}, err
+	}
+
+	return FileStats{
+		Size:     info.Size(),
+		PageSize: f.file.PageSize(),
+		Events:   events,
+	}, nil
+}
+
+// Read decodes all events not yet ACKed, in the order they have been written.
+// Events failing to decode are passed to fn together with the decoding error.
+// Reading stops if fn returns an error or if the spool file can not be read.
+func (f *File) Read(fn func(publisher.Event, error) error) error {
+	// The queue keeps the read position of its reader. Use a new queue
+	// instance, such that every call reads all events.
+	queue, err := pq.New(f.delegate, pq.Settings{})
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	reader := queue.Reader()
+	if err := reader.Begin(); err != nil {
+		return err
+	}
+	defer reader.Done()
+
+	dec := newDecoder()
+	for {
+		n, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return nil
+		}
+
+		buf := dec.Buffer(n)
+		if _, err := reader.Read(buf); err != nil {
+			return err
+		}
+
+		event, err := dec.Decode()
+		if err := fn(event, err); err != nil {
+			return err
+		}
+	}
+}
+
+// Truncate removes up to n events from the head of the spool. The number of
+// removed events is returned.
+func (f *File) Truncate(n int) (int, error) {
+	events, err := f.queue.Pending()
+	if err != nil {
+		return 0, err
+	}
+	if n > events {
+		n = events
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+
+	if err := f.queue.ACK(uint(n)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
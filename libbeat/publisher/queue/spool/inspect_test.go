@@ -0,0 +1,100 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build !integration
+
+package spool
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/snappyflow/beats/v7/libbeat/beat"
+	"github.com/snappyflow/beats/v7/libbeat/common"
+	"github.com/snappyflow/beats/v7/libbeat/publisher"
+	"github.com/snappyflow/beats/v7/libbeat/publisher/queue"
+	"github.com/elastic/go-txfile"
+	"github.com/elastic/go-txfile/txfiletest"
+)
+
+func TestInspectFile(t *testing.T) {
+	path, teardown := txfiletest.SetupPath(t, "")
+	defer teardown()
+
+	spool, err := newDiskSpool(new(silentLogger), path, settings{
+		Mode:              0600,
+		WriteBuffer:       16 * humanize.KiByte,
+		WriteFlushTimeout: 10 * time.Millisecond,
+		Codec:             codecCBORL,
+		File: txfile.Options{
+			MaxSize:  humanize.MiByte,
+			PageSize: 4 * humanize.KiByte,
+			Prealloc: true,
+		},
+	})
+	require.NoError(t, err)
+
+	producer := spool.Producer(queue.ProducerConfig{})
+	for i := 0; i < 10; i++ {
+		require.True(t, producer.Publish(publisher.Event{
+			Content: beat.Event{
+				Timestamp: time.Now(),
+				Fields:    common.MapStr{"value": i},
+			},
+		}))
+	}
+	require.NoError(t, spool.Close())
+
+	f, err := OpenFile(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	stats, err := f.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 10, stats.Events)
+	assert.Equal(t, 4*humanize.KiByte, stats.PageSize)
+
+	readValues := func() []string {
+		var values []string
+		err := f.Read(func(event publisher.Event, err error) error {
+			require.NoError(t, err)
+			values = append(values, fmt.Sprint(event.Content.Fields["value"]))
+			return nil
+		})
+		require.NoError(t, err)
+		return values
+	}
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, readValues())
+
+	n, err := f.Truncate(3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"3", "4", "5", "6", "7", "8", "9"}, readValues())
+
+	n, err = f.Truncate(100)
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+
+	stats, err = f.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Events)
+}
@@ -47,10 +47,7 @@ func create(
 		return nil, err
 	}
 
-	path := config.File.Path
-	if path == "" {
-		path = paths.Resolve(paths.Data, "spool.dat")
-	}
+	path := filePath(config)
 
 	flushEvents := uint(0)
 	if count := config.Write.FlushEvents; count > 0 {
@@ -78,3 +75,19 @@ func create(
 		},
 	})
 }
+
+// FilePath returns the path of the spool file configured by cfg.
+func FilePath(cfg *common.Config) (string, error) {
+	config := defaultConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return "", err
+	}
+	return filePath(config), nil
+}
+
+func filePath(config config) string {
+	if config.File.Path == "" {
+		return paths.Resolve(paths.Data, "spool.dat")
+	}
+	return config.File.Path
+}
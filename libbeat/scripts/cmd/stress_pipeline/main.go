@@ -34,7 +34,10 @@ import (
 	_ "github.com/snappyflow/beats/v7/libbeat/outputs/logstash"
 	"github.com/snappyflow/beats/v7/libbeat/paths"
 	"github.com/snappyflow/beats/v7/libbeat/publisher/pipeline/stress"
+	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/diskqueue"
+	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/hybridqueue"
 	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/memqueue"
+	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/priorityqueue"
 	_ "github.com/snappyflow/beats/v7/libbeat/publisher/queue/spool"
 	"github.com/snappyflow/beats/v7/libbeat/service"
 )
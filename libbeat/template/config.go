@@ -17,7 +17,13 @@
 
 package template
 
-import "github.com/snappyflow/beats/v7/libbeat/mapping"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/snappyflow/beats/v7/libbeat/mapping"
+)
 
 // TemplateConfig holds config information about the Elasticsearch template
 type TemplateConfig struct {
@@ -34,6 +40,56 @@ type TemplateConfig struct {
 	Overwrite    bool             `config:"overwrite"`
 	Settings     TemplateSettings `config:"settings"`
 	Order        int              `config:"order"`
+
+	// Type selects between legacy templates and composable index templates.
+	Type IndexTemplateType `config:"type"`
+
+	// DataStream installs the index template for data streams. It requires
+	// the index template type.
+	DataStream bool `config:"data_stream"`
+}
+
+// IndexTemplateType is used for enumerating the template types.
+type IndexTemplateType uint8
+
+const (
+	// IndexTemplateLegacy enum 'legacy', loaded using the _template API.
+	IndexTemplateLegacy IndexTemplateType = iota
+
+	// IndexTemplateIndex enum 'index', loaded using the _index_template API.
+	IndexTemplateIndex
+)
+
+var templateTypes = map[string]IndexTemplateType{
+	"legacy": IndexTemplateLegacy,
+	"index":  IndexTemplateIndex,
+}
+
+// Unpack creates the enumeration value legacy or index.
+func (t *IndexTemplateType) Unpack(in string) error {
+	v, ok := templateTypes[strings.ToLower(in)]
+	if !ok {
+		return fmt.Errorf("template type '%v' is invalid (try legacy, index)", in)
+	}
+	*t = v
+	return nil
+}
+
+func (t IndexTemplateType) String() string {
+	for name, v := range templateTypes {
+		if v == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Validate verifies that the template type supports the configured settings.
+func (c *TemplateConfig) Validate() error {
+	if c.DataStream && c.Type != IndexTemplateIndex {
+		return errors.New("setup.template.data_stream requires setup.template.type: index")
+	}
+	return nil
 }
 
 // TemplateSettings are part of the Elasticsearch template and hold index and source specific information.
@@ -48,5 +104,6 @@ func DefaultConfig() TemplateConfig {
 		Enabled: true,
 		Fields:  "",
 		Order:   1,
+		Type:    IndexTemplateLegacy,
 	}
 }
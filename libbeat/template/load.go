@@ -91,13 +91,17 @@ func (l *ESLoader) Load(config TemplateConfig, info beat.Info, fields []byte, mi
 		return err
 	}
 
+	if err := checkTemplateTypeSupport(config, l.client.GetVersion()); err != nil {
+		return err
+	}
+
 	// Check if template already exist or should be overwritten
 	templateName := tmpl.GetName()
 	if config.JSON.Enabled {
 		templateName = config.JSON.Name
 	}
 
-	if l.templateExists(templateName) && !config.Overwrite {
+	if l.templateExists(templateName, config.Type) && !config.Overwrite {
 		l.log.Infof("Template %s already exists and will not be overwritten.", templateName)
 		return nil
 	}
@@ -107,7 +111,7 @@ func (l *ESLoader) Load(config TemplateConfig, info beat.Info, fields []byte, mi
 	if err != nil {
 		return err
 	}
-	if err := l.loadTemplate(templateName, body); err != nil {
+	if err := l.loadTemplate(templateName, config.Type, body); err != nil {
 		return fmt.Errorf("could not load template. Elasticsearch returned: %v. Template is: %s", err, body.StringToPrint())
 	}
 	l.log.Infof("template with name '%s' loaded.", templateName)
@@ -117,10 +121,14 @@ func (l *ESLoader) Load(config TemplateConfig, info beat.Info, fields []byte, mi
 // loadTemplate loads a template into Elasticsearch overwriting the existing
 // template if it exists. If you wish to not overwrite an existing template
 // then use CheckTemplate prior to calling this method.
-func (l *ESLoader) loadTemplate(templateName string, template map[string]interface{}) error {
-	l.log.Infof("Try loading template %s to Elasticsearch", templateName)
+func (l *ESLoader) loadTemplate(templateName string, templateType IndexTemplateType, template map[string]interface{}) error {
+	l.log.Infof("Try loading %v template %s to Elasticsearch", templateType, templateName)
 	path := "/_template/" + templateName
 	params := esVersionParams(l.client.GetVersion())
+	if templateType == IndexTemplateIndex {
+		path = "/_index_template/" + templateName
+		params = nil
+	}
 	status, body, err := l.client.Request("PUT", path, "", params, template)
 	if err != nil {
 		return fmt.Errorf("couldn't load template: %v. Response body: %s", err, body)
@@ -133,11 +141,16 @@ func (l *ESLoader) loadTemplate(templateName string, template map[string]interfa
 
 // templateExists checks if a given template already exist. It returns true if
 // and only if Elasticsearch returns with HTTP status code 200.
-func (l *ESLoader) templateExists(templateName string) bool {
+func (l *ESLoader) templateExists(templateName string, templateType IndexTemplateType) bool {
 	if l.client == nil {
 		return false
 	}
 
+	if templateType == IndexTemplateIndex {
+		status, _, _ := l.client.Request("GET", "/_index_template/"+templateName, "", nil, nil)
+		return status == http.StatusOK
+	}
+
 	status, body, _ := l.client.Request("GET", "/_cat/templates/"+templateName, "", nil, nil)
 
 	return status == http.StatusOK && strings.Contains(string(body), templateName)
@@ -240,6 +253,28 @@ func (b *templateBuilder) buildMinimalTemplate(tmpl *Template) (common.MapStr, e
 	return body, nil
 }
 
+var (
+	minIndexTemplateVersion = common.MustNewVersion("7.8.0")
+	minDataStreamVersion    = common.MustNewVersion("7.9.0")
+)
+
+// checkTemplateTypeSupport verifies that Elasticsearch supports composable
+// index templates and data streams if they are configured.
+func checkTemplateTypeSupport(config TemplateConfig, esVersion common.Version) error {
+	if !esVersion.IsValid() {
+		return nil
+	}
+	if config.DataStream && esVersion.LessThan(minDataStreamVersion) {
+		return fmt.Errorf("data streams require Elasticsearch %v or newer, found %v",
+			minDataStreamVersion, esVersion)
+	}
+	if config.Type == IndexTemplateIndex && esVersion.LessThan(minIndexTemplateVersion) {
+		return fmt.Errorf("index templates require Elasticsearch %v or newer, found %v",
+			minIndexTemplateVersion, esVersion)
+	}
+	return nil
+}
+
 func esVersionParams(ver common.Version) map[string]string {
 	if ver.Major == 6 && ver.Minor == 7 {
 		return map[string]string{
@@ -36,8 +36,10 @@ func TestFileLoader_Load(t *testing.T) {
 	tmplName := fmt.Sprintf("%s-%s", prefix, ver)
 
 	for name, test := range map[string]struct {
-		settings TemplateSettings
-		body     common.MapStr
+		settings   TemplateSettings
+		typ        IndexTemplateType
+		dataStream bool
+		body       common.MapStr
 	}{
 		"load minimal config info": {
 			body: common.MapStr{
@@ -66,6 +68,17 @@ func TestFileLoader_Load(t *testing.T) {
 					"properties":        nil,
 				}},
 		},
+		"load minimal index template for data streams": {
+			typ:        IndexTemplateIndex,
+			dataStream: true,
+			body: common.MapStr{
+				"index_patterns": []string{"mock-7.0.0*"},
+				"priority":       order,
+				"data_stream":    common.MapStr{},
+				"template": common.MapStr{
+					"settings": common.MapStr{"index": nil},
+				}},
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			fc, err := newFileClient(ver)
@@ -74,6 +87,7 @@ func TestFileLoader_Load(t *testing.T) {
 
 			cfg := DefaultConfig()
 			cfg.Settings = test.settings
+			cfg.Type, cfg.DataStream = test.typ, test.dataStream
 
 			err = fl.Load(cfg, info, nil, false)
 			require.NoError(t, err)
@@ -100,3 +114,31 @@ func (c *fileClient) Write(component string, name string, body string) error {
 	c.component, c.name, c.body = component, name, body
 	return nil
 }
+
+func TestCheckTemplateTypeSupport(t *testing.T) {
+	indexTemplate := DefaultConfig()
+	indexTemplate.Type = IndexTemplateIndex
+	dataStream := indexTemplate
+	dataStream.DataStream = true
+
+	for name, test := range map[string]struct {
+		config TemplateConfig
+		ver    string
+		err    bool
+	}{
+		"legacy template":              {config: DefaultConfig(), ver: "6.8.0"},
+		"index template":               {config: indexTemplate, ver: "7.8.0"},
+		"index template not supported": {config: indexTemplate, ver: "7.7.1", err: true},
+		"data stream":                  {config: dataStream, ver: "7.9.0"},
+		"data stream not supported":    {config: dataStream, ver: "7.8.0", err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := checkTemplateTypeSupport(test.config, *common.MustNewVersion(test.ver))
+			if test.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
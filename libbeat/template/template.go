@@ -77,6 +77,10 @@ func New(
 	pattern := config.Pattern
 	if pattern == "" {
 		pattern = name + "-*"
+		if config.DataStream {
+			// the data stream is named after the template
+			pattern = name + "*"
+		}
 	}
 
 	event := &beat.Event{
@@ -184,21 +188,17 @@ func (t *Template) LoadBytes(data []byte) (common.MapStr, error) {
 
 // LoadMinimal loads the template only with the given configuration
 func (t *Template) LoadMinimal() (common.MapStr, error) {
-	keyPattern, patterns := buildPatternSettings(t.esVersion, t.GetPattern())
-	m := common.MapStr{
-		keyPattern: patterns,
-		"order":    t.order,
-		"settings": common.MapStr{
-			"index": t.config.Settings.Index,
-		},
-	}
+	var mappings common.MapStr
 	if t.config.Settings.Source != nil {
-		m["mappings"] = buildMappings(
+		mappings = buildMappings(
 			t.beatVersion, t.esVersion, t.beatName,
 			nil, nil,
 			common.MapStr(t.config.Settings.Source))
 	}
-	return m, nil
+	settings := common.MapStr{
+		"index": t.config.Settings.Index,
+	}
+	return t.buildBody(mappings, settings), nil
 }
 
 // GetName returns the name of the template
@@ -214,22 +214,52 @@ func (t *Template) GetPattern() string {
 // Generate generates the full template
 // The default values are taken from the default variable.
 func (t *Template) Generate(properties common.MapStr, dynamicTemplates []common.MapStr) common.MapStr {
+	mappings := buildMappings(
+		t.beatVersion, t.esVersion, t.beatName,
+		properties,
+		append(dynamicTemplates, buildDynTmpl(t.esVersion)),
+		common.MapStr(t.config.Settings.Source))
+	settings := common.MapStr{
+		"index": buildIdxSettings(
+			t.esVersion,
+			t.config.Settings.Index,
+		),
+	}
+	return t.buildBody(mappings, settings)
+}
+
+// buildBody wraps mappings and settings into the body of the configured
+// template type. Composable index templates nest them in `template` and use
+// the order as priority.
+func (t *Template) buildBody(mappings, settings common.MapStr) common.MapStr {
+	if t.config.Type == IndexTemplateIndex {
+		tmpl := common.MapStr{
+			"settings": settings,
+		}
+		if mappings != nil {
+			tmpl["mappings"] = mappings
+		}
+		body := common.MapStr{
+			"index_patterns": []string{t.GetPattern()},
+			"priority":       t.order,
+			"template":       tmpl,
+		}
+		if t.config.DataStream {
+			body["data_stream"] = common.MapStr{}
+		}
+		return body
+	}
+
 	keyPattern, patterns := buildPatternSettings(t.esVersion, t.GetPattern())
-	return common.MapStr{
+	body := common.MapStr{
 		keyPattern: patterns,
 		"order":    t.order,
-		"mappings": buildMappings(
-			t.beatVersion, t.esVersion, t.beatName,
-			properties,
-			append(dynamicTemplates, buildDynTmpl(t.esVersion)),
-			common.MapStr(t.config.Settings.Source)),
-		"settings": common.MapStr{
-			"index": buildIdxSettings(
-				t.esVersion,
-				t.config.Settings.Index,
-			),
-		},
+		"settings": settings,
+	}
+	if mappings != nil {
+		body["mappings"] = mappings
 	}
+	return body
 }
 
 func buildPatternSettings(ver common.Version, pattern string) (string, interface{}) {
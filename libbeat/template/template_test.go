@@ -131,6 +131,27 @@ func TestTemplate(t *testing.T) {
 		template.Assert("mappings._meta", common.MapStr{"beat": "testbeat", "version": currentVersion})
 		template.Assert("settings.index.max_docvalue_fields_search", 200)
 	})
+
+	t.Run("index template", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Type = IndexTemplateIndex
+		template := createTestTemplate(t, currentVersion, "7.9.0", config)
+		template.Assert("index_patterns", []string{"testbeat-" + currentVersion + "-*"})
+		template.Assert("priority", 1)
+		template.Assert("template.mappings._meta", common.MapStr{"beat": "testbeat", "version": currentVersion})
+		template.Assert("template.settings.index.max_docvalue_fields_search", 200)
+		template.AssertMissing("order")
+		template.AssertMissing("data_stream")
+	})
+
+	t.Run("index template for data streams", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Type = IndexTemplateIndex
+		config.DataStream = true
+		template := createTestTemplate(t, currentVersion, "7.9.0", config)
+		template.Assert("index_patterns", []string{"testbeat-" + currentVersion + "*"})
+		template.Assert("data_stream", common.MapStr{})
+	})
 }
 
 func createTestTemplate(t *testing.T, beatVersion, esVersion string, config TemplateConfig) *testTemplate {